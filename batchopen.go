@@ -0,0 +1,126 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+批量打开通道:
+1. 一次请求和多个节点创建通道并存款,比如新的超级节点连接所有的 pub 和 hub.
+	每个通道单独检查,有问题的通道只在自己的结果中报告错误,不影响其他通道
+2. 交易通过 TokenNetworkProxy.NewChannelsAndDepositAsync 连续发送,不等待前一个打包.
+	需要 approve 的 token 只 approve 一次所有通道的金额
+3. 每个通道返回 channel identifier 和 tx hash,后续结果按 channel identifier 在 TXInfo 中查询
+*/
+/*
+ *	Batch open channels :
+ *	1. create and fund channels with many nodes in one request, e.g. a new supernode connecting to every pub and hub.
+ *		every entry is checked on its own, an invalid entry only reports the error in its own result without affecting others.
+ *	2. txs are sent back to back by TokenNetworkProxy.NewChannelsAndDepositAsync without waiting for the previous one to be mined.
+ *		tokens which need approve are approved only once for the amount of all channels.
+ *	3. every entry returns the channel identifier and tx hash, later results are queried in TXInfo by channel identifier.
+ */
+
+//BatchOpenEntry 批量打开通道中的一个通道
+type BatchOpenEntry struct {
+	PartnerAddress common.Address `json:"partner_address"`
+	Balance        *big.Int       `json:"balance"`
+	SettleTimeout  int            `json:"settle_timeout"` // 0 使用默认的 settle timeout
+}
+
+//BatchOpenResult 一个通道的提交结果,Error 为空表示交易已经进入缓冲池
+type BatchOpenResult struct {
+	PartnerAddress    common.Address `json:"partner_address"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	Balance           *big.Int       `json:"balance"`
+	SettleTimeout     int            `json:"settle_timeout"`
+	TXHash            common.Hash    `json:"tx_hash"` // 需要 approve 的 token 是共同的 approve tx
+	Error             string         `json:"error,omitempty"`
+}
+
+/*
+checkBatchOpenEntries 检查每个通道的参数,返回所有通道的结果以及可以提交的那些
+*/
+func checkBatchOpenEntries(entries []*BatchOpenEntry, self common.Address, settleTimeout, revealTimeout int, exist func(partner common.Address) bool) (results, valid []*BatchOpenResult) {
+	seen := make(map[common.Address]bool)
+	for _, e := range entries {
+		r := &BatchOpenResult{
+			PartnerAddress: e.PartnerAddress,
+			Balance:        e.Balance,
+			SettleTimeout:  e.SettleTimeout,
+		}
+		if r.SettleTimeout <= 0 {
+			r.SettleTimeout = settleTimeout
+		}
+		results = append(results, r)
+		var err error
+		switch {
+		case e.PartnerAddress == utils.EmptyAddress || e.PartnerAddress == self:
+			err = rerr.ErrArgumentError.Append("invalid partner address")
+		case seen[e.PartnerAddress]:
+			err = rerr.ErrArgumentError.Append("duplicate partner address")
+		case e.Balance == nil || e.Balance.Cmp(utils.BigInt0) <= 0:
+			err = rerr.ErrInvalidAmount
+		case r.SettleTimeout <= revealTimeout:
+			err = rerr.ErrChannelInvalidSttleTimeout
+		case exist(e.PartnerAddress):
+			err = rerr.ErrChannelAlreadExist
+		}
+		seen[e.PartnerAddress] = true
+		if err != nil {
+			r.Error = err.Error()
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return
+}
+
+/*
+batchOpenChannels 在 service 的主线程中检查通道是否存在,发送交易在另外的 goroutine 中进行,
+通道很多时不会长时间阻塞主线程
+*/
+func (rs *Service) batchOpenChannels(req *batchOpenChannelReq) (result *utils.AsyncResult) {
+	results, valid := checkBatchOpenEntries(req.Entries, rs.NodeAddress, rs.Config.SettleTimeout, rs.Config.RevealTimeout, func(partner common.Address) bool {
+		return rs.getChannel(req.TokenAddress, partner) != nil
+	})
+	tokenNetwork, err := rs.Chain.TokenNetwork(req.TokenAddress)
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	registry := rs.Chain.GetRegistryAddress()
+	var deposits []*models.DepositTXParams
+	for _, r := range valid {
+		r.ChannelIdentifier = utils.CalcChannelID(req.TokenAddress, registry, rs.NodeAddress, r.PartnerAddress)
+		deposits = append(deposits, &models.DepositTXParams{
+			TokenAddress:       req.TokenAddress,
+			ParticipantAddress: rs.NodeAddress,
+			PartnerAddress:     r.PartnerAddress,
+			Amount:             r.Balance,
+			SettleTimeout:      uint64(r.SettleTimeout),
+		})
+	}
+	log.Info(fmt.Sprintf("batch open %d channels on token %s, %d invalid", len(deposits), utils.APex2(req.TokenAddress), len(results)-len(deposits)))
+	result = utils.NewAsyncResult()
+	go func() {
+		if len(deposits) > 0 {
+			txHashes, errs := tokenNetwork.NewChannelsAndDepositAsync(deposits)
+			for i, r := range valid {
+				r.TXHash = txHashes[i]
+				if errs[i] != nil {
+					r.Error = errs[i].Error()
+				}
+			}
+		}
+		result.Tag = results
+		result.Result <- nil
+	}()
+	return
+}
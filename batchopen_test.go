@@ -0,0 +1,34 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBatchOpenEntries(t *testing.T) {
+	self, existed := utils.NewRandomAddress(), utils.NewRandomAddress()
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	entries := []*BatchOpenEntry{
+		{PartnerAddress: p1, Balance: big.NewInt(10)},
+		{PartnerAddress: p2, Balance: big.NewInt(20), SettleTimeout: 200},
+		{PartnerAddress: p1, Balance: big.NewInt(10)},
+		{PartnerAddress: self, Balance: big.NewInt(10)},
+		{PartnerAddress: utils.NewRandomAddress(), Balance: big.NewInt(0)},
+		{PartnerAddress: utils.NewRandomAddress(), Balance: big.NewInt(10), SettleTimeout: 5},
+		{PartnerAddress: existed, Balance: big.NewInt(10)},
+	}
+	results, valid := checkBatchOpenEntries(entries, self, 100, 10, func(partner common.Address) bool {
+		return partner == existed
+	})
+	assert.EqualValues(t, len(entries), len(results))
+	assert.EqualValues(t, 2, len(valid))
+	assert.EqualValues(t, 100, results[0].SettleTimeout)
+	assert.EqualValues(t, 200, results[1].SettleTimeout)
+	for i, r := range results {
+		assert.EqualValues(t, i >= 2, r.Error != "", "entry %d", i)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"math/big"
 
+	"sort"
 	"strings"
 
 	"github.com/MetaLife-Protocol/SuperNode/log"
@@ -63,6 +64,26 @@ func makeEventID(l *types.Log) eventID {
 	return e
 }
 
+//deliveredEvent 已经交给 photon 处理的事件,分叉时用来判断哪些需要回滚
+type deliveredEvent struct {
+	blockNumber  uint64
+	blockHash    common.Hash
+	recordID     models.ChainEventID
+	stateChanges []mediatedtransfer.ContractStateChange
+}
+
+/*
+reorgWindow 保留最近多少块的 hash 和事件流水,每次轮询都重新获取这些块中的事件,
+必须大于所有事件的确认块数,否则等待确认的事件会丢失
+*/
+func reorgWindow() int64 {
+	w := 2 * params.ForkConfirmNumber
+	if m := params.MaxEventConfirmBlocks() + params.ForkConfirmNumber; m > w {
+		w = m
+	}
+	return w
+}
+
 /*
 Events handles all contract events from blockchain
 */
@@ -71,11 +92,19 @@ type Events struct {
 	lastBlockNumber     int64
 	rpcModuleDependency RPCModuleDependency
 	client              *helper.SafeEthClient
-	pollPeriod          time.Duration              // 轮询周期,必须与公链出块间隔一致
-	stopChan            chan int                   // has stopped?
-	txDone              map[eventID]uint64         // 该map记录最近30块内处理的events流水,用于事件去重
-	firstStart          bool                       //保证ContractHistoryEventCompleteStateChange 只会发送一次
-	chainEventRecordDao models.ChainEventRecordDao // 事件处理记录保存
+	pollPeriod          time.Duration                          // 轮询周期,必须与公链出块间隔一致
+	stopChan            chan int                               // has stopped?
+	txDone              map[eventID]*deliveredEvent            // 该map记录最近 reorgWindow 块内处理的events流水,用于事件去重和分叉回滚
+	blockHashes         map[int64]common.Hash                  // 最近 reorgWindow 块内见到的块 hash,用于检测分叉
+	orphaned            []mediatedtransfer.ContractStateChange // 分叉回滚的事件,等待通知 photon
+	forkBlockNumber     int64                                  // orphaned 从这个块开始被替换
+	startBlockNumber    int64                                  // 启动时已经处理到的块
+	lastClearBlock      int64                                  // 上次清理 ChainEventRecord 时的块
+	sub                 *eventSubscription                     // 订阅新块和事件,为nil时轮询
+	noSubscription      bool                                   // 连接不支持订阅,一直轮询
+	nextSubscribeTime   time.Time                              // 订阅失败以后,到这个时间再重新订阅
+	firstStart          bool                                   //保证ContractHistoryEventCompleteStateChange 只会发送一次
+	chainEventRecordDao models.ChainEventRecordDao             // 事件处理记录保存
 }
 
 //NewBlockChainEvents create BlockChainEvents
@@ -84,7 +113,8 @@ func NewBlockChainEvents(client *helper.SafeEthClient, rpcModuleDependency RPCMo
 		StateChangeChannel:  make(chan transfer.StateChange, 10),
 		rpcModuleDependency: rpcModuleDependency,
 		client:              client,
-		txDone:              make(map[eventID]uint64),
+		txDone:              make(map[eventID]*deliveredEvent),
+		blockHashes:         make(map[int64]common.Hash),
 		firstStart:          true,
 		chainEventRecordDao: chainEventRecordDao,
 	}
@@ -123,6 +153,7 @@ Start listening events send to  channel can duplicate but cannot lose.
 func (be *Events) Start(LastBlockNumber int64) {
 	log.Info(fmt.Sprintf("get state change since %d", LastBlockNumber))
 	be.lastBlockNumber = LastBlockNumber
+	be.startBlockNumber = LastBlockNumber
 	/*
 		1. start alarm task
 	*/
@@ -144,6 +175,8 @@ func (be *Events) startAlarmTask() {
 	logPeriod := int64(1)
 	retryTime := 0
 	be.stopChan = make(chan int)
+	defer be.unsubscribe()
+	var newHead *types.Header
 	be.StateChangeChannel <- &transfer.BlockStateChange{BlockNumber: currentBlock}
 	/*
 		正常处理流程:
@@ -167,20 +200,27 @@ func (be *Events) startAlarmTask() {
 				be.pollPeriod = params.DefaultEthRPCPollPeriod
 			}
 		}
-		ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
-		h, err := be.client.HeaderByNumber(ctx, nil)
-		if err != nil {
-			//无论公链发生什么错误,都应该让photon启动起来,而不是卡主
-			be.notifyPhotonStartupCompleteIfNeeded(currentBlock)
-			log.Error(fmt.Sprintf("HeaderByNumber err=%s", err))
-			cancelFunc()
-			if be.stopChan != nil {
-				be.pollPeriod = 0
-				go be.client.RecoverDisconnect()
+		be.subscribe()
+		// 订阅期间直接使用推送的新块
+		h := newHead
+		newHead = nil
+		if h == nil {
+			ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+			var err error
+			h, err = be.client.HeaderByNumber(ctx, nil)
+			if err != nil {
+				//无论公链发生什么错误,都应该让photon启动起来,而不是卡主
+				be.notifyPhotonStartupCompleteIfNeeded(currentBlock)
+				log.Error(fmt.Sprintf("HeaderByNumber err=%s", err))
+				cancelFunc()
+				if be.stopChan != nil {
+					be.pollPeriod = 0
+					go be.client.RecoverDisconnect()
+				}
+				return
 			}
-			return
+			cancelFunc()
 		}
-		cancelFunc()
 		lastedBlock := h.Number.Int64()
 		// 这里如果出现切换公链导致获取到的新块比当前块更小的话,只需要等待即可
 		if currentBlock >= lastedBlock {
@@ -211,12 +251,20 @@ func (be *Events) startAlarmTask() {
 			log.Trace(fmt.Sprintf("new block :%d", lastedBlock))
 		}
 
-		fromBlockNumber := currentBlock - 2*params.ForkConfirmNumber
+		// 检查 currentBlock 是否还在主链上,不在说明发生了分叉
+		forkBlock, err := be.detectReorg(currentBlock, h)
+		if err != nil {
+			log.Error(fmt.Sprintf("detectReorg err=%s", err))
+			be.notifyPhotonStartupCompleteIfNeeded(currentBlock)
+			time.Sleep(be.pollPeriod / 2)
+			continue
+		}
+		fromBlockNumber := currentBlock - reorgWindow()
 		if fromBlockNumber < 0 {
 			fromBlockNumber = 0
 		}
 		// get all state change between currentBlock and lastedBlock
-		stateChanges, err := be.queryAllStateChange(fromBlockNumber, lastedBlock)
+		stateChanges, err := be.queryAllStateChange(fromBlockNumber, h, forkBlock)
 		if err != nil {
 			log.Error(fmt.Sprintf("queryAllStateChange err=%s", err))
 			//无论公链发生什么错误,都应该让photon启动起来,而不是卡主
@@ -229,9 +277,19 @@ func (be *Events) startAlarmTask() {
 			log.Trace(fmt.Sprintf("receive %d events between block %d - %d", len(stateChanges), fromBlockNumber, lastedBlock))
 		}
 
+		// 先通知 photon 回滚没有被重新打包的事件,重新打包的事件和新事件一样再处理一次
+		if len(be.orphaned) > 0 {
+			be.StateChangeChannel <- &mediatedtransfer.ContractReorgStateChange{
+				ForkBlockNumber: be.forkBlockNumber,
+				BlockNumber:     lastedBlock,
+				Orphaned:        be.orphaned,
+			}
+			be.orphaned = nil
+		}
 		// refresh block number and notify PhotonService
 		currentBlock = lastedBlock
 		be.lastBlockNumber = currentBlock
+		be.blockHashes[currentBlock] = h.Hash()
 		var lastSendBlockNumber int64
 		// notify Photon service
 		//我们需要photon service在处理相关事件的时候知道了对应的块已经发生了,否则可能因为错误的当前块数而出现逻辑错误.
@@ -252,21 +310,40 @@ func (be *Events) startAlarmTask() {
 		if lastSendBlockNumber != currentBlock {
 			be.StateChangeChannel <- &transfer.BlockStateChange{BlockNumber: currentBlock}
 		}
-		//// 每5倍确认块清除一次过期流水
-		//if fromBlockNumber%(5*params.ForkConfirmNumber) == 0 {
-		//	be.chainEventRecordDao.ClearOldChainEventRecord(uint64(fromBlockNumber))
-		//}
+		// 每5倍确认块清除一次过期流水
+		if fromBlockNumber-be.lastClearBlock >= 5*params.ForkConfirmNumber {
+			be.chainEventRecordDao.ClearOldChainEventRecord(uint64(fromBlockNumber))
+			be.lastClearBlock = fromBlockNumber
+		}
 		// 清除过期流水
-		for key, blockNumber := range be.txDone {
-			if blockNumber <= uint64(fromBlockNumber) {
+		for key, d := range be.txDone {
+			if d.blockNumber <= uint64(fromBlockNumber) {
 				delete(be.txDone, key)
 			}
 		}
+		for n := range be.blockHashes {
+			if n < fromBlockNumber {
+				delete(be.blockHashes, n)
+			}
+		}
+		if be.sub != nil {
+			be.sub.prune(fromBlockNumber)
+		}
 		// wait to next time
 		//time.Sleep(be.pollPeriod)
-		select {
-		case <-time.After(be.pollPeriod):
-		case <-be.stopChan:
+		if be.sub == nil {
+			select {
+			case <-time.After(be.pollPeriod):
+			case <-be.stopChan:
+				be.stopChan = nil
+				log.Info(fmt.Sprintf("AlarmTask quit complete"))
+				return
+			}
+			continue
+		}
+		var stopped bool
+		newHead, stopped = be.waitNewHead()
+		if stopped {
 			be.stopChan = nil
 			log.Info(fmt.Sprintf("AlarmTask quit complete"))
 			return
@@ -274,15 +351,23 @@ func (be *Events) startAlarmTask() {
 	}
 }
 
-func (be *Events) queryAllStateChange(fromBlock int64, toBlock int64) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
+/*
+queryAllStateChange 获取 [fromBlock,toBlock] 之间的事件,
+forkBlock 不大于上次处理的块时,说明从 forkBlock 开始的块被分叉替换了,先回滚这些块中的事件
+*/
+func (be *Events) queryAllStateChange(fromBlock int64, latest *types.Header, forkBlock int64) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
 	/*
 		get all event of contract TokenNetworkRegistry, SecretRegistry , TokenNetwork
 	*/
-	logs, err := be.getLogsFromChain(fromBlock, toBlock)
+	toBlock := latest.Number.Int64()
+	logs, err := be.getLogs(fromBlock, latest, forkBlock)
 	if err != nil {
 		return
 	}
-	stateChanges, err = be.parseLogsToEvents(logs)
+	if forkBlock <= be.lastBlockNumber {
+		be.rollback(forkBlock, logs)
+	}
+	stateChanges, err = be.parseLogsToEvents(logs, toBlock)
 	if err != nil {
 		return
 	}
@@ -291,57 +376,133 @@ func (be *Events) queryAllStateChange(fromBlock int64, toBlock int64) (stateChan
 	return
 }
 
+/*
+detectReorg 返回第一个被分叉替换的块,没有分叉时返回 currentBlock+1.
+最新块的 parent 就是 currentBlock 时不需要额外查询,否则从 currentBlock 往前逐个比较记录的 hash,
+直到找到一个仍然在主链上的块
+*/
+func (be *Events) detectReorg(currentBlock int64, latest *types.Header) (forkBlock int64, err error) {
+	forkBlock = currentBlock + 1
+	hash, ok := be.blockHashes[currentBlock]
+	if !ok {
+		return
+	}
+	if latest.Number.Int64() == currentBlock+1 && latest.ParentHash == hash {
+		return
+	}
+	var numbers []int64
+	for n := range be.blockHashes {
+		if n <= currentBlock {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] > numbers[j]
+	})
+	matched := false
+	for _, n := range numbers {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+		h, err2 := be.client.HeaderByNumber(ctx, big.NewInt(n))
+		cancelFunc()
+		if err = err2; err != nil {
+			return currentBlock + 1, err
+		}
+		if h.Hash() == be.blockHashes[n] {
+			forkBlock = n + 1
+			matched = true
+			break
+		}
+		forkBlock = n
+	}
+	if !matched {
+		log.Error(fmt.Sprintf("reorg deeper than %d blocks, events before block %d can not be rolled back", reorgWindow(), forkBlock))
+	}
+	if forkBlock <= currentBlock {
+		log.Warn(fmt.Sprintf("chain reorg detected, blocks from %d to %d are replaced", forkBlock, currentBlock))
+	}
+	return
+}
+
+/*
+rollback 从 forkBlock 开始的块已经不在主链上了,
+删除这些块中已处理事件的流水,重新打包到新块中的事件会再处理一次,
+没有重新打包的事件记录在 orphaned 中,通知 photon 回滚
+*/
+func (be *Events) rollback(forkBlock int64, logs []types.Log) {
+	exist := make(map[eventID]bool)
+	for i := range logs {
+		exist[makeEventID(&logs[i])] = true
+	}
+	for id, d := range be.txDone {
+		if d.blockNumber < uint64(forkBlock) {
+			continue
+		}
+		delete(be.txDone, id)
+		be.chainEventRecordDao.RemoveChainEventRecord(d.recordID)
+		if exist[id] {
+			continue
+		}
+		be.orphaned = append(be.orphaned, d.stateChanges...)
+	}
+	for n := range be.blockHashes {
+		if n >= forkBlock {
+			delete(be.blockHashes, n)
+		}
+	}
+	if len(be.orphaned) > 0 {
+		sortContractStateChange(be.orphaned)
+		be.forkBlockNumber = forkBlock
+		log.Warn(fmt.Sprintf("%d events orphaned by reorg from block %d", len(be.orphaned), forkBlock))
+	}
+}
+
 func (be *Events) getLogsFromChain(fromBlock int64, toBlock int64) (logs []types.Log, err error) {
 	/*
 		get all event of contract TokenNetworkRegistry, SecretRegistry , TokenNetwork
 	*/
-	contractAddresses := []common.Address{
-		be.rpcModuleDependency.GetRegistryAddress(),
-		be.rpcModuleDependency.GetSecretRegistryAddress(),
-	}
 	logs, err = rpc.EventsGetInternal(
-		rpc.GetQueryConext(), contractAddresses, fromBlock, toBlock, be.client)
+		rpc.GetQueryConext(), be.contractAddresses(), fromBlock, toBlock, be.client)
 	if err != nil {
 		return
 	}
 	return
 }
 
-func (be *Events) parseLogsToEvents(logs []types.Log) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
+/*
+parseLogsToEvents 把还没有处理过的事件转换为 statechange,
+同一个事件在同一个块中只处理一次,分叉以后被打包到新块中的会再处理一次.
+事件所在块之后不足 params.EventConfirmBlocks 块的先不处理,下次轮询时会重新获取
+*/
+func (be *Events) parseLogsToEvents(logs []types.Log, toBlock int64) (stateChanges []mediatedtransfer.ContractStateChange, err error) {
 	for _, l := range logs {
 		eventName := topicToEventName[l.Topics[0]]
+		id := makeEventID(&l)
+		recordID := be.chainEventRecordDao.MakeChainEventID(&l)
 		// 根据已处理流水去重
-		if doneBlockNumber, ok := be.txDone[makeEventID(&l)]; ok {
-			if doneBlockNumber == l.BlockNumber {
+		alreadyDelivered := false
+		if d, ok := be.txDone[id]; ok {
+			if d.blockHash == l.BlockHash {
 				//log.Trace(fmt.Sprintf("get event txhash=%s repeated,ignore...", l.TxHash.String()))
 				continue
 			}
-			log.Warn(fmt.Sprintf("event tx=%s happened at %d, but now happend at %d ", l.TxHash.String(), doneBlockNumber, l.BlockNumber))
-		}
-		//chainEventRecordID := be.chainEventRecordDao.MakeChainEventID(&l)
-		//// 根据已处理流水去重
-		//if doneBlockNumber, delivered := be.chainEventRecordDao.CheckChainEventDelivered(chainEventRecordID); delivered {
-		//	if doneBlockNumber == l.BlockNumber {
-		//		//log.Trace(fmt.Sprintf("get event txhash=%s repeated,ignore...", l.TxHash.String()))
-		//		continue
-		//	}
-		//	log.Warn(fmt.Sprintf("event tx=%s happened at %d, but now happend at %d ", l.TxHash.String(), doneBlockNumber, l.BlockNumber))
-		//}
-
-		// open,deposit,withdraw事件延迟确认,开关默认关闭,方便测试
-		if params.EnableForkConfirm && needConfirm(eventName) {
-			if be.lastBlockNumber-int64(l.BlockNumber) < params.ForkConfirmNumber {
-				continue
+			log.Warn(fmt.Sprintf("event tx=%s happened at %d, but now happend at %d ", l.TxHash.String(), d.blockNumber, l.BlockNumber))
+		} else if doneBlockNumber, doneBlockHash, delivered := be.chainEventRecordDao.CheckChainEventDelivered(recordID); delivered {
+			//重启前已经处理过了,只恢复流水,不再交给 photon.
+			//startBlockNumber 所在块的事件可能投递了但是还没处理完就退出了,需要再处理一次
+			alreadyDelivered = doneBlockNumber == l.BlockNumber && doneBlockHash == l.BlockHash &&
+				int64(l.BlockNumber) < be.startBlockNumber
+			if !alreadyDelivered {
+				log.Warn(fmt.Sprintf("event tx=%s happened at %d, but now happend at %d ", l.TxHash.String(), doneBlockNumber, l.BlockNumber))
 			}
-			log.Info(fmt.Sprintf("event %s tx=%s happened at %d, confirmed at %d", eventName, l.TxHash.String(), l.BlockNumber, be.lastBlockNumber))
 		}
-		// registry secret事件延迟确认,否则在出现恶意分叉的情况下,中间节点有损失资金的风险
-		if eventName == params.NameSecretRevealed && params.EnableForkConfirm {
-			if be.lastBlockNumber-int64(l.BlockNumber) < params.ForkConfirmNumber {
+		// 延迟确认,否则在出现分叉的情况下,比如注册密码事件被回滚,中间节点有损失资金的风险
+		if confirmBlocks := params.GetEventConfirmBlocks(eventName); confirmBlocks > 0 && !alreadyDelivered {
+			if toBlock-int64(l.BlockNumber) < confirmBlocks {
 				continue
 			}
-			log.Info(fmt.Sprintf("event %s tx=%s happened at %d, confirmed at %d", eventName, l.TxHash.String(), l.BlockNumber, be.lastBlockNumber))
+			log.Info(fmt.Sprintf("event %s tx=%s happened at %d, confirmed at %d", eventName, l.TxHash.String(), l.BlockNumber, toBlock))
 		}
+		var scs []mediatedtransfer.ContractStateChange
 
 		switch eventName {
 		case params.NameTokenNetworkCreated:
@@ -349,89 +510,89 @@ func (be *Events) parseLogsToEvents(logs []types.Log) (stateChanges []mediatedtr
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventTokenNetworkCreated2StateChange(e))
+			scs = append(scs, eventTokenNetworkCreated2StateChange(e))
 		case params.NameSecretRevealed:
 			e, err2 := newEventSecretRevealed(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventSecretRevealed2StateChange(e))
+			scs = append(scs, eventSecretRevealed2StateChange(e))
 		case params.NameChannelOpenedAndDeposit:
 			e, err2 := newEventChannelOpenAndDeposit(&l)
 			if err = err2; err != nil {
 				return
 			}
 			oev, dev := eventChannelOpenAndDeposit2StateChange(e)
-			stateChanges = append(stateChanges, oev)
-			stateChanges = append(stateChanges, dev)
+			scs = append(scs, oev)
+			scs = append(scs, dev)
 		case params.NameChannelNewDeposit:
 			e, err2 := newEventChannelNewDeposit(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelNewDeposit2StateChange(e))
+			scs = append(scs, eventChannelNewDeposit2StateChange(e))
 		case params.NameChannelClosed:
 			e, err2 := newEventChannelClosed(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelClosed2StateChange(e))
+			scs = append(scs, eventChannelClosed2StateChange(e))
 		case params.NameChannelUnlocked:
 			e, err2 := newEventChannelUnlocked(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelUnlocked2StateChange(e))
+			scs = append(scs, eventChannelUnlocked2StateChange(e))
 		case params.NameBalanceProofUpdated:
 			e, err2 := newEventBalanceProofUpdated(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventBalanceProofUpdated2StateChange(e))
+			scs = append(scs, eventBalanceProofUpdated2StateChange(e))
 		case params.NameChannelPunished:
 			e, err2 := newEventChannelPunished(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelPunished2StateChange(e))
+			scs = append(scs, eventChannelPunished2StateChange(e))
 		case params.NameChannelSettled:
 			e, err2 := newEventChannelSettled(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelSettled2StateChange(e))
+			scs = append(scs, eventChannelSettled2StateChange(e))
 		case params.NameChannelCooperativeSettled:
 			e, err2 := newEventChannelCooperativeSettled(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelCooperativeSettled2StateChange(e))
+			scs = append(scs, eventChannelCooperativeSettled2StateChange(e))
 		case params.NameChannelWithdraw:
 			e, err2 := newEventChannelWithdraw(&l)
 			if err = err2; err != nil {
 				return
 			}
-			stateChanges = append(stateChanges, eventChannelWithdraw2StateChange(e))
+			scs = append(scs, eventChannelWithdraw2StateChange(e))
 		default:
 			log.Warn(fmt.Sprintf("receive unkonwn type event from chain : \n%s\n", utils.StringInterface(l, 3)))
 		}
 		// 记录处理流水
-		//be.chainEventRecordDao.NewDeliveredChainEvent(chainEventRecordID, l.BlockNumber)
-		be.txDone[makeEventID(&l)] = l.BlockNumber
+		be.txDone[id] = &deliveredEvent{
+			blockNumber:  l.BlockNumber,
+			blockHash:    l.BlockHash,
+			recordID:     recordID,
+			stateChanges: scs,
+		}
+		be.blockHashes[int64(l.BlockNumber)] = l.BlockHash
+		if alreadyDelivered {
+			continue
+		}
+		be.chainEventRecordDao.NewDeliveredChainEvent(recordID, l.BlockNumber, l.BlockHash)
+		stateChanges = append(stateChanges, scs...)
 	}
 	return
 }
 
-func needConfirm(eventName string) bool {
-
-	if eventName == params.NameChannelOpenedAndDeposit ||
-		eventName == params.NameChannelNewDeposit ||
-		eventName == params.NameChannelWithdraw {
-		return true
-	}
-	return false
-}
-
 //eventChannelSettled2StateChange to stateChange
 func eventChannelSettled2StateChange(ev *contracts.TokensNetworkChannelSettled) *mediatedtransfer.ContractSettledStateChange {
 	return &mediatedtransfer.ContractSettledStateChange{
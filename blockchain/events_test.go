@@ -41,10 +41,13 @@ func (r *fakeRPCModule) GetSecretRegistryAddress() common.Address {
 
 type fakeChainEventRecordDao struct{}
 
-func (f *fakeChainEventRecordDao) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64) {
+func (f *fakeChainEventRecordDao) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64, blockHash common.Hash) {
 	return
 }
-func (f *fakeChainEventRecordDao) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, delivered bool) {
+func (f *fakeChainEventRecordDao) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, blockHash common.Hash, delivered bool) {
+	return
+}
+func (f *fakeChainEventRecordDao) RemoveChainEventRecord(id models.ChainEventID) {
 	return
 }
 func (f *fakeChainEventRecordDao) ClearOldChainEventRecord(blockNumber uint64) {
@@ -134,7 +137,7 @@ func TestEvents_Start2(t *testing.T) {
 		t.Error("NewBlockChainEvents failed")
 	}
 	params.ChainID = big.NewInt(8888)
-	chs, err := be.queryAllStateChange(13362234, 13362238)
+	chs, err := be.queryAllStateChange(13362234, &types.Header{Number: big.NewInt(13362238)}, 13362239)
 	if err != nil {
 		t.Error(err)
 		return
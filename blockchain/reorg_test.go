@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type memChainEventRecordDao struct {
+	records map[models.ChainEventID]*models.ChainEventRecord
+}
+
+func (m *memChainEventRecordDao) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64, blockHash common.Hash) {
+	m.records[id] = &models.ChainEventRecord{ID: id, BlockNumber: blockNumber, BlockHash: blockHash, Status: models.ChainEventStatusDelivered}
+}
+func (m *memChainEventRecordDao) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, blockHash common.Hash, delivered bool) {
+	r, ok := m.records[id]
+	if !ok {
+		return
+	}
+	return r.BlockNumber, r.BlockHash, true
+}
+func (m *memChainEventRecordDao) RemoveChainEventRecord(id models.ChainEventID) {
+	delete(m.records, id)
+}
+func (m *memChainEventRecordDao) ClearOldChainEventRecord(blockNumber uint64) {
+}
+func (m *memChainEventRecordDao) MakeChainEventID(l *types.Log) models.ChainEventID {
+	id := makeEventID(l)
+	return models.ChainEventID(common.Bytes2Hex(id[:]))
+}
+
+func newSecretRevealedLog(secret, txHash common.Hash, blockNumber uint64) types.Log {
+	return types.Log{
+		Topics:      []common.Hash{secretRegistryAbi.Events[params.NameSecretRevealed].Id(), secret},
+		BlockNumber: blockNumber,
+		BlockHash:   utils.NewRandomHash(),
+		TxHash:      txHash,
+	}
+}
+
+func TestEventsRollback(t *testing.T) {
+	dao := &memChainEventRecordDao{records: make(map[models.ChainEventID]*models.ChainEventRecord)}
+	be := NewBlockChainEvents(nil, &fakeRPCModule{}, dao)
+	secret, txHash := utils.NewRandomHash(), utils.NewRandomHash()
+	l := newSecretRevealedLog(secret, txHash, 10)
+	scs, err := be.parseLogsToEvents([]types.Log{l}, 10)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(scs))
+	assert.EqualValues(t, 1, len(dao.records))
+	//同一个块中的事件只处理一次
+	scs, err = be.parseLogsToEvents([]types.Log{l}, 11)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, len(scs))
+
+	//块10被替换,事件没有重新打包
+	be.rollback(10, nil)
+	assert.EqualValues(t, 1, len(be.orphaned))
+	assert.EqualValues(t, secret, be.orphaned[0].(*mediatedtransfer.ContractSecretRevealOnChainStateChange).Secret)
+	assert.EqualValues(t, 0, len(dao.records))
+	be.orphaned = nil
+
+	//重新打包到块11中,再处理一次
+	l2 := newSecretRevealedLog(secret, txHash, 11)
+	scs, err = be.parseLogsToEvents([]types.Log{l2}, 11)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(scs))
+	be.rollback(11, []types.Log{l2})
+	assert.EqualValues(t, 0, len(be.orphaned))
+	scs, err = be.parseLogsToEvents([]types.Log{l2}, 12)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(scs))
+
+	//重启以后,启动块之前已经处理过的事件不再处理
+	be2 := NewBlockChainEvents(nil, &fakeRPCModule{}, dao)
+	be2.startBlockNumber = 12
+	scs, err = be2.parseLogsToEvents([]types.Log{l2}, 12)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, len(scs))
+	assert.NotNil(t, be2.txDone[makeEventID(&l2)])
+	be3 := NewBlockChainEvents(nil, &fakeRPCModule{}, dao)
+	be3.startBlockNumber = 11
+	scs, err = be3.parseLogsToEvents([]types.Log{l2}, 12)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(scs))
+}
+
+func TestEventsConfirmBlocks(t *testing.T) {
+	params.EventConfirmBlocks[params.NameSecretRevealed] = 3
+	defer delete(params.EventConfirmBlocks, params.NameSecretRevealed)
+	assert.True(t, reorgWindow() >= 3)
+	be := NewBlockChainEvents(nil, &fakeRPCModule{}, &memChainEventRecordDao{records: make(map[models.ChainEventID]*models.ChainEventRecord)})
+	l := newSecretRevealedLog(utils.NewRandomHash(), utils.NewRandomHash(), 20)
+	scs, err := be.parseLogsToEvents([]types.Log{l}, 22)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, len(scs))
+	scs, err = be.parseLogsToEvents([]types.Log{l}, 23)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(scs))
+}
@@ -0,0 +1,259 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+/*
+eventSubscription 通过 websocket 订阅新块和合约事件,
+订阅期间新块到达就处理,不再每个周期轮询 HeaderByNumber 和整个 reorgWindow 的 FilterLogs.
+只有缓存中缺少的块才从链上补齐: 刚订阅或者重新订阅以后的整个窗口,跳过的块,分叉替换的块,
+以及 bloom 显示可能有事件但是还没有收到的块.
+*/
+/*
+ *	eventSubscription : subscribes new heads and contract logs over websocket,
+ *	new heads are processed as soon as they arrive instead of polling the whole reorgWindow every period.
+ *	Only blocks missing from the cache are fetched with FilterLogs: the whole window after (re)subscribing,
+ *	skipped blocks, blocks replaced by a reorg and blocks whose bloom says there may be logs not yet received.
+ */
+type eventSubscription struct {
+	headChan    chan *types.Header
+	logChan     chan types.Log
+	headSub     ethereum.Subscription
+	logSub      ethereum.Subscription
+	logs        []types.Log //最近 reorgWindow 块内收到的事件
+	syncedBlock int64       //这个块以及之前的事件都已经在 logs 中了
+}
+
+func (be *Events) contractAddresses() []common.Address {
+	return []common.Address{
+		be.rpcModuleDependency.GetRegistryAddress(),
+		be.rpcModuleDependency.GetSecretRegistryAddress(),
+	}
+}
+
+/*
+subscribe 尝试订阅新块和合约事件,http 连接不支持订阅,以后一直轮询,
+其他错误在 params.EventSubscribeRetryInterval 以后再试
+*/
+func (be *Events) subscribe() {
+	if be.sub != nil || be.noSubscription || time.Now().Before(be.nextSubscribeTime) {
+		return
+	}
+	if be.client.QuorumEnabled() {
+		//推送只来自一个节点,无法交叉校验,quorum 模式下一直轮询
+		be.noSubscription = true
+		log.Info("eth rpc quorum enabled, poll events instead of subscription")
+		return
+	}
+	s := &eventSubscription{
+		headChan:    make(chan *types.Header, 10),
+		logChan:     make(chan types.Log, 100),
+		syncedBlock: -1,
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	defer cancelFunc()
+	var err error
+	s.headSub, err = be.client.SubscribeNewHead(ctx, s.headChan)
+	if err == nil {
+		s.logSub, err = be.client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{Addresses: be.contractAddresses()}, s.logChan)
+		if err != nil {
+			s.headSub.Unsubscribe()
+		}
+	}
+	if err == gethrpc.ErrNotificationsUnsupported {
+		be.noSubscription = true
+		log.Info(fmt.Sprintf("eth rpc endpoint does not support subscription, poll events every %s", be.pollPeriod))
+		return
+	}
+	if err != nil {
+		be.nextSubscribeTime = time.Now().Add(params.EventSubscribeRetryInterval)
+		log.Warn(fmt.Sprintf("subscribe new head and logs err %s, poll events until %s", err, be.nextSubscribeTime))
+		return
+	}
+	log.Info("subscribed new head and contract logs")
+	be.sub = s
+}
+
+func (be *Events) unsubscribe() {
+	if be.sub == nil {
+		return
+	}
+	be.sub.headSub.Unsubscribe()
+	be.sub.logSub.Unsubscribe()
+	be.sub = nil
+}
+
+/*
+waitNewHead 订阅期间等待下一个新块,期间收到的事件先放入缓存.
+订阅出错或者长时间没有新块时返回 nil,由调用者主动查询一次
+*/
+func (be *Events) waitNewHead() (h *types.Header, stopped bool) {
+	s := be.sub
+	idle := time.After(params.EventSubscribeIdlePeriod)
+	for {
+		select {
+		case h = <-s.headChan:
+			//处理慢了只需要最新的块,跳过的块由 getLogs 补齐
+			for {
+				select {
+				case h2 := <-s.headChan:
+					h = h2
+				default:
+					return
+				}
+			}
+		case l := <-s.logChan:
+			s.addLog(l)
+		case err := <-s.headSub.Err():
+			be.onSubscriptionError(err)
+			return
+		case err := <-s.logSub.Err():
+			be.onSubscriptionError(err)
+			return
+		case <-idle:
+			log.Warn(fmt.Sprintf("no new head received in %s, query it", params.EventSubscribeIdlePeriod))
+			return
+		case <-be.stopChan:
+			return nil, true
+		}
+	}
+}
+
+//onSubscriptionError 订阅断开以后先轮询,下次循环重新订阅,重新订阅以后整个窗口的事件从链上补齐
+func (be *Events) onSubscriptionError(err error) {
+	log.Warn(fmt.Sprintf("event subscription err %v, fall back to polling", err))
+	be.unsubscribe()
+}
+
+/*
+getLogs 返回 [fromBlock,latest] 之间的事件,没有订阅时直接从链上获取,
+订阅期间从缓存中获取,forkBlock 开始的块已经被分叉替换,需要重新获取
+*/
+func (be *Events) getLogs(fromBlock int64, latest *types.Header, forkBlock int64) (logs []types.Log, err error) {
+	toBlock := latest.Number.Int64()
+	s := be.sub
+	if s == nil {
+		return be.getLogsFromChain(fromBlock, toBlock)
+	}
+	s.drainLogs()
+	//同一高度上被替换掉的块中的事件不能用
+	s.removeLogs(func(l *types.Log) bool {
+		return int64(l.BlockNumber) == toBlock && l.BlockHash != latest.Hash()
+	})
+	start := s.syncedBlock + 1
+	if start < fromBlock {
+		start = fromBlock
+	}
+	if forkBlock < start {
+		start = forkBlock
+	}
+	if start < toBlock || (start == toBlock && !s.hasLogsInBlock(latest.Hash()) && be.bloomMayContainLogs(latest)) {
+		logs, err = be.getLogsFromChain(start, toBlock)
+		if err != nil {
+			return
+		}
+		s.replaceLogs(start, toBlock, logs)
+	}
+	s.syncedBlock = toBlock
+	return s.logsBetween(fromBlock, toBlock), nil
+}
+
+func (be *Events) bloomMayContainLogs(h *types.Header) bool {
+	for _, addr := range be.contractAddresses() {
+		if types.BloomLookup(h.Bloom, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+//drainLogs 取出已经收到的所有事件,不会阻塞
+func (s *eventSubscription) drainLogs() {
+	for {
+		select {
+		case l := <-s.logChan:
+			s.addLog(l)
+		default:
+			return
+		}
+	}
+}
+
+//addLog 分叉时订阅会重新推送 Removed 的事件
+func (s *eventSubscription) addLog(l types.Log) {
+	same := func(l2 *types.Log) bool {
+		return l2.BlockHash == l.BlockHash && l2.TxHash == l.TxHash && l2.Index == l.Index
+	}
+	if l.Removed {
+		s.removeLogs(same)
+		return
+	}
+	for i := range s.logs {
+		if same(&s.logs[i]) {
+			return
+		}
+	}
+	s.logs = append(s.logs, l)
+}
+
+func (s *eventSubscription) removeLogs(match func(l *types.Log) bool) {
+	logs := s.logs[:0]
+	for i := range s.logs {
+		if !match(&s.logs[i]) {
+			logs = append(logs, s.logs[i])
+		}
+	}
+	s.logs = logs
+}
+
+//replaceLogs 从链上重新获取了 [fromBlock,toBlock] 之间的事件,以链上的为准
+func (s *eventSubscription) replaceLogs(fromBlock, toBlock int64, logs []types.Log) {
+	s.removeLogs(func(l *types.Log) bool {
+		return int64(l.BlockNumber) >= fromBlock && int64(l.BlockNumber) <= toBlock
+	})
+	for _, l := range logs {
+		s.addLog(l)
+	}
+}
+
+func (s *eventSubscription) hasLogsInBlock(blockHash common.Hash) bool {
+	for i := range s.logs {
+		if s.logs[i].BlockHash == blockHash {
+			return true
+		}
+	}
+	return false
+}
+
+//logsBetween 按照块号和事件序号排序,和 FilterLogs 的返回一致
+func (s *eventSubscription) logsBetween(fromBlock, toBlock int64) (logs []types.Log) {
+	for _, l := range s.logs {
+		if int64(l.BlockNumber) >= fromBlock && int64(l.BlockNumber) <= toBlock {
+			logs = append(logs, l)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+	return
+}
+
+func (s *eventSubscription) prune(belowBlock int64) {
+	s.removeLogs(func(l *types.Log) bool {
+		return int64(l.BlockNumber) < belowBlock
+	})
+}
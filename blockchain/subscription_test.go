@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventSubscriptionCache(t *testing.T) {
+	be := NewBlockChainEvents(nil, &fakeRPCModule{}, &memChainEventRecordDao{records: make(map[models.ChainEventID]*models.ChainEventRecord)})
+	s := &eventSubscription{
+		logChan:     make(chan types.Log, 10),
+		syncedBlock: 9,
+	}
+	be.sub = s
+	h10 := &types.Header{Number: big.NewInt(10)}
+	l10 := newSecretRevealedLog(utils.NewRandomHash(), utils.NewRandomHash(), 10)
+	l10.BlockHash = h10.Hash()
+	//下一个块的事件先到
+	l11 := newSecretRevealedLog(utils.NewRandomHash(), utils.NewRandomHash(), 11)
+	s.logChan <- l11
+	s.logChan <- l10
+	s.logChan <- l10
+	//缓存中已经有新块的事件,不需要查询链
+	logs, err := be.getLogs(0, h10, 11)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []types.Log{l10}, logs)
+	assert.EqualValues(t, 10, s.syncedBlock)
+	assert.EqualValues(t, 2, len(s.logs))
+
+	//块11被同一高度的其他块替换了
+	h11 := &types.Header{Number: big.NewInt(11), ParentHash: h10.Hash()}
+	logs, err = be.getLogs(0, h11, 11)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(logs))
+	assert.EqualValues(t, 1, len(s.logs))
+
+	//分叉时推送的 Removed 事件
+	l10.Removed = true
+	s.addLog(l10)
+	assert.EqualValues(t, 0, len(s.logsBetween(0, 11)))
+
+	s.addLog(l11)
+	s.prune(11)
+	assert.EqualValues(t, []types.Log{l11}, s.logs)
+	s.prune(12)
+	assert.EqualValues(t, 0, len(s.logs))
+}
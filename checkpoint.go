@@ -0,0 +1,196 @@
+package photon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/helper"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+makeCheckpoint 用我已经处理到的块生成通道网络快照并签名.
+先读块号再读通道,读取期间新打开的通道会被导入方在 reorgWindow 内重新处理一次,重复是没有问题的
+*/
+func (rs *Service) makeCheckpoint() (cp *models.Checkpoint, err error) {
+	cp = &models.Checkpoint{
+		ChainID:         params.ChainID.Int64(),
+		RegistryAddress: rs.Chain.GetRegistryAddress(),
+		BlockNumber:     rs.dao.GetLatestBlockNumber(),
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	h, err := rs.Chain.Client.HeaderByNumber(ctx, big.NewInt(cp.BlockNumber))
+	cancelFunc()
+	if err != nil {
+		return
+	}
+	cp.BlockHash = h.Hash()
+	tokens, err := rs.dao.GetAllTokens()
+	if err != nil {
+		return
+	}
+	for token := range tokens {
+		cp.Tokens = append(cp.Tokens, token)
+	}
+	sort.Slice(cp.Tokens, func(i, j int) bool {
+		return bytes.Compare(cp.Tokens[i][:], cp.Tokens[j][:]) < 0
+	})
+	for _, token := range cp.Tokens {
+		channels, edges, err2 := rs.dao.GetAllNonParticipantChannelIdentifiersByToken(token)
+		if err2 != nil {
+			return nil, err2
+		}
+		for i, c := range channels {
+			cp.Channels = append(cp.Channels, &models.CheckpointChannel{
+				TokenAddress:      token,
+				ChannelIdentifier: c,
+				Participant1:      edges[2*i],
+				Participant2:      edges[2*i+1],
+			})
+		}
+	}
+	err = cp.Sign(rs.PrivateKey)
+	return
+}
+
+//ReadCheckpointFile 读取 /api/1/checkpoint 导出的快照文件
+func ReadCheckpointFile(path string) (cp *models.Checkpoint, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	cp = new(models.Checkpoint)
+	err = json.Unmarshal(data, cp)
+	return
+}
+
+//FetchCheckpoint 从其他 supernode 的 /api/1/checkpoint 获取快照
+func FetchCheckpoint(url string) (cp *models.Checkpoint, err error) {
+	client := &http.Client{Timeout: time.Minute}
+	r, err := client.Get(strings.TrimRight(url, "/") + "/api/1/checkpoint")
+	if err != nil {
+		return
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status=%d body=%s", r.StatusCode, string(body))
+	}
+	var resp dto.APIResponse
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return
+	}
+	if resp.ErrorCode != dto.SUCCESS {
+		return nil, fmt.Errorf("errorCode=%d,errorMsg=%s", resp.ErrorCode, resp.ErrorMsg)
+	}
+	cp = new(models.Checkpoint)
+	err = json.Unmarshal(resp.Data, cp)
+	return
+}
+
+/*
+verifyCheckpoint 快照必须由 trustedSigner 签名,并且属于我正在使用的链和合约.
+快照中不能有我参与的通道,因为快照中没有通道的余额和 balance proof,无法恢复
+*/
+func verifyCheckpoint(cp *models.Checkpoint, trustedSigner, me, registry common.Address) error {
+	if trustedSigner == utils.EmptyAddress {
+		return fmt.Errorf("checkpoint signer must be specified")
+	}
+	if cp.Signer != trustedSigner {
+		return fmt.Errorf("checkpoint is signed by %s, not trusted", cp.Signer.String())
+	}
+	err := cp.VerifySignature()
+	if err != nil {
+		return err
+	}
+	if cp.ChainID != params.ChainID.Int64() {
+		return fmt.Errorf("checkpoint chain id %d, but connected to chain %s", cp.ChainID, params.ChainID)
+	}
+	if cp.RegistryAddress != registry {
+		return fmt.Errorf("checkpoint registry %s, but use registry %s", cp.RegistryAddress.String(), registry.String())
+	}
+	if cp.BlockNumber <= 0 {
+		return fmt.Errorf("checkpoint block number %d invalid", cp.BlockNumber)
+	}
+	for _, c := range cp.Channels {
+		if c.Participant1 == me || c.Participant2 == me {
+			return fmt.Errorf("checkpoint contains my channel %s, can not fast sync", c.ChannelIdentifier.String())
+		}
+	}
+	return nil
+}
+
+//applyCheckpoint 导入快照中的 token 和通道,已经存在的跳过,这样导入中途失败以后可以重新导入
+func applyCheckpoint(cp *models.Checkpoint, dao models.Dao) error {
+	tokens, err := dao.GetAllTokens()
+	if err != nil {
+		return err
+	}
+	for _, token := range cp.Tokens {
+		if _, ok := tokens[token]; ok {
+			continue
+		}
+		err = dao.AddToken(token, utils.EmptyAddress)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range cp.Channels {
+		if _, _, _, err = dao.GetNonParticipantChannelByID(c.ChannelIdentifier); err == nil {
+			continue
+		}
+		err = dao.NewNonParticipantChannel(c.TokenAddress, c.ChannelIdentifier, c.Participant1, c.Participant2)
+		if err != nil {
+			return err
+		}
+	}
+	dao.SaveLatestBlockNumber(cp.BlockNumber)
+	return nil
+}
+
+/*
+ApplyCheckpoint 第一次启动时导入快照,以后只处理 cp.BlockNumber 之后的事件.
+快照的块必须在我连接的链上,否则快照来自一条分叉
+*/
+func ApplyCheckpoint(cp *models.Checkpoint, trustedSigner, me, registry common.Address, dao models.Dao, client *helper.SafeEthClient) error {
+	err := verifyCheckpoint(cp, trustedSigner, me, registry)
+	if err != nil {
+		return err
+	}
+	if dao.GetLatestBlockNumber() > cp.BlockNumber {
+		return fmt.Errorf("already processed block %d, checkpoint at block %d is useless", dao.GetLatestBlockNumber(), cp.BlockNumber)
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	h, err := client.HeaderByNumber(ctx, big.NewInt(cp.BlockNumber))
+	cancelFunc()
+	if err != nil {
+		return err
+	}
+	if h.Hash() != cp.BlockHash {
+		return fmt.Errorf("checkpoint block %d hash %s, but %s on chain", cp.BlockNumber, cp.BlockHash.String(), h.Hash().String())
+	}
+	err = applyCheckpoint(cp, dao)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("fast sync from checkpoint at block %d signed by %s, %d tokens, %d channels",
+		cp.BlockNumber, utils.APex2(cp.Signer), len(cp.Tokens), len(cp.Channels)))
+	return nil
+}
@@ -0,0 +1,91 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	params.ChainID = big.NewInt(8888)
+	key, signer := utils.MakePrivateKeyAddress()
+	me, registry, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	cp := &models.Checkpoint{
+		ChainID:         8888,
+		RegistryAddress: registry,
+		BlockNumber:     1000,
+		BlockHash:       utils.NewRandomHash(),
+		Tokens:          []common.Address{token},
+		Channels: []*models.CheckpointChannel{{
+			TokenAddress:      token,
+			ChannelIdentifier: utils.NewRandomHash(),
+			Participant1:      utils.NewRandomAddress(),
+			Participant2:      utils.NewRandomAddress(),
+		}},
+	}
+	assert.Nil(t, cp.Sign(key))
+	assert.Nil(t, verifyCheckpoint(cp, signer, me, registry))
+	assert.NotNil(t, verifyCheckpoint(cp, utils.NewRandomAddress(), me, registry))
+	assert.NotNil(t, verifyCheckpoint(cp, signer, me, utils.NewRandomAddress()))
+	//快照被篡改
+	cp.BlockNumber++
+	assert.NotNil(t, verifyCheckpoint(cp, signer, me, registry))
+	cp.BlockNumber--
+	//有我参与的通道,无法恢复
+	cp.Channels[0].Participant2 = me
+	assert.Nil(t, cp.Sign(key))
+	assert.NotNil(t, verifyCheckpoint(cp, signer, me, registry))
+
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	assert.Nil(t, applyCheckpoint(cp, dao))
+	//可以重复导入
+	assert.Nil(t, applyCheckpoint(cp, dao))
+	assert.EqualValues(t, 1000, dao.GetLatestBlockNumber())
+	tokens, err := dao.GetAllTokens()
+	assert.Nil(t, err)
+	assert.Contains(t, tokens, token)
+	channels, _, err := dao.GetAllNonParticipantChannelIdentifiersByToken(token)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []common.Hash{cp.Channels[0].ChannelIdentifier}, channels)
+}
+
+func TestCheckpointSignDataBoundary(t *testing.T) {
+	key, _ := utils.MakePrivateKeyAddress()
+	cp := &models.Checkpoint{
+		ChainID:         8888,
+		RegistryAddress: utils.NewRandomAddress(),
+		BlockNumber:     1000,
+		BlockHash:       utils.NewRandomHash(),
+	}
+	//5 个通道正好是 23 个 token 的长度
+	var data []byte
+	for i := 0; i < 5; i++ {
+		ch := &models.CheckpointChannel{
+			TokenAddress:      utils.NewRandomAddress(),
+			ChannelIdentifier: utils.NewRandomHash(),
+			Participant1:      utils.NewRandomAddress(),
+			Participant2:      utils.NewRandomAddress(),
+		}
+		cp.Channels = append(cp.Channels, ch)
+		data = append(data, ch.TokenAddress[:]...)
+		data = append(data, ch.ChannelIdentifier[:]...)
+		data = append(data, ch.Participant1[:]...)
+		data = append(data, ch.Participant2[:]...)
+	}
+	assert.Nil(t, cp.Sign(key))
+	assert.Nil(t, cp.VerifySignature())
+	forged := *cp
+	forged.Channels = nil
+	for i := 0; i < len(data); i += common.AddressLength {
+		forged.Tokens = append(forged.Tokens, common.BytesToAddress(data[i:i+common.AddressLength]))
+	}
+	assert.EqualValues(t, 23, len(forged.Tokens))
+	assert.NotNil(t, forged.VerifySignature())
+}
@@ -95,6 +95,10 @@ func StartMain() (*photon.API, error) {
 	           'Also accepts a protocol prefix (ws:// or ipc channel) with optional port',`,
 			Value: node.DefaultIPCEndpoint("geth"),
 		},
+		cli.IntFlag{
+			Name:  "eth-rpc-quorum",
+			Usage: "when eth-rpc-endpoint contains several endpoints separated by comma, header and log queries must be answered identically by this many endpoints. 0 means no cross check",
+		},
 		cli.StringFlag{
 			Name:  "registry-contract-address",
 			Usage: `hex encoded address of the registry contract.it's the token network contract address '`,
@@ -168,11 +172,75 @@ func StartMain() (*photon.API, error) {
 		},
 		cli.StringFlag{
 			Name:  "pfs",
-			Usage: "pathfinder service host,example http://transport01.smartmesh.cn:7000,default ",
+			Usage: "pathfinder service host,multiple hosts are separated by comma and used in order,example http://transport01.smartmesh.cn:7000,default ",
+		},
+		cli.StringFlag{
+			Name:  "pfs-server",
+			Usage: "start built-in pathfinder service on this address,example 127.0.0.1:9001,used as pfs if --pfs is not set",
+		},
+		cli.StringFlag{
+			Name:  "auto-rebalance",
+			Usage: "keep our share of balance in every channel within low,high percent by circular transfers to ourselves,example 20,80,default is disabled",
+		},
+		cli.Int64Flag{
+			Name:  "auto-rebalance-fee-percent",
+			Usage: "max fee of auto rebalance is amount/auto-rebalance-fee-percent,0 means no fee allowed",
+			Value: 1000,
+		},
+		cli.BoolFlag{
+			Name:  "enable-trampoline",
+			Usage: "compute the rest of the route for mobile nodes which specify me as their trampoline",
+		},
+		cli.Int64Flag{
+			Name:  "trampoline-fee-percent",
+			Usage: "trampoline fee is amount/trampoline-fee-percent besides the fee of the channel,0 means no extra fee",
+			Value: 10000,
+		},
+		cli.BoolFlag{
+			Name:  "enable-watchtower",
+			Usage: "watch channels for mobile nodes which delegate their channels to me",
+		},
+		cli.Int64Flag{
+			Name:  "watchtower-fee",
+			Usage: "tokens should be paid to me before delegating a channel,0 means free",
+			Value: 0,
+		},
+		cli.StringFlag{
+			Name:  "watchtowers",
+			Usage: "delegate my channels to these watchtowers automatically, address@url separated by comma, e.g. 0x3bc7726c489e617571792ac0cd8b70df8a5d0e22@http://127.0.0.1:5001",
+		},
+		cli.Int64Flag{
+			Name:  "watchtower-pay-fee",
+			Usage: "tokens paid to each watchtower before delegating a channel, should be the --watchtower-fee of the watchtowers,0 means free",
+			Value: 0,
+		},
+		cli.StringFlag{
+			Name:  "checkpoint-file",
+			Usage: "on first startup, import this checkpoint exported by /api/1/checkpoint and only process events after its block",
+		},
+		cli.StringFlag{
+			Name:  "checkpoint-url",
+			Usage: "on first startup, fetch checkpoint from this supernode, e.g. http://127.0.0.1:5001",
+		},
+		cli.StringFlag{
+			Name:  "checkpoint-signer",
+			Usage: "only accept checkpoint signed by this address, required by --checkpoint-file and --checkpoint-url",
+		},
+		cli.BoolFlag{
+			Name:  "auto-settle",
+			Usage: "unlock and settle closed channels automatically, the queue can be queried by /api/1/settle_queue",
+		},
+		cli.StringFlag{
+			Name:  "auto-unlock-min-amount",
+			Usage: "locks with amount less than this are not worth the gas to unlock on chain, used with --auto-settle",
 		},
 		cli.BoolFlag{
 			Name:  "enable-fork-confirm",
-			Usage: "enable fork confirm when receive events from chain,default is false,default is disabled",
+			Usage: "shortcut of --event-confirm-blocks with open,deposit,withdraw and secret registered events confirmed after 17 blocks",
+		},
+		cli.StringFlag{
+			Name:  "event-confirm-blocks",
+			Usage: "confirmation depth of each contract event, e.g. ChannelClosed=6,SecretRevealed=17. events without depth are processed immediately",
 		},
 		cli.StringFlag{
 			Name:  "http-username",
@@ -306,6 +374,11 @@ func mainCtx(ctx *cli.Context) (err error) {
 		err = fmt.Errorf("cannot connect to geth :%s err=%s", cfg.EthRPCEndPoint, err)
 		err = nil
 	}
+	err = client.SetQuorum(cfg.EthRPCQuorum)
+	if err != nil {
+		client.Close()
+		return
+	}
 	// open db
 	var dao models.Dao
 	err = checkDbMeta(cfg.DataBasePath, "boltdb")
@@ -383,6 +456,12 @@ func mainCtx(ctx *cli.Context) (err error) {
 			client.Close()
 			return
 		}
+		err = loadCheckpoint(cfg, dao, client)
+		if err != nil {
+			dao.CloseDB()
+			client.Close()
+			return
+		}
 		dao.SaveContractStatus(models.ContractStatus{
 			RegistryAddress:       cfg.RegistryAddress,
 			SecretRegistryAddress: secretRegisteryAddress,
@@ -486,6 +565,7 @@ func regQuitHandler(api *photon.API) {
 func config(ctx *cli.Context) (config *params.Config, err error) {
 	config = &params.DefaultConfig
 	config.EthRPCEndPoint = ctx.String("eth-rpc-endpoint")
+	config.EthRPCQuorum = ctx.Int("eth-rpc-quorum")
 
 	listenhost, listenport, err := net.SplitHostPort(ctx.String("listen-address"))
 	if err != nil {
@@ -583,10 +663,91 @@ func config(ctx *cli.Context) (config *params.Config, err error) {
 		}
 	}
 	config.PfsHost = ctx.String("pfs")
+	if ctx.IsSet("pfs-server") {
+		config.PfsServerListen = ctx.String("pfs-server")
+		//没有指定外部的 pfs 时使用内置的 pfs
+		if config.PfsHost == "" {
+			config.PfsHost = "http://" + config.PfsServerListen
+		}
+	}
+
+	if ctx.IsSet("auto-rebalance") {
+		band := strings.Split(ctx.String("auto-rebalance"), ",")
+		if len(band) != 2 {
+			err = fmt.Errorf("auto-rebalance should be low,high")
+			return
+		}
+		config.AutoRebalanceLow, err = strconv.Atoi(strings.TrimSpace(band[0]))
+		if err != nil {
+			return
+		}
+		config.AutoRebalanceHigh, err = strconv.Atoi(strings.TrimSpace(band[1]))
+		if err != nil {
+			return
+		}
+		if config.AutoRebalanceLow <= 0 || config.AutoRebalanceHigh <= config.AutoRebalanceLow || config.AutoRebalanceHigh >= 100 {
+			err = fmt.Errorf("auto-rebalance should be 0<low<high<100")
+			return
+		}
+	}
+	config.AutoRebalanceFeePercent = ctx.Int64("auto-rebalance-fee-percent")
+	config.EnableTrampoline = ctx.Bool("enable-trampoline")
+	if config.EnableTrampoline && params.MobileMode {
+		err = fmt.Errorf("mobile node can not be a trampoline")
+		return
+	}
+	config.TrampolineFeePercent = ctx.Int64("trampoline-fee-percent")
+	config.EnableWatchtower = ctx.Bool("enable-watchtower")
+	if config.EnableWatchtower && params.MobileMode {
+		err = fmt.Errorf("mobile node can not be a watchtower")
+		return
+	}
+	config.WatchtowerFee = ctx.Int64("watchtower-fee")
+	if towers := ctx.String("watchtowers"); towers != "" {
+		for _, t := range strings.Split(towers, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				if _, _, err = photon.ParseWatchtower(t); err != nil {
+					return
+				}
+				config.Watchtowers = append(config.Watchtowers, t)
+			}
+		}
+	}
+	config.WatchtowerPayFee = ctx.Int64("watchtower-pay-fee")
+	config.CheckpointFile = ctx.String("checkpoint-file")
+	config.CheckpointURL = ctx.String("checkpoint-url")
+	if config.CheckpointFile != "" && config.CheckpointURL != "" {
+		err = fmt.Errorf("--checkpoint-file and --checkpoint-url can not be used together")
+		return
+	}
+	if signer := ctx.String("checkpoint-signer"); signer != "" {
+		config.CheckpointSigner, err = utils.HexToAddress(signer)
+		if err != nil {
+			err = fmt.Errorf("arg checkpoint-signer err %s", err)
+			return
+		}
+	}
+	config.AutoSettle = ctx.Bool("auto-settle")
+	if amount := ctx.String("auto-unlock-min-amount"); amount != "" {
+		var ok bool
+		config.AutoUnlockMinAmount, ok = new(big.Int).SetString(amount, 10)
+		if !ok || config.AutoUnlockMinAmount.Sign() < 0 {
+			err = fmt.Errorf("arg auto-unlock-min-amount err %s", amount)
+			return
+		}
+	}
 
 	if ctx.Bool("enable-fork-confirm") {
 		log.Info("fork-confirm enable...")
-		params.EnableForkConfirm = true
+		for _, name := range params.ForkConfirmEvents {
+			params.EventConfirmBlocks[name] = params.ForkConfirmNumber
+		}
+	}
+	if s := ctx.String("event-confirm-blocks"); s != "" {
+		err = params.ParseEventConfirmBlocks(s)
+		if err != nil {
+			return
+		}
 	}
 	if ctx.IsSet("http-username") && ctx.IsSet("http-password") {
 		config.HTTPUsername = ctx.String("http-username")
@@ -763,6 +924,29 @@ func getPrivateKey(ctx *cli.Context) (privateKey *ecdsa.PrivateKey, err error) {
 	return crypto.ToECDSA(keyBin)
 }
 
+/*
+loadCheckpoint 第一次启动时如果配置了快照,导入以后从快照的块开始处理事件.
+在保存合约信息之前导入,中途失败的话下次启动还是第一次启动,可以重新导入
+*/
+func loadCheckpoint(cfg *params.Config, dao models.Dao, client *helper.SafeEthClient) (err error) {
+	var cp *models.Checkpoint
+	if cfg.CheckpointFile != "" {
+		cp, err = photon.ReadCheckpointFile(cfg.CheckpointFile)
+	} else if cfg.CheckpointURL != "" {
+		cp, err = photon.FetchCheckpoint(cfg.CheckpointURL)
+	} else {
+		return
+	}
+	if err != nil {
+		return fmt.Errorf("load checkpoint err %s", err)
+	}
+	err = photon.ApplyCheckpoint(cp, cfg.CheckpointSigner, cfg.MyAddress, cfg.RegistryAddress, dao, client)
+	if err != nil {
+		return fmt.Errorf("apply checkpoint err %s", err)
+	}
+	return
+}
+
 func getRegistryAddress(config *params.Config, dao models.Dao, client *helper.SafeEthClient) (registryAddress common.Address, isFirstStartUp, hasConnectedChain bool, err error) {
 	dbRegistryAddress := dao.GetContractStatus().RegistryAddress
 	isFirstStartUp = dbRegistryAddress == utils.EmptyAddress
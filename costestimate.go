@@ -0,0 +1,173 @@
+package photon
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+/*
+链上操作的费用:
+1. estimateChannelCost 对每种通道操作通过合约绑定调用 EstimateGas,乘以现在发送时会使用的 gas price.
+	需要对方签名的操作(withdraw, cooperative settle)以及通道当前状态下还不能进行的操作,EstimateGas 会失败,
+	这时使用我以前同类 tx 的平均 gas,没有历史的话使用 defaultTXGas
+2. 每个 tx 打包以后消耗的 gas 按通道和类型累计在 models.GasSpent 中
+*/
+/*
+ *	Cost of on-chain operations :
+ *	1. estimateChannelCost runs EstimateGas through the contract bindings for every channel operation, times the gas price used to send it now.
+ *		EstimateGas fails for operations needing the partner's signature (withdraw, cooperative settle) and operations not allowed in
+ *		the current state of the channel, then the average gas of my former txs of the type is used, or defaultTXGas without history.
+ *	2. gas used by every mined tx is accumulated per channel and type in models.GasSpent.
+ */
+
+//没有估计结果也没有历史记录时使用的大致 gas 消耗
+var defaultTXGas = map[models.TXInfoType]uint64{
+	models.TXInfoTypeDeposit:         150000,
+	models.TXInfoTypeClose:           100000,
+	models.TXInfoTypeWithdraw:        100000,
+	models.TXInfoTypeCooperateSettle: 90000,
+	models.TXInfoTypeSettle:          80000,
+}
+
+//费用估计中 gas 的来源
+const (
+	TXCostSourceEstimate = "estimate" //EstimateGas 的结果
+	TXCostSourceHistory  = "history"  //我以前同类 tx 的平均值
+	TXCostSourceDefault  = "default"  //defaultTXGas
+)
+
+//TXCost 一种链上操作的费用估计
+type TXCost struct {
+	Type          models.TXInfoType `json:"type"`
+	Gas           uint64            `json:"gas"`
+	GasPrice      *big.Int          `json:"gas_price"`
+	Cost          *big.Int          `json:"cost"` // Gas*GasPrice,单位 wei
+	Source        string            `json:"source"`
+	EstimateError string            `json:"estimate_error,omitempty"`
+}
+
+//GasSpentSummary 累计消耗的 gas
+type GasSpentSummary struct {
+	TotalGasUsed uint64             `json:"total_gas_used"`
+	TotalCost    *big.Int           `json:"total_cost"`
+	Types        []*models.GasSpent `json:"types"`    // 按 tx 类型汇总,不区分通道
+	Channels     []*models.GasSpent `json:"channels"` // 每个通道上每种类型的 tx
+}
+
+/*
+estimateChannelCost 我和 partner 之间没有通道时只估计打开通道,否则估计通道上的各种操作
+*/
+func (rs *Service) estimateChannelCost(tokenAddress, partnerAddress common.Address, amount *big.Int) (costs []*TXCost, err error) {
+	tokenNetwork, err := rs.Chain.TokenNetwork(tokenAddress)
+	if err != nil {
+		return
+	}
+	history, err := rs.dao.GetGasSpentList(utils.EmptyHash)
+	if err != nil {
+		return
+	}
+	averages := summarizeGasSpent(history).Types
+	contract := tokenNetwork.GetContract()
+	estimate := func(txType models.TXInfoType, send func(opts *bind.TransactOpts) (*types.Transaction, error)) {
+		gas, gasPrice, err2 := rs.Chain.EstimateTX(txType, send)
+		costs = append(costs, newTXCost(txType, gas, gasPrice, err2, averages))
+	}
+	c, err := rs.dao.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		err = nil
+		estimate(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			return contract.Deposit(opts, tokenAddress, rs.NodeAddress, partnerAddress, amount, uint64(rs.Config.SettleTimeout))
+		})
+		return
+	}
+	estimate(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Deposit(opts, tokenAddress, rs.NodeAddress, partnerAddress, amount, uint64(c.SettleTimeout))
+	})
+	bp := c.PartnerBalanceProof
+	estimate(models.TXInfoTypeClose, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.PrepareSettle(opts, tokenAddress, partnerAddress, bigOrZero(bp.TransferAmount), bp.LocksRoot, bp.Nonce, bp.MessageHash, bp.Signature)
+	})
+	estimate(models.TXInfoTypeWithdraw, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.WithDraw(opts, tokenAddress, rs.NodeAddress, partnerAddress, c.OurBalance(), amount, nil, nil)
+	})
+	estimate(models.TXInfoTypeCooperateSettle, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.CooperativeSettle(opts, tokenAddress, rs.NodeAddress, c.OurBalance(), partnerAddress, c.PartnerBalance(), nil, nil)
+	})
+	estimate(models.TXInfoTypeSettle, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Settle(opts, tokenAddress, rs.NodeAddress, bigOrZero(c.OurBalanceProof.ContractTransferAmount), c.OurBalanceProof.ContractLocksRoot,
+			partnerAddress, bigOrZero(bp.ContractTransferAmount), bp.ContractLocksRoot)
+	})
+	return
+}
+
+/*
+newTXCost EstimateGas 失败时使用历史平均值或者 defaultTXGas
+*/
+func newTXCost(txType models.TXInfoType, gas uint64, gasPrice *big.Int, estimateErr error, history []*models.GasSpent) *TXCost {
+	c := &TXCost{
+		Type:     txType,
+		Gas:      gas,
+		GasPrice: gasPrice,
+		Source:   TXCostSourceEstimate,
+	}
+	if estimateErr != nil {
+		c.EstimateError = estimateErr.Error()
+		c.Gas, c.Source = defaultTXGas[txType], TXCostSourceDefault
+		for _, g := range history {
+			if g.Type == txType && g.TXCount > 0 {
+				c.Gas, c.Source = g.GasUsed/uint64(g.TXCount), TXCostSourceHistory
+			}
+		}
+	}
+	c.Cost = new(big.Int).Mul(new(big.Int).SetUint64(c.Gas), gasPrice)
+	return c
+}
+
+//summarizeGasSpent 按 tx 类型汇总每个通道的记录
+func summarizeGasSpent(list []*models.GasSpent) *GasSpentSummary {
+	s := &GasSpentSummary{
+		TotalCost: big.NewInt(0),
+		Channels:  list,
+	}
+	byType := make(map[models.TXInfoType]*models.GasSpent)
+	for _, g := range list {
+		t := byType[g.Type]
+		if t == nil {
+			t = &models.GasSpent{Type: g.Type, Cost: big.NewInt(0)}
+			byType[g.Type] = t
+			s.Types = append(s.Types, t)
+		}
+		t.TXCount += g.TXCount
+		t.GasUsed += g.GasUsed
+		t.Cost.Add(t.Cost, g.Cost)
+		s.TotalGasUsed += g.GasUsed
+		s.TotalCost.Add(s.TotalCost, g.Cost)
+	}
+	sort.Slice(s.Types, func(i, j int) bool {
+		return s.Types[i].Type < s.Types[j].Type
+	})
+	return s
+}
+
+//getGasSpent channelIdentifier 为空时汇总所有通道
+func (rs *Service) getGasSpent(channelIdentifier common.Hash) (*GasSpentSummary, error) {
+	list, err := rs.dao.GetGasSpentList(channelIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeGasSpent(list), nil
+}
+
+//合约参数不能是 nil
+func bigOrZero(x *big.Int) *big.Int {
+	if x == nil {
+		return big.NewInt(0)
+	}
+	return x
+}
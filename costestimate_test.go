@@ -0,0 +1,50 @@
+package photon
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTXCost(t *testing.T) {
+	c1, c2 := utils.NewRandomHash(), utils.NewRandomHash()
+	var list []*models.GasSpent
+	for _, c := range []struct {
+		channel  common.Hash
+		txType   models.TXInfoType
+		gasUsed  uint64
+		gasPrice uint64
+	}{
+		{c1, models.TXInfoTypeClose, 90000, 10},
+		{c2, models.TXInfoTypeClose, 110000, 20},
+		{c2, models.TXInfoTypeSettle, 70000, 10},
+	} {
+		g := &models.GasSpent{ChannelIdentifier: c.channel, Type: c.txType}
+		g.Add(c.gasUsed, c.gasPrice)
+		list = append(list, g)
+	}
+	s := summarizeGasSpent(list)
+	assert.EqualValues(t, 270000, s.TotalGasUsed)
+	assert.EqualValues(t, big.NewInt(900000+2200000+700000), s.TotalCost)
+	assert.EqualValues(t, 2, len(s.Types))
+	assert.EqualValues(t, models.TXInfoTypeClose, s.Types[0].Type)
+	assert.EqualValues(t, 2, s.Types[0].TXCount)
+
+	price := big.NewInt(100)
+	c := newTXCost(models.TXInfoTypeClose, 50000, price, nil, s.Types)
+	assert.EqualValues(t, TXCostSourceEstimate, c.Source)
+	assert.EqualValues(t, big.NewInt(5000000), c.Cost)
+	//估计失败时使用历史平均值
+	c = newTXCost(models.TXInfoTypeClose, 0, price, errors.New("revert"), s.Types)
+	assert.EqualValues(t, TXCostSourceHistory, c.Source)
+	assert.EqualValues(t, 100000, c.Gas)
+	assert.EqualValues(t, "revert", c.EstimateError)
+	c = newTXCost(models.TXInfoTypeWithdraw, 0, price, errors.New("revert"), s.Types)
+	assert.EqualValues(t, TXCostSourceDefault, c.Source)
+	assert.EqualValues(t, defaultTXGas[models.TXInfoTypeWithdraw], c.Gas)
+}
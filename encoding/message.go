@@ -31,6 +31,12 @@ var MessageVersionControlMap = map[int16]int16{
 	MediatedTransferCmdID: int16(1), // 2019-03 MediatedTransfer消息升级,带上了Path,不兼容verison<1的版本
 }
 
+// MediatedTransferTrampolineVersion 带有 TrampolineOnion 的 MediatedTransfer 的版本号,不带的依然使用 version 1,和老节点兼容
+const MediatedTransferTrampolineVersion = int16(2)
+
+// AckTrampolineVersion 能够处理 MediatedTransferTrampolineVersion 的节点回复的 Ack 的版本号,老节点回复的是 version 0
+const AckTrampolineVersion = int16(1)
+
 //MessageType is the type of message for receive and send
 type MessageType int
 
@@ -232,10 +238,10 @@ type Ack struct {
 	Echo   common.Hash
 }
 
-//NewAck create ack message
+//NewAck create ack message, version tells the receiver we can handle trampoline onion
 func NewAck(sender common.Address, echo common.Hash) *Ack {
 	return &Ack{
-		CmdStruct: CmdStruct{CmdID: AckCmdID},
+		CmdStruct: CmdStruct{CmdID: AckCmdID, Version: AckTrampolineVersion},
 		Sender:    sender,
 		Echo:      echo,
 	}
@@ -331,12 +337,7 @@ func HashMessageWithoutSignature(pack MessagePacker) common.Hash {
 
 //VerifyMessage returns the sender of message if data is a valid SignedMessage
 func VerifyMessage(data []byte) (sender common.Address, err error) {
-	messageData := data[:len(data)-signatureLength]
-	signature := make([]byte, signatureLength)
-	copy(signature, data[len(data)-signatureLength:])
-	hash := utils.Sha3(messageData)
-	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
+	pubkey, err := recoverMessagePubkey(data)
 	if err != nil {
 		return
 	}
@@ -344,6 +345,32 @@ func VerifyMessage(data []byte) (sender common.Address, err error) {
 	return
 }
 
+/*
+RecoverSenderPubkey 从收到的消息的签名中恢复发送方的公钥,不保存在消息中,以免改变消息的编码和比较
+*/
+func RecoverSenderPubkey(msg SignedMessager) (pubkey []byte, err error) {
+	data := msg.Pack()
+	if len(data) <= signatureLength {
+		err = errors.New("message is not signed")
+		return
+	}
+	if e, ok := msg.(interface {
+		recoverPubkey(data []byte) ([]byte, error)
+	}); ok {
+		return e.recoverPubkey(data)
+	}
+	return recoverMessagePubkey(data)
+}
+
+func recoverMessagePubkey(data []byte) (pubkey []byte, err error) {
+	messageData := data[:len(data)-signatureLength]
+	signature := make([]byte, signatureLength)
+	copy(signature, data[len(data)-signatureLength:])
+	hash := utils.Sha3(messageData)
+	signature[len(signature)-1] -= 27 //why?
+	return crypto.Ecrecover(hash[:], signature)
+}
+
 //Ping message
 type Ping struct {
 	SignedMessage
@@ -594,22 +621,26 @@ func (m *EnvelopMessage) String() string {
 		utils.HPex(m.ChannelIdentifier), m.OpenBlockNumber, m.TransferAmount, utils.HPex(m.Locksroot), utils.APex2(m.Sender), len(m.Signature) != 0)
 }
 func (m *EnvelopMessage) signData(datahash common.Hash) []byte {
+	return BalanceProofSignData(m.TransferAmount, m.Locksroot, m.Nonce, datahash, m.ChannelIdentifier, m.OpenBlockNumber)
+}
+
+//BalanceProofSignData returns the data of balance proof signed by the sender, which is verified by the contract
+func BalanceProofSignData(transferAmount *big.Int, locksroot common.Hash, nonce uint64, additionHash, channelIdentifier common.Hash, openBlockNumber int64) []byte {
 	var err error
 	buf := new(bytes.Buffer)
 	_, err = buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractBalanceProofMessageLength))
-	_, err = buf.Write(utils.BigIntTo32Bytes(m.TransferAmount))
-	_, err = buf.Write(m.Locksroot[:])
-	err = binary.Write(buf, binary.BigEndian, m.Nonce)
-	_, err = buf.Write(datahash[:])
-	_, err = buf.Write(m.ChannelIdentifier[:])
-	err = binary.Write(buf, binary.BigEndian, m.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(transferAmount))
+	_, err = buf.Write(locksroot[:])
+	err = binary.Write(buf, binary.BigEndian, nonce)
+	_, err = buf.Write(additionHash[:])
+	_, err = buf.Write(channelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, openBlockNumber)
 	_, err = buf.Write(utils.BigIntTo32Bytes(params.ChainID))
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	dataToSign := buf.Bytes()
-	return dataToSign
+	return buf.Bytes()
 }
 
 /*
@@ -631,6 +662,16 @@ func (m *EnvelopMessage) Sign(privKey *ecdsa.PrivateKey, msg MessagePacker) erro
 
 //verifySignature returns error if is not a valid signature
 func (m *EnvelopMessage) verifySignature(data []byte) error {
+	pubkey, err := m.recoverPubkey(data)
+	if err != nil {
+		return err
+	}
+	m.Sender = utils.PubkeyToAddress(pubkey)
+	return nil
+
+}
+
+func (m *EnvelopMessage) recoverPubkey(data []byte) (pubkey []byte, err error) {
 	dataWithoutSignature := data[:len(data)-signatureLength]
 	datahash := utils.Sha3(dataWithoutSignature)
 	datatosign := m.signData(datahash)
@@ -639,13 +680,7 @@ func (m *EnvelopMessage) verifySignature(data []byte) error {
 	copy(signature, data[len(data)-signatureLength:])
 	hash := utils.Sha3(datatosign)
 	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
-	if err != nil {
-		return err
-	}
-	m.Sender = utils.PubkeyToAddress(pubkey)
-	return nil
-
+	return crypto.Ecrecover(hash[:], signature)
 }
 func (m *EnvelopMessage) checkValid() error {
 	if m.Nonce <= 0 {
@@ -954,13 +989,30 @@ type MediatedTransfer struct {
 	Initiator      common.Address
 	Fee            *big.Int
 	Path           []common.Address // 2019-03 消息升级后,带全路径信息
+	/*
+		TrampolineOnion 还需要经过的 trampoline 节点,Path 的最后一个节点是第一个 trampoline,
+		每一层用这一层 trampoline 的公钥加密,内容是它的下一跳(下一个 trampoline 或者 Target)和里面一层,
+		它剥开自己这一层以后自己计算到下一跳的路由,中间节点看不到后面的 trampoline.
+		只有 version 2 的消息才有这个字段
+	*/
+	TrampolineOnion []byte
 }
 
 //String is fmt.Stringer
 func (m *MediatedTransfer) String() string {
-	return fmt.Sprintf("Message{type=MediatedTransfer expiration=%d,target=%s,initiator=%s,hashlock=%s,amount=%s,fee=%s,path=%s,%s}",
+	return fmt.Sprintf("Message{type=MediatedTransfer expiration=%d,target=%s,initiator=%s,hashlock=%s,amount=%s,fee=%s,path=%s,onion=%d,%s}",
 		m.Expiration, utils.APex2(m.Target), utils.APex2(m.Initiator),
-		utils.HPex(m.LockSecretHash), m.PaymentAmount, m.Fee, m.GetPathStr(), m.EnvelopMessage.String())
+		utils.HPex(m.LockSecretHash), m.PaymentAmount, m.Fee, m.GetPathStr(), len(m.TrampolineOnion), m.EnvelopMessage.String())
+}
+
+//SetTrampolineOnion must be called before sign, message with trampoline onion is version 2
+func (m *MediatedTransfer) SetTrampolineOnion(onion []byte) {
+	m.TrampolineOnion = onion
+	if len(onion) > 0 {
+		m.Version = MediatedTransferTrampolineVersion
+	} else {
+		m.Version = MessageVersionControlMap[MediatedTransferCmdID]
+	}
 }
 
 //NewMediatedTransfer create MediatedTransfer
@@ -1010,6 +1062,10 @@ func (m *MediatedTransfer) Pack() []byte {
 	for _, addr := range m.Path {
 		_, err = buf.Write(addr[:])
 	}
+	if m.Version >= MediatedTransferTrampolineVersion {
+		err = binary.Write(buf, binary.BigEndian, int32(len(m.TrampolineOnion)))
+		_, err = buf.Write(m.TrampolineOnion)
+	}
 	m.EnvelopMessage.pack(buf)
 	if err != nil {
 		log.Crit(fmt.Sprintf("MediatedTransfer Pack err %s", err))
@@ -1046,6 +1102,17 @@ func (m *MediatedTransfer) UnPack(data []byte) error {
 		_, err = buf.Read(addr[:])
 		m.Path = append(m.Path, addr)
 	}
+	if m.Version >= MediatedTransferTrampolineVersion {
+		var onionLen int32
+		err = binary.Read(buf, binary.BigEndian, &onionLen)
+		if err != nil || onionLen < 0 || int(onionLen) > params.MaxTrampolineOnionLen {
+			return fmt.Errorf("MediatedTransfer unpack invalid trampoline onion length %d", onionLen)
+		}
+		m.TrampolineOnion = make([]byte, onionLen)
+		if n, _ := buf.Read(m.TrampolineOnion); n != int(onionLen) {
+			return errPacketLength
+		}
+	}
 	err = m.EnvelopMessage.unpack(buf)
 	if err != nil {
 		return err
@@ -189,6 +189,50 @@ func TestMediatedTransfer(t *testing.T) {
 	}
 }
 
+func TestMediatedTransferWithTrampolineOnion(t *testing.T) {
+	bp := &BalanceProof{
+		Nonce:             11,
+		ChannelIdentifier: utils.Sha3([]byte("123")),
+		TransferAmount:    big.NewInt(12),
+		OpenBlockNumber:   3,
+		Locksroot:         utils.EmptyHash,
+	}
+	lock := &mtree.Lock{
+		Amount:         big.NewInt(34),
+		Expiration:     4589895, //expiration block number
+		LockSecretHash: utils.ShaSecret([]byte("hashlock")),
+	}
+	trampoline := utils.NewRandomAddress()
+	m1 := NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(33), []common.Address{trampoline})
+	m1.SetTrampolineOnion(utils.NewRandomHash().Bytes())
+	if m1.Version != MediatedTransferTrampolineVersion {
+		t.Errorf("version should be %d", MediatedTransferTrampolineVersion)
+		return
+	}
+	err := m1.Sign(GetTestPrivKey(), m1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data := m1.Pack()
+	m2 := new(MediatedTransfer)
+	err = m2.UnPack(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, m1.Path, m2.Path)
+	assert.EqualValues(t, m1.TrampolineOnion, m2.TrampolineOnion)
+	assert.EqualValues(t, m1.Version, m2.Version)
+	assert.EqualValues(t, m1.Fee, m2.Fee)
+	assert.EqualValues(t, m1.Signature, m2.Signature)
+	//without trampoline onion it's the same as version 1
+	m1.SetTrampolineOnion(nil)
+	if m1.Version != MessageVersionControlMap[MediatedTransferCmdID] {
+		t.Error("version should be 1 without trampolines")
+	}
+}
+
 func TestNewAnnounceDisposedTransfer(t *testing.T) {
 	bp := &AnnounceDisposedProof{
 		ChannelIDInMessage: ChannelIDInMessage{
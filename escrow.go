@@ -0,0 +1,311 @@
+package photon
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/mediator"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+)
+
+/*
+托管支付(hold invoice):
+1. 接收方事先登记 lockSecretHash 以及托管条件
+2. 收到对应的锁以后,不发送 SecretRequest, 锁被暂扣
+3. 外部条件满足(api 或者 oracle webhook)以后才向发起方请求密码,交易正常完成
+4. 被拒绝或者在锁过期前 CancelBeforeBlocks 块还没有批准,通过 AnnounceDisposed 放弃该锁,付款方可以安全的收回资金
+*/
+/*
+ *	Escrow (hold invoice) :
+ *	1. target registers a lockSecretHash with its condition beforehand.
+ *	2. when the lock arrives, SecretRequest is not sent and the lock is held.
+ *	3. only after the condition is approved (by api or oracle webhook) the secret is requested and the transfer finishes normally.
+ *	4. if it is rejected or still not approved CancelBeforeBlocks blocks before expiration,
+ *		the lock is given up via AnnounceDisposed, so the payer gets the tokens back safely.
+ */
+
+func (rs *Service) loadEscrowHolds() (err error) {
+	for _, status := range []models.EscrowStatus{models.EscrowStatusWaiting, models.EscrowStatusHolding, models.EscrowStatusCanceled} {
+		var list []*models.EscrowHold
+		list, err = rs.dao.GetEscrowHoldList(status)
+		if err != nil {
+			return
+		}
+		for _, h := range list {
+			//已经处理过的锁不再关注
+			if status == models.EscrowStatusCanceled && h.Expiration > 0 {
+				continue
+			}
+			rs.Key2EscrowHold[h.Key] = h
+		}
+	}
+	return
+}
+
+func (rs *Service) newEscrow(req *escrowReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	if req.CancelBeforeBlocks <= 0 {
+		req.CancelBeforeBlocks = int64(rs.Config.RevealTimeout)
+	}
+	if req.CancelBeforeBlocks < int64(rs.Config.RevealTimeout) {
+		result.Result <- rerr.ErrArgumentError.Printf("cancel_before_blocks must not be less than reveal timeout %d", rs.Config.RevealTimeout)
+		return
+	}
+	smKey := utils.Sha3(req.LockSecretHash[:], req.TokenAddress[:])
+	if rs.Transfer2StateManager[smKey] != nil {
+		result.Result <- rerr.ErrDuplicateTransfer.Append("lock already received,too late to hold it")
+		return
+	}
+	h := &models.EscrowHold{
+		LockSecretHash:     req.LockSecretHash,
+		TokenAddress:       req.TokenAddress,
+		Condition:          req.Condition,
+		OracleToken:        utils.RandomString(32),
+		CancelBeforeBlocks: req.CancelBeforeBlocks,
+		Status:             models.EscrowStatusWaiting,
+		StatusMessage:      "waiting for lock",
+	}
+	err := rs.dao.NewEscrowHold(h)
+	if err != nil {
+		result.Result <- err
+		return
+	}
+	rs.Key2EscrowHold[h.Key] = h
+	result.Tag = h
+	result.Result <- nil
+	return
+}
+
+/*
+escrowHoldSecretRequest 作为接收方准备请求密码,如果这个锁登记了托管条件,那么暂扣住,不发送 SecretRequest.
+如果需要立即放弃该锁,返回对应的 EventSendAnnounceDisposed
+*/
+func (rs *Service) escrowHoldSecretRequest(stateManager *transfer.StateManager) (hold bool, disposed transfer.Event) {
+	state, ok := stateManager.CurrentState.(*mediatedtransfer.TargetState)
+	if !ok {
+		return false, nil
+	}
+	tr := state.FromTransfer
+	h := rs.Key2EscrowHold[models.EscrowHoldKey(tr.Token, tr.LockSecretHash)]
+	if h == nil {
+		return false, nil
+	}
+	h.Initiator = tr.Initiator
+	h.ChannelIdentifier = state.FromRoute.ChannelIdentifier
+	h.Amount = tr.Amount
+	h.Expiration = tr.Expiration
+	if h.Status == models.EscrowStatusCanceled {
+		//锁到达之前就已经拒绝了
+		disposed, _, _ = rs.disposeEscrow(h, stateManager, h.StatusMessage)
+		return true, disposed
+	}
+	h.Status = models.EscrowStatusHolding
+	h.StatusMessage = "lock received,waiting for approval"
+	err := rs.dao.UpdateEscrowHold(h)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateEscrowHold err %s", err))
+	}
+	log.Info(fmt.Sprintf("hold lock lockSecretHash=%s,amount=%s,expiration=%d,cancel at %d",
+		utils.HPex(h.LockSecretHash), h.Amount, h.Expiration, h.CancelBlockNumber()))
+	if rs.GetBlockNumber() >= h.CancelBlockNumber() {
+		disposed, _, _ = rs.disposeEscrow(h, stateManager, "lock too close to expiration")
+	}
+	return true, disposed
+}
+
+func (rs *Service) approveEscrow(req *escrowReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	result.Result <- rs.sendEscrowEvent(rs.escrowApproval(req))
+	return
+}
+
+/*
+escrowApproval 批准托管支付,锁已经到达时返回向发起方请求密码的 EventSendSecretRequest
+*/
+func (rs *Service) escrowApproval(req *escrowReq) (ev transfer.Event, manager *transfer.StateManager, err error) {
+	h, err := rs.getEscrowForUpdate(req)
+	if err != nil {
+		return
+	}
+	if h.Status == models.EscrowStatusWaiting {
+		//锁还没到,批准以后按照普通交易处理即可
+		h.Status = models.EscrowStatusApproved
+		h.StatusMessage = req.Reason
+		delete(rs.Key2EscrowHold, h.Key)
+		err = rs.dao.UpdateEscrowHold(h)
+		return
+	}
+	smKey := utils.Sha3(h.LockSecretHash[:], h.TokenAddress[:])
+	manager = rs.Transfer2StateManager[smKey]
+	if manager == nil {
+		err = rerr.ErrTransferNotFound.Printf("lockSecretHash=%s", h.LockSecretHash.String())
+		return
+	}
+	state, ok := manager.CurrentState.(*mediatedtransfer.TargetState)
+	if !ok {
+		err = rerr.InvalidState("not a target")
+		return
+	}
+	if !mediator.IsSafeToWait(state.FromTransfer, state.FromRoute.RevealTimeout(), rs.GetBlockNumber()) {
+		err = rerr.ErrChannelLockAlreadyExpired.Append("too late to approve,lock is about to expire")
+		return
+	}
+	h.Status = models.EscrowStatusApproved
+	h.StatusMessage = req.Reason
+	delete(rs.Key2EscrowHold, h.Key)
+	err = rs.dao.UpdateEscrowHold(h)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateEscrowHold err %s", err))
+	}
+	ev = &mediatedtransfer.EventSendSecretRequest{
+		ChannelIdentifier: state.FromRoute.ChannelIdentifier,
+		LockSecretHash:    state.FromTransfer.LockSecretHash,
+		Amount:            state.FromTransfer.Amount,
+		Receiver:          state.FromTransfer.Initiator,
+	}
+	return ev, manager, nil
+}
+
+func (rs *Service) cancelEscrow(req *escrowReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	result.Result <- rs.sendEscrowEvent(rs.escrowCancellation(req))
+	return
+}
+
+/*
+escrowCancellation 拒绝托管支付,锁已经到达时返回放弃该锁的 EventSendAnnounceDisposed
+*/
+func (rs *Service) escrowCancellation(req *escrowReq) (ev transfer.Event, manager *transfer.StateManager, err error) {
+	h, err := rs.getEscrowForUpdate(req)
+	if err != nil {
+		return
+	}
+	if h.Status == models.EscrowStatusWaiting {
+		//锁到达以后直接放弃
+		h.Status = models.EscrowStatusCanceled
+		h.StatusMessage = req.Reason
+		err = rs.dao.UpdateEscrowHold(h)
+		return
+	}
+	smKey := utils.Sha3(h.LockSecretHash[:], h.TokenAddress[:])
+	manager = rs.Transfer2StateManager[smKey]
+	if manager == nil {
+		err = rerr.ErrTransferNotFound.Printf("lockSecretHash=%s", h.LockSecretHash.String())
+		return
+	}
+	return rs.disposeEscrow(h, manager, req.Reason)
+}
+
+func (rs *Service) escrowWebhook(req *escrowReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	result.Result <- rs.sendEscrowEvent(rs.escrowWebhookDecision(req))
+	return
+}
+
+func (rs *Service) escrowWebhookDecision(req *escrowReq) (ev transfer.Event, manager *transfer.StateManager, err error) {
+	h := rs.Key2EscrowHold[models.EscrowHoldKey(req.TokenAddress, req.LockSecretHash)]
+	if h == nil || subtle.ConstantTimeCompare([]byte(h.OracleToken), []byte(req.OracleToken)) != 1 {
+		err = rerr.ErrNotFound.Append("no such escrow or oracle token mismatch")
+		return
+	}
+	if req.Approve {
+		return rs.escrowApproval(req)
+	}
+	return rs.escrowCancellation(req)
+}
+
+func (rs *Service) getEscrowForUpdate(req *escrowReq) (h *models.EscrowHold, err error) {
+	h = rs.Key2EscrowHold[models.EscrowHoldKey(req.TokenAddress, req.LockSecretHash)]
+	if h == nil {
+		err = rerr.ErrNotFound.Printf("no pending escrow for lockSecretHash=%s", req.LockSecretHash.String())
+		return
+	}
+	if h.Status != models.EscrowStatusWaiting && h.Status != models.EscrowStatusHolding {
+		err = rerr.InvalidState(fmt.Sprintf("escrow status=%d", h.Status))
+	}
+	return
+}
+
+/*
+disposeEscrow 放弃暂扣的锁,返回的 EventSendAnnounceDisposed 发出以后付款方可以移除该锁
+*/
+func (rs *Service) disposeEscrow(h *models.EscrowHold, stateManager *transfer.StateManager, reason string) (ev transfer.Event, manager *transfer.StateManager, err error) {
+	state, ok := stateManager.CurrentState.(*mediatedtransfer.TargetState)
+	if !ok {
+		err = rerr.InvalidState("not a target")
+		return
+	}
+	h.Status = models.EscrowStatusCanceled
+	h.StatusMessage = reason
+	delete(rs.Key2EscrowHold, h.Key)
+	err = rs.dao.UpdateEscrowHold(h)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateEscrowHold err %s", err))
+	}
+	ev = &mediatedtransfer.EventSendAnnounceDisposed{
+		Amount:         state.FromTransfer.Amount,
+		LockSecretHash: state.FromTransfer.LockSecretHash,
+		Expiration:     state.FromTransfer.Expiration,
+		Token:          state.FromTransfer.Token,
+		Receiver:       state.FromRoute.HopNode(),
+		Reason:         rerr.ErrTransferUnwanted.Append(reason),
+	}
+	log.Info(fmt.Sprintf("dispose escrow lockSecretHash=%s,reason=%s", utils.HPex(h.LockSecretHash), reason))
+	return ev, stateManager, nil
+}
+
+func (rs *Service) sendEscrowEvent(ev transfer.Event, stateManager *transfer.StateManager, err error) error {
+	if err != nil || ev == nil {
+		return err
+	}
+	return rs.StateMachineEventHandler.OnEvent(ev, stateManager)
+}
+
+/*
+handleEscrowOnBlock 到达 CancelBlockNumber 仍未批准的锁,自动放弃
+*/
+func (rs *Service) handleEscrowOnBlock(blockNumber int64) {
+	for stateManager, ev := range rs.escrowTimeouts(blockNumber) {
+		err := rs.StateMachineEventHandler.OnEvent(ev, stateManager)
+		if err != nil {
+			log.Error(fmt.Sprintf("auto dispose escrow %s err %s", utils.HPex(stateManager.Identifier), err))
+		}
+	}
+}
+
+/*
+escrowTimeouts 返回需要放弃的锁
+*/
+func (rs *Service) escrowTimeouts(blockNumber int64) (disposed map[*transfer.StateManager]transfer.Event) {
+	disposed = make(map[*transfer.StateManager]transfer.Event)
+	for _, h := range rs.Key2EscrowHold {
+		if h.Status != models.EscrowStatusHolding || blockNumber < h.CancelBlockNumber() {
+			continue
+		}
+		smKey := utils.Sha3(h.LockSecretHash[:], h.TokenAddress[:])
+		manager := rs.Transfer2StateManager[smKey]
+		if manager == nil {
+			//锁已经过期,或者重启后状态丢失,不需要处理
+			h.Status = models.EscrowStatusCanceled
+			h.StatusMessage = "lock not found when timeout"
+			delete(rs.Key2EscrowHold, h.Key)
+			err := rs.dao.UpdateEscrowHold(h)
+			if err != nil {
+				log.Error(fmt.Sprintf("UpdateEscrowHold err %s", err))
+			}
+			continue
+		}
+		ev, _, err := rs.disposeEscrow(h, manager, "escrow not approved before timeout")
+		if err != nil {
+			log.Error(fmt.Sprintf("auto dispose escrow %s err %s", utils.HPex(h.LockSecretHash), err))
+			continue
+		}
+		disposed[manager] = ev
+	}
+	return
+}
@@ -0,0 +1,256 @@
+package photon
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEscrowTestService(dao models.Dao) *Service {
+	rs := &Service{
+		dao:                   dao,
+		Config:                &params.Config{RevealTimeout: 10},
+		Key2EscrowHold:        make(map[string]*models.EscrowHold),
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		BlockNumber:           new(atomic.Value),
+	}
+	rs.BlockNumber.Store(int64(50))
+	return rs
+}
+
+//receiveEscrowLock 模拟作为接收方收到了锁
+func receiveEscrowLock(rs *Service, token common.Address, lockSecretHash common.Hash, expiration int64) *transfer.StateManager {
+	ch, _ := channel.MakeTestPairChannel()
+	state := &mediatedtransfer.TargetState{
+		FromTransfer: &mediatedtransfer.LockedTransferState{
+			Amount:         big.NewInt(10),
+			Token:          token,
+			Initiator:      utils.NewRandomAddress(),
+			Expiration:     expiration,
+			LockSecretHash: lockSecretHash,
+		},
+		FromRoute: route.NewState(ch, []common.Address{ch.PartnerState.Address}),
+	}
+	manager := transfer.NewStateManager(nil, state, "TargetTransition", utils.NewRandomHash(), token)
+	rs.Transfer2StateManager[utils.Sha3(lockSecretHash[:], token[:])] = manager
+	return manager
+}
+
+func TestNewEscrow(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := newEscrowTestService(dao)
+	token := utils.NewRandomAddress()
+
+	req := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	result := rs.newEscrow(req)
+	assert.Nil(t, <-result.Result)
+	h := result.Tag.(*models.EscrowHold)
+	assert.EqualValues(t, 10, h.CancelBeforeBlocks)
+	assert.NotEmpty(t, h.OracleToken)
+	assert.Equal(t, h, rs.Key2EscrowHold[h.Key])
+	h2, err := dao.GetEscrowHold(token, req.LockSecretHash)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.EscrowStatusWaiting, h2.Status)
+
+	//比 reveal timeout 还小,来不及放弃该锁
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token, CancelBeforeBlocks: 5}
+	assert.NotNil(t, <-rs.newEscrow(req).Result)
+
+	//锁已经到了,太晚了
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	receiveEscrowLock(rs, token, req.LockSecretHash, 100)
+	assert.NotNil(t, <-rs.newEscrow(req).Result)
+	assert.Nil(t, rs.Key2EscrowHold[models.EscrowHoldKey(token, req.LockSecretHash)])
+}
+
+func TestEscrowHoldAndApprove(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := newEscrowTestService(dao)
+	token := utils.NewRandomAddress()
+
+	//没有登记的锁正常请求密码
+	hold, _ := rs.escrowHoldSecretRequest(receiveEscrowLock(rs, token, utils.NewRandomHash(), 100))
+	assert.False(t, hold)
+
+	req := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(req).Result)
+	manager := receiveEscrowLock(rs, token, req.LockSecretHash, 100)
+	hold, disposed := rs.escrowHoldSecretRequest(manager)
+	assert.True(t, hold)
+	assert.Nil(t, disposed)
+	h := rs.Key2EscrowHold[models.EscrowHoldKey(token, req.LockSecretHash)]
+	assert.EqualValues(t, models.EscrowStatusHolding, h.Status)
+	assert.EqualValues(t, 90, h.CancelBlockNumber())
+
+	ev, evManager, err := rs.escrowApproval(req)
+	assert.Nil(t, err)
+	assert.Equal(t, manager, evManager)
+	state := manager.CurrentState.(*mediatedtransfer.TargetState)
+	sr, ok := ev.(*mediatedtransfer.EventSendSecretRequest)
+	if assert.True(t, ok) {
+		assert.Equal(t, state.FromTransfer.Initiator, sr.Receiver)
+		assert.Equal(t, req.LockSecretHash, sr.LockSecretHash)
+	}
+	assert.Nil(t, rs.Key2EscrowHold[h.Key])
+	h2, err := dao.GetEscrowHold(token, req.LockSecretHash)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.EscrowStatusApproved, h2.Status)
+	//不能重复批准
+	_, _, err = rs.escrowApproval(req)
+	assert.NotNil(t, err)
+
+	//锁还没到就批准了,按照普通交易处理
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(req).Result)
+	ev, _, err = rs.escrowApproval(req)
+	assert.Nil(t, err)
+	assert.Nil(t, ev)
+	hold, _ = rs.escrowHoldSecretRequest(receiveEscrowLock(rs, token, req.LockSecretHash, 100))
+	assert.False(t, hold)
+
+	//路由的 reveal timeout 更大,虽然还没到 cancel block,但是已经来不及批准了
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(req).Result)
+	manager = receiveEscrowLock(rs, token, req.LockSecretHash, 100)
+	manager.CurrentState.(*mediatedtransfer.TargetState).FromRoute.MinRevealTimeout = 20
+	hold, _ = rs.escrowHoldSecretRequest(manager)
+	assert.True(t, hold)
+	rs.BlockNumber.Store(int64(85))
+	ev, _, err = rs.escrowApproval(req)
+	assert.NotNil(t, err)
+	assert.Nil(t, ev)
+
+	//锁到达时已经过了 cancel block,直接放弃
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(req).Result)
+	hold, disposed = rs.escrowHoldSecretRequest(receiveEscrowLock(rs, token, req.LockSecretHash, 90))
+	assert.True(t, hold)
+	_, ok = disposed.(*mediatedtransfer.EventSendAnnounceDisposed)
+	assert.True(t, ok)
+}
+
+func TestEscrowCancel(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := newEscrowTestService(dao)
+	token := utils.NewRandomAddress()
+
+	//锁到达之前就拒绝了,到达以后立即放弃
+	req := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token, Reason: "out of stock"}
+	assert.Nil(t, <-rs.newEscrow(req).Result)
+	ev, _, err := rs.escrowCancellation(req)
+	assert.Nil(t, err)
+	assert.Nil(t, ev)
+	manager := receiveEscrowLock(rs, token, req.LockSecretHash, 100)
+	hold, disposed := rs.escrowHoldSecretRequest(manager)
+	assert.True(t, hold)
+	ad, ok := disposed.(*mediatedtransfer.EventSendAnnounceDisposed)
+	if assert.True(t, ok) {
+		assert.Equal(t, manager.CurrentState.(*mediatedtransfer.TargetState).FromRoute.HopNode(), ad.Receiver)
+		assert.Equal(t, req.LockSecretHash, ad.LockSecretHash)
+	}
+	assert.Nil(t, rs.Key2EscrowHold[models.EscrowHoldKey(token, req.LockSecretHash)])
+
+	//暂扣中被拒绝
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token, Reason: "rejected"}
+	assert.Nil(t, <-rs.newEscrow(req).Result)
+	manager = receiveEscrowLock(rs, token, req.LockSecretHash, 100)
+	hold, _ = rs.escrowHoldSecretRequest(manager)
+	assert.True(t, hold)
+	ev, evManager, err := rs.escrowCancellation(req)
+	assert.Nil(t, err)
+	assert.Equal(t, manager, evManager)
+	_, ok = ev.(*mediatedtransfer.EventSendAnnounceDisposed)
+	assert.True(t, ok)
+	h, err := dao.GetEscrowHold(token, req.LockSecretHash)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.EscrowStatusCanceled, h.Status)
+	assert.Equal(t, "rejected", h.StatusMessage)
+	_, _, err = rs.escrowCancellation(req)
+	assert.NotNil(t, err)
+}
+
+func TestEscrowTimeouts(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := newEscrowTestService(dao)
+	token := utils.NewRandomAddress()
+
+	held := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(held).Result)
+	heldManager := receiveEscrowLock(rs, token, held.LockSecretHash, 100)
+	rs.escrowHoldSecretRequest(heldManager)
+	lost := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(lost).Result)
+	rs.escrowHoldSecretRequest(receiveEscrowLock(rs, token, lost.LockSecretHash, 100))
+	delete(rs.Transfer2StateManager, utils.Sha3(lost.LockSecretHash[:], token[:]))
+	waiting := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	assert.Nil(t, <-rs.newEscrow(waiting).Result)
+
+	assert.Empty(t, rs.escrowTimeouts(89))
+	assert.Equal(t, 3, len(rs.Key2EscrowHold))
+
+	disposed := rs.escrowTimeouts(90)
+	assert.Equal(t, 1, len(disposed))
+	_, ok := disposed[heldManager].(*mediatedtransfer.EventSendAnnounceDisposed)
+	assert.True(t, ok)
+	for _, req := range []*escrowReq{held, lost} {
+		h, err := dao.GetEscrowHold(token, req.LockSecretHash)
+		assert.Nil(t, err)
+		assert.EqualValues(t, models.EscrowStatusCanceled, h.Status)
+	}
+	//还没收到锁的不受影响
+	assert.Equal(t, 1, len(rs.Key2EscrowHold))
+	assert.NotNil(t, rs.Key2EscrowHold[models.EscrowHoldKey(token, waiting.LockSecretHash)])
+	assert.Empty(t, rs.escrowTimeouts(91))
+}
+
+func TestEscrowWebhook(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := newEscrowTestService(dao)
+	token := utils.NewRandomAddress()
+
+	req := &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	result := rs.newEscrow(req)
+	assert.Nil(t, <-result.Result)
+	h := result.Tag.(*models.EscrowHold)
+
+	req.OracleToken = "wrong"
+	req.Approve = true
+	assert.NotNil(t, <-rs.escrowWebhook(req).Result)
+	assert.EqualValues(t, models.EscrowStatusWaiting, h.Status)
+
+	req.OracleToken = h.OracleToken
+	req.Approve = false
+	assert.Nil(t, <-rs.escrowWebhook(req).Result)
+	assert.EqualValues(t, models.EscrowStatusCanceled, h.Status)
+
+	req = &escrowReq{LockSecretHash: utils.NewRandomHash(), TokenAddress: token}
+	result = rs.newEscrow(req)
+	assert.Nil(t, <-result.Result)
+	req.OracleToken = result.Tag.(*models.EscrowHold).OracleToken
+	rs.escrowHoldSecretRequest(receiveEscrowLock(rs, token, req.LockSecretHash, 100))
+	req.Approve = true
+	ev, _, err := rs.escrowWebhookDecision(req)
+	assert.Nil(t, err)
+	_, ok := ev.(*mediatedtransfer.EventSendSecretRequest)
+	assert.True(t, ok)
+	assert.EqualValues(t, models.EscrowStatusApproved, result.Tag.(*models.EscrowHold).Status)
+	//已经处理过的不能再用
+	_, _, err = rs.escrowWebhookDecision(req)
+	assert.NotNil(t, err)
+}
@@ -2,6 +2,7 @@ package photon
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/MetaLife-Protocol/SuperNode/params"
 
@@ -13,10 +14,13 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/models"
 	"github.com/MetaLife-Protocol/SuperNode/network/graph"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/notify"
 	"github.com/MetaLife-Protocol/SuperNode/transfer"
 	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
 	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/initiator"
 	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/target"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mtree"
 	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -108,7 +112,12 @@ func (eh *stateMachineEventHandler) eventSendRevealSecret(event *mediatedtransfe
 	// 带上交易附加信息
 	revealMessage.Data = []byte(event.Data)
 	err = revealMessage.Sign(eh.photon.PrivateKey, revealMessage)
-	err = eh.photon.sendAsync(event.Receiver, revealMessage) //单独处理 reaveal secret
+	if event.Receiver == eh.photon.NodeAddress {
+		//再平衡,我就是接收方
+		err = eh.photon.onSelfRevealSecret(event, revealMessage)
+	} else {
+		err = eh.photon.sendAsync(event.Receiver, revealMessage) //单独处理 reaveal secret
+	}
 	if err == nil {
 		std := eh.photon.dao.UpdateSentTransferDetailStatus(event.Token, revealMessage.LockSecretHash(), models.TransferStatusCanNotCancel, fmt.Sprintf("RevealSecret sending target=%s", utils.APex2(event.Receiver)), nil)
 		//eh.photon.dao.UpdateTransferStatus(event.Token, revealMessage.LockSecretHash(), models.TransferStatusCanNotCancel, fmt.Sprintf("RevealSecret 正在发送 target=%s", utils.APex2(event.Receiver)))
@@ -132,6 +141,18 @@ func (eh *stateMachineEventHandler) eventSendSecretRequest(event *mediatedtransf
 		eh.photon.UpdateChannelAndSaveAck(ch, stateManager.LastReceivedMessage.Tag())
 		stateManager.LastReceivedMessage = nil
 	}
+	if event.Receiver == eh.photon.NodeAddress {
+		//再平衡,我就是发起方
+		eh.photon.onSelfSecretRequest(event, ch.TokenAddress)
+		return
+	}
+	//托管支付,等待外部条件批准以后才能请求密码
+	if hold, disposed := eh.photon.escrowHoldSecretRequest(stateManager); hold {
+		if disposed != nil {
+			err = eh.OnEvent(disposed, stateManager)
+		}
+		return
+	}
 	err = eh.photon.sendAsync(event.Receiver, secretRequest)
 	return
 }
@@ -152,6 +173,7 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 	if err != nil {
 		return
 	}
+	mtr.SetTrampolineOnion(event.TrampolineOnion)
 	//log.Trace(fmt.Sprintf("mtr=%s", utils.StringInterface(mtr, 5)))
 	err = mtr.Sign(eh.photon.PrivateKey, mtr)
 	err = ch.RegisterTransfer(eh.photon.GetBlockNumber(), mtr)
@@ -464,8 +486,23 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		if err != nil {
 			log.Error(fmt.Sprintf("UpdateChannelNoTx err %s", err))
 		}
-		rt := eh.photon.dao.NewReceivedTransfer(eh.photon.GetBlockNumber(), e2.ChannelIdentifier, ch.ChannelIdentifier.OpenBlockNumber, ch.TokenAddress, e2.Initiator, ch.PartnerState.BalanceProofState.Nonce, e2.Amount, e2.LockSecretHash, e2.Data)
+		data := e2.Data
+		if utils.IsEncryptedData(data) {
+			// 发给我的加密附加信息,解密后保存,解密失败则保留密文
+			plain, err2 := utils.DecryptData(eh.photon.PrivateKey, data)
+			if err2 != nil {
+				log.Warn(fmt.Sprintf("decrypt transfer data from %s err %s", utils.APex2(e2.Initiator), err2))
+			} else {
+				data = plain
+			}
+		}
+		rt := eh.photon.dao.NewReceivedTransfer(eh.photon.GetBlockNumber(), e2.ChannelIdentifier, ch.ChannelIdentifier.OpenBlockNumber, ch.TokenAddress, e2.Initiator, ch.PartnerState.BalanceProofState.Nonce, e2.Amount, e2.LockSecretHash, data)
+		if rt != nil && rt.RefundOf != utils.EmptyHash {
+			eh.photon.onReceiveRefund(rt)
+		}
 		eh.photon.NotifyHandler.NotifyReceiveTransfer(rt)
+	case *mediatedtransfer.EventRouteFinished:
+		eh.photon.MissionControl.OnRouteFinished(e2)
 	case *mediatedtransfer.EventUnlockSuccess:
 	case *mediatedtransfer.EventWithdrawFailed:
 		log.Error(fmt.Sprintf("EventWithdrawFailed hashlock=%s,reason=%s", utils.HPex(e2.LockSecretHash), e2.Reason))
@@ -485,7 +522,13 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 	case *mediatedtransfer.EventContractSendRegisterSecret:
 		err = eh.eventContractSendRegisterSecret(e2)
 	case *mediatedtransfer.EventRemoveStateManager:
-		delete(eh.photon.Transfer2StateManager, e2.Key)
+		if eh.photon.Transfer2StateManager[circularTargetKey(e2.Key)] == stateManager {
+			//再平衡的接收方
+			delete(eh.photon.Transfer2StateManager, circularTargetKey(e2.Key))
+		} else {
+			delete(eh.photon.Transfer2StateManager, e2.Key)
+		}
+		eh.photon.removeExpirationRecord(stateManager)
 	case *mediatedtransfer.EventSaveFeeChargeRecord:
 		err = eh.eventSaveFeeChargeRecord(e2)
 	default:
@@ -590,6 +633,9 @@ func (eh *stateMachineEventHandler) handleChannelNew(st *mediatedtransfer.Contra
 
 //1. 重复的ContractBalanceStateChange没有什么大的影响
 func (eh *stateMachineEventHandler) handleBalance(st *mediatedtransfer.ContractBalanceStateChange) error {
+	if eh.photon.PfsServer != nil {
+		eh.photon.PfsServer.OnDeposit(st.ChannelIdentifier, st.ParticipantAddress, st.Balance)
+	}
 	ch, err := eh.photon.findChannelByIdentifier(st.ChannelIdentifier)
 	if err != nil {
 		//log.Trace(fmt.Sprintf("ContractBalanceStateChange i'm not a participant,channelIdentifier=%s", utils.HPex(st.ChannelIdentifier)))
@@ -748,6 +794,10 @@ func (eh *stateMachineEventHandler) handleCooperativeSettled(st *mediatedtransfe
 //1. 必须能够处理重复的ContractChannelWithdrawStateChange
 func (eh *stateMachineEventHandler) handleWithdraw(st *mediatedtransfer.ContractChannelWithdrawStateChange) error {
 	log.Trace(fmt.Sprintf("%s withdraw event handle", utils.HPex(st.ChannelIdentifier.ChannelIdentifier)))
+	if eh.photon.PfsServer != nil {
+		eh.photon.PfsServer.OnWithdraw(st.ChannelIdentifier.ChannelIdentifier, st.ChannelIdentifier.OpenBlockNumber,
+			st.Participant1, st.Participant1Balance, st.Participant2, st.Participant2Balance)
+	}
 	ch, err := eh.photon.findChannelByIdentifier(st.ChannelIdentifier.ChannelIdentifier)
 	if err != nil {
 		return nil
@@ -867,6 +917,299 @@ func (eh *stateMachineEventHandler) handleSecretRegisteredOnChain(st *mediatedtr
 	return nil
 }
 
+/*
+handleReorg 公链分叉回滚了已经处理过的事件.
+1. 和我有关的注册密码事件被回滚以后,锁可能因此无法在链上解锁,需要重新注册密码;
+2. 通道的打开,存款,关闭,settle,取现,提交 balance proof,unlock,punish 事件被回滚以后,本地的通道和链上不一致了,
+按照链上现在的状态逐个修正受影响的通道.新链上如果再次包含这些事件,还会重新收到并处理.
+*/
+func (eh *stateMachineEventHandler) handleReorg(st *mediatedtransfer.ContractReorgStateChange) error {
+	for _, sc := range st.Orphaned {
+		log.Error(fmt.Sprintf("event orphaned by reorg from block %d : %s", st.ForkBlockNumber, utils.StringInterface(sc, 3)))
+		if s, ok := sc.(*mediatedtransfer.ContractSecretRevealOnChainStateChange); ok {
+			eh.registerSecretAgainAfterReorg(s)
+		}
+	}
+	channels, newChannels, unlocks := orphanedChannels(st.Orphaned)
+	for _, channelIdentifier := range channels {
+		err := eh.resyncChannelAfterReorg(channelIdentifier, newChannels[channelIdentifier], unlocks[channelIdentifier])
+		if err != nil {
+			log.Error(fmt.Sprintf("resync channel %s after reorg err %s", utils.HPex(channelIdentifier), err))
+		}
+	}
+	eh.photon.NotifyHandler.NotifyString(notify.LevelWarn, fmt.Sprintf("公链发生分叉,从块%d开始的%d个事件被回滚", st.ForkBlockNumber, len(st.Orphaned)))
+	return nil
+}
+
+/*
+orphanedChannels 返回被回滚的事件影响到的通道,以及其中被回滚的打开事件和 unlock 事件
+*/
+func orphanedChannels(orphaned []mediatedtransfer.ContractStateChange) (channels []common.Hash, newChannels map[common.Hash]*mediatedtransfer.ContractNewChannelStateChange,
+	unlocks map[common.Hash][]*mediatedtransfer.ContractUnlockStateChange) {
+	newChannels = make(map[common.Hash]*mediatedtransfer.ContractNewChannelStateChange)
+	unlocks = make(map[common.Hash][]*mediatedtransfer.ContractUnlockStateChange)
+	for _, sc := range orphaned {
+		var channelIdentifier common.Hash
+		switch s := sc.(type) {
+		case *mediatedtransfer.ContractNewChannelStateChange:
+			channelIdentifier = s.ChannelIdentifier.ChannelIdentifier
+			newChannels[channelIdentifier] = s
+		case *mediatedtransfer.ContractBalanceStateChange:
+			channelIdentifier = s.ChannelIdentifier
+		case *mediatedtransfer.ContractClosedStateChange:
+			channelIdentifier = s.ChannelIdentifier
+		case *mediatedtransfer.ContractSettledStateChange:
+			channelIdentifier = s.ChannelIdentifier
+		case *mediatedtransfer.ContractCooperativeSettledStateChange:
+			channelIdentifier = s.ChannelIdentifier
+		case *mediatedtransfer.ContractChannelWithdrawStateChange:
+			channelIdentifier = s.ChannelIdentifier.ChannelIdentifier
+		case *mediatedtransfer.ContractBalanceProofUpdatedStateChange:
+			channelIdentifier = s.ChannelIdentifier
+		case *mediatedtransfer.ContractUnlockStateChange:
+			channelIdentifier = s.ChannelIdentifier
+			unlocks[channelIdentifier] = append(unlocks[channelIdentifier], s)
+		case *mediatedtransfer.ContractPunishedStateChange:
+			channelIdentifier = s.ChannelIdentifier
+		default:
+			continue
+		}
+		channels = appendHashIfNotExist(channels, channelIdentifier)
+	}
+	return
+}
+
+func (eh *stateMachineEventHandler) registerSecretAgainAfterReorg(s *mediatedtransfer.ContractSecretRevealOnChainStateChange) {
+	for _, hashchannel := range eh.photon.Token2LockSecretHash2Channels {
+		if len(hashchannel[s.LockSecretHash]) > 0 {
+			err := eh.eventContractSendRegisterSecret(&mediatedtransfer.EventContractSendRegisterSecret{Secret: s.Secret})
+			if err != nil {
+				log.Error(fmt.Sprintf("register secret %s again after reorg err %s", utils.HPex(s.LockSecretHash), err))
+			}
+			return
+		}
+	}
+}
+
+/*
+resyncChannelAfterReorg 按照链上现在的状态修正一个受影响的通道:
+1. 我参与的通道,打开事件被回滚就删除,否则按链上的状态,打开块和存款修正;
+2. 我参与的通道已经因为 settle 事件删除了,链上通道还在就从 settle 记录中恢复;
+3. 别人的通道,只有打开事件被回滚时需要从路由中删除.
+*/
+func (eh *stateMachineEventHandler) resyncChannelAfterReorg(channelIdentifier common.Hash, newChannel *mediatedtransfer.ContractNewChannelStateChange,
+	unlocks []*mediatedtransfer.ContractUnlockStateChange) error {
+	rs := eh.photon
+	ch, err := rs.findChannelByIdentifier(channelIdentifier)
+	if err != nil {
+		if newChannel != nil && newChannel.Participant1 != rs.NodeAddress && newChannel.Participant2 != rs.NodeAddress {
+			return eh.resyncNonParticipantChannelAfterReorg(newChannel)
+		}
+		return eh.restoreSettledChannelAfterReorg(channelIdentifier)
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(ch.TokenAddress)
+	if err != nil {
+		return err
+	}
+	id, _, openBlockNumber, state, _, err := tokenNetwork.GetChannelInfo(ch.OurState.Address, ch.PartnerState.Address)
+	if err != nil {
+		return err
+	}
+	var ourDeposit, partnerDeposit *big.Int
+	var ourBalanceHash, partnerBalanceHash common.Hash
+	var ourNonce, partnerNonce uint64
+	if state != contracts.ChannelStateSettledOrNotExist && id == channelIdentifier {
+		ourDeposit, ourBalanceHash, ourNonce, err = tokenNetwork.GetChannelParticipantInfo(ch.OurState.Address, ch.PartnerState.Address)
+		if err != nil {
+			return err
+		}
+		partnerDeposit, partnerBalanceHash, partnerNonce, err = tokenNetwork.GetChannelParticipantInfo(ch.PartnerState.Address, ch.OurState.Address)
+		if err != nil {
+			return err
+		}
+	} else {
+		state = contracts.ChannelStateSettledOrNotExist
+	}
+	if !resyncChannelWithChain(ch, state, int64(openBlockNumber), ourDeposit, partnerDeposit) {
+		if state == contracts.ChannelStateClosed {
+			if !resyncBalanceProofWithChain(ch.OurState, ourBalanceHash, ourNonce, unlocks) {
+				log.Error(fmt.Sprintf("channel %s my balance proof on chain is unknown after reorg", utils.HPex(channelIdentifier)))
+			}
+			if !resyncBalanceProofWithChain(ch.PartnerState, partnerBalanceHash, partnerNonce, unlocks) {
+				log.Error(fmt.Sprintf("channel %s partner's balance proof on chain is unknown after reorg", utils.HPex(channelIdentifier)))
+			}
+		}
+		return rs.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
+	}
+	log.Error(fmt.Sprintf("channel %s with %s does not exist after reorg, remove it", utils.HPex(channelIdentifier), utils.APex2(ch.PartnerState.Address)))
+	rs.getToken2ChannelGraph(ch.TokenAddress).RemoveChannel(ch)
+	cs := channel.NewChannelSerialization(ch)
+	err = rs.dao.RemoveChannel(cs)
+	if err != nil {
+		return err
+	}
+	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(cs))
+	return rs.dao.RemoveNonParticipantChannel(channelIdentifier)
+}
+
+/*
+resyncChannelWithChain 按照链上的状态修正本地通道,返回 true 表示链上已经没有这个通道了,需要删除.
+取现事件被回滚时,取现之前的交易记录已经丢弃,只能按照链上的打开块和存款重新开始.
+*/
+func resyncChannelWithChain(ch *channel.Channel, state uint8, openBlockNumber int64, ourDeposit, partnerDeposit *big.Int) (removed bool) {
+	if state == contracts.ChannelStateSettledOrNotExist {
+		return true
+	}
+	if openBlockNumber != ch.ChannelIdentifier.OpenBlockNumber {
+		log.Error(fmt.Sprintf("channel %s open block changed from %d to %d after reorg",
+			utils.HPex(ch.ChannelIdentifier.ChannelIdentifier), ch.ChannelIdentifier.OpenBlockNumber, openBlockNumber))
+		ch.HandleWithdrawed(openBlockNumber, ch.OurState.Address, ch.PartnerState.Address, ourDeposit, partnerDeposit)
+	}
+	if state == contracts.ChannelStateOpened && ch.State != channeltype.StateOpened {
+		//关闭事件被回滚了
+		ch.State = channeltype.StateOpened
+		ch.ExternState.ClosedBlock = 0
+		ch.ExternState.SettledBlock = 0
+	}
+	//存款只会增加,回滚以后只能直接设置
+	ch.OurState.ContractBalance = new(big.Int).Set(ourDeposit)
+	ch.PartnerState.ContractBalance = new(big.Int).Set(partnerDeposit)
+	return false
+}
+
+/*
+resyncBalanceProofWithChain 提交 balance proof,unlock,punish 事件被回滚以后,按链上的状态修正 state 在合约上的 transferAmount,locksroot 和 nonce.
+链上只保存了 balance hash,只能从本地知道的可能值中找出和它一致的:没有提交过,回滚前合约上的值,链下最新的 balance proof,
+以及被回滚的 unlock 前后的值.返回 false 表示没有一致的值,只能保留现在的状态.
+*/
+func resyncBalanceProofWithChain(state *channel.EndState, balanceHash common.Hash, nonce uint64, unlocks []*mediatedtransfer.ContractUnlockStateChange) bool {
+	bp := state.BalanceProofState
+	state.SetContractNonce(nonce)
+	type candidate struct {
+		transferAmount *big.Int
+		locksRoot      common.Hash
+	}
+	candidates := []candidate{
+		{utils.BigInt0, utils.EmptyHash},
+		{bp.ContractTransferAmount, bp.ContractLocksRoot},
+		{bp.TransferAmount, bp.LocksRoot},
+	}
+	for _, u := range unlocks {
+		if u.Participant != state.Address || u.TransferAmount == nil {
+			continue
+		}
+		//unlock 只改变 transferAmount
+		candidates = append(candidates, candidate{u.TransferAmount, bp.ContractLocksRoot})
+		if l := lockByHash(state, u.LockHash); l != nil {
+			candidates = append(candidates, candidate{new(big.Int).Sub(u.TransferAmount, l.Amount), bp.ContractLocksRoot})
+		}
+	}
+	for _, c := range candidates {
+		if c.transferAmount != nil && contractBalanceHash(c.transferAmount, c.locksRoot) == balanceHash {
+			bp.ContractTransferAmount = new(big.Int).Set(c.transferAmount)
+			bp.ContractLocksRoot = c.locksRoot
+			return true
+		}
+	}
+	return false
+}
+
+//lockByHash state 发出的锁中 hash 为 lockHash 的,没有返回 nil
+func lockByHash(state *channel.EndState, lockHash common.Hash) *mtree.Lock {
+	for _, pl := range state.Lock2PendingLocks {
+		if pl.Lock != nil && pl.Lock.Hash() == lockHash {
+			return pl.Lock
+		}
+	}
+	for _, pl := range state.Lock2UnclaimedLocks {
+		if pl.Lock != nil && pl.Lock.Hash() == lockHash {
+			return pl.Lock
+		}
+	}
+	return nil
+}
+
+//contractBalanceHash 和合约中的 calceBalanceHash 一致,合约返回的 bytes24 在 common.Hash 中右对齐
+func contractBalanceHash(transferAmount *big.Int, locksRoot common.Hash) common.Hash {
+	if transferAmount.Cmp(utils.BigInt0) == 0 && locksRoot == utils.EmptyHash {
+		return utils.EmptyHash
+	}
+	h := utils.Sha3(locksRoot[:], utils.BigIntTo32Bytes(transferAmount))
+	return common.BytesToHash(h[:24])
+}
+
+//restoreSettledChannelAfterReorg settle 事件被回滚以后,链上通道还在,从 settle 记录中恢复
+func (eh *stateMachineEventHandler) restoreSettledChannelAfterReorg(channelIdentifier common.Hash) error {
+	rs := eh.photon
+	css, err := rs.dao.GetAllSettledChannel()
+	if err != nil {
+		return err
+	}
+	var cs *channeltype.Serialization
+	for _, c := range css {
+		if c.ChannelIdentifier.ChannelIdentifier == channelIdentifier && (cs == nil || c.ChannelIdentifier.OpenBlockNumber > cs.ChannelIdentifier.OpenBlockNumber) {
+			cs = c
+		}
+	}
+	if cs == nil {
+		//不是我参与的通道
+		return nil
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(cs.TokenAddress())
+	if err != nil {
+		return err
+	}
+	id, _, openBlockNumber, state, _, err := tokenNetwork.GetChannelInfo(cs.OurAddress, cs.PartnerAddress())
+	if err != nil {
+		return err
+	}
+	if state == contracts.ChannelStateSettledOrNotExist || id != channelIdentifier || int64(openBlockNumber) != cs.ChannelIdentifier.OpenBlockNumber {
+		return nil
+	}
+	cs.State = channeltype.StateOpened
+	if state == contracts.ChannelStateClosed {
+		cs.State = channeltype.StateClosed
+	}
+	cs.SettledBlock = 0
+	ch, err := rs.channelSerilization2Channel(cs, tokenNetwork)
+	if err != nil {
+		return err
+	}
+	log.Error(fmt.Sprintf("channel %s with %s is not settled after reorg, restore it", utils.HPex(channelIdentifier), utils.APex2(cs.PartnerAddress())))
+	err = rs.getToken2ChannelGraph(cs.TokenAddress()).AddChannel(ch)
+	if err != nil {
+		return err
+	}
+	err = rs.dao.NewNonParticipantChannel(cs.TokenAddress(), channelIdentifier, cs.OurAddress, cs.PartnerAddress())
+	if err != nil {
+		return err
+	}
+	cs = channel.NewChannelSerialization(ch)
+	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(cs))
+	return rs.dao.NewChannel(cs)
+}
+
+//resyncNonParticipantChannelAfterReorg 别人的通道打开事件被回滚了,链上没有这个通道就从路由中删除
+func (eh *stateMachineEventHandler) resyncNonParticipantChannelAfterReorg(st *mediatedtransfer.ContractNewChannelStateChange) error {
+	rs := eh.photon
+	tokenNetwork, err := rs.Chain.TokenNetwork(st.TokenAddress)
+	if err != nil {
+		return err
+	}
+	id, _, _, state, _, err := tokenNetwork.GetChannelInfo(st.Participant1, st.Participant2)
+	if err != nil {
+		return err
+	}
+	if state != contracts.ChannelStateSettledOrNotExist && id == st.ChannelIdentifier.ChannelIdentifier {
+		return nil
+	}
+	g := rs.getToken2ChannelGraph(st.TokenAddress)
+	if g != nil {
+		g.RemovePath(st.Participant1, st.Participant2)
+	}
+	return rs.dao.RemoveNonParticipantChannel(st.ChannelIdentifier.ChannelIdentifier)
+}
+
 func (eh *stateMachineEventHandler) handleBlockStateChange(st *transfer.BlockStateChange) error {
 	eh.dispatchToAllTasks(st)
 	//for _, cg := range eh.photon.Token2ChannelGraph {
@@ -999,6 +1342,8 @@ func (eh *stateMachineEventHandler) OnBlockchainStateChange(st transfer.StateCha
 		eh.photon.conditionQuit("EventWithdrawFromChainBeforeDeal")
 		err = eh.handleWithdraw(st2)
 		eh.photon.conditionQuit("EventWithdrawFromChainAfterDeal")
+	case *mediatedtransfer.ContractReorgStateChange:
+		err = eh.handleReorg(st2)
 	case *transfer.BlockStateChange:
 		err = eh.handleBlockStateChange(st2)
 	default:
@@ -1018,7 +1363,10 @@ func (eh *stateMachineEventHandler) updateStateManagerFromStateChange(mgr *trans
 		msg = st2.Message
 	case *mediatedtransfer.ReceiveSecretRequestStateChange:
 		quitName = "ReceiveSecretRequestStateChange"
-		msg = st2.Message
+		//再平衡时接收方在本地请求密码,没有消息
+		if st2.Message != nil {
+			msg = st2.Message
+		}
 	case *mediatedtransfer.ReceiveAnnounceDisposedStateChange:
 		quitName = "ReceiveAnnounceDisposedStateChange"
 		msg = st2.Message
@@ -0,0 +1,105 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mtree"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResyncChannelWithChain(t *testing.T) {
+	ch, _ := channel.MakeTestPairChannel()
+	openBlockNumber := ch.ChannelIdentifier.OpenBlockNumber
+	//打开事件被回滚
+	assert.True(t, resyncChannelWithChain(ch, contracts.ChannelStateSettledOrNotExist, 0, nil, nil))
+
+	//关闭和存款事件被回滚
+	ch.State = channeltype.StateClosed
+	ch.ExternState.ClosedBlock = openBlockNumber + 10
+	ch.ExternState.SettledBlock = openBlockNumber + 110
+	ch.OurState.ContractBalance = big.NewInt(200)
+	assert.False(t, resyncChannelWithChain(ch, contracts.ChannelStateOpened, openBlockNumber, big.NewInt(100), big.NewInt(50)))
+	assert.EqualValues(t, channeltype.StateOpened, ch.State)
+	assert.EqualValues(t, 0, ch.ExternState.ClosedBlock)
+	assert.EqualValues(t, 0, ch.ExternState.SettledBlock)
+	assert.EqualValues(t, big.NewInt(100), ch.OurState.ContractBalance)
+	assert.EqualValues(t, big.NewInt(50), ch.PartnerState.ContractBalance)
+	assert.EqualValues(t, openBlockNumber, ch.ChannelIdentifier.OpenBlockNumber)
+
+	//链上的通道还是关闭的
+	ch.State = channeltype.StateClosed
+	assert.False(t, resyncChannelWithChain(ch, contracts.ChannelStateClosed, openBlockNumber, big.NewInt(100), big.NewInt(50)))
+	assert.EqualValues(t, channeltype.StateClosed, ch.State)
+
+	//取现事件被回滚,按链上的打开块重新开始
+	ch.State = channeltype.StateOpened
+	assert.False(t, resyncChannelWithChain(ch, contracts.ChannelStateOpened, openBlockNumber-1, big.NewInt(30), big.NewInt(20)))
+	assert.EqualValues(t, openBlockNumber-1, ch.ChannelIdentifier.OpenBlockNumber)
+	assert.EqualValues(t, openBlockNumber-1, ch.ExternState.ChannelIdentifier.OpenBlockNumber)
+	assert.EqualValues(t, big.NewInt(30), ch.OurState.ContractBalance)
+	assert.EqualValues(t, big.NewInt(20), ch.PartnerState.ContractBalance)
+}
+
+func TestOrphanedChannels(t *testing.T) {
+	updated := &mediatedtransfer.ContractBalanceProofUpdatedStateChange{ChannelIdentifier: utils.NewRandomHash()}
+	unlock := &mediatedtransfer.ContractUnlockStateChange{ChannelIdentifier: utils.NewRandomHash(), LockHash: utils.NewRandomHash()}
+	punished := &mediatedtransfer.ContractPunishedStateChange{ChannelIdentifier: utils.NewRandomHash()}
+	channels, newChannels, unlocks := orphanedChannels([]mediatedtransfer.ContractStateChange{updated, unlock, punished, unlock})
+	assert.EqualValues(t, 3, len(channels))
+	assert.EqualValues(t, updated.ChannelIdentifier, channels[0])
+	assert.EqualValues(t, unlock.ChannelIdentifier, channels[1])
+	assert.EqualValues(t, punished.ChannelIdentifier, channels[2])
+	assert.EqualValues(t, 0, len(newChannels))
+	assert.EqualValues(t, 2, len(unlocks[unlock.ChannelIdentifier]))
+}
+
+func TestResyncBalanceProofWithChain(t *testing.T) {
+	ch, _ := channel.MakeTestPairChannel()
+	state := ch.PartnerState
+	bp := state.BalanceProofState
+	bp.TransferAmount = big.NewInt(30)
+	bp.LocksRoot = utils.NewRandomHash()
+
+	//提交 balance proof 的事件被回滚,链上还没有提交过
+	bp.ContractTransferAmount = new(big.Int).Set(bp.TransferAmount)
+	bp.ContractLocksRoot = bp.LocksRoot
+	bp.ContractNonce = 3
+	assert.True(t, resyncBalanceProofWithChain(state, utils.EmptyHash, 0, nil))
+	assert.EqualValues(t, big.NewInt(0), bp.ContractTransferAmount)
+	assert.EqualValues(t, utils.EmptyHash, bp.ContractLocksRoot)
+	assert.EqualValues(t, 0, bp.ContractNonce)
+
+	//unlock 事件被回滚,链上是 unlock 之前的值
+	lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(5), LockSecretHash: utils.NewRandomHash()}
+	state.Lock2PendingLocks[lock.LockSecretHash] = channeltype.PendingLock{Lock: lock, LockHash: lock.Hash()}
+	bp.ContractTransferAmount = big.NewInt(35)
+	bp.ContractLocksRoot = bp.LocksRoot
+	unlock := &mediatedtransfer.ContractUnlockStateChange{
+		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+		LockHash:          lock.Hash(),
+		Participant:       state.Address,
+		TransferAmount:    big.NewInt(35),
+	}
+	assert.True(t, resyncBalanceProofWithChain(state, contractBalanceHash(big.NewInt(30), bp.LocksRoot), 3, []*mediatedtransfer.ContractUnlockStateChange{unlock}))
+	assert.EqualValues(t, big.NewInt(30), bp.ContractTransferAmount)
+	assert.EqualValues(t, bp.LocksRoot, bp.ContractLocksRoot)
+	assert.EqualValues(t, 3, bp.ContractNonce)
+
+	//punish 事件被回滚,链上还是受益方提交的 balance proof
+	ch.HandleChannelPunished(state.Address)
+	assert.EqualValues(t, big.NewInt(0), bp.ContractTransferAmount)
+	assert.True(t, resyncBalanceProofWithChain(state, contractBalanceHash(big.NewInt(30), bp.LocksRoot), 3, nil))
+	assert.EqualValues(t, big.NewInt(30), bp.ContractTransferAmount)
+	assert.EqualValues(t, bp.LocksRoot, bp.ContractLocksRoot)
+	assert.EqualValues(t, 3, bp.ContractNonce)
+
+	//不知道链上是什么
+	assert.False(t, resyncBalanceProofWithChain(state, utils.NewRandomHash(), 3, nil))
+	assert.EqualValues(t, big.NewInt(30), bp.ContractTransferAmount)
+}
@@ -0,0 +1,295 @@
+package photon
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/initiator"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/mediator"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/target"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+动态超时策略:
+1. reveal timeout 不再只使用 Config.RevealTimeout,而是根据最近自己发起的链上 tx 从发起到打包的耗时(TXInfo)
+	和观察到的出块间隔(BlockNumberDao)计算出注册密码上链需要的块数,拥堵时放大,但不会小于 Config.RevealTimeout
+2. 发起方在没有指定过期时间时,锁的过期时间根据路径长度计算,短路径不再使用接近 settle timeout 的长锁
+3. 每次选择的结果都会记录下来,供诊断使用,交易结束或者锁过期以后删除
+*/
+/*
+ *	Expiration policy :
+ *	1. reveal timeout is no longer Config.RevealTimeout only, it's derived from how long our recent on-chain tx
+ *		took to be packed (TXInfo) and observed block interval (BlockNumberDao). It grows on a congested chain,
+ *		but never goes below Config.RevealTimeout.
+ *	2. when the initiator doesn't specify an expiration, lock expiration is computed from the path length,
+ *		so short routes don't hold locks close to settle timeout.
+ *	3. values chosen are recorded for diagnostics, records are removed when the transfer finishes or the lock expires.
+ */
+
+const (
+	// 没有观察到出块间隔之前假定的出块间隔
+	defaultSecondsPerBlock = 15.0
+	// 新的出块间隔样本的权重
+	blockTimeSampleWeight = 0.1
+	// 超过这个时间的样本被认为是节点离线造成的,忽略
+	maxBlockTimeSample = 5 * time.Minute
+	// 注册密码的 tx 可能需要重试,reveal timeout 至少要能容纳这么多次 tx 打包
+	revealTimeoutTxFactor = 3
+	// reveal timeout 最多放大到 Config.RevealTimeout 的倍数
+	maxRevealTimeoutFactor = 4
+	// 每一跳消息往返和处理预留的时间
+	hopSeconds = 30
+	// 计算 tx 打包耗时使用的最近 tx 数量
+	txLatencySamples = 20
+	// 只使用这段时间内发起的 tx 计算打包耗时,不扫描整个 TXInfo 表
+	txLatencyWindow = 24 * time.Hour
+	// tx 打包耗时的刷新间隔
+	txLatencyRefreshInterval = 10 * time.Minute
+)
+
+// ExpirationPolicy computes per-transfer lock expiration and per-hop reveal timeout from chain conditions
+type ExpirationPolicy struct {
+	dao                  models.Dao
+	configRevealTimeout  int
+	secondsPerBlock      float64
+	txLatency            float64 //seconds
+	lastTxLatencyRefresh time.Time
+	lock                 sync.Mutex
+}
+
+// NewExpirationPolicy :
+func NewExpirationPolicy(dao models.Dao, configRevealTimeout int) *ExpirationPolicy {
+	return &ExpirationPolicy{
+		dao:                 dao,
+		configRevealTimeout: configRevealTimeout,
+		secondsPerBlock:     defaultSecondsPerBlock,
+	}
+}
+
+/*
+OnNewBlock 必须在新块保存到 BlockNumberDao 之前调用,根据上一个块的时间更新出块间隔,并删除锁已经过期的记录
+*/
+func (ep *ExpirationPolicy) OnNewBlock(blockNumber int64) {
+	lastBlockNumber := ep.dao.GetLatestBlockNumber()
+	lastBlockTime := ep.dao.GetLastBlockNumberTime()
+	if lastBlockNumber > 0 && !lastBlockTime.IsZero() && blockNumber > lastBlockNumber {
+		ep.addBlockTimeSample(blockNumber-lastBlockNumber, time.Since(lastBlockTime))
+	}
+	if time.Since(ep.lastTxLatencyRefresh) > txLatencyRefreshInterval {
+		ep.refreshTxLatency()
+	}
+	err := ep.dao.RemoveExpiredExpirationRecords(blockNumber)
+	if err != nil {
+		log.Error(fmt.Sprintf("RemoveExpiredExpirationRecords err %s", err))
+	}
+}
+
+func (ep *ExpirationPolicy) addBlockTimeSample(blocks int64, elapsed time.Duration) {
+	if blocks <= 0 || elapsed <= 0 || elapsed > maxBlockTimeSample {
+		return
+	}
+	sample := elapsed.Seconds() / float64(blocks)
+	ep.lock.Lock()
+	ep.secondsPerBlock = ep.secondsPerBlock*(1-blockTimeSampleWeight) + sample*blockTimeSampleWeight
+	ep.lock.Unlock()
+}
+
+/*
+refreshTxLatency 使用 txLatencyWindow 内自己发起并成功打包的 tx 计算平均打包耗时
+*/
+func (ep *ExpirationPolicy) refreshTxLatency() {
+	ep.lastTxLatencyRefresh = time.Now()
+	list, err := ep.dao.GetTXInfoListByCallTime(ep.lastTxLatencyRefresh.Add(-txLatencyWindow).Unix(), ep.lastTxLatencyRefresh.Unix())
+	if err != nil {
+		log.Error(fmt.Sprintf("refreshTxLatency GetTXInfoListByCallTime err %s", err))
+		return
+	}
+	ep.setTxLatency(txLatencyOf(list))
+}
+
+func (ep *ExpirationPolicy) setTxLatency(latency float64) {
+	ep.lock.Lock()
+	ep.txLatency = latency
+	ep.lock.Unlock()
+}
+
+//txLatencyOf returns average seconds from call to pack of the latest txLatencySamples self-call txs
+func txLatencyOf(list []*models.TXInfo) float64 {
+	var latest []*models.TXInfo
+	for _, tx := range list {
+		if !tx.IsSelfCall || tx.Status != models.TXInfoStatusSuccess || tx.PackTime <= tx.CallTime || tx.CallTime <= 0 {
+			continue
+		}
+		if len(latest) < txLatencySamples {
+			latest = append(latest, tx)
+			continue
+		}
+		//replace the oldest one
+		oldest := 0
+		for i, t := range latest {
+			if t.CallTime < latest[oldest].CallTime {
+				oldest = i
+			}
+		}
+		if tx.CallTime > latest[oldest].CallTime {
+			latest[oldest] = tx
+		}
+	}
+	if len(latest) == 0 {
+		return 0
+	}
+	var total int64
+	for _, tx := range latest {
+		total += tx.PackTime - tx.CallTime
+	}
+	return float64(total) / float64(len(latest))
+}
+
+// RevealTimeout blocks needed to register secret on-chain in current chain conditions
+func (ep *ExpirationPolicy) RevealTimeout() int {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	txBlocks := int(math.Ceil(ep.txLatency / ep.secondsPerBlock))
+	revealTimeout := txBlocks * revealTimeoutTxFactor
+	if revealTimeout < ep.configRevealTimeout {
+		revealTimeout = ep.configRevealTimeout
+	}
+	if revealTimeout > ep.configRevealTimeout*maxRevealTimeoutFactor {
+		revealTimeout = ep.configRevealTimeout * maxRevealTimeoutFactor
+	}
+	return revealTimeout
+}
+
+/*
+LockTimeout 发起方的锁需要的块数,
+路径上每个节点都需要在锁过期前 reveal timeout 块拿到密码,再为每一跳的消息传递预留时间
+*/
+func (ep *ExpirationPolicy) LockTimeout(pathLength int) int {
+	return ep.LockTimeoutWithRevealTimeout(pathLength, 0)
+}
+
+/*
+LockTimeoutWithRevealTimeout 路径上有节点要求的 reveal timeout 比我们的大时(比如来自路由提示),按大的计算
+*/
+func (ep *ExpirationPolicy) LockTimeoutWithRevealTimeout(pathLength, revealTimeout int) int {
+	if pathLength < 1 {
+		pathLength = 1
+	}
+	ep.lock.Lock()
+	hopBlocks := int(math.Ceil(hopSeconds / ep.secondsPerBlock))
+	ep.lock.Unlock()
+	if hopBlocks < 1 {
+		hopBlocks = 1
+	}
+	if rt := ep.RevealTimeout(); rt > revealTimeout {
+		revealTimeout = rt
+	}
+	return 2*revealTimeout + pathLength*hopBlocks
+}
+
+/*
+FitsSettleTimeout 路由提示中的 reveal timeout 来自别人,按它算出的锁必须在通道 settle 之前过期,
+否则恶意的提示可以让锁占用资金直到 settle,或者锁根本不能被通道接受
+*/
+func (ep *ExpirationPolicy) FitsSettleTimeout(pathLength, revealTimeout, settleTimeout int) bool {
+	return ep.LockTimeoutWithRevealTimeout(pathLength, revealTimeout) < settleTimeout
+}
+
+// Conditions returns observed seconds per block and tx latency
+func (ep *ExpirationPolicy) Conditions() (secondsPerBlock, txLatency float64) {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	return ep.secondsPerBlock, ep.txLatency
+}
+
+//maxPathLength of routes, path of a route doesn't contain ourself
+func maxPathLength(routes []*route.State) (l int) {
+	for _, r := range routes {
+		if n := routePathLength(r); n > l {
+			l = n
+		}
+	}
+	return
+}
+
+//routePathLength trampolines compute the rest of the route, at most params.TrampolineMaxHops for each of them
+func routePathLength(r *route.State) int {
+	return len(r.Path) + len(r.Trampolines)*params.TrampolineMaxHops
+}
+
+//hopsAfter returns how many hops left after node in path
+func hopsAfter(path []common.Address, node common.Address) int {
+	for i, addr := range path {
+		if addr == node {
+			return len(path) - i - 1
+		}
+	}
+	return len(path)
+}
+
+/*
+applyRevealTimeout 中间节点和接收方使用动态的 reveal timeout 判断是否需要上链注册密码
+*/
+func (rs *Service) applyRevealTimeout(routes ...*route.State) (revealTimeout int) {
+	revealTimeout = rs.ExpirationPolicy.RevealTimeout()
+	for _, r := range routes {
+		if r != nil {
+			r.MinRevealTimeout = revealTimeout
+		}
+	}
+	return
+}
+
+//expirationRoleOf returns role of the state manager, empty for the crash node which may have any role
+func expirationRoleOf(mgr *transfer.StateManager) models.ExpirationRole {
+	switch mgr.Name {
+	case initiator.NameInitiatorTransition:
+		return models.ExpirationRoleInitiator
+	case mediator.NameMediatorTransition:
+		return models.ExpirationRoleMediator
+	case target.NameTargetTransition:
+		return models.ExpirationRoleTarget
+	}
+	return ""
+}
+
+/*
+removeExpirationRecord 交易结束,锁已经解锁或者移除,诊断记录不再需要
+*/
+func (rs *Service) removeExpirationRecord(mgr *transfer.StateManager) {
+	err := rs.dao.RemoveExpirationRecords(mgr.Identifier, expirationRoleOf(mgr))
+	if err != nil {
+		log.Error(fmt.Sprintf("RemoveExpirationRecords err %s", err))
+	}
+}
+
+func (rs *Service) saveExpirationRecord(role models.ExpirationRole, tokenAddress common.Address, lockSecretHash common.Hash, pathLength int, expiration int64, revealTimeout int) {
+	secondsPerBlock, txLatency := rs.ExpirationPolicy.Conditions()
+	r := &models.ExpirationRecord{
+		LockSecretHash:  lockSecretHash,
+		TokenAddress:    tokenAddress,
+		Role:            role,
+		PathLength:      pathLength,
+		BlockNumber:     rs.GetBlockNumber(),
+		Expiration:      expiration,
+		RevealTimeout:   revealTimeout,
+		ConfigTimeout:   rs.Config.RevealTimeout,
+		SecondsPerBlock: secondsPerBlock,
+		TxLatency:       txLatency,
+	}
+	log.Trace(fmt.Sprintf("%s choose expiration=%d,revealTimeout=%d for %s,pathLength=%d,secondsPerBlock=%.2f,txLatency=%.2f",
+		role, expiration, revealTimeout, utils.HPex(lockSecretHash), pathLength, secondsPerBlock, txLatency))
+	err := rs.dao.SaveExpirationRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveExpirationRecord err %s", err))
+	}
+}
@@ -0,0 +1,127 @@
+package photon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/crashnode"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/mediator"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpirationPolicy(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	ep := NewExpirationPolicy(db, 10)
+	// no tx observed, use config reveal timeout
+	assert.EqualValues(t, 10, ep.RevealTimeout())
+	// 15 seconds per block, 2 blocks for each hop
+	assert.EqualValues(t, 2*10+3*2, ep.LockTimeout(3))
+	assert.EqualValues(t, ep.LockTimeout(1), ep.LockTimeout(0))
+	assert.True(t, ep.LockTimeout(1) < ep.LockTimeout(5))
+
+	// offline too long, ignored
+	ep.addBlockTimeSample(1, time.Hour)
+	spb, _ := ep.Conditions()
+	assert.EqualValues(t, defaultSecondsPerBlock, spb)
+	ep.addBlockTimeSample(2, 10*time.Second)
+	spb, _ = ep.Conditions()
+	assert.True(t, spb < defaultSecondsPerBlock)
+
+	// congested chain, 10 blocks to pack a tx
+	ep.secondsPerBlock = 5
+	ep.setTxLatency(50)
+	assert.EqualValues(t, 30, ep.RevealTimeout())
+	// never too large
+	ep.setTxLatency(5000)
+	assert.EqualValues(t, 40, ep.RevealTimeout())
+
+	// reveal timeout of route hint must fit in settle timeout
+	ep.setTxLatency(0)
+	ep.secondsPerBlock = defaultSecondsPerBlock
+	assert.True(t, ep.FitsSettleTimeout(3, 0, 100))
+	assert.True(t, ep.FitsSettleTimeout(3, 30, 100))
+	assert.EqualValues(t, 2*37+3*2, ep.LockTimeoutWithRevealTimeout(3, 37))
+	assert.False(t, ep.FitsSettleTimeout(3, 47, 100))
+	assert.False(t, ep.FitsSettleTimeout(3, 1000, 100))
+}
+
+func TestTxLatencyOf(t *testing.T) {
+	var list []*models.TXInfo
+	assert.EqualValues(t, 0, txLatencyOf(list))
+	list = append(list, &models.TXInfo{IsSelfCall: false, CallTime: 100, PackTime: 1000})
+	list = append(list, &models.TXInfo{IsSelfCall: true, CallTime: 100, PackTime: 0})
+	list = append(list, &models.TXInfo{IsSelfCall: true, Status: models.TXInfoStatusFailed, CallTime: 100, PackTime: 1000})
+	assert.EqualValues(t, 0, txLatencyOf(list))
+	// only the latest txLatencySamples txs are used
+	for i := 0; i < txLatencySamples; i++ {
+		list = append(list, &models.TXInfo{IsSelfCall: true, Status: models.TXInfoStatusSuccess, CallTime: int64(1000 + i), PackTime: int64(1010 + i)})
+	}
+	list = append(list, &models.TXInfo{IsSelfCall: true, Status: models.TXInfoStatusSuccess, CallTime: 10, PackTime: 1000})
+	assert.EqualValues(t, 10, txLatencyOf(list))
+}
+
+func TestRemoveExpirationRecords(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	rs := &Service{dao: db}
+	token := utils.NewRandomAddress()
+	lockSecretHash1, lockSecretHash2 := utils.NewRandomHash(), utils.NewRandomHash()
+	save := func(lockSecretHash common.Hash, role models.ExpirationRole, expiration int64) {
+		err := db.SaveExpirationRecord(&models.ExpirationRecord{
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   token,
+			Role:           role,
+			Expiration:     expiration,
+		})
+		assert.Empty(t, err)
+	}
+	count := func() int {
+		records, err := db.GetExpirationRecords(token, utils.EmptyHash)
+		assert.Empty(t, err)
+		return len(records)
+	}
+	save(lockSecretHash1, models.ExpirationRoleMediator, 100)
+	save(lockSecretHash1, models.ExpirationRoleTarget, 100)
+	save(lockSecretHash2, models.ExpirationRoleInitiator, 200)
+	// mediator finished, the record as target is still needed
+	rs.removeExpirationRecord(transfer.NewStateManager(nil, nil, mediator.NameMediatorTransition, lockSecretHash1, token))
+	records, err := db.GetExpirationRecords(token, lockSecretHash1)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(records))
+	assert.EqualValues(t, models.ExpirationRoleTarget, records[0].Role)
+	// crash node may have any role
+	save(lockSecretHash1, models.ExpirationRoleMediator, 100)
+	rs.removeExpirationRecord(transfer.NewStateManager(nil, nil, crashnode.NameCrashNodeTransition, lockSecretHash1, token))
+	assert.EqualValues(t, 1, count())
+
+	// records are removed after the lock expires
+	save(lockSecretHash1, models.ExpirationRoleTarget, 100)
+	ep := NewExpirationPolicy(db, 10)
+	ep.OnNewBlock(100)
+	assert.EqualValues(t, 2, count())
+	ep.OnNewBlock(101)
+	assert.EqualValues(t, 1, count())
+	ep.OnNewBlock(201)
+	assert.EqualValues(t, 0, count())
+}
+
+func TestHopsAfter(t *testing.T) {
+	a, b, c := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	path := []common.Address{a, b, c}
+	assert.EqualValues(t, 2, hopsAfter(path, a))
+	assert.EqualValues(t, 0, hopsAfter(path, c))
+	assert.EqualValues(t, 3, hopsAfter(path, utils.NewRandomAddress()))
+}
@@ -0,0 +1,185 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+手续费收益分析:
+根据 FeeChargeRecord 统计一段时间内每个通道和每对上下家的手续费收益和转发量,
+手续费记在付款的通道(OutChannel)上,因为是我们在这个通道上的余额被用掉了,
+用通道的手续费收益除以我方押金得到押金的收益率,按收益率排序,并给出关闭或者充值的建议
+*/
+/*
+ *	Fee revenue analytics :
+ *	fee revenue and forwarded volume of every channel and every in/out partner pair over a period, computed from FeeChargeRecord.
+ *	fee is counted on the channel we pay (OutChannel), because it's our balance in that channel that is spent,
+ *	return on capacity is fee of the channel divided by our deposit, channels are ranked by it with a suggestion to close or fund.
+ */
+
+const (
+	//SuggestionKeep 通道收益正常
+	SuggestionKeep = "keep"
+	//SuggestionClose 这段时间没有任何转发,押金被白白占用
+	SuggestionClose = "close"
+	//SuggestionFund 转出的金额超过了我方现有余额,需要的流动性比现有的多
+	SuggestionFund = "fund"
+)
+
+//ChannelRevenue 一个通道在统计期间内的收益
+type ChannelRevenue struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	State             string         `json:"state"`
+	Capacity          *big.Int       `json:"capacity"` // 我方押金
+	Balance           *big.Int       `json:"balance"`  // 我方当前余额
+	Fee               *big.Int       `json:"fee"`      // 从这个通道转出时收取的手续费
+	InFee             *big.Int       `json:"in_fee"`   // 从这个通道转入时收取的手续费,已经计入了转出通道
+	InVolume          *big.Int       `json:"in_volume"`
+	OutVolume         *big.Int       `json:"out_volume"`
+	ForwardCount      int            `json:"forward_count"`
+	ReturnPPM         *big.Int       `json:"return_ppm"` // Fee/Capacity,单位百万分之一
+	Suggestion        string         `json:"suggestion"`
+}
+
+//PartnerPairRevenue 从 InPartner 转给 OutPartner 的交易的收益
+type PartnerPairRevenue struct {
+	InPartner    common.Address `json:"in_partner"`
+	OutPartner   common.Address `json:"out_partner"`
+	Fee          *big.Int       `json:"fee"`
+	Volume       *big.Int       `json:"volume"`
+	ForwardCount int            `json:"forward_count"`
+}
+
+//FeeAnalytics 一个 token 在统计期间内的手续费收益分析
+type FeeAnalytics struct {
+	TokenAddress common.Address        `json:"token_address"`
+	FromTime     int64                 `json:"from_time"`
+	ToTime       int64                 `json:"to_time"`
+	TotalFee     *big.Int              `json:"total_fee"`
+	TotalVolume  *big.Int              `json:"total_volume"`
+	Channels     []*ChannelRevenue     `json:"channels"` // 按 ReturnPPM 从高到低排序
+	Pairs        []*PartnerPairRevenue `json:"pairs"`    // 按 Fee 从高到低排序
+}
+
+/*
+analyzeFeeRevenue channels 是当前的通道,参与排名;settled 是已经结算的通道,只用来找到记录中通道的对方
+*/
+func analyzeFeeRevenue(token common.Address, fromTime, toTime int64, records []*models.FeeChargeRecord, channels, settled []*channeltype.Serialization) *FeeAnalytics {
+	fa := &FeeAnalytics{
+		TokenAddress: token,
+		FromTime:     fromTime,
+		ToTime:       toTime,
+		TotalFee:     big.NewInt(0),
+		TotalVolume:  big.NewInt(0),
+	}
+	partners := make(map[common.Hash]common.Address)
+	for _, c := range settled {
+		partners[c.ChannelIdentifier.ChannelIdentifier] = c.PartnerAddress()
+	}
+	revenues := make(map[common.Hash]*ChannelRevenue)
+	for _, c := range channels {
+		id := c.ChannelIdentifier.ChannelIdentifier
+		partners[id] = c.PartnerAddress()
+		cr := &ChannelRevenue{
+			ChannelIdentifier: id,
+			PartnerAddress:    c.PartnerAddress(),
+			State:             c.State.String(),
+			Capacity:          new(big.Int).Set(c.OurContractBalance),
+			Balance:           c.OurBalance(),
+			Fee:               big.NewInt(0),
+			InFee:             big.NewInt(0),
+			InVolume:          big.NewInt(0),
+			OutVolume:         big.NewInt(0),
+			ReturnPPM:         big.NewInt(0),
+		}
+		revenues[id] = cr
+		fa.Channels = append(fa.Channels, cr)
+	}
+	pairs := make(map[string]*PartnerPairRevenue)
+	for _, r := range records {
+		fa.TotalFee.Add(fa.TotalFee, r.Fee)
+		fa.TotalVolume.Add(fa.TotalVolume, r.TransferAmount)
+		if cr := revenues[r.InChannel]; cr != nil {
+			cr.InFee.Add(cr.InFee, r.Fee)
+			cr.InVolume.Add(cr.InVolume, r.TransferAmount)
+			cr.ForwardCount++
+		}
+		if cr := revenues[r.OutChannel]; cr != nil {
+			cr.Fee.Add(cr.Fee, r.Fee)
+			cr.OutVolume.Add(cr.OutVolume, r.TransferAmount)
+			cr.ForwardCount++
+		}
+		in, out := partners[r.InChannel], partners[r.OutChannel]
+		key := fmt.Sprintf("%s-%s", in.String(), out.String())
+		p := pairs[key]
+		if p == nil {
+			p = &PartnerPairRevenue{
+				InPartner:  in,
+				OutPartner: out,
+				Fee:        big.NewInt(0),
+				Volume:     big.NewInt(0),
+			}
+			pairs[key] = p
+			fa.Pairs = append(fa.Pairs, p)
+		}
+		p.Fee.Add(p.Fee, r.Fee)
+		p.Volume.Add(p.Volume, r.TransferAmount)
+		p.ForwardCount++
+	}
+	for _, cr := range fa.Channels {
+		if cr.Capacity.Sign() > 0 {
+			cr.ReturnPPM.Mul(cr.Fee, big.NewInt(1000000))
+			cr.ReturnPPM.Div(cr.ReturnPPM, cr.Capacity)
+		}
+		switch {
+		case cr.ForwardCount == 0:
+			cr.Suggestion = SuggestionClose
+		case cr.OutVolume.Cmp(cr.Balance) > 0:
+			cr.Suggestion = SuggestionFund
+		default:
+			cr.Suggestion = SuggestionKeep
+		}
+	}
+	sort.SliceStable(fa.Channels, func(i, j int) bool {
+		c := fa.Channels[i].ReturnPPM.Cmp(fa.Channels[j].ReturnPPM)
+		if c == 0 {
+			return fa.Channels[i].Fee.Cmp(fa.Channels[j].Fee) > 0
+		}
+		return c > 0
+	})
+	sort.SliceStable(fa.Pairs, func(i, j int) bool {
+		return fa.Pairs[i].Fee.Cmp(fa.Pairs[j].Fee) > 0
+	})
+	return fa
+}
+
+//getFeeAnalytics 统计 token 在 [fromTime,toTime] 之间的手续费收益
+func (rs *Service) getFeeAnalytics(token common.Address, fromTime, toTime int64) (fa *FeeAnalytics, err error) {
+	records, err := rs.dao.GetAllFeeChargeRecord(token, fromTime, toTime)
+	if err != nil {
+		return
+	}
+	channels, err := rs.dao.GetChannelList(token, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	allSettled, err := rs.dao.GetAllSettledChannel()
+	if err != nil {
+		return
+	}
+	var settled []*channeltype.Serialization
+	for _, c := range allSettled {
+		if c.TokenAddress() == token {
+			settled = append(settled, c)
+		}
+	}
+	return analyzeFeeRevenue(token, fromTime, toTime, records, channels, settled), nil
+}
@@ -0,0 +1,74 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAnalyticsTestChannel(token, partner common.Address, ours int64) *channeltype.Serialization {
+	h := utils.NewRandomHash()
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier = &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3}
+	c.Key = h[:]
+	c.TokenAddressBytes = token[:]
+	c.PartnerAddressBytes = partner[:]
+	c.State = channeltype.StateOpened
+	c.OurContractBalance = big.NewInt(ours)
+	c.PartnerContractBalance = big.NewInt(ours)
+	return c
+}
+
+func TestAnalyzeFeeRevenue(t *testing.T) {
+	token := utils.NewRandomAddress()
+	a, b, c, d := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	chA := newAnalyticsTestChannel(token, a, 1000)
+	chB := newAnalyticsTestChannel(token, b, 100)
+	chC := newAnalyticsTestChannel(token, c, 1000)
+	chD := newAnalyticsTestChannel(token, d, 1000)
+	chD.State = channeltype.StateSettled
+	forward := func(in, out *channeltype.Serialization, amount, fee int64) *models.FeeChargeRecord {
+		return &models.FeeChargeRecord{
+			TokenAddress:   token,
+			InChannel:      in.ChannelIdentifier.ChannelIdentifier,
+			OutChannel:     out.ChannelIdentifier.ChannelIdentifier,
+			TransferAmount: big.NewInt(amount),
+			Fee:            big.NewInt(fee),
+		}
+	}
+	records := []*models.FeeChargeRecord{
+		forward(chA, chB, 80, 2),
+		forward(chA, chB, 70, 2),
+		forward(chB, chA, 10, 1),
+		forward(chD, chA, 10, 5),
+	}
+	fa := analyzeFeeRevenue(token, 0, 0, records, []*channeltype.Serialization{chA, chB, chC}, []*channeltype.Serialization{chD})
+	assert.EqualValues(t, 10, fa.TotalFee.Int64())
+	assert.EqualValues(t, 170, fa.TotalVolume.Int64())
+	assert.Len(t, fa.Channels, 3)
+	// b earns 4 on a deposit of 100, a earns 6 on 1000
+	assert.Equal(t, b, fa.Channels[0].PartnerAddress)
+	assert.EqualValues(t, 40000, fa.Channels[0].ReturnPPM.Int64())
+	assert.EqualValues(t, 150, fa.Channels[0].OutVolume.Int64())
+	assert.EqualValues(t, 1, fa.Channels[0].InFee.Int64())
+	assert.Equal(t, SuggestionFund, fa.Channels[0].Suggestion)
+	assert.Equal(t, a, fa.Channels[1].PartnerAddress)
+	assert.EqualValues(t, 6000, fa.Channels[1].ReturnPPM.Int64())
+	assert.Equal(t, SuggestionKeep, fa.Channels[1].Suggestion)
+	assert.Equal(t, c, fa.Channels[2].PartnerAddress)
+	assert.Equal(t, SuggestionClose, fa.Channels[2].Suggestion)
+
+	assert.Len(t, fa.Pairs, 3)
+	assert.Equal(t, d, fa.Pairs[0].InPartner)
+	assert.Equal(t, a, fa.Pairs[0].OutPartner)
+	assert.Equal(t, a, fa.Pairs[1].InPartner)
+	assert.Equal(t, b, fa.Pairs[1].OutPartner)
+	assert.EqualValues(t, 4, fa.Pairs[1].Fee.Int64())
+	assert.EqualValues(t, 2, fa.Pairs[1].ForwardCount)
+}
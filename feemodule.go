@@ -9,6 +9,9 @@ import (
 
 	"fmt"
 
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/models"
 	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
@@ -16,6 +19,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
+//动态收费变化后多久内提交给 pfs
+const dynamicFeeRefreshInterval = time.Minute
+
 //NoFeePolicy charge no fee
 type NoFeePolicy struct {
 }
@@ -30,6 +36,8 @@ type FeeModule struct {
 	dao       models.Dao
 	pfsProxy  pfsproxy.PfsProxy
 	feePolicy *models.FeePolicy
+	submitted *models.FeePolicy // 最后一次成功提交给 pfs 的收费策略
+	now       func() time.Time
 	lock      sync.Mutex
 }
 
@@ -42,6 +50,7 @@ func NewFeeModule(dao models.Dao, pfsProxy pfsproxy.PfsProxy) (fm *FeeModule, er
 		dao:       dao,
 		pfsProxy:  pfsProxy,
 		feePolicy: dao.GetFeePolicy(),
+		now:       time.Now,
 	}
 	if fm.pfsProxy != nil {
 		log.Info("init fee module with pfs success")
@@ -65,11 +74,17 @@ func (fm *FeeModule) SetFeePolicy(fp *models.FeePolicy) (err error) {
 	if fp.ChannelFeeMap == nil {
 		return errors.New("ChannelFeeMap can not be nil")
 	}
+	if fp.DynamicFee != nil {
+		err = fp.DynamicFee.Validate()
+		if err != nil {
+			return
+		}
+	}
 	fm.lock.Lock()
 	defer fm.lock.Unlock()
 	// set fee policy to pfs
 	if fm.pfsProxy != nil {
-		err = fm.pfsProxy.SetFeePolicy(fp)
+		err = fm.submit(fp)
 		if err != nil {
 			log.Error(fmt.Sprintf("commit fee policy to pfs failed, err = %s", err.Error()))
 			return
@@ -90,30 +105,199 @@ func (fm *FeeModule) SetFeePolicy(fp *models.FeePolicy) (err error) {
 //SubmitFeePolicyToPFS :
 func (fm *FeeModule) SubmitFeePolicyToPFS() (err error) {
 	if fm.pfsProxy != nil {
-		err = fm.pfsProxy.SetFeePolicy(fm.feePolicy)
+		fm.lock.Lock()
+		defer fm.lock.Unlock()
+		err = fm.submit(fm.feePolicy)
+	}
+	return
+}
+
+/*
+RefreshDynamicFee 启用动态收费时,通道余额和时间段变化会改变实际的收费,有变化时重新提交给 pfs
+*/
+func (fm *FeeModule) RefreshDynamicFee() (err error) {
+	if fm.pfsProxy == nil {
+		return
+	}
+	fm.lock.Lock()
+	defer fm.lock.Unlock()
+	if fm.feePolicy.DynamicFee == nil {
+		return
+	}
+	if fm.submitted != nil && sameFeePolicy(fm.submitted, fm.effectiveFeePolicy(fm.feePolicy)) {
+		return
+	}
+	log.Info("dynamic fee changed, submit fee policy to pfs")
+	return fm.submit(fm.feePolicy)
+}
+
+func (fm *FeeModule) submit(fp *models.FeePolicy) (err error) {
+	efp := fm.effectiveFeePolicy(fp)
+	err = fm.pfsProxy.SetFeePolicy(efp)
+	if err == nil {
+		fm.submitted = efp
 	}
 	return
 }
 
-//GetNodeChargeFee : impl of FeeCharge
+/*
+effectiveFeePolicy pfs 只支持静态的收费设置,把动态收费换算成我们每个通道当前的设置.
+实际收费 GetNodeChargeFee 用的也是这个设置,所以 pfs 的报价和我们收取的手续费一致
+*/
+func (fm *FeeModule) effectiveFeePolicy(fp *models.FeePolicy) *models.FeePolicy {
+	if fp.DynamicFee == nil {
+		return fp
+	}
+	efp := &models.FeePolicy{
+		Key:           fp.Key,
+		AccountFee:    scaleFeeSetting(fp.AccountFee, 100),
+		TokenFeeMap:   make(map[common.Address]*models.FeeSetting),
+		ChannelFeeMap: make(map[common.Hash]*models.FeeSetting),
+	}
+	for token, fs := range fp.TokenFeeMap {
+		efp.TokenFeeMap[token] = scaleFeeSetting(fs, 100)
+	}
+	for ch, fs := range fp.ChannelFeeMap {
+		efp.ChannelFeeMap[ch] = scaleFeeSetting(fs, 100)
+	}
+	channels, err := fm.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetChannelList err %s", err))
+	}
+	for _, c := range channels {
+		if c.State != channeltype.StateOpened {
+			continue
+		}
+		efp.ChannelFeeMap[c.ChannelIdentifier.ChannelIdentifier] = fm.channelFeeSetting(fp, c.TokenAddress(), c)
+	}
+	return efp
+}
+
+//ChannelFeeSetting 通道 c 当前实际的收费设置,启用动态收费时按当前余额和时间段换算
+func (fm *FeeModule) ChannelFeeSetting(c *channeltype.Serialization) *models.FeeSetting {
+	fm.lock.Lock()
+	defer fm.lock.Unlock()
+	if fm.pfsProxy != nil && fm.submitted != nil {
+		return staticFeeSetting(fm.submitted, c.TokenAddress(), c)
+	}
+	return fm.channelFeeSetting(fm.feePolicy, c.TokenAddress(), c)
+}
+
+//channelFeeSetting 和 effectiveFeePolicy 中提交给 pfs 的设置相同
+func (fm *FeeModule) channelFeeSetting(fp *models.FeePolicy, tokenAddress common.Address, c *channeltype.Serialization) *models.FeeSetting {
+	fs := staticFeeSetting(fp, tokenAddress, c)
+	// 动态收费只作用于我们和 nodeAddress 之间的通道
+	if fp.DynamicFee == nil || c == nil {
+		return fs
+	}
+	return scaleFeeSetting(fs, fm.dynamicRate(fp.DynamicFee, c, utils.BigInt0))
+}
+
+/*
+GetNodeChargeFee : impl of FeeCharge
+有 pfs 时按最后一次提交给 pfs 的设置收费,保证和 pfs 的报价一致,余额变化由 RefreshDynamicFee 重新提交.
+没有 pfs 时按交易之后的余额计算动态收费,会耗尽我方余额的交易收费更高
+*/
 func (fm *FeeModule) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
-	var feeSetting *models.FeeSetting
-	var ok bool
-	// 优先channel
 	c, err := fm.dao.GetChannel(tokenAddress, nodeAddress)
-	if c != nil && err == nil {
-		feeSetting, ok = fm.feePolicy.ChannelFeeMap[c.ChannelIdentifier.ChannelIdentifier]
+	if err != nil {
+		c = nil
+	}
+	fm.lock.Lock()
+	defer fm.lock.Unlock()
+	if fm.pfsProxy != nil && fm.submitted != nil {
+		return calculateFee(staticFeeSetting(fm.submitted, tokenAddress, c), amount)
+	}
+	fee := calculateFee(staticFeeSetting(fm.feePolicy, tokenAddress, c), amount)
+	if fm.feePolicy.DynamicFee == nil || c == nil {
+		return fee
+	}
+	fee = fee.Mul(fee, big.NewInt(fm.dynamicRate(fm.feePolicy.DynamicFee, c, amount)))
+	return fee.Div(fee, big.NewInt(100))
+}
+
+// staticFeeSetting 优先channel,其次token,最后account
+func staticFeeSetting(fp *models.FeePolicy, tokenAddress common.Address, c *channeltype.Serialization) *models.FeeSetting {
+	if c != nil {
+		feeSetting, ok := fp.ChannelFeeMap[c.ChannelIdentifier.ChannelIdentifier]
 		if ok {
-			return calculateFee(feeSetting, amount)
+			return feeSetting
 		}
 	}
-	// 其次token
-	feeSetting, ok = fm.feePolicy.TokenFeeMap[tokenAddress]
+	feeSetting, ok := fp.TokenFeeMap[tokenAddress]
 	if ok {
-		return calculateFee(feeSetting, amount)
+		return feeSetting
+	}
+	return fp.AccountFee
+}
+
+/*
+dynamicRate 返回手续费应该按静态设置的百分之多少收取,
+先根据当前时间段确定基础比例,再根据我方余额在通道中的占比调整:
+占比为0时增加 ImbalanceRate%,占比为一半时不变,占比为100%时减少 ImbalanceRate%.
+占比按转出 amount 之后的余额计算,提交给 pfs 时 amount 为0
+*/
+func (fm *FeeModule) dynamicRate(d *models.DynamicFeeSetting, c *channeltype.Serialization, amount *big.Int) int64 {
+	rate := int64(100)
+	hour := fm.now().UTC().Hour()
+	for _, s := range d.Schedules {
+		if s.Contains(hour) {
+			rate = s.Rate
+			break
+		}
+	}
+	if d.ImbalanceRate == 0 {
+		return rate
+	}
+	ours := new(big.Int).Sub(c.OurBalance(), c.OurAmountLocked())
+	total := new(big.Int).Add(ours, c.PartnerBalance())
+	ours.Sub(ours, amount)
+	if total.Sign() <= 0 {
+		return rate
+	}
+	if ours.Sign() < 0 {
+		ours.SetInt64(0)
+	}
+	share := ours.Mul(ours, big.NewInt(100)).Div(ours, total).Int64()
+	adjust := d.ImbalanceRate * (50 - share) / 50
+	return rate * (100 + adjust) / 100
+}
+
+//scaleFeeSetting 按 rate% 调整收费设置
+func scaleFeeSetting(fs *models.FeeSetting, rate int64) *models.FeeSetting {
+	scaled := &models.FeeSetting{
+		FeeConstant: new(big.Int).Div(new(big.Int).Mul(fs.FeeConstant, big.NewInt(rate)), big.NewInt(100)),
+	}
+	if fs.FeePercent > 0 && rate > 0 {
+		scaled.FeePercent = fs.FeePercent * 100 / rate
+		if scaled.FeePercent == 0 {
+			scaled.FeePercent = 1
+		}
+	}
+	return scaled
+}
+
+func sameFeeSetting(a, b *models.FeeSetting) bool {
+	return a.FeePercent == b.FeePercent && a.FeeConstant.Cmp(b.FeeConstant) == 0
+}
+
+func sameFeePolicy(a, b *models.FeePolicy) bool {
+	if !sameFeeSetting(a.AccountFee, b.AccountFee) || len(a.TokenFeeMap) != len(b.TokenFeeMap) || len(a.ChannelFeeMap) != len(b.ChannelFeeMap) {
+		return false
+	}
+	for token, fs := range a.TokenFeeMap {
+		fs2, ok := b.TokenFeeMap[token]
+		if !ok || !sameFeeSetting(fs, fs2) {
+			return false
+		}
+	}
+	for ch, fs := range a.ChannelFeeMap {
+		fs2, ok := b.ChannelFeeMap[ch]
+		if !ok || !sameFeeSetting(fs, fs2) {
+			return false
+		}
 	}
-	// 最后account
-	return calculateFee(fm.feePolicy.AccountFee, amount)
+	return true
 }
 
 func calculateFee(feeSetting *models.FeeSetting, amount *big.Int) *big.Int {
@@ -4,10 +4,13 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
 
 	"math/big"
 
@@ -115,6 +118,126 @@ func TestFeeModule_WithPFS(t *testing.T) {
 	}
 }
 
+func TestFeeModule_Dynamic(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	fm, err := NewFeeModule(db, nil)
+	fm.now = func() time.Time {
+		return time.Date(2019, 1, 1, 23, 0, 0, 0, time.UTC)
+	}
+	tokenAddress, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	h := utils.NewRandomHash()
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier = &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3}
+	c.Key = h[:]
+	c.TokenAddressBytes = tokenAddress[:]
+	c.PartnerAddressBytes = partner[:]
+	c.State = channeltype.StateOpened
+	c.OurContractBalance = big.NewInt(50000)
+	c.PartnerContractBalance = big.NewInt(150000)
+	err = db.NewChannel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := models.NewDefaultFeePolicy()
+	fp.AccountFee.FeePercent = 100
+	fp.DynamicFee = &models.DynamicFeeSetting{ImbalanceRate: 200}
+	assert.NotNil(t, fm.SetFeePolicy(fp))
+	fp.DynamicFee.ImbalanceRate = 50
+	assert.Nil(t, fm.SetFeePolicy(fp))
+	amount := big.NewInt(10000)
+	// static fee 100, 20% of the balance on our side after the transfer
+	assert.EqualValues(t, 130, fm.GetNodeChargeFee(partner, tokenAddress, amount).Int64())
+	// a node we have no channel with uses static fee
+	assert.EqualValues(t, 100, fm.GetNodeChargeFee(utils.NewRandomAddress(), tokenAddress, amount).Int64())
+	// a transfer that would drain our side pays the most
+	assert.EqualValues(t, 750, fm.GetNodeChargeFee(partner, tokenAddress, big.NewInt(50000)).Int64())
+	assert.EqualValues(t, 900, fm.GetNodeChargeFee(partner, tokenAddress, big.NewInt(60000)).Int64())
+
+	// night is cheaper
+	fp.DynamicFee.Schedules = []*models.FeeSchedule{{StartHour: 22, EndHour: 6, Rate: 50}}
+	assert.Nil(t, fm.SetFeePolicy(fp))
+	assert.EqualValues(t, 65, fm.GetNodeChargeFee(partner, tokenAddress, amount).Int64())
+
+	// pfs gets the fee of the channel at current balance
+	efp := fm.effectiveFeePolicy(fp)
+	fs := efp.ChannelFeeMap[h]
+	assert.EqualValues(t, 161, fs.FeePercent)
+	assert.EqualValues(t, 100, efp.AccountFee.FeePercent)
+	assert.True(t, sameFeePolicy(efp, fm.effectiveFeePolicy(fp)))
+	fm.now = func() time.Time {
+		return time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	}
+	assert.False(t, sameFeePolicy(efp, fm.effectiveFeePolicy(fp)))
+}
+
+//feeTestPfs 只实现收费用到的接口
+type feeTestPfs struct {
+	pfsproxy.PfsProxy
+	submitted *models.FeePolicy
+}
+
+func (p *feeTestPfs) SetFeePolicy(fp *models.FeePolicy) error {
+	p.submitted = fp
+	return nil
+}
+
+func TestFeeModule_DynamicWithPFS(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	pfs := &feeTestPfs{}
+	fm, err := NewFeeModule(db, pfs)
+	fm.now = func() time.Time {
+		return time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	}
+	tokenAddress, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	h := utils.NewRandomHash()
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier = &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3}
+	c.Key = h[:]
+	c.TokenAddressBytes = tokenAddress[:]
+	c.PartnerAddressBytes = partner[:]
+	c.State = channeltype.StateOpened
+	c.OurContractBalance = big.NewInt(50000)
+	c.PartnerContractBalance = big.NewInt(150000)
+	err = db.NewChannel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := models.NewDefaultFeePolicy()
+	fp.AccountFee.FeePercent = 100
+	fp.DynamicFee = &models.DynamicFeeSetting{ImbalanceRate: 50}
+	assert.Nil(t, fm.SetFeePolicy(fp))
+	fs := pfs.submitted.ChannelFeeMap[h]
+	assert.EqualValues(t, 80, fs.FeePercent)
+	// we charge exactly what pfs quotes, whatever the amount
+	for _, a := range []int64{1, 999, 10000, 50000} {
+		assert.EqualValues(t, calculateFee(fs, big.NewInt(a)), fm.GetNodeChargeFee(partner, tokenAddress, big.NewInt(a)))
+	}
+	assert.True(t, sameFeeSetting(fs, fm.ChannelFeeSetting(c)))
+
+	// balance changes, the fee does not change until it is submitted again
+	c.OurContractBalance = big.NewInt(150000)
+	c.PartnerContractBalance = big.NewInt(50000)
+	err = db.UpdateChannelNoTx(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, calculateFee(fs, big.NewInt(10000)), fm.GetNodeChargeFee(partner, tokenAddress, big.NewInt(10000)))
+	assert.Nil(t, fm.RefreshDynamicFee())
+	fs = pfs.submitted.ChannelFeeMap[h]
+	assert.EqualValues(t, 133, fs.FeePercent)
+	assert.EqualValues(t, calculateFee(fs, big.NewInt(10000)), fm.GetNodeChargeFee(partner, tokenAddress, big.NewInt(10000)))
+}
+
 // newTestStormDb :
 func newTestStormDb() (dao models.Dao, err error) {
 	dbPath := path.Join(os.TempDir(), "testxxxx.dao")
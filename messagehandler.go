@@ -31,16 +31,37 @@ import (
 type photonMessageHandler struct {
 	photon        *Service
 	blockedTokens map[common.Address]bool
+	knownPubKeys  map[common.Address]bool
 }
 
 func newPhotonMessageHandler(photon *Service) *photonMessageHandler {
 	h := &photonMessageHandler{
 		photon:        photon,
 		blockedTokens: make(map[common.Address]bool),
+		knownPubKeys:  make(map[common.Address]bool),
 	}
 	return h
 }
 
+/*
+记住发送方的公钥,以后给该节点发起交易时可以用来加密附加信息
+*/
+func (mh *photonMessageHandler) rememberSenderPubKey(msg encoding.SignedMessager) {
+	if mh.knownPubKeys[msg.GetSender()] {
+		return
+	}
+	pubkey, err := encoding.RecoverSenderPubkey(msg)
+	if err != nil {
+		return
+	}
+	err = mh.photon.dao.SaveNodePubKey(msg.GetSender(), pubkey)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveNodePubKey for %s err %s", utils.APex2(msg.GetSender()), err))
+		return
+	}
+	mh.knownPubKeys[msg.GetSender()] = true
+}
+
 /*
  Handles `message` and sends an ACK on success.
 */
@@ -48,6 +69,7 @@ func (mh *photonMessageHandler) onMessage(msg encoding.SignedMessager, hash comm
 	msg.SetTag(&transfer.MessageTag{
 		EchoHash: hash,
 	})
+	mh.rememberSenderPubKey(msg)
 	switch m2 := msg.(type) {
 	case *encoding.SecretRequest:
 		f := mh.photon.SecretRequestPredictorMap[m2.LockSecretHash]
@@ -253,7 +275,8 @@ func (mh *photonMessageHandler) messageUnlock(msg *encoding.UnLock) error {
 	/*
 		验证过消息是有效的,然后通知相应的 stateMana 该结束的结束,
 	*/
-	smkey := utils.Sha3(lockSecretHash[:], ch.TokenAddress[:])
+	//再平衡时 unlock 是给接收方的
+	smkey := mh.photon.receiverStateManagerKey(utils.Sha3(lockSecretHash[:], ch.TokenAddress[:]))
 	mh.balanceProof(msg, smkey)
 	mh.photon.UpdateChannelAndSaveAck(ch, msg.Tag())
 	// submit balance proof to pathfinder
@@ -595,7 +618,6 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 	key := swapKey{
 		LockSecretHash: msg.LockSecretHash,
 		FromToken:      token,
-		FromAmount:     msg.PaymentAmount.String(),
 	}
 	if tokenswap, ok := mh.photon.SwapKey2TokenSwap[key]; ok {
 		remove := mh.photon.messageTokenSwapTaker(msg, tokenswap)
@@ -0,0 +1,49 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/encoding"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRememberSenderPubKey(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	api := &API{Photon: rs}
+	mh := newPhotonMessageHandler(rs)
+	key, _ := crypto.GenerateKey()
+	target := crypto.PubkeyToAddress(key.PublicKey)
+
+	//从未收到过 target 的消息,无法加密
+	_, err := api.EncryptTransferData(target, "order 42")
+	assert.NotNil(t, err)
+	encdata, err := api.EncryptTransferData(target, "")
+	assert.Nil(t, err)
+	assert.Empty(t, encdata)
+
+	//没有签名的消息不能恢复公钥
+	mh.rememberSenderPubKey(encoding.NewSecretRequest(utils.NewRandomHash(), big.NewInt(10)))
+	assert.Empty(t, mh.knownPubKeys)
+
+	msg := encoding.NewSecretRequest(utils.NewRandomHash(), big.NewInt(10))
+	err = msg.Sign(key, msg)
+	assert.Nil(t, err)
+	mh.rememberSenderPubKey(msg)
+	assert.True(t, mh.knownPubKeys[target])
+	pubkey, err := dao.GetNodePubKey(target)
+	assert.Nil(t, err)
+	assert.EqualValues(t, crypto.FromECDSAPub(&key.PublicKey), pubkey)
+
+	encdata, err = api.EncryptTransferData(target, "order 42")
+	assert.Nil(t, err)
+	assert.True(t, utils.IsEncryptedData(encdata))
+	data, err := utils.DecryptData(key, encdata)
+	assert.Nil(t, err)
+	assert.Equal(t, "order 42", data)
+}
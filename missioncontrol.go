@@ -0,0 +1,320 @@
+package photon
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+MissionControl 记录发起方在每个通道方向上观察到的成功和失败:
+1. 成功记录能通过的金额下限,失败记录能通过的金额上限.收到 AnnounceDisposed 时只有发送它的节点转出的通道记一次失败
+2. 失败的上限随时间放大,每过 observationHalfLife 翻倍,超过上限的金额只是降低成功的可能性,不会被排除
+3. 节点的可靠性来自它作为 From 的所有通道随时间衰减的成功和失败次数,按节点缓存
+4. 超过 maxObservationAge 没有更新的观察结果被删除
+本地路由通过 graph.RouteScorer 使用这些信息,从 pfs 得到的路由也按照成功的可能性排序
+*/
+/*
+ *	MissionControl : successes and failures observed by the initiator on each direction of channels.
+ *	1. a success gives a lower bound of amount can be sent, a failure gives an upper bound. when AnnounceDisposed
+ *		is received, only the channel sent by the node reporting it gets a failure.
+ *	2. the failure upper bound doubles every observationHalfLife, amounts above it are less likely to succeed but not excluded.
+ *	3. reliability of a node comes from decayed counts of successes and failures of channels it sends on, cached per node.
+ *	4. observations not updated for maxObservationAge are removed.
+ *	local routing uses it as graph.RouteScorer, routes from pfs are also ordered by probability of success.
+ */
+
+const (
+	// 观察结果的影响每过这么久减半
+	observationHalfLife = time.Hour
+	// 超过这个时间没有更新的观察结果不再使用
+	maxObservationAge = 24 * time.Hour
+	// 没有失败记录的节点可靠性为1,每次失败按照这个先验降低
+	reliabilityPriorSuccesses = 2
+	// 金额不低于失败上限时通道成功的可能性
+	failedChannelProbability = 0.1
+)
+
+//nodeStats 节点作为 From 的所有通道的成功和失败次数之和,衰减到 updateTime
+type nodeStats struct {
+	successes  float64
+	failures   float64
+	updateTime int64
+}
+
+func (n *nodeStats) decayTo(now time.Time) {
+	f := decayFactor(now.Sub(time.Unix(n.updateTime, 0)))
+	n.successes *= f
+	n.failures *= f
+	n.updateTime = now.Unix()
+}
+
+// MissionControl learns channel capacity and node reliability from the results of routes
+type MissionControl struct {
+	dao          models.Dao
+	observations map[string]*models.ChannelObservation
+	nodes        map[common.Address]*nodeStats
+	lock         sync.Mutex
+}
+
+// ChannelObservationInfo observation with the estimates derived from it, for debug
+type ChannelObservationInfo struct {
+	*models.ChannelObservation
+	EstimatedCapacity *big.Int `json:"estimated_capacity"` // nil means unknown
+	Reliability       float64  `json:"reliability"`        // reliability of From
+}
+
+// NewMissionControl :
+func NewMissionControl(dao models.Dao) *MissionControl {
+	mc := &MissionControl{
+		dao:          dao,
+		observations: make(map[string]*models.ChannelObservation),
+		nodes:        make(map[common.Address]*nodeStats),
+	}
+	list, err := dao.GetAllChannelObservations()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetAllChannelObservations err %s", err))
+	}
+	now := time.Now()
+	for _, o := range list {
+		mc.observations[o.Key] = o
+		n := mc.nodeStats(o.From, now)
+		f := decayFactor(now.Sub(time.Unix(o.UpdateTime, 0)))
+		n.successes += o.Successes * f
+		n.failures += o.Failures * f
+	}
+	mc.expire(now)
+	return mc
+}
+
+func decayFactor(age time.Duration) float64 {
+	if age <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, age.Seconds()/observationHalfLife.Seconds())
+}
+
+/*
+OnRouteFinished 我们自己的通道余额是确定的,只记录第一跳以后的通道.
+成功时路径上所有的通道都记一次成功,失败时只有 Reporter 转出的通道记一次失败,其他节点并没有报告失败
+*/
+func (mc *MissionControl) OnRouteFinished(e *mediatedtransfer.EventRouteFinished) {
+	if e.Amount == nil {
+		return
+	}
+	now := time.Now()
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.expire(now)
+	if e.Success {
+		for i := 0; i < len(e.Path)-1; i++ {
+			mc.observe(e.Token, e.Path[i], e.Path[i+1], e.Amount, true, now)
+		}
+		return
+	}
+	log.Trace(fmt.Sprintf("mission control record failure of path %s reported by %s amount=%s,reason=%s",
+		utils.StringInterface(e.Path, 2), utils.APex2(e.Reporter), e.Amount, e.Reason))
+	for i := 0; i < len(e.Path)-1; i++ {
+		if e.Path[i] == e.Reporter {
+			mc.observe(e.Token, e.Path[i], e.Path[i+1], e.Amount, false, now)
+			return
+		}
+	}
+}
+
+func (mc *MissionControl) observe(tokenAddress, from, to common.Address, amount *big.Int, success bool, now time.Time) {
+	key := models.ChannelObservationKey(tokenAddress, from, to)
+	o := mc.observations[key]
+	if o == nil {
+		o = &models.ChannelObservation{
+			Key:          key,
+			TokenAddress: tokenAddress,
+			From:         from,
+			To:           to,
+		}
+		mc.observations[key] = o
+	}
+	f := decayFactor(now.Sub(time.Unix(o.UpdateTime, 0)))
+	o.Successes *= f
+	o.Failures *= f
+	n := mc.nodeStats(from, now)
+	if success {
+		o.Successes++
+		n.successes++
+		if o.SuccessAmount == nil || amount.Cmp(o.SuccessAmount) > 0 {
+			o.SuccessAmount = new(big.Int).Set(amount)
+		}
+		o.SuccessTime = now.Unix()
+		//the failure bound is wrong now
+		if o.FailAmount != nil && amount.Cmp(o.FailAmount) >= 0 {
+			o.FailAmount = nil
+			o.FailTime = 0
+		}
+	} else {
+		o.Failures++
+		n.failures++
+		bound, ok := failBound(o, now)
+		if !ok || amount.Cmp(bound) < 0 {
+			bound = amount
+		}
+		o.FailAmount = new(big.Int).Set(bound)
+		o.FailTime = now.Unix()
+		//the balance has moved
+		if o.SuccessAmount != nil && o.SuccessAmount.Cmp(amount) >= 0 {
+			o.SuccessAmount = nil
+			o.SuccessTime = 0
+		}
+	}
+	o.UpdateTime = now.Unix()
+	err := mc.dao.SaveChannelObservation(o)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveChannelObservation err %s", err))
+	}
+}
+
+//nodeStats 返回衰减到 now 的节点统计,没有时创建
+func (mc *MissionControl) nodeStats(node common.Address, now time.Time) *nodeStats {
+	n := mc.nodes[node]
+	if n == nil {
+		n = &nodeStats{updateTime: now.Unix()}
+		mc.nodes[node] = n
+	}
+	n.decayTo(now)
+	return n
+}
+
+//expire 删除超过 maxObservationAge 没有更新的观察结果,它们在节点统计中的次数已经衰减到可以忽略
+func (mc *MissionControl) expire(now time.Time) {
+	for key, o := range mc.observations {
+		if now.Sub(time.Unix(o.UpdateTime, 0)) <= maxObservationAge {
+			continue
+		}
+		delete(mc.observations, key)
+		err := mc.dao.RemoveChannelObservation(key)
+		if err != nil {
+			log.Error(fmt.Sprintf("RemoveChannelObservation err %s", err))
+		}
+	}
+	for node, n := range mc.nodes {
+		if now.Sub(time.Unix(n.updateTime, 0)) > maxObservationAge {
+			delete(mc.nodes, node)
+		}
+	}
+}
+
+//failBound the smallest amount that is expected to fail now
+func failBound(o *models.ChannelObservation, now time.Time) (bound *big.Int, ok bool) {
+	if o.FailAmount == nil {
+		return
+	}
+	age := now.Sub(time.Unix(o.FailTime, 0))
+	if age > maxObservationAge {
+		return
+	}
+	growth := 1 / decayFactor(age)
+	b, _ := new(big.Float).Mul(new(big.Float).SetInt(o.FailAmount), big.NewFloat(growth)).Int(nil)
+	return b, true
+}
+
+func estimateCapacity(o *models.ChannelObservation, now time.Time) (capacity *big.Int, ok bool) {
+	bound, ok := failBound(o, now)
+	if !ok {
+		return
+	}
+	return bound.Sub(bound, big.NewInt(1)), true
+}
+
+// ChannelProbability implements graph.RouteScorer
+func (mc *MissionControl) ChannelProbability(tokenAddress, from, to common.Address, amount *big.Int) float64 {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	return mc.channelProbability(tokenAddress, from, to, amount, time.Now())
+}
+
+//channelProbability 金额不超过估计的容量时为1,否则为 failedChannelProbability
+func (mc *MissionControl) channelProbability(tokenAddress, from, to common.Address, amount *big.Int, now time.Time) float64 {
+	o := mc.observations[models.ChannelObservationKey(tokenAddress, from, to)]
+	if o == nil {
+		return 1
+	}
+	capacity, ok := estimateCapacity(o, now)
+	if ok && capacity.Cmp(amount) < 0 {
+		return failedChannelProbability
+	}
+	return 1
+}
+
+// NodeReliability implements graph.RouteScorer
+func (mc *MissionControl) NodeReliability(node common.Address) float64 {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	return mc.nodeReliability(node, time.Now())
+}
+
+func (mc *MissionControl) nodeReliability(node common.Address, now time.Time) float64 {
+	n := mc.nodes[node]
+	if n == nil {
+		return 1
+	}
+	f := decayFactor(now.Sub(time.Unix(n.updateTime, 0)))
+	successes, failures := n.successes*f, n.failures*f
+	return (successes + reliabilityPriorSuccesses) / (successes + failures + reliabilityPriorSuccesses)
+}
+
+//routeProbability our own channel is checked by the initiator, so only channels after the first hop are counted
+func (mc *MissionControl) routeProbability(tokenAddress common.Address, amount *big.Int, path []common.Address) float64 {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	now := time.Now()
+	p := 1.0
+	for i := 0; i < len(path)-1; i++ {
+		p *= mc.channelProbability(tokenAddress, path[i], path[i+1], amount, now)
+		p *= mc.nodeReliability(path[i], now)
+	}
+	return p
+}
+
+/*
+SortRoutes 按照成功的可能性从大到小排序,可能性相同的保持原来的顺序
+*/
+func (mc *MissionControl) SortRoutes(tokenAddress common.Address, amount *big.Int, routes []*route.State) {
+	probabilities := make(map[*route.State]float64)
+	for _, r := range routes {
+		probabilities[r] = mc.routeProbability(tokenAddress, amount, r.Path)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return probabilities[routes[i]] > probabilities[routes[j]]
+	})
+}
+
+// GetObservations returns observations on tokenAddress, all tokens when tokenAddress is empty
+func (mc *MissionControl) GetObservations(tokenAddress common.Address) (infos []*ChannelObservationInfo) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	now := time.Now()
+	for _, o := range mc.observations {
+		if tokenAddress != utils.EmptyAddress && o.TokenAddress != tokenAddress {
+			continue
+		}
+		info := &ChannelObservationInfo{
+			ChannelObservation: o,
+			Reliability:        mc.nodeReliability(o.From, now),
+		}
+		info.EstimatedCapacity, _ = estimateCapacity(o, now)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpdateTime > infos[j].UpdateTime
+	})
+	return
+}
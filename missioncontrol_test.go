@@ -0,0 +1,113 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissionControl(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	mc := NewMissionControl(db)
+	token := utils.NewRandomAddress()
+	b, c, d, e := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	// nothing known
+	assert.EqualValues(t, 1, mc.ChannelProbability(token, b, c, big.NewInt(100)))
+	assert.EqualValues(t, 1, mc.NodeReliability(b))
+
+	// only the channel sent by the reporter fails
+	mc.OnRouteFinished(&mediatedtransfer.EventRouteFinished{
+		Token:    token,
+		Path:     []common.Address{b, c, e},
+		Amount:   big.NewInt(100),
+		Reporter: b,
+	})
+	capacity, ok := estimateCapacity(mc.observations[models.ChannelObservationKey(token, b, c)], time.Now())
+	assert.True(t, ok)
+	assert.EqualValues(t, 99, capacity.Int64())
+	assert.Nil(t, mc.observations[models.ChannelObservationKey(token, c, e)])
+	assert.True(t, mc.NodeReliability(b) < 1)
+	assert.EqualValues(t, 1, mc.NodeReliability(c))
+	// a larger amount is less likely to pass, but not excluded
+	assert.EqualValues(t, failedChannelProbability, mc.ChannelProbability(token, b, c, big.NewInt(100)))
+	assert.EqualValues(t, 1, mc.ChannelProbability(token, b, c, big.NewInt(99)))
+	// a reporter not on the path is ignored
+	mc.OnRouteFinished(&mediatedtransfer.EventRouteFinished{
+		Token:    token,
+		Path:     []common.Address{b, c, e},
+		Amount:   big.NewInt(100),
+		Reporter: d,
+	})
+	assert.Len(t, mc.observations, 1)
+
+	// the path through d is preferred now
+	r1 := &route.State{Path: []common.Address{b, c, e}}
+	r2 := &route.State{Path: []common.Address{d, e}}
+	routes := []*route.State{r1, r2}
+	mc.SortRoutes(token, big.NewInt(150), routes)
+	assert.Equal(t, r2, routes[0])
+	assert.True(t, mc.routeProbability(token, big.NewInt(150), r1.Path) > 0)
+
+	// a smaller amount succeeds, the upper bound is kept
+	mc.OnRouteFinished(&mediatedtransfer.EventRouteFinished{
+		Token:   token,
+		Path:    []common.Address{b, c, e},
+		Amount:  big.NewInt(60),
+		Success: true,
+	})
+	capacity, _ = estimateCapacity(mc.observations[models.ChannelObservationKey(token, b, c)], time.Now())
+	assert.EqualValues(t, 99, capacity.Int64())
+	// the cached reliability is the same as counted from the observations
+	var successes, failures float64
+	for _, o := range mc.observations {
+		if o.From == b {
+			successes += o.Successes
+			failures += o.Failures
+		}
+	}
+	assert.InDelta(t, (successes+reliabilityPriorSuccesses)/(successes+failures+reliabilityPriorSuccesses), mc.NodeReliability(b), 0.001)
+
+	// the failure bound grows with time and is forgotten at last
+	o := mc.observations[models.ChannelObservationKey(token, b, c)]
+	now := time.Now()
+	bound, _ := failBound(o, now.Add(observationHalfLife))
+	assert.True(t, bound.Int64() >= 199 && bound.Int64() <= 201)
+	_, ok = failBound(o, now.Add(maxObservationAge+time.Minute))
+	assert.False(t, ok)
+
+	// a larger amount succeeds, the upper bound is wrong
+	mc.OnRouteFinished(&mediatedtransfer.EventRouteFinished{
+		Token:   token,
+		Path:    []common.Address{b, c, e},
+		Amount:  big.NewInt(100),
+		Success: true,
+	})
+	_, ok = estimateCapacity(mc.observations[models.ChannelObservationKey(token, b, c)], time.Now())
+	assert.False(t, ok)
+
+	// reload from db
+	mc2 := NewMissionControl(db)
+	assert.Len(t, mc2.GetObservations(token), 2)
+	assert.Len(t, mc2.GetObservations(utils.NewRandomAddress()), 0)
+	assert.InDelta(t, mc.NodeReliability(b), mc2.NodeReliability(b), 0.001)
+
+	// old observations are removed
+	mc2.lock.Lock()
+	mc2.expire(now.Add(maxObservationAge + time.Minute))
+	mc2.lock.Unlock()
+	assert.Len(t, mc2.GetObservations(token), 0)
+	assert.EqualValues(t, 1, mc2.NodeReliability(b))
+	assert.Len(t, NewMissionControl(db).GetObservations(token), 0)
+}
@@ -474,6 +474,19 @@ transfer:
 the caller should call GetSentTransferDetail periodically to query this transfer's latest status.
 */
 func (a *API) Transfers(tokenAddress, targetAddress string, amountstr string, secretStr string, isDirect bool, data string, routeInfoStr string) (result string) {
+	return a.transfers(tokenAddress, targetAddress, amountstr, secretStr, isDirect, data, false, routeInfoStr)
+}
+
+/*
+TransfersWithEncryptedData is the same as Transfers,
+but data will be encrypted with target's public key, only target can read it.
+returns error if target's public key is unknown, which means we never received any message from target.
+*/
+func (a *API) TransfersWithEncryptedData(tokenAddress, targetAddress string, amountstr string, secretStr string, isDirect bool, data string, routeInfoStr string) (result string) {
+	return a.transfers(tokenAddress, targetAddress, amountstr, secretStr, isDirect, data, true, routeInfoStr)
+}
+
+func (a *API) transfers(tokenAddress, targetAddress string, amountstr string, secretStr string, isDirect bool, data string, isDataEncrypt bool, routeInfoStr string) (result string) {
 	defer func() {
 		log.Trace(fmt.Sprintf("Api Transfers tokenAddress=%s,targetAddress=%s,amountstr=%s,secretStr=%s,isDirect=%v, data=%s routeInfo=%s\nout transfer=\n%s ",
 			tokenAddress, targetAddress, amountstr, secretStr, isDirect, data, result, routeInfoStr,
@@ -518,7 +531,7 @@ func (a *API) Transfers(tokenAddress, targetAddress string, amountstr string, se
 			return dto.NewErrorMobileResponse(err)
 		}
 	}
-	tr, err := a.api.TransferAsync(tokenAddr, amount, targetAddr, secret, isDirect, data, routeInfo)
+	tr, err := a.api.TransferAsync(tokenAddr, amount, targetAddr, secret, isDirect, data, isDataEncrypt, routeInfo)
 	if err != nil {
 		log.Error(err.Error())
 		return dto.NewErrorMobileResponse(err)
@@ -531,6 +544,7 @@ func (a *API) Transfers(tokenAddress, targetAddress string, amountstr string, se
 	req.Amount = amount
 	req.Secret = secretStr
 	req.Data = data
+	req.IsDataEncrypt = isDataEncrypt
 	return dto.NewSuccessMobileResponse(req)
 }
 
@@ -993,6 +1007,126 @@ func (a *API) FindPath(targetStr, tokenStr, amountStr string) (result string) {
 	return dto.NewSuccessMobileResponse(routes)
 }
 
+/*
+FindPathWithRouteHints 和 FindPath 一样,但是会合并接收方给出的路由提示 routeHintsStr,格式和 GetRouteHints 的结果一样,
+返回的结果可以作为 Transfers 的 routeInfoStr
+*/
+func (a *API) FindPathWithRouteHints(targetStr, tokenStr, amountStr, routeHintsStr string) (result string) {
+	defer func() {
+		log.Trace(fmt.Sprintf("ApiCall FindPathWithRouteHints result=%s", result))
+	}()
+	target := common.HexToAddress(targetStr)
+	token := common.HexToAddress(tokenStr)
+	amount, isSuccess := new(big.Int).SetString(amountStr, 0)
+	if !isSuccess {
+		err := rerr.ErrArgumentError.Errorf("arg amount err %s", amountStr)
+		return dto.NewErrorMobileResponse(err)
+	}
+	var hints [][]*pfsproxy.RouteHint
+	err := json.Unmarshal([]byte(routeHintsStr), &hints)
+	if err != nil {
+		return dto.NewErrorMobileResponse(rerr.ErrArgumentError.AppendError(err))
+	}
+	routes, err := a.api.FindPathWithRouteHints(target, token, amount, hints)
+	if err != nil {
+		return dto.NewErrorMobileResponse(err)
+	}
+	return dto.NewSuccessMobileResponse(routes)
+}
+
+/*
+QuoteTransfer 试运行一笔交易,返回每条候选路由的手续费,跳数,过期块和是否可行,不锁定任何金额,
+routeHintsStr 可以为空,否则格式和 GetRouteHints 的结果一样
+*/
+func (a *API) QuoteTransfer(targetStr, tokenStr, amountStr, routeHintsStr string) (result string) {
+	defer func() {
+		log.Trace(fmt.Sprintf("ApiCall QuoteTransfer result=%s", result))
+	}()
+	target := common.HexToAddress(targetStr)
+	token := common.HexToAddress(tokenStr)
+	amount, isSuccess := new(big.Int).SetString(amountStr, 0)
+	if !isSuccess {
+		err := rerr.ErrArgumentError.Errorf("arg amount err %s", amountStr)
+		return dto.NewErrorMobileResponse(err)
+	}
+	var hints [][]*pfsproxy.RouteHint
+	if routeHintsStr != "" {
+		err := json.Unmarshal([]byte(routeHintsStr), &hints)
+		if err != nil {
+			return dto.NewErrorMobileResponse(rerr.ErrArgumentError.AppendError(err))
+		}
+	}
+	quote, err := a.api.QuoteTransfer(target, token, amount, hints)
+	if err != nil {
+		return dto.NewErrorMobileResponse(err)
+	}
+	return dto.NewSuccessMobileResponse(quote)
+}
+
+/*
+GetTrampolineRouteInfo 手机节点没有 pfs 或者找不到路由时,指定 trampoline 超级节点(逗号分隔)替我计算剩下的路由,
+feeStr 是手续费的总预算,返回的结果可以作为 Transfers 的 routeInfoStr
+*/
+func (a *API) GetTrampolineRouteInfo(tokenStr, targetStr, amountStr, feeStr, trampolinesStr string) (result string) {
+	defer func() {
+		log.Trace(fmt.Sprintf("ApiCall GetTrampolineRouteInfo result=%s", result))
+	}()
+	target := common.HexToAddress(targetStr)
+	token := common.HexToAddress(tokenStr)
+	amount, isSuccess := new(big.Int).SetString(amountStr, 0)
+	if !isSuccess {
+		err := rerr.ErrArgumentError.Errorf("arg amount err %s", amountStr)
+		return dto.NewErrorMobileResponse(err)
+	}
+	fee, isSuccess := new(big.Int).SetString(feeStr, 0)
+	if !isSuccess {
+		err := rerr.ErrArgumentError.Errorf("arg fee err %s", feeStr)
+		return dto.NewErrorMobileResponse(err)
+	}
+	var trampolines []common.Address
+	for _, s := range strings.Split(trampolinesStr, ",") {
+		addr, err := utils.HexToAddressWithoutValidation(strings.TrimSpace(s))
+		if err != nil {
+			return dto.NewErrorMobileResponse(rerr.ErrArgumentError.AppendError(err))
+		}
+		trampolines = append(trampolines, addr)
+	}
+	routes, err := a.api.GetTrampolineRouteInfo(token, target, amount, fee, trampolines)
+	if err != nil {
+		return dto.NewErrorMobileResponse(err)
+	}
+	return dto.NewSuccessMobileResponse(routes)
+}
+
+/*
+GetRouteHints 手机节点收款时把路由提示交给付款方,付款方经过我的邻居(超级节点)转给我
+example:
+[
+    [
+        {
+            "node_address": "0x3bc7726c489e617571792ac0cd8b70df8a5d0e22",
+            "channel_identifier": "0x6a5e1bd2d3b2ffa4b4d2c8b4bd2a1c7e69d6b1bb1d5a6ee1b5a4f1ea3fba8e71",
+            "fee_setting": {
+                "fee_constant": 0,
+                "fee_percent": 10000,
+                "signature": null
+            },
+            "reveal_timeout": 30
+        }
+    ]
+]
+*/
+func (a *API) GetRouteHints(tokenStr string) (result string) {
+	defer func() {
+		log.Trace(fmt.Sprintf("ApiCall GetRouteHints result=%s", result))
+	}()
+	hints, err := a.api.GetRouteHints(common.HexToAddress(tokenStr), utils.EmptyAddress)
+	if err != nil {
+		return dto.NewErrorMobileResponse(err)
+	}
+	return dto.NewSuccessMobileResponse(hints)
+}
+
 /*
 ContractCallTXQuery 合约调用TX查询接口,4个参数均可传空值,空值即为不限制,4个参数对应的查询条件关系为and
 channelIdentifierStr 有值时按通道ID查询
@@ -1008,6 +1142,7 @@ txTypeStr 有值时按tx类型查询,取值:
 	TXInfoTypeWithdraw           = "Withdraw"
 	TXInfoTypeApproveDeposit     = "ApproveDeposit"
 	TXInfoTypeRegisterSecret     = "RegisterSecret"
+	TXInfoTypeApproveBatchDeposit = "ApproveBatchDeposit"
 txStatusStr 有值时按tx状态查询,取值:
 	TXInfoStatusPending = "pending"
 	TXInfoStatusSuccess = "success"
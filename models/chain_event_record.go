@@ -1,6 +1,10 @@
 package models
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+
+	"github.com/ethereum/go-ethereum/common"
+)
 
 // ChainEventID 一个链上事件的唯一ID, txHash+logIndex
 type ChainEventID string
@@ -17,6 +21,7 @@ const (
 type ChainEventRecord struct {
 	ID          ChainEventID     `json:"id" storm:"id"`
 	BlockNumber uint64           `json:"block_number" storm:"index"`
+	BlockHash   common.Hash      `json:"block_hash"` // 事件所在块的 hash,分叉以后同一个事件可能被打包到不同的块中
 	Status      ChainEventStatus `json:"status"`
 }
 
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+Checkpoint 某个 supernode 在 BlockNumber 块时的通道网络快照,由 Signer 签名.
+新节点第一次启动时导入快照,从 BlockNumber 开始处理链上事件,不需要从合约部署开始重放
+*/
+/*
+ *	Checkpoint : a snapshot of the channel network of a supernode at BlockNumber, signed by Signer.
+ *	A new node imports it on first startup and processes contract events from BlockNumber,
+ *	instead of replaying them from the deployment of the registry.
+ */
+type Checkpoint struct {
+	ChainID         int64                `json:"chain_id"`
+	RegistryAddress common.Address       `json:"registry_address"`
+	BlockNumber     int64                `json:"block_number"`
+	BlockHash       common.Hash          `json:"block_hash"`
+	Tokens          []common.Address     `json:"tokens"`
+	Channels        []*CheckpointChannel `json:"channels"`
+	Signer          common.Address       `json:"signer"`
+	Signature       []byte               `json:"signature"`
+}
+
+//CheckpointChannel 快照中的一个通道
+type CheckpointChannel struct {
+	TokenAddress      common.Address `json:"token_address"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	Participant1      common.Address `json:"participant1"`
+	Participant2      common.Address `json:"participant2"`
+}
+
+func (c *Checkpoint) signData() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(utils.BigIntTo32Bytes(big.NewInt(c.ChainID)))
+	buf.Write(c.RegistryAddress[:])
+	buf.Write(utils.BigIntTo32Bytes(big.NewInt(c.BlockNumber)))
+	buf.Write(c.BlockHash[:])
+	//写入长度,否则 token 和通道的边界可以移动,签名不变
+	buf.Write(utils.BigIntTo32Bytes(big.NewInt(int64(len(c.Tokens)))))
+	for _, t := range c.Tokens {
+		buf.Write(t[:])
+	}
+	buf.Write(utils.BigIntTo32Bytes(big.NewInt(int64(len(c.Channels)))))
+	for _, ch := range c.Channels {
+		buf.Write(ch.TokenAddress[:])
+		buf.Write(ch.ChannelIdentifier[:])
+		buf.Write(ch.Participant1[:])
+		buf.Write(ch.Participant2[:])
+	}
+	return buf.Bytes()
+}
+
+//Sign 用 key 签名,Signer 设置为 key 对应的地址
+func (c *Checkpoint) Sign(key *ecdsa.PrivateKey) (err error) {
+	c.Signature, err = utils.SignData(key, c.signData())
+	if err != nil {
+		return
+	}
+	c.Signer = crypto.PubkeyToAddress(key.PublicKey)
+	return
+}
+
+//VerifySignature 签名必须来自 Signer
+func (c *Checkpoint) VerifySignature() error {
+	signer, err := utils.Ecrecover(utils.Sha3(c.signData()), c.Signature)
+	if err != nil {
+		return err
+	}
+	if signer != c.Signer {
+		return fmt.Errorf("checkpoint signed by %s, but signer is %s", signer.String(), c.Signer.String())
+	}
+	return nil
+}
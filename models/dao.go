@@ -121,6 +121,7 @@ type NonParticipantChannelDao interface {
 	GetAllNonParticipantChannelByToken(token common.Address) (edges []common.Address, err error)
 	GetNonParticipantChannelByID(channelIdentifierForQuery common.Hash) (
 		tokenAddress common.Address, participant1, participant2 common.Address, err error)
+	GetAllNonParticipantChannelIdentifiersByToken(token common.Address) (channels []common.Hash, edges []common.Address, err error)
 }
 
 // SentAnnounceDisposedDao :
@@ -156,6 +157,8 @@ type ReceivedTransferDao interface {
 	NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string) *ReceivedTransfer
 	GetReceivedTransfer(key string) (*ReceivedTransfer, error)
 	GetReceivedTransferList(tokenAddress common.Address, fromBlock, toBlock, fromTime, toTime int64) (transfers []*ReceivedTransfer, err error)
+	GetReceivedTransferByLockSecretHash(tokenAddress common.Address, lockSecretHash common.Hash) (*ReceivedTransfer, error)
+	AddReceivedTransferRefund(tokenAddress common.Address, lockSecretHash common.Hash, refundLockSecretHash common.Hash) (*ReceivedTransfer, error)
 }
 
 // SentTransferDetailDao :
@@ -163,6 +166,7 @@ type SentTransferDetailDao interface {
 	NewSentTransferDetail(tokenAddress, target common.Address, amount *big.Int, data string, isDirect bool, lockSecretHash common.Hash)
 	UpdateSentTransferDetailStatus(tokenAddress common.Address, lockSecretHash common.Hash, status TransferStatusCode, statusMessage string, otherParams interface{}) (transfer *SentTransferDetail)
 	UpdateSentTransferDetailStatusMessage(tokenAddress common.Address, lockSecretHash common.Hash, statusMessage string) (transfer *SentTransferDetail)
+	AddSentTransferDetailRefundedAmount(tokenAddress common.Address, lockSecretHash common.Hash, amount *big.Int) (transfer *SentTransferDetail, err error)
 	GetSentTransferDetail(tokenAddress common.Address, lockSecretHash common.Hash) (*SentTransferDetail, error)
 	GetSentTransferDetailList(tokenAddress common.Address, fromTime, toTime int64, fromBlock, toBlock int64) (transfers []*SentTransferDetail, err error)
 }
@@ -179,17 +183,91 @@ type TXInfoDao interface {
 	NewPendingTXInfo(tx *types.Transaction, txType TXInfoType, channelIdentifier common.Hash, openBlockNumber int64, txParams TXParams) (txInfo *TXInfo, err error)
 	SaveEventToTXInfo(event interface{}) (txInfo *TXInfo, err error)
 	UpdateTXInfoStatus(txHash common.Hash, status TXInfoStatus, pendingBlockNumber int64, gasUsed uint64) (txInfo *TXInfo, err error)
+	UpdateTXInfoHash(oldTXHash, newTXHash common.Hash, gasPrice uint64, rawTX []byte) (txInfo *TXInfo, err error)
 	GetTXInfoList(channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType TXInfoType, status TXInfoStatus) (list []*TXInfo, err error)
+	GetTXInfoListByCallTime(from, to int64) (list []*TXInfo, err error)
 }
 
 // ChainEventRecordDao :
 type ChainEventRecordDao interface {
-	NewDeliveredChainEvent(id ChainEventID, blockNumber uint64)
-	CheckChainEventDelivered(id ChainEventID) (blockNumber uint64, delivered bool)
+	NewDeliveredChainEvent(id ChainEventID, blockNumber uint64, blockHash common.Hash)
+	CheckChainEventDelivered(id ChainEventID) (blockNumber uint64, blockHash common.Hash, delivered bool)
+	RemoveChainEventRecord(id ChainEventID)
 	ClearOldChainEventRecord(blockNumber uint64)
 	MakeChainEventID(l *types.Log) ChainEventID
 }
 
+// EscrowHoldDao :
+type EscrowHoldDao interface {
+	NewEscrowHold(h *EscrowHold) error
+	UpdateEscrowHold(h *EscrowHold) error
+	GetEscrowHold(tokenAddress common.Address, lockSecretHash common.Hash) (h *EscrowHold, err error)
+	GetEscrowHoldList(status EscrowStatus) (list []*EscrowHold, err error)
+}
+
+// SwapRateDao :
+type SwapRateDao interface {
+	SaveSwapRate(sr *SwapRate) error
+	RemoveSwapRate(fromToken, toToken common.Address) error
+	GetSwapRateList() (list []*SwapRate, err error)
+}
+
+// NodePubKeyDao :
+type NodePubKeyDao interface {
+	SaveNodePubKey(addr common.Address, pubkey []byte) error
+	GetNodePubKey(addr common.Address) (pubkey []byte, err error)
+}
+
+// ExpirationRecordDao :
+type ExpirationRecordDao interface {
+	SaveExpirationRecord(r *ExpirationRecord) error
+	GetExpirationRecords(tokenAddress common.Address, lockSecretHash common.Hash) (records []*ExpirationRecord, err error)
+	RemoveExpirationRecords(lockSecretHash common.Hash, role ExpirationRole) error
+	RemoveExpiredExpirationRecords(blockNumber int64) error
+}
+
+// PfsDao : storage of the local pfs server
+type PfsDao interface {
+	GetPfsChannelParticipant(channelIdentifier common.Hash, participant common.Address) (p *PfsChannelParticipant, err error)
+	SavePfsChannelParticipant(p *PfsChannelParticipant) error
+	GetPfsFeePolicy(node common.Address) (fp *FeePolicy, err error)
+	SavePfsFeePolicy(node common.Address, fp *FeePolicy) error
+	SavePfsSwapRates(marketMaker common.Address, nonce uint64, rates []*SwapRate) error
+	GetPfsSwapRatesNonce(marketMaker common.Address) (nonce uint64, err error)
+	GetPfsSwapRates(fromToken, toToken common.Address) (rates []*SwapRate, err error)
+}
+
+// PfsSubmissionQueueDao : balance proofs waiting to be replayed to pfs hosts
+type PfsSubmissionQueueDao interface {
+	SavePfsBalanceSubmission(s *PfsBalanceSubmission) error
+	RemovePfsBalanceSubmission(host string, channelIdentifier common.Hash, openBlockNumber int64, nonce uint64) error
+	GetPfsBalanceSubmissions(host string) (list []*PfsBalanceSubmission, err error)
+}
+
+// MissionControlDao : results observed on channels by the initiator
+type MissionControlDao interface {
+	SaveChannelObservation(o *ChannelObservation) error
+	GetAllChannelObservations() (list []*ChannelObservation, err error)
+	RemoveChannelObservation(key string) error
+}
+
+// WatchtowerDao : channels delegated to me as a watchtower, and my channels acknowledged by watchtowers
+type WatchtowerDao interface {
+	SaveWatchtowerDelegate(d *WatchtowerDelegate) error
+	GetWatchtowerDelegate(channelIdentifier common.Hash) (d *WatchtowerDelegate, err error)
+	GetWatchtowerDelegateList() (list []*WatchtowerDelegate, err error)
+	RemoveWatchtowerDelegate(channelIdentifier common.Hash) error
+	SaveWatchtowerAck(ack *WatchtowerAck) error
+	GetWatchtowerAck(watchtowerURL string, channelIdentifier common.Hash) (ack *WatchtowerAck, err error)
+	GetWatchtowerAckList() (list []*WatchtowerAck, err error)
+	RemoveWatchtowerAck(watchtowerURL string, channelIdentifier common.Hash) error
+}
+
+// GasSpentDao : gas used by my txs, accumulated per channel and tx type
+type GasSpentDao interface {
+	GetGasSpentList(channelIdentifier common.Hash) (list []*GasSpent, err error)
+}
+
 // Dao :
 type Dao interface {
 	AckDao
@@ -213,6 +291,15 @@ type Dao interface {
 	TXInfoDao
 	SentTransferDetailDao
 	ChainEventRecordDao
+	EscrowHoldDao
+	SwapRateDao
+	NodePubKeyDao
+	ExpirationRecordDao
+	PfsDao
+	PfsSubmissionQueueDao
+	MissionControlDao
+	WatchtowerDao
+	GasSpentDao
 
 	StartTx() (tx TX)
 	CloseDB()
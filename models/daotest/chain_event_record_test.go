@@ -26,28 +26,37 @@ func TestChainEventRecord(t *testing.T) {
 	id1 := dao.MakeChainEventID(l1)
 	id2 := dao.MakeChainEventID(l2)
 
-	dao.NewDeliveredChainEvent(id1, 1)
+	dao.NewDeliveredChainEvent(id1, 1, utils.EmptyHash)
 
-	blockNumber, delivered := dao.CheckChainEventDelivered(id1)
+	blockNumber, _, delivered := dao.CheckChainEventDelivered(id1)
 	assert.EqualValues(t, true, delivered)
 	assert.EqualValues(t, 1, blockNumber)
 
-	blockNumber, delivered = dao.CheckChainEventDelivered(id2)
+	blockNumber, _, delivered = dao.CheckChainEventDelivered(id2)
 	assert.EqualValues(t, false, delivered)
 	assert.EqualValues(t, 0, blockNumber)
 
 	dao.ClearOldChainEventRecord(0)
 
-	blockNumber, delivered = dao.CheckChainEventDelivered(id1)
+	blockNumber, _, delivered = dao.CheckChainEventDelivered(id1)
 	assert.EqualValues(t, true, delivered)
 	assert.EqualValues(t, 1, blockNumber)
 
 	dao.ClearOldChainEventRecord(100)
 
-	blockNumber, delivered = dao.CheckChainEventDelivered(id1)
+	blockNumber, _, delivered = dao.CheckChainEventDelivered(id1)
 	assert.EqualValues(t, false, delivered)
 	assert.EqualValues(t, 0, blockNumber)
 
+	blockHash := utils.NewRandomHash()
+	dao.NewDeliveredChainEvent(id2, 3, blockHash)
+	blockNumber, hash, delivered := dao.CheckChainEventDelivered(id2)
+	assert.EqualValues(t, true, delivered)
+	assert.EqualValues(t, 3, blockNumber)
+	assert.EqualValues(t, blockHash, hash)
+	dao.RemoveChainEventRecord(id2)
+	_, _, delivered = dao.CheckChainEventDelivered(id2)
+	assert.EqualValues(t, false, delivered)
 }
 
 func Test1(t *testing.T) {
@@ -61,7 +70,7 @@ func Test1(t *testing.T) {
 		l.Index = i
 		id := dao.MakeChainEventID(l)
 		idList = append(idList, id)
-		dao.NewDeliveredChainEvent(id, uint64(i)+1)
+		dao.NewDeliveredChainEvent(id, uint64(i)+1, utils.EmptyHash)
 	}
 	//fmt.Println("total==============", len(idList))
 	//for _, id := range idList {
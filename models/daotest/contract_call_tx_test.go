@@ -7,6 +7,7 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/codefortest"
 	"github.com/MetaLife-Protocol/SuperNode/models"
 	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -77,7 +78,22 @@ func TestModelDB_ContractCallTX(t *testing.T) {
 	assert.Empty(t, err)
 	assert.EqualValues(t, 0, len(list))
 
-	// 8. update
+	// 8. replace
+	newTX := types.NewTransaction(1, utils.NewRandomAddress(), big.NewInt(1), 0, big.NewInt(2), nil)
+	txInfo, err := dao.UpdateTXInfoHash(tx.Hash(), newTX.Hash(), 2, []byte{1})
+	assert.Empty(t, err)
+	assert.EqualValues(t, newTX.Hash(), txInfo.TXHash)
+	assert.EqualValues(t, 1, txInfo.Nonce)
+	assert.EqualValues(t, []common.Hash{tx.Hash()}, txInfo.ReplacedTXHashes)
+
+	list, err = dao.GetTXInfoList(utils.EmptyHash, 0, utils.EmptyAddress, "", "")
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(list))
+	assert.EqualValues(t, newTX.Hash(), list[0].TXHash)
+	assert.EqualValues(t, 2, list[0].GasPrice)
+	tx = newTX
+
+	// 9. update
 	_, err = dao.UpdateTXInfoStatus(tx.Hash(), models.TXInfoStatusSuccess, 2, 10000)
 	assert.Empty(t, err)
 
@@ -0,0 +1,62 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_EscrowHold(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	tokenAddress := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	_, err := dao.GetEscrowHold(tokenAddress, lockSecretHash)
+	assert.NotEmpty(t, err)
+
+	h := &models.EscrowHold{
+		LockSecretHash:     lockSecretHash,
+		TokenAddress:       tokenAddress,
+		Condition:          "nft delivered",
+		CancelBeforeBlocks: 30,
+		Status:             models.EscrowStatusWaiting,
+	}
+	err = dao.NewEscrowHold(h)
+	assert.Empty(t, err)
+	err = dao.NewEscrowHold(h)
+	assert.NotEmpty(t, err)
+
+	h.Status = models.EscrowStatusHolding
+	h.Amount = big.NewInt(10)
+	h.Expiration = 100
+	err = dao.UpdateEscrowHold(h)
+	assert.Empty(t, err)
+
+	h2, err := dao.GetEscrowHold(tokenAddress, lockSecretHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, models.EscrowStatusHolding, h2.Status)
+	assert.EqualValues(t, 70, h2.CancelBlockNumber())
+
+	err = dao.NewEscrowHold(&models.EscrowHold{
+		LockSecretHash: utils.NewRandomHash(),
+		TokenAddress:   tokenAddress,
+		Status:         models.EscrowStatusWaiting,
+	})
+	assert.Empty(t, err)
+	list, err := dao.GetEscrowHoldList(models.EscrowStatusHolding)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(list))
+	list, err = dao.GetEscrowHoldList(models.EscrowStatusAll)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 2, len(list))
+	list, err = dao.GetEscrowHoldList(models.EscrowStatusWaiting)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(list))
+	list, err = dao.GetEscrowHoldList(models.EscrowStatusCanceled)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 0, len(list))
+}
@@ -0,0 +1,68 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_ExpirationRecord(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	r := &models.ExpirationRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.ExpirationRoleInitiator,
+		PathLength:     3,
+		Expiration:     100,
+		RevealTimeout:  30,
+	}
+	err := dao.SaveExpirationRecord(r)
+	assert.Empty(t, err)
+	r2 := *r
+	r2.Key = ""
+	r2.Role = models.ExpirationRoleMediator
+	err = dao.SaveExpirationRecord(&r2)
+	assert.Empty(t, err)
+	err = dao.SaveExpirationRecord(&models.ExpirationRecord{
+		LockSecretHash: utils.NewRandomHash(),
+		TokenAddress:   token,
+		Role:           models.ExpirationRoleTarget,
+		Expiration:     200,
+	})
+	assert.Empty(t, err)
+	records, err := dao.GetExpirationRecords(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 2, len(records))
+	records, err = dao.GetExpirationRecords(token, utils.EmptyHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 3, len(records))
+	records, err = dao.GetExpirationRecords(utils.NewRandomAddress(), utils.EmptyHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 0, len(records))
+
+	//交易结束后删除记录
+	err = dao.RemoveExpirationRecords(lockSecretHash, models.ExpirationRoleMediator)
+	assert.Empty(t, err)
+	records, err = dao.GetExpirationRecords(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(records))
+	assert.EqualValues(t, models.ExpirationRoleInitiator, records[0].Role)
+	//锁已经过期的记录
+	err = dao.RemoveExpiredExpirationRecords(100)
+	assert.Empty(t, err)
+	records, err = dao.GetExpirationRecords(token, utils.EmptyHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 2, len(records))
+	err = dao.RemoveExpiredExpirationRecords(101)
+	assert.Empty(t, err)
+	records, err = dao.GetExpirationRecords(token, utils.EmptyHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(records))
+	assert.EqualValues(t, models.ExpirationRoleTarget, records[0].Role)
+}
@@ -0,0 +1,70 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_GasSpent(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	channelIdentifier := utils.NewRandomHash()
+	newTX := func(nonce uint64, txType models.TXInfoType) *models.TXInfo {
+		tx := types.NewTransaction(nonce, utils.NewRandomAddress(), big.NewInt(0), 0, big.NewInt(10), nil)
+		txInfo, err := dao.NewPendingTXInfo(tx, txType, channelIdentifier, 5, "")
+		assert.Empty(t, err)
+		return txInfo
+	}
+	gasSpent := func(txType models.TXInfoType) *models.GasSpent {
+		list, err := dao.GetGasSpentList(channelIdentifier)
+		assert.Empty(t, err)
+		for _, g := range list {
+			if g.Type == txType {
+				return g
+			}
+		}
+		return nil
+	}
+	// still pending, nothing spent
+	closeTX := newTX(1, models.TXInfoTypeClose)
+	_, err := dao.UpdateTXInfoStatus(closeTX.TXHash, models.TXInfoStatusPending, 0, 0)
+	assert.Empty(t, err)
+	assert.Nil(t, gasSpent(models.TXInfoTypeClose))
+	// packed, status and gas are saved together
+	txInfo, err := dao.UpdateTXInfoStatus(closeTX.TXHash, models.TXInfoStatusSuccess, 6, 100)
+	assert.Empty(t, err)
+	assert.EqualValues(t, models.TXInfoStatusSuccess, txInfo.Status)
+	g := gasSpent(models.TXInfoTypeClose)
+	assert.EqualValues(t, 1, g.TXCount)
+	assert.EqualValues(t, big.NewInt(1000), g.Cost)
+	// updated again, for example the block is reorged, not added again
+	_, err = dao.UpdateTXInfoStatus(closeTX.TXHash, models.TXInfoStatusFailed, 7, 100)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, gasSpent(models.TXInfoTypeClose).TXCount)
+	// failed txs spend gas too, replaced tx is accounted with the new gas price
+	unlock := newTX(2, models.TXInfoTypeUnlock)
+	_, err = dao.UpdateTXInfoStatus(unlock.TXHash, models.TXInfoStatusFailed, 6, 100)
+	assert.Empty(t, err)
+	unlock = newTX(3, models.TXInfoTypeUnlock)
+	replaced := types.NewTransaction(3, utils.NewRandomAddress(), big.NewInt(0), 0, big.NewInt(20), nil)
+	_, err = dao.UpdateTXInfoHash(unlock.TXHash, replaced.Hash(), 20, nil)
+	assert.Empty(t, err)
+	_, err = dao.UpdateTXInfoStatus(replaced.Hash(), models.TXInfoStatusSuccess, 6, 100)
+	assert.Empty(t, err)
+	g = gasSpent(models.TXInfoTypeUnlock)
+	assert.EqualValues(t, 2, g.TXCount)
+	assert.EqualValues(t, 200, g.GasUsed)
+	assert.EqualValues(t, big.NewInt(3000), g.Cost)
+	// unknown tx changes nothing
+	_, err = dao.UpdateTXInfoStatus(utils.NewRandomHash(), models.TXInfoStatusSuccess, 6, 100)
+	assert.NotEmpty(t, err)
+	list, err := dao.GetGasSpentList(utils.EmptyHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 2, len(list))
+}
@@ -0,0 +1,24 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_NodePubKey(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	_, err := dao.GetNodePubKey(addr)
+	assert.NotEmpty(t, err)
+	pubkey := crypto.FromECDSAPub(&key.PublicKey)
+	err = dao.SaveNodePubKey(addr, pubkey)
+	assert.Empty(t, err)
+	pubkey2, err := dao.GetNodePubKey(addr)
+	assert.Empty(t, err)
+	assert.EqualValues(t, pubkey, pubkey2)
+}
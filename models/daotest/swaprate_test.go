@@ -0,0 +1,66 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_SwapRate(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	fromToken := utils.NewRandomAddress()
+	toToken := utils.NewRandomAddress()
+	sr := &models.SwapRate{
+		Key:             models.SwapRateKey(fromToken, toToken),
+		MarketMaker:     utils.NewRandomAddress(),
+		FromToken:       fromToken,
+		ToToken:         toToken,
+		RateNumerator:   big.NewInt(3),
+		RateDenominator: big.NewInt(2),
+		MinAmount:       big.NewInt(10),
+	}
+	err := dao.SaveSwapRate(sr)
+	assert.Empty(t, err)
+	sr.MaxAmount = big.NewInt(100)
+	err = dao.SaveSwapRate(sr)
+	assert.Empty(t, err)
+	list, err := dao.GetSwapRateList()
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(list))
+	assert.EqualValues(t, big.NewInt(100), list[0].MaxAmount)
+
+	err = dao.RemoveSwapRate(fromToken, toToken)
+	assert.Empty(t, err)
+	list, err = dao.GetSwapRateList()
+	assert.Empty(t, err)
+	assert.EqualValues(t, 0, len(list))
+}
+
+func TestSwapRate_Quote(t *testing.T) {
+	sr := &models.SwapRate{
+		RateNumerator:   big.NewInt(3),
+		RateDenominator: big.NewInt(2),
+		MinAmount:       big.NewInt(10),
+		MaxAmount:       big.NewInt(100),
+	}
+	_, ok := sr.Quote(big.NewInt(9))
+	assert.False(t, ok)
+	_, ok = sr.Quote(big.NewInt(101))
+	assert.False(t, ok)
+	toAmount, ok := sr.Quote(big.NewInt(11))
+	assert.True(t, ok)
+	assert.EqualValues(t, big.NewInt(16), toAmount)
+
+	key, _ := crypto.GenerateKey()
+	sr.MarketMaker = crypto.PubkeyToAddress(key.PublicKey)
+	sr.Sign(key)
+	assert.True(t, sr.VerifySignature())
+	sr.RateNumerator = big.NewInt(4)
+	assert.False(t, sr.VerifySignature())
+}
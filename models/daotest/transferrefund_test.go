@@ -0,0 +1,83 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRefundData(t *testing.T) {
+	refundOf := utils.NewRandomHash()
+	refundOf2, data, ok := models.ParseRefundData(models.MakeRefundData(refundOf, "sorry:out of stock"))
+	assert.True(t, ok)
+	assert.EqualValues(t, refundOf, refundOf2)
+	assert.EqualValues(t, "sorry:out of stock", data)
+	_, _, ok = models.ParseRefundData("refund:123:abc")
+	assert.False(t, ok)
+	_, _, ok = models.ParseRefundData("123")
+	assert.False(t, ok)
+}
+
+func TestModelDB_TransferRefund(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	initiator := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	dao.NewReceivedTransfer(2, utils.NewRandomHash(), 3, token, initiator, 3, big.NewInt(10), lockSecretHash, "123")
+	rt, err := dao.GetReceivedTransferByLockSecretHash(token, lockSecretHash)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, big.NewInt(10), rt.Amount)
+	_, err = dao.GetReceivedTransferByLockSecretHash(utils.NewRandomAddress(), lockSecretHash)
+	assert.NotEmpty(t, err)
+
+	refundLockSecretHash := utils.NewRandomHash()
+	dao.NewSentTransferDetail(token, initiator, big.NewInt(3), models.MakeRefundData(lockSecretHash, "abc"), false, refundLockSecretHash)
+	std, err := dao.GetSentTransferDetail(token, refundLockSecretHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, lockSecretHash, std.RefundOf)
+	assert.EqualValues(t, "abc", std.Data)
+	rt, err = dao.AddReceivedTransferRefund(token, lockSecretHash, refundLockSecretHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(rt.Refunds))
+
+	std, err = dao.AddSentTransferDetailRefundedAmount(token, refundLockSecretHash, big.NewInt(2))
+	assert.Empty(t, err)
+	std, err = dao.AddSentTransferDetailRefundedAmount(token, refundLockSecretHash, big.NewInt(1))
+	assert.Empty(t, err)
+	assert.EqualValues(t, big.NewInt(3), std.RefundedAmount)
+}
+
+func TestModelDB_GetReceivedTransferByLockSecretHash(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	initiator := utils.NewRandomAddress()
+	channelIdentifier := utils.NewRandomHash()
+	//直接交易使用由通道和 nonce 生成的 LockSecretHash
+	dao.NewReceivedTransfer(2, channelIdentifier, 3, token, initiator, 3, big.NewInt(10), utils.EmptyHash, "")
+	dao.NewReceivedTransfer(2, channelIdentifier, 3, token, initiator, 4, big.NewInt(20), utils.EmptyHash, "")
+	rt, err := dao.GetReceivedTransferByLockSecretHash(token, models.DirectTransferLockSecretHash(channelIdentifier, 3, 4))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, big.NewInt(20), rt.Amount)
+	assert.NotEqual(t, models.DirectTransferLockSecretHash(channelIdentifier, 3, 3), rt.LockSecretHash)
+	_, err = dao.GetReceivedTransferByLockSecretHash(token, utils.EmptyHash)
+	assert.NotEmpty(t, err)
+
+	//同一个 LockSecretHash 对应多笔交易时无法确定是哪一笔
+	lockSecretHash := utils.NewRandomHash()
+	dao.NewReceivedTransfer(2, channelIdentifier, 3, token, initiator, 5, big.NewInt(10), lockSecretHash, "")
+	dao.NewReceivedTransfer(2, channelIdentifier, 3, token, initiator, 6, big.NewInt(10), lockSecretHash, "")
+	_, err = dao.GetReceivedTransferByLockSecretHash(token, lockSecretHash)
+	assert.NotEmpty(t, err)
+}
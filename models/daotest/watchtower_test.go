@@ -0,0 +1,48 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_WatchtowerDelegate(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	channelIdentifier := utils.NewRandomHash()
+	_, err := dao.GetWatchtowerDelegate(channelIdentifier)
+	assert.NotEmpty(t, err)
+
+	d := &models.WatchtowerDelegate{
+		ChannelIdentifier: channelIdentifier,
+		OpenBlockNumber:   3,
+		Delegator:         utils.NewRandomAddress(),
+		PartnerAddress:    utils.NewRandomAddress(),
+		Nonce:             1,
+		Content:           "{}",
+	}
+	err = dao.SaveWatchtowerDelegate(d)
+	assert.Empty(t, err)
+	d.Nonce = 2
+	d.RegisteredSecrets = append(d.RegisteredSecrets, utils.NewRandomHash())
+	err = dao.SaveWatchtowerDelegate(d)
+	assert.Empty(t, err)
+
+	d2, err := dao.GetWatchtowerDelegate(channelIdentifier)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 2, d2.Nonce)
+	assert.EqualValues(t, d.RegisteredSecrets, d2.RegisteredSecrets)
+	assert.EqualValues(t, d.Content, d2.Content)
+
+	list, err := dao.GetWatchtowerDelegateList()
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(list))
+
+	err = dao.RemoveWatchtowerDelegate(channelIdentifier)
+	assert.Empty(t, err)
+	_, err = dao.GetWatchtowerDelegate(channelIdentifier)
+	assert.NotEmpty(t, err)
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EscrowStatus :
+type EscrowStatus int
+
+//storm 不会为零值建立索引,所以状态从1开始
+const (
+	// EscrowStatusWaiting 已登记托管条件,但是还没有收到对应的锁
+	EscrowStatusWaiting EscrowStatus = iota + 1
+	// EscrowStatusHolding 收到了锁,正在等待外部条件批准
+	EscrowStatusHolding
+	// EscrowStatusApproved 条件满足,已经向发起方请求密码
+	EscrowStatusApproved
+	// EscrowStatusCanceled 被主动取消或者临近过期自动取消,已经通过 AnnounceDisposed 放弃该锁
+	EscrowStatusCanceled
+)
+
+// EscrowStatusAll 查询时不限制状态
+const EscrowStatusAll EscrowStatus = 0
+
+/*
+EscrowHold 作为接收方暂扣收到的锁,在外部条件(api 调用或者 oracle webhook)批准之前不会请求密码,
+如果一直没有批准,会在锁过期前 CancelBeforeBlocks 块通过 AnnounceDisposed 放弃该锁,资金退回给付款方.
+*/
+/*
+ *	EscrowHold : a lock received as target is held until an external condition (an api call or an oracle webhook) approves it.
+ *	Until then the secret is not requested. If not approved, the lock is disposed via AnnounceDisposed
+ *	CancelBeforeBlocks blocks before it expires, which gives the tokens back to the payer.
+ */
+type EscrowHold struct {
+	Key                string         `json:"-" storm:"id"`
+	LockSecretHash     common.Hash    `json:"lock_secret_hash"`
+	TokenAddress       common.Address `json:"token_address"`
+	Condition          string         `json:"condition"`
+	OracleToken        string         `json:"oracle_token"` // webhook 调用时需要提供的凭证
+	CancelBeforeBlocks int64          `json:"cancel_before_blocks"`
+	/*
+		以下信息在收到锁以后才会有
+	*/
+	Initiator         common.Address `json:"initiator_address"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	Amount            *big.Int       `json:"amount"`
+	Expiration        int64          `json:"expiration"`
+
+	Status        EscrowStatus `json:"status" storm:"index"`
+	StatusMessage string       `json:"status_message"`
+	CreateTime    int64        `json:"create_time"`
+	UpdateTime    int64        `json:"update_time"`
+}
+
+// EscrowHoldKey :
+func EscrowHoldKey(tokenAddress common.Address, lockSecretHash common.Hash) string {
+	return utils.Sha3(tokenAddress[:], lockSecretHash[:]).String()
+}
+
+// CancelBlockNumber 到达这个块以后就不能再等待批准了
+func (h *EscrowHold) CancelBlockNumber() int64 {
+	return h.Expiration - h.CancelBeforeBlocks
+}
+
+func init() {
+	gob.Register(&EscrowHold{})
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/gob"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ExpirationRole 记录是以哪个身份为交易选择的超时参数
+type ExpirationRole string
+
+/* #nosec */
+const (
+	ExpirationRoleInitiator ExpirationRole = "initiator"
+	ExpirationRoleMediator  ExpirationRole = "mediator"
+	ExpirationRoleTarget    ExpirationRole = "target"
+)
+
+/*
+ExpirationRecord 记录为一笔交易动态选择的锁过期时间和 reveal timeout,以及做出选择时观察到的链上状况,供诊断使用
+*/
+/*
+ *	ExpirationRecord : the lock expiration and reveal timeout chosen for a transfer,
+ *	together with the chain conditions observed when they were chosen, for diagnostics.
+ */
+type ExpirationRecord struct {
+	Key             string         `json:"-" storm:"id"`
+	LockSecretHash  common.Hash    `json:"lock_secret_hash"`
+	TokenAddress    common.Address `json:"token_address"`
+	Role            ExpirationRole `json:"role"`
+	PathLength      int            `json:"path_length"`              // 从我开始还剩下的跳数
+	BlockNumber     int64          `json:"block_number"`             // 选择时的块号
+	Expiration      int64          `json:"expiration" storm:"index"` // 发起方选择的锁过期块号,中间节点和接收方为收到的锁的过期块号
+	RevealTimeout   int            `json:"reveal_timeout"`           // 本节点使用的 reveal timeout
+	ConfigTimeout   int            `json:"config_timeout"`           // Config.RevealTimeout
+	SecondsPerBlock float64        `json:"seconds_per_block"`
+	TxLatency       float64        `json:"tx_latency"` // 最近链上 tx 从发起到打包的平均耗时,单位秒
+	CreateTime      int64          `json:"create_time"`
+}
+
+// ExpirationRecordKey :
+func ExpirationRecordKey(tokenAddress common.Address, lockSecretHash common.Hash, role ExpirationRole) string {
+	return utils.Sha3(tokenAddress[:], lockSecretHash[:], []byte(role)).String()
+}
+
+func init() {
+	gob.Register(&ExpirationRecord{})
+}
@@ -19,46 +19,151 @@ import (
 // 其中FeeConstant为固定费率,比如5代表手续费固定部分为5个token,设置为0即不收费
 // FeePercent为比例费率,计算方式为 交易金额/FeePercent,比如交易金额50000,FeePercent=10000,那么手续费比例部分=50000/10000=5,设置为0即不收费
 // 最终为手续费为固定收费+比例收费
+// Nonce 提交给 pfs 时使用,必须比上次提交的大,防止旧的签名数据被重放
 type FeeSetting struct {
 	FeeConstant *big.Int `json:"fee_constant"`
 	FeePercent  int64    `json:"fee_percent"`
+	Nonce       uint64   `json:"nonce,omitempty"`
 	Signature   []byte   `json:"signature"` // used when set fee policy to pfs
 }
 
-func (fs *FeeSetting) sign(key *ecdsa.PrivateKey) []byte {
+func (fs *FeeSetting) signData() []byte {
 	var err error
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.BigEndian, fs.FeePercent)
 	_, err = buf.Write(utils.BigIntTo32Bytes(fs.FeeConstant))
+	err = binary.Write(buf, binary.BigEndian, fs.Nonce)
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	fs.Signature, err = utils.SignData(key, buf.Bytes())
+	return buf.Bytes()
+}
+
+func (fs *FeeSetting) sign(key *ecdsa.PrivateKey) []byte {
+	var err error
+	fs.Signature, err = utils.SignData(key, fs.signData())
 	if err != nil {
 		log.Crit(fmt.Sprintf("signDataFor FeeSetting err %s", err))
 	}
 	return fs.Signature
 }
 
+// VerifySignature check the setting is signed by signer
+func (fs *FeeSetting) VerifySignature(signer common.Address) bool {
+	if fs.FeeConstant == nil {
+		return false
+	}
+	addr, err := utils.Ecrecover(utils.Sha3(fs.signData()), fs.Signature)
+	return err == nil && addr == signer
+}
+
+// FeeSchedule :
+// 在 [StartHour,EndHour) 这个时间段内手续费按 Rate% 收取,比如150代表1.5倍,小时为 UTC 时间
+// StartHour 大于 EndHour 表示跨越午夜,比如 22 到 6
+type FeeSchedule struct {
+	StartHour int   `json:"start_hour"`
+	EndHour   int   `json:"end_hour"`
+	Rate      int64 `json:"rate"`
+}
+
+// Contains returns true when hour is in this schedule
+func (s *FeeSchedule) Contains(hour int) bool {
+	if s.StartHour <= s.EndHour {
+		return hour >= s.StartHour && hour < s.EndHour
+	}
+	return hour >= s.StartHour || hour < s.EndHour
+}
+
+// DynamicFeeSetting :
+// 作为中间节点时,在静态设置的基础上根据下家通道的余额和时间调整手续费
+// ImbalanceRate 为不平衡调整的最大百分比,交易会耗尽我方余额时手续费增加 ImbalanceRate%,
+// 交易后余额全部在我方时减少 ImbalanceRate%,交易后双方余额相等时不调整
+// Schedules 按时间段调整手续费,第一个包含当前时间的生效
+type DynamicFeeSetting struct {
+	ImbalanceRate int64          `json:"imbalance_rate"`
+	Schedules     []*FeeSchedule `json:"schedules"`
+}
+
+// Validate :
+func (d *DynamicFeeSetting) Validate() error {
+	if d.ImbalanceRate < 0 || d.ImbalanceRate > 100 {
+		return fmt.Errorf("imbalance_rate must be between 0 and 100, got %d", d.ImbalanceRate)
+	}
+	for _, s := range d.Schedules {
+		if s.StartHour < 0 || s.StartHour > 23 || s.EndHour < 0 || s.EndHour > 24 {
+			return fmt.Errorf("invalid schedule hours %d-%d", s.StartHour, s.EndHour)
+		}
+		if s.Rate < 0 {
+			return fmt.Errorf("schedule rate can not be negative, got %d", s.Rate)
+		}
+	}
+	return nil
+}
+
 // FeePolicy :
+// DynamicFee 为 nil 时只使用静态设置,提交给 pfs 时会换算成每个通道当前的静态设置
 type FeePolicy struct {
 	Key           string                         `storm:"id"`
 	AccountFee    *FeeSetting                    `json:"account_fee"`
 	TokenFeeMap   map[common.Address]*FeeSetting `json:"token_fee_map"`
 	ChannelFeeMap map[common.Hash]*FeeSetting    `json:"channel_fee_map"`
+	DynamicFee    *DynamicFeeSetting             `json:"dynamic_fee,omitempty"`
 }
 
-// Sign for pfs
-func (fp *FeePolicy) Sign(key *ecdsa.PrivateKey) {
+// Sign for pfs, all the settings use the same nonce
+func (fp *FeePolicy) Sign(key *ecdsa.PrivateKey, nonce uint64) {
+	fp.AccountFee.Nonce = nonce
 	fp.AccountFee.sign(key)
 	for _, fs := range fp.TokenFeeMap {
+		fs.Nonce = nonce
 		fs.sign(key)
 	}
 	for _, fs := range fp.ChannelFeeMap {
+		fs.Nonce = nonce
 		fs.sign(key)
 	}
 }
 
+// Nonces returns the smallest and largest nonce of all the settings
+func (fp *FeePolicy) Nonces() (min, max uint64) {
+	if fp.AccountFee != nil {
+		min, max = fp.AccountFee.Nonce, fp.AccountFee.Nonce
+	}
+	update := func(fs *FeeSetting) {
+		if fs.Nonce < min {
+			min = fs.Nonce
+		}
+		if fs.Nonce > max {
+			max = fs.Nonce
+		}
+	}
+	for _, fs := range fp.TokenFeeMap {
+		update(fs)
+	}
+	for _, fs := range fp.ChannelFeeMap {
+		update(fs)
+	}
+	return
+}
+
+// VerifySignature check all the settings are signed by signer
+func (fp *FeePolicy) VerifySignature(signer common.Address) bool {
+	if fp.AccountFee == nil || !fp.AccountFee.VerifySignature(signer) {
+		return false
+	}
+	for _, fs := range fp.TokenFeeMap {
+		if !fs.VerifySignature(signer) {
+			return false
+		}
+	}
+	for _, fs := range fp.ChannelFeeMap {
+		if !fs.VerifySignature(signer) {
+			return false
+		}
+	}
+	return true
+}
+
 const defaultKey string = "feePolicy"
 
 // NewDefaultFeePolicy : 默认手续费万分之一
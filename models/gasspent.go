@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+GasSpent 我在一个通道上某种类型的 tx 累计消耗的 gas,失败的 tx 同样消耗 gas,也计算在内.
+Cost 是 GasUsed 乘以每个 tx 的 gas price,单位是 wei
+*/
+/*
+ *	GasSpent : gas used by my txs of one type on a channel, failed txs use gas too and are counted.
+ *	Cost is the sum of GasUsed times the gas price of each tx, in wei.
+ */
+type GasSpent struct {
+	Key               string         `json:"-" storm:"id"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier" storm:"index"`
+	TokenAddress      common.Address `json:"token_address"`
+	Type              TXInfoType     `json:"type"`
+	TXCount           int            `json:"tx_count"`
+	GasUsed           uint64         `json:"gas_used"`
+	Cost              *big.Int       `json:"cost"`
+	UpdateTime        int64          `json:"update_time"`
+}
+
+// GasSpentKey :
+func GasSpentKey(channelIdentifier common.Hash, txType TXInfoType) string {
+	return utils.Sha3(channelIdentifier[:], []byte(txType)).String()
+}
+
+// Add 累加一个已经打包的 tx
+func (g *GasSpent) Add(gasUsed, gasPrice uint64) {
+	if g.Cost == nil {
+		g.Cost = big.NewInt(0)
+	}
+	g.TXCount++
+	g.GasUsed += gasUsed
+	g.Cost.Add(g.Cost, new(big.Int).Mul(new(big.Int).SetUint64(gasUsed), new(big.Int).SetUint64(gasPrice)))
+}
+
+func init() {
+	gob.Register(&GasSpent{})
+}
@@ -11,10 +11,11 @@ import (
 )
 
 // NewDeliveredChainEvent save one
-func (dao *GkvDB) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64) {
+func (dao *GkvDB) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64, blockHash common.Hash) {
 	e := &models.ChainEventRecord{
 		ID:          id,
 		BlockNumber: blockNumber,
+		BlockHash:   blockHash,
 		Status:      models.ChainEventStatusDelivered,
 	}
 	err := dao.saveKeyValueToBucket(models.BucketChainEventRecord, e.ID, e)
@@ -24,9 +25,9 @@ func (dao *GkvDB) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uin
 }
 
 // CheckChainEventDelivered check one ChainEvent is delivered or not
-func (dao *GkvDB) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, delivered bool) {
+func (dao *GkvDB) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, blockHash common.Hash, delivered bool) {
 	e := &models.ChainEventRecord{}
-	err := dao.getKeyValueToBucket(models.BucketChainEventRecord, e.ID, e)
+	err := dao.getKeyValueToBucket(models.BucketChainEventRecord, id, e)
 	if err == storm.ErrNotFound {
 		delivered = false
 		return
@@ -42,9 +43,18 @@ func (dao *GkvDB) CheckChainEventDelivered(id models.ChainEventID) (blockNumber
 	}
 	delivered = true
 	blockNumber = e.BlockNumber
+	blockHash = e.BlockHash
 	return
 }
 
+// RemoveChainEventRecord 事件所在的块被分叉替换了,删除以后如果事件被重新打包,可以再次处理
+func (dao *GkvDB) RemoveChainEventRecord(id models.ChainEventID) {
+	err := dao.removeKeyValueFromBucket(models.BucketChainEventRecord, id)
+	if err != nil {
+		log.Error(fmt.Sprintf("models RemoveChainEventRecord id=%s err=%s", id, err))
+	}
+}
+
 // ClearOldChainEventRecord delete records which blockNumber <= blockNumber in param
 func (dao *GkvDB) ClearOldChainEventRecord(blockNumber uint64) {
 	tb, err := dao.db.Table(models.BucketChainEventRecord)
@@ -15,6 +15,7 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // NewPendingTXInfo 创建pending状态的TXInfo,即自己发起的tx
@@ -57,6 +58,12 @@ func (dao *GkvDB) NewPendingTXInfo(tx *types.Transaction, txType models.TXInfoTy
 		Status:            models.TXInfoStatusPending,
 		CallTime:          time.Now().Unix(),
 		GasPrice:          tx.GasPrice().Uint64(),
+		Nonce:             tx.Nonce(),
+	}
+	txInfo.RawTX, err = rlp.EncodeToBytes(tx)
+	if err != nil {
+		err = models.GeneratDBError(err)
+		return
 	}
 	tis := txInfo.ToTXInfoSerialization()
 	err = dao.saveKeyValueToBucket(models.BucketTXInfo, tis.TXHash, tis)
@@ -113,6 +120,38 @@ func (dao *GkvDB) UpdateTXInfoStatus(txHash common.Hash, status models.TXInfoSta
 	return
 }
 
+// UpdateTXInfoHash tx 被提高 gas price 以后重新发送,换成新的 txhash,旧的 txhash 记录在 ReplacedTXHashes 中
+func (dao *GkvDB) UpdateTXInfoHash(oldTXHash, newTXHash common.Hash, gasPrice uint64, rawTX []byte) (txInfo *models.TXInfo, err error) {
+	var tis models.TXInfoSerialization
+	err = dao.getKeyValueToBucket(models.BucketTXInfo, oldTXHash[:], &tis)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoHash err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	err = dao.removeKeyValueFromBucket(models.BucketTXInfo, oldTXHash[:])
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoHash err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	tis.ReplacedTXHashes = append(tis.ReplacedTXHashes, oldTXHash)
+	tis.TXHash = newTXHash[:]
+	tis.GasPrice = gasPrice
+	if rawTX != nil {
+		tis.RawTX = rawTX
+	}
+	err = dao.saveKeyValueToBucket(models.BucketTXInfo, tis.TXHash, tis)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoHash err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	log.Trace(fmt.Sprintf("UpdateTXInfoHash %s -> %s gasPrice=%d", oldTXHash.String(), newTXHash.String(), gasPrice))
+	txInfo = tis.ToTXInfo()
+	return
+}
+
 // GetTXInfoList :
 // 如果参数不为空,则根据参数查询
 func (dao *GkvDB) GetTXInfoList(channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType models.TXInfoType, status models.TXInfoStatus) (list []*models.TXInfo, err error) {
@@ -134,6 +173,25 @@ func (dao *GkvDB) GetTXInfoList(channelIdentifier common.Hash, openBlockNumber i
 	return
 }
 
+// GetTXInfoListByCallTime : txs called between from and to, both included
+func (dao *GkvDB) GetTXInfoListByCallTime(from, to int64) (list []*models.TXInfo, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketTXInfo)
+	if err != nil {
+		err = models.GeneratDBError(err)
+		return
+	}
+	buf := tb.Values(-1)
+	for _, v := range buf {
+		var tis models.TXInfoSerialization
+		gobDecode(v, &tis)
+		if tis.CallTime >= from && tis.CallTime <= to {
+			list = append(list, tis.ToTXInfo())
+		}
+	}
+	return
+}
+
 func appendTXInfoIfMatch(list *[]*models.TXInfo, tis *models.TXInfoSerialization, channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType models.TXInfoType, status models.TXInfoStatus) {
 	var b1, b2, b3, b4, b5 bool
 	if channelIdentifier == utils.EmptyHash || bytes.Compare(tis.ChannelIdentifier, channelIdentifier[:]) == 0 {
@@ -16,22 +16,24 @@ import (
 //NewReceivedTransfer save a new received transfer to db
 func (dao *GkvDB) NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string) *models.ReceivedTransfer {
 	if lockSecretHash == utils.EmptyHash {
-		// direct transfer, use fakeLockSecretHash
-		lockSecretHash = utils.NewRandomHash()
+		// direct transfer, use the same hash as the sender
+		lockSecretHash = models.DirectTransferLockSecretHash(channelIdentifier, openBlockNumber, nonce)
 	}
 	key := fmt.Sprintf("%s-%d-%d", channelIdentifier.String(), openBlockNumber, nonce)
 	st := &models.ReceivedTransfer{
-		Key:               key,
-		BlockNumber:       blockNumber,
-		ChannelIdentifier: channelIdentifier,
-		TokenAddress:      tokenAddr,
-		TokenAddressBytes: tokenAddr[:],
-		FromAddress:       fromAddr,
-		Nonce:             nonce,
-		Amount:            amount,
-		Data:              data,
-		OpenBlockNumber:   openBlockNumber,
-		TimeStamp:         time.Now().Unix(),
+		Key:                 key,
+		BlockNumber:         blockNumber,
+		ChannelIdentifier:   channelIdentifier,
+		TokenAddress:        tokenAddr,
+		TokenAddressBytes:   tokenAddr[:],
+		FromAddress:         fromAddr,
+		Nonce:               nonce,
+		Amount:              amount,
+		Data:                data,
+		OpenBlockNumber:     openBlockNumber,
+		TimeStamp:           time.Now().Unix(),
+		LockSecretHash:      lockSecretHash,
+		LockSecretHashBytes: lockSecretHash[:],
 	}
 	var ost models.ReceivedTransfer
 	err := dao.getKeyValueToBucket(models.BucketReceivedTransfer, key, &ost)
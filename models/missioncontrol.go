@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+ChannelObservation 发起方在一个通道方向(From->To)上观察到的交易结果,
+SuccessAmount 是最近成功通过的最大金额,FailAmount 是最近失败的最小金额
+*/
+/*
+ *	ChannelObservation : results observed by the initiator on a direction (From->To) of a channel,
+ *	SuccessAmount is the largest amount passed recently, FailAmount is the smallest amount failed recently.
+ */
+type ChannelObservation struct {
+	Key           string         `json:"-" storm:"id"`
+	TokenAddress  common.Address `json:"token_address"`
+	From          common.Address `json:"from"`
+	To            common.Address `json:"to"`
+	SuccessAmount *big.Int       `json:"success_amount"`
+	SuccessTime   int64          `json:"success_time"`
+	FailAmount    *big.Int       `json:"fail_amount"`
+	FailTime      int64          `json:"fail_time"`
+	Successes     float64        `json:"successes"` // 随时间衰减的成功次数	// decayed count of successes
+	Failures      float64        `json:"failures"`  // 随时间衰减的失败次数	// decayed count of failures
+	UpdateTime    int64          `json:"update_time"`
+}
+
+// ChannelObservationKey :
+func ChannelObservationKey(tokenAddress, from, to common.Address) string {
+	return utils.Sha3(tokenAddress[:], from[:], to[:]).String()
+}
+
+func init() {
+	gob.Register(&ChannelObservation{})
+}
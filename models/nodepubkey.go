@@ -0,0 +1,19 @@
+package models
+
+import (
+	"encoding/gob"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// NodePubKey :
+// 从节点发来的签名消息中恢复出的公钥,用于加密发给该节点的交易附加信息
+type NodePubKey struct {
+	Key     []byte `storm:"id"`
+	Address common.Address
+	PubKey  []byte
+}
+
+func init() {
+	gob.Register(&NodePubKey{})
+}
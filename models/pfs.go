@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+PfsChannelParticipant 本地 pfs 服务记录的通道中一方的状态
+Deposit 来自链上事件,TransferAmount,LockAmount 和 Nonce 来自对方提交的 balance proof
+*/
+/*
+ *	PfsChannelParticipant : state of one participant of a channel, kept by the local pfs server.
+ *	Deposit comes from chain events, TransferAmount, LockAmount and Nonce come from balance proof submitted by the partner.
+ */
+type PfsChannelParticipant struct {
+	Key               string         `json:"-" storm:"id"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	OpenBlockNumber   int64          `json:"open_block_number"`
+	Participant       common.Address `json:"participant"`
+	Deposit           *big.Int       `json:"deposit"`         // nil 表示还不知道
+	TransferAmount    *big.Int       `json:"transfer_amount"` // Participant 转给对方的总额
+	LockAmount        *big.Int       `json:"lock_amount"`     // Participant 锁定给对方的总额
+	Nonce             uint64         `json:"nonce"`
+}
+
+// PfsChannelParticipantKey :
+func PfsChannelParticipantKey(channelIdentifier common.Hash, participant common.Address) string {
+	return utils.Sha3(channelIdentifier[:], participant[:]).String()
+}
+
+// PfsFeePolicy 节点提交给本地 pfs 服务的收费策略
+type PfsFeePolicy struct {
+	Key       string     `json:"-" storm:"id"`
+	FeePolicy *FeePolicy `json:"fee_policy"`
+}
+
+// PfsSwapRates 做市商提交给本地 pfs 服务的兑换价格,Nonce 为最后一次提交的 nonce
+type PfsSwapRates struct {
+	Key         string         `json:"-" storm:"id"`
+	MarketMaker common.Address `json:"market_maker"`
+	Nonce       uint64         `json:"nonce"`
+	Rates       []*SwapRate    `json:"rates"`
+}
+
+/*
+PfsBalanceSubmission 提交给某个 pfs 失败的 balance proof,等这个 pfs 恢复后重新提交.
+同一个通道只保留最新的一个
+*/
+/*
+ *	PfsBalanceSubmission : balance proof failed to submit to a pfs host, it will be replayed when the host comes back.
+ *	only the latest one of a channel is kept.
+ */
+type PfsBalanceSubmission struct {
+	Key               string         `json:"-" storm:"id"`
+	Host              string         `json:"host" storm:"index"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	Nonce             uint64         `json:"nonce"`
+	TransferAmount    *big.Int       `json:"transfer_amount"`
+	LockAmount        *big.Int       `json:"lock_amount"`
+	OpenBlockNumber   int64          `json:"open_block_number"`
+	Locksroot         common.Hash    `json:"locksroot"`
+	AdditionHash      common.Hash    `json:"addition_hash"`
+	ProofSigner       common.Address `json:"proof_signer"`
+	Signature         []byte         `json:"signature"`
+	CreateTime        int64          `json:"create_time"`
+}
+
+// PfsBalanceSubmissionKey :
+func PfsBalanceSubmissionKey(host string, channelIdentifier common.Hash) string {
+	return utils.Sha3([]byte(host), channelIdentifier[:]).String()
+}
+
+func init() {
+	gob.Register(&PfsChannelParticipant{})
+	gob.Register(&PfsFeePolicy{})
+	gob.Register(&PfsSwapRates{})
+	gob.Register(&PfsBalanceSubmission{})
+}
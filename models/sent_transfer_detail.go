@@ -28,6 +28,9 @@ type SentTransferDetail struct {
 	*/
 	ChannelIdentifier common.Hash `json:"channel_identifier"`
 	OpenBlockNumber   int64       `json:"open_block_number"`
+
+	RefundOf       common.Hash `json:"refund_of,omitempty"`       // 这是一笔退款,对应我收到的原交易的 LockSecretHash
+	RefundedAmount *big.Int    `json:"refunded_amount,omitempty"` // 对方已经为这笔交易退还给我的金额
 }
 
 func init() {
@@ -11,10 +11,11 @@ import (
 )
 
 // NewDeliveredChainEvent save one
-func (model *StormDB) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64) {
+func (model *StormDB) NewDeliveredChainEvent(id models.ChainEventID, blockNumber uint64, blockHash common.Hash) {
 	e := &models.ChainEventRecord{
 		ID:          id,
 		BlockNumber: blockNumber,
+		BlockHash:   blockHash,
 		Status:      models.ChainEventStatusDelivered,
 	}
 	err := model.db.Save(e)
@@ -25,7 +26,7 @@ func (model *StormDB) NewDeliveredChainEvent(id models.ChainEventID, blockNumber
 }
 
 // CheckChainEventDelivered check one ChainEvent is delivered or not
-func (model *StormDB) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, delivered bool) {
+func (model *StormDB) CheckChainEventDelivered(id models.ChainEventID) (blockNumber uint64, blockHash common.Hash, delivered bool) {
 	e := &models.ChainEventRecord{}
 	err := model.db.One("ID", id, e)
 	if err == storm.ErrNotFound {
@@ -43,9 +44,18 @@ func (model *StormDB) CheckChainEventDelivered(id models.ChainEventID) (blockNum
 	}
 	delivered = true
 	blockNumber = e.BlockNumber
+	blockHash = e.BlockHash
 	return
 }
 
+// RemoveChainEventRecord 事件所在的块被分叉替换了,删除以后如果事件被重新打包,可以再次处理
+func (model *StormDB) RemoveChainEventRecord(id models.ChainEventID) {
+	err := model.db.DeleteStruct(&models.ChainEventRecord{ID: id})
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(fmt.Sprintf("models RemoveChainEventRecord id=%s err=%s", id, err))
+	}
+}
+
 // ClearOldChainEventRecord delete records which blockNumber <= blockNumber in param
 func (model *StormDB) ClearOldChainEventRecord(blockNumber uint64) {
 	var list []*models.ChainEventRecord
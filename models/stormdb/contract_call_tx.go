@@ -15,6 +15,7 @@ import (
 	"github.com/asdine/storm/q"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/kataras/go-errors"
 )
 
@@ -46,6 +47,9 @@ func (model *StormDB) NewPendingTXInfo(tx *types.Transaction, txType models.TXIn
 		if p, ok := txParams.(*models.DepositTXParams); ok && tokenAddress == utils.EmptyAddress {
 			tokenAddress = p.TokenAddress
 		}
+		if p, ok := txParams.(*models.BatchDepositTXParams); ok && tokenAddress == utils.EmptyAddress {
+			tokenAddress = p.TokenAddress
+		}
 	}
 	txInfo = &models.TXInfo{
 		TXHash:            tx.Hash(),
@@ -58,6 +62,12 @@ func (model *StormDB) NewPendingTXInfo(tx *types.Transaction, txType models.TXIn
 		Status:            models.TXInfoStatusPending,
 		CallTime:          time.Now().Unix(),
 		GasPrice:          tx.GasPrice().Uint64(),
+		Nonce:             tx.Nonce(),
+	}
+	txInfo.RawTX, err = rlp.EncodeToBytes(tx)
+	if err != nil {
+		err = models.GeneratDBError(err)
+		return
 	}
 	err = model.db.Save(txInfo.ToTXInfoSerialization())
 	if err != nil {
@@ -98,6 +108,7 @@ func (model *StormDB) UpdateTXInfoStatus(txHash common.Hash, status models.TXInf
 		err = models.GeneratDBError(err)
 		return
 	}
+	wasPending := tis.Status == models.TXInfoStatusPending
 	tis.Status = string(status)
 	tis.PackBlockNumber = packBlockNumber
 	tis.PackTime = time.Now().Unix()
@@ -112,7 +123,29 @@ func (model *StormDB) UpdateTXInfoStatus(txHash common.Hash, status models.TXInf
 			tis.TokenAddress = ch.TokenAddressBytes
 		}
 	}
-	err = model.db.Save(&tis)
+	//状态和消耗的 gas 在同一个事务中保存,要么都成功,要么都不变,调用者重试时不会重复累加或者漏算
+	stx, err := model.db.Begin(true)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoStatus err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	defer stx.Rollback()
+	err = stx.Save(&tis)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoStatus err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	//只在第一次从 pending 变为打包状态时计算,避免重复累加
+	if wasPending && status != models.TXInfoStatusPending {
+		err = addGasSpent(stx, &tis)
+		if err != nil {
+			log.Error(fmt.Sprintf("addGasSpent txhash=%s err %s", txHash.String(), err))
+			return
+		}
+	}
+	err = stx.Commit()
 	if err != nil {
 		log.Error(fmt.Sprintf("UpdateTXInfoStatus err %s", err))
 		err = models.GeneratDBError(err)
@@ -123,6 +156,38 @@ func (model *StormDB) UpdateTXInfoStatus(txHash common.Hash, status models.TXInf
 	return
 }
 
+// UpdateTXInfoHash tx 被提高 gas price 以后重新发送,换成新的 txhash,旧的 txhash 记录在 ReplacedTXHashes 中
+func (model *StormDB) UpdateTXInfoHash(oldTXHash, newTXHash common.Hash, gasPrice uint64, rawTX []byte) (txInfo *models.TXInfo, err error) {
+	var tis models.TXInfoSerialization
+	err = model.db.One("TXHash", oldTXHash[:], &tis)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoHash err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	err = model.db.DeleteStruct(&tis)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoHash err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	tis.ReplacedTXHashes = append(tis.ReplacedTXHashes, oldTXHash)
+	tis.TXHash = newTXHash[:]
+	tis.GasPrice = gasPrice
+	if rawTX != nil {
+		tis.RawTX = rawTX
+	}
+	err = model.db.Save(&tis)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateTXInfoHash err %s", err))
+		err = models.GeneratDBError(err)
+		return
+	}
+	log.Trace(fmt.Sprintf("UpdateTXInfoHash %s -> %s gasPrice=%d", oldTXHash.String(), newTXHash.String(), gasPrice))
+	txInfo = tis.ToTXInfo()
+	return
+}
+
 // GetTXInfoList :
 // 如果参数不为空,则根据参数查询
 func (model *StormDB) GetTXInfoList(channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType models.TXInfoType, status models.TXInfoStatus) (list []*models.TXInfo, err error) {
@@ -176,3 +241,22 @@ func (model *StormDB) GetTXInfoList(channelIdentifier common.Hash, openBlockNumb
 	}
 	return
 }
+
+// GetTXInfoListByCallTime : txs called between from and to, both included, only the index of CallTime is scanned
+func (model *StormDB) GetTXInfoListByCallTime(from, to int64) (list []*models.TXInfo, err error) {
+	var l []*models.TXInfoSerialization
+	err = model.db.Range("CallTime", from, to, &l)
+	if err == storm.ErrNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("GetTXInfoListByCallTime err %s", err)
+		err = models.GeneratDBError(err)
+		return
+	}
+	for _, tis := range l {
+		list = append(list, tis.ToTXInfo())
+	}
+	return
+}
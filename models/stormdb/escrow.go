@@ -0,0 +1,73 @@
+package stormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// NewEscrowHold :
+func (model *StormDB) NewEscrowHold(h *models.EscrowHold) (err error) {
+	h.Key = models.EscrowHoldKey(h.TokenAddress, h.LockSecretHash)
+	var old models.EscrowHold
+	err = model.db.One("Key", h.Key, &old)
+	if err == nil {
+		return rerr.ErrDBDuplicateKey.Printf("escrow for lockSecretHash=%s already exist", h.LockSecretHash.String())
+	}
+	now := time.Now().Unix()
+	h.CreateTime = now
+	h.UpdateTime = now
+	err = model.db.Save(h)
+	if err != nil {
+		err = fmt.Errorf("NewEscrowHold err %s", err)
+		return models.GeneratDBError(err)
+	}
+	log.Trace(fmt.Sprintf("NewEscrowHold key=%s lockSecretHash=%s", h.Key, h.LockSecretHash.String()))
+	return
+}
+
+// UpdateEscrowHold :
+func (model *StormDB) UpdateEscrowHold(h *models.EscrowHold) (err error) {
+	h.Key = models.EscrowHoldKey(h.TokenAddress, h.LockSecretHash)
+	h.UpdateTime = time.Now().Unix()
+	err = model.db.Save(h)
+	if err != nil {
+		err = fmt.Errorf("UpdateEscrowHold err %s", err)
+		return models.GeneratDBError(err)
+	}
+	log.Trace(fmt.Sprintf("UpdateEscrowHold key=%s lockSecretHash=%s status=%d", h.Key, h.LockSecretHash.String(), h.Status))
+	return
+}
+
+// GetEscrowHold :
+func (model *StormDB) GetEscrowHold(tokenAddress common.Address, lockSecretHash common.Hash) (h *models.EscrowHold, err error) {
+	h = new(models.EscrowHold)
+	err = model.db.One("Key", models.EscrowHoldKey(tokenAddress, lockSecretHash), h)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("escrow for lockSecretHash=%s not found", lockSecretHash.String())
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+// GetEscrowHoldList :
+// status 为 EscrowStatusAll 代表不限制
+func (model *StormDB) GetEscrowHoldList(status models.EscrowStatus) (list []*models.EscrowHold, err error) {
+	if status == models.EscrowStatusAll {
+		err = model.db.All(&list)
+	} else {
+		err = model.db.Find("Status", status, &list)
+	}
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	err = models.GeneratDBError(err)
+	return
+}
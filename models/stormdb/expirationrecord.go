@@ -0,0 +1,88 @@
+package stormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveExpirationRecord :
+func (model *StormDB) SaveExpirationRecord(r *models.ExpirationRecord) (err error) {
+	r.Key = models.ExpirationRecordKey(r.TokenAddress, r.LockSecretHash, r.Role)
+	if r.CreateTime <= 0 {
+		r.CreateTime = time.Now().Unix()
+	}
+	err = model.db.Save(r)
+	if err != nil {
+		err = fmt.Errorf("SaveExpirationRecord err %s", err)
+		err = models.GeneratDBError(err)
+	}
+	return
+}
+
+// RemoveExpirationRecords : 交易结束后删除 lockSecretHash 的记录,role 为空时删除所有身份的记录
+func (model *StormDB) RemoveExpirationRecords(lockSecretHash common.Hash, role models.ExpirationRole) (err error) {
+	selectList := []q.Matcher{q.Eq("LockSecretHash", lockSecretHash)}
+	if role != "" {
+		selectList = append(selectList, q.Eq("Role", role))
+	}
+	err = model.db.Select(selectList...).Delete(&models.ExpirationRecord{})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("RemoveExpirationRecords err %s", err)
+		err = models.GeneratDBError(err)
+	}
+	return
+}
+
+// RemoveExpiredExpirationRecords : 删除锁在 blockNumber 之前已经过期的记录
+func (model *StormDB) RemoveExpiredExpirationRecords(blockNumber int64) (err error) {
+	var records []*models.ExpirationRecord
+	err = model.db.Range("Expiration", int64(0), blockNumber-1, &records)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("RemoveExpiredExpirationRecords err %s", err)
+		return models.GeneratDBError(err)
+	}
+	for _, r := range records {
+		err = model.db.DeleteStruct(r)
+		if err != nil {
+			err = fmt.Errorf("RemoveExpiredExpirationRecords err %s", err)
+			return models.GeneratDBError(err)
+		}
+	}
+	return
+}
+
+// GetExpirationRecords : records of lockSecretHash, all records of tokenAddress when lockSecretHash is empty
+func (model *StormDB) GetExpirationRecords(tokenAddress common.Address, lockSecretHash common.Hash) (records []*models.ExpirationRecord, err error) {
+	var selectList []q.Matcher
+	if tokenAddress != utils.EmptyAddress {
+		selectList = append(selectList, q.Eq("TokenAddress", tokenAddress))
+	}
+	if lockSecretHash != utils.EmptyHash {
+		selectList = append(selectList, q.Eq("LockSecretHash", lockSecretHash))
+	}
+	if len(selectList) == 0 {
+		err = model.db.All(&records)
+	} else {
+		err = model.db.Select(selectList...).Find(&records)
+	}
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("GetExpirationRecords err %s", err)
+		err = models.GeneratDBError(err)
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package stormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// addGasSpent tx 打包以后把消耗的 gas 累加到通道和 tx 类型上,node 是保存 tx 状态的事务
+func addGasSpent(node storm.Node, tis *models.TXInfoSerialization) (err error) {
+	channelIdentifier := common.BytesToHash(tis.ChannelIdentifier)
+	txType := models.TXInfoType(tis.Type)
+	g := new(models.GasSpent)
+	err = node.One("Key", models.GasSpentKey(channelIdentifier, txType), g)
+	if err == storm.ErrNotFound {
+		g = &models.GasSpent{
+			Key:               models.GasSpentKey(channelIdentifier, txType),
+			ChannelIdentifier: channelIdentifier,
+			Type:              txType,
+		}
+	} else if err != nil {
+		return models.GeneratDBError(err)
+	}
+	if g.TokenAddress == utils.EmptyAddress {
+		g.TokenAddress = common.BytesToAddress(tis.TokenAddress)
+	}
+	g.Add(tis.GasUsed, tis.GasPrice)
+	g.UpdateTime = time.Now().Unix()
+	err = node.Save(g)
+	if err != nil {
+		err = fmt.Errorf("addGasSpent err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetGasSpentList :
+// channelIdentifier 为空时返回所有通道的
+func (model *StormDB) GetGasSpentList(channelIdentifier common.Hash) (list []*models.GasSpent, err error) {
+	if channelIdentifier == utils.EmptyHash {
+		err = model.db.All(&list)
+	} else {
+		err = model.db.Find("ChannelIdentifier", channelIdentifier, &list)
+	}
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return list, models.GeneratDBError(err)
+}
@@ -0,0 +1,36 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/asdine/storm"
+)
+
+// SaveChannelObservation :
+func (model *StormDB) SaveChannelObservation(o *models.ChannelObservation) (err error) {
+	o.Key = models.ChannelObservationKey(o.TokenAddress, o.From, o.To)
+	err = model.db.Save(o)
+	if err != nil {
+		err = fmt.Errorf("SaveChannelObservation err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetAllChannelObservations :
+func (model *StormDB) GetAllChannelObservations() (list []*models.ChannelObservation, err error) {
+	err = model.db.All(&list)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return list, models.GeneratDBError(err)
+}
+
+// RemoveChannelObservation :
+func (model *StormDB) RemoveChannelObservation(key string) (err error) {
+	err = model.db.DeleteStruct(&models.ChannelObservation{Key: key})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return models.GeneratDBError(err)
+}
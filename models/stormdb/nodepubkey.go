@@ -0,0 +1,31 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveNodePubKey :
+func (model *StormDB) SaveNodePubKey(addr common.Address, pubkey []byte) (err error) {
+	err = model.db.Save(&models.NodePubKey{
+		Key:     addr[:],
+		Address: addr,
+		PubKey:  pubkey,
+	})
+	if err != nil {
+		err = fmt.Errorf("SaveNodePubKey err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetNodePubKey :
+func (model *StormDB) GetNodePubKey(addr common.Address) (pubkey []byte, err error) {
+	var npk models.NodePubKey
+	err = model.db.One("Key", addr[:], &npk)
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return npk.PubKey, nil
+}
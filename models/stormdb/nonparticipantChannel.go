@@ -61,7 +61,7 @@ func (model *StormDB) GetNonParticipantChannelByID(channelIdentifierForQuery com
 	}
 	tokenAddress = common.BytesToAddress(channel.TokenAddressBytes)
 	participant1 = common.BytesToAddress(channel.Participant1Bytes)
-	participant1 = common.BytesToAddress(channel.Participant2Bytes)
+	participant2 = common.BytesToAddress(channel.Participant2Bytes)
 	return
 }
 
@@ -83,3 +83,23 @@ func (model *StormDB) GetAllNonParticipantChannelByToken(token common.Address) (
 	}
 	return
 }
+
+//GetAllNonParticipantChannelIdentifiersByToken returns all channels on this `token`, edges[2*i] and edges[2*i+1] are participants of channels[i]
+func (model *StormDB) GetAllNonParticipantChannelIdentifiersByToken(token common.Address) (channels []common.Hash, edges []common.Address, err error) {
+	var list []*NonParticipantChannel
+	err = model.db.Find("TokenAddressBytes", token[:], &list)
+	if err == storm.ErrNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("GetAllNonParticipantChannelIdentifiersByToken err %s", err)
+		err = models.GeneratDBError(err)
+		return
+	}
+	for _, c := range list {
+		channels = append(channels, common.BytesToHash(c.ChannelIdentifierBytes))
+		edges = append(edges, common.BytesToAddress(c.Participant1Bytes), common.BytesToAddress(c.Participant2Bytes))
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// GetPfsChannelParticipant :
+func (model *StormDB) GetPfsChannelParticipant(channelIdentifier common.Hash, participant common.Address) (p *models.PfsChannelParticipant, err error) {
+	p = new(models.PfsChannelParticipant)
+	err = model.db.One("Key", models.PfsChannelParticipantKey(channelIdentifier, participant), p)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("participant %s of channel %s not found", participant.String(), channelIdentifier.String())
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+// SavePfsChannelParticipant :
+func (model *StormDB) SavePfsChannelParticipant(p *models.PfsChannelParticipant) (err error) {
+	p.Key = models.PfsChannelParticipantKey(p.ChannelIdentifier, p.Participant)
+	err = model.db.Save(p)
+	if err != nil {
+		err = fmt.Errorf("SavePfsChannelParticipant err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetPfsFeePolicy :
+func (model *StormDB) GetPfsFeePolicy(node common.Address) (fp *models.FeePolicy, err error) {
+	var p models.PfsFeePolicy
+	err = model.db.One("Key", node.String(), &p)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("fee policy of %s not found", node.String())
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return p.FeePolicy, nil
+}
+
+// SavePfsFeePolicy :
+func (model *StormDB) SavePfsFeePolicy(node common.Address, fp *models.FeePolicy) (err error) {
+	err = model.db.Save(&models.PfsFeePolicy{
+		Key:       node.String(),
+		FeePolicy: fp,
+	})
+	if err != nil {
+		err = fmt.Errorf("SavePfsFeePolicy err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// SavePfsSwapRates : all the old rates of marketMaker will be replaced
+func (model *StormDB) SavePfsSwapRates(marketMaker common.Address, nonce uint64, rates []*models.SwapRate) (err error) {
+	err = model.db.Save(&models.PfsSwapRates{
+		Key:         marketMaker.String(),
+		MarketMaker: marketMaker,
+		Nonce:       nonce,
+		Rates:       rates,
+	})
+	if err != nil {
+		err = fmt.Errorf("SavePfsSwapRates err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetPfsSwapRatesNonce : nonce of the last rates saved for marketMaker
+func (model *StormDB) GetPfsSwapRatesNonce(marketMaker common.Address) (nonce uint64, err error) {
+	var p models.PfsSwapRates
+	err = model.db.One("Key", marketMaker.String(), &p)
+	if err == storm.ErrNotFound {
+		return 0, rerr.ErrNotFound.Printf("swap rates of %s not found", marketMaker.String())
+	}
+	if err != nil {
+		return 0, models.GeneratDBError(err)
+	}
+	return p.Nonce, nil
+}
+
+// GetPfsSwapRates : rates from fromToken to toToken of all market makers
+func (model *StormDB) GetPfsSwapRates(fromToken, toToken common.Address) (rates []*models.SwapRate, err error) {
+	var all []*models.PfsSwapRates
+	err = model.db.All(&all)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	for _, p := range all {
+		for _, sr := range p.Rates {
+			if sr.FromToken == fromToken && sr.ToToken == toToken {
+				rates = append(rates, sr)
+			}
+		}
+	}
+	return
+}
+
+// SavePfsBalanceSubmission : the older one of the same host and channel will be replaced
+func (model *StormDB) SavePfsBalanceSubmission(s *models.PfsBalanceSubmission) (err error) {
+	s.Key = models.PfsBalanceSubmissionKey(s.Host, s.ChannelIdentifier)
+	err = model.db.Save(s)
+	if err != nil {
+		err = fmt.Errorf("SavePfsBalanceSubmission err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// RemovePfsBalanceSubmission : only remove when it's not newer than openBlockNumber and nonce, a newer one may be saved after it was read
+func (model *StormDB) RemovePfsBalanceSubmission(host string, channelIdentifier common.Hash, openBlockNumber int64, nonce uint64) (err error) {
+	var s models.PfsBalanceSubmission
+	err = model.db.One("Key", models.PfsBalanceSubmissionKey(host, channelIdentifier), &s)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return models.GeneratDBError(err)
+	}
+	if s.OpenBlockNumber > openBlockNumber || (s.OpenBlockNumber == openBlockNumber && s.Nonce > nonce) {
+		return nil
+	}
+	err = model.db.DeleteStruct(&s)
+	return models.GeneratDBError(err)
+}
+
+// GetPfsBalanceSubmissions : all the submissions waiting to be replayed to host
+func (model *StormDB) GetPfsBalanceSubmissions(host string) (list []*models.PfsBalanceSubmission, err error) {
+	err = model.db.Find("Host", host, &list)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return list, models.GeneratDBError(err)
+}
@@ -33,6 +33,10 @@ func (model *StormDB) NewSentTransferDetail(tokenAddress, target common.Address,
 		ChannelIdentifier: utils.EmptyHash,
 		OpenBlockNumber:   0,
 	}
+	if refundOf, userData, ok := models.ParseRefundData(data); ok {
+		std.RefundOf = refundOf
+		std.Data = userData
+	}
 	err := model.db.Save(std)
 	if err != nil {
 		log.Error(fmt.Sprintf("NewSendTransferDetail key=%s, err %s", std.Key, err))
@@ -99,6 +103,25 @@ func (model *StormDB) UpdateSentTransferDetailStatusMessage(tokenAddress common.
 	return
 }
 
+// AddSentTransferDetailRefundedAmount : target refunded `amount` of this transfer to me
+func (model *StormDB) AddSentTransferDetailRefundedAmount(tokenAddress common.Address, lockSecretHash common.Hash, amount *big.Int) (transfer *models.SentTransferDetail, err error) {
+	transfer = &models.SentTransferDetail{}
+	key := utils.Sha3(tokenAddress[:], lockSecretHash[:]).String()
+	err = model.db.One("Key", key, transfer)
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	if transfer.RefundedAmount == nil {
+		transfer.RefundedAmount = big.NewInt(0)
+	}
+	transfer.RefundedAmount = new(big.Int).Add(transfer.RefundedAmount, amount)
+	err = model.db.Save(transfer)
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
 // GetSentTransferDetail :
 func (model *StormDB) GetSentTransferDetail(tokenAddress common.Address, lockSecretHash common.Hash) (*models.SentTransferDetail, error) {
 	var ts models.SentTransferDetail
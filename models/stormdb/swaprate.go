@@ -0,0 +1,35 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveSwapRate :
+func (model *StormDB) SaveSwapRate(sr *models.SwapRate) (err error) {
+	sr.Key = models.SwapRateKey(sr.FromToken, sr.ToToken)
+	err = model.db.Save(sr)
+	if err != nil {
+		err = fmt.Errorf("SaveSwapRate err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// RemoveSwapRate :
+func (model *StormDB) RemoveSwapRate(fromToken, toToken common.Address) (err error) {
+	err = model.db.DeleteStruct(&models.SwapRate{Key: models.SwapRateKey(fromToken, toToken)})
+	return models.GeneratDBError(err)
+}
+
+// GetSwapRateList :
+func (model *StormDB) GetSwapRateList() (list []*models.SwapRate, err error) {
+	err = model.db.All(&list)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	err = models.GeneratDBError(err)
+	return
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
 	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/asdine/storm"
 	"github.com/asdine/storm/q"
@@ -17,22 +18,28 @@ import (
 //NewReceivedTransfer save a new received transfer to db
 func (model *StormDB) NewReceivedTransfer(blockNumber int64, channelIdentifier common.Hash, openBlockNumber int64, tokenAddr, fromAddr common.Address, nonce uint64, amount *big.Int, lockSecretHash common.Hash, data string) *models.ReceivedTransfer {
 	if lockSecretHash == utils.EmptyHash {
-		// direct transfer, use fakeLockSecretHash
-		lockSecretHash = utils.NewRandomHash()
+		// direct transfer, use the same hash as the sender
+		lockSecretHash = models.DirectTransferLockSecretHash(channelIdentifier, openBlockNumber, nonce)
 	}
 	key := fmt.Sprintf("%s-%d-%d", channelIdentifier.String(), openBlockNumber, nonce)
 	st := &models.ReceivedTransfer{
-		Key:               key,
-		BlockNumber:       blockNumber,
-		ChannelIdentifier: channelIdentifier,
-		TokenAddress:      tokenAddr,
-		TokenAddressBytes: tokenAddr[:],
-		FromAddress:       fromAddr,
-		Nonce:             nonce,
-		Amount:            amount,
-		Data:              data,
-		OpenBlockNumber:   openBlockNumber,
-		TimeStamp:         time.Now().Unix(),
+		Key:                 key,
+		BlockNumber:         blockNumber,
+		ChannelIdentifier:   channelIdentifier,
+		TokenAddress:        tokenAddr,
+		TokenAddressBytes:   tokenAddr[:],
+		FromAddress:         fromAddr,
+		Nonce:               nonce,
+		Amount:              amount,
+		Data:                data,
+		OpenBlockNumber:     openBlockNumber,
+		TimeStamp:           time.Now().Unix(),
+		LockSecretHash:      lockSecretHash,
+		LockSecretHashBytes: lockSecretHash[:],
+	}
+	if refundOf, userData, ok := models.ParseRefundData(data); ok {
+		st.RefundOf = refundOf
+		st.Data = userData
 	}
 	if ost, err := model.GetReceivedTransfer(key); err == nil {
 		log.Error(fmt.Sprintf("NewReceivedTransfer, but already exist, old=\n%s,new=\n%s",
@@ -83,3 +90,33 @@ func (model *StormDB) GetReceivedTransferList(tokenAddress common.Address, fromB
 	}
 	return
 }
+
+//GetReceivedTransferByLockSecretHash returns the received transfer by token and lockSecretHash
+func (model *StormDB) GetReceivedTransferByLockSecretHash(tokenAddress common.Address, lockSecretHash common.Hash) (*models.ReceivedTransfer, error) {
+	var transfers []*models.ReceivedTransfer
+	if lockSecretHash == utils.EmptyHash {
+		return nil, rerr.ErrArgumentError.Append("empty lockSecretHash")
+	}
+	err := model.db.Select(q.Eq("LockSecretHashBytes", lockSecretHash[:]), q.Eq("TokenAddressBytes", tokenAddress[:])).Find(&transfers)
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	if len(transfers) > 1 {
+		return nil, rerr.ErrGeneralDBError.Printf("%d received transfers with lockSecretHash %s", len(transfers), lockSecretHash.String())
+	}
+	return transfers[0], nil
+}
+
+//AddReceivedTransferRefund record a refund transfer sent for this received transfer
+func (model *StormDB) AddReceivedTransferRefund(tokenAddress common.Address, lockSecretHash common.Hash, refundLockSecretHash common.Hash) (*models.ReceivedTransfer, error) {
+	rt, err := model.GetReceivedTransferByLockSecretHash(tokenAddress, lockSecretHash)
+	if err != nil {
+		return nil, err
+	}
+	rt.Refunds = append(rt.Refunds, refundLockSecretHash)
+	err = model.db.Save(rt)
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return rt, nil
+}
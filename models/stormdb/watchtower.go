@@ -0,0 +1,102 @@
+package stormdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveWatchtowerDelegate :
+func (model *StormDB) SaveWatchtowerDelegate(d *models.WatchtowerDelegate) (err error) {
+	d.Key = d.ChannelIdentifier[:]
+	d.UpdateTime = time.Now().Unix()
+	if d.CreateTime == 0 {
+		d.CreateTime = d.UpdateTime
+	}
+	err = model.db.Save(d)
+	if err != nil {
+		err = fmt.Errorf("SaveWatchtowerDelegate err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetWatchtowerDelegate :
+func (model *StormDB) GetWatchtowerDelegate(channelIdentifier common.Hash) (d *models.WatchtowerDelegate, err error) {
+	d = new(models.WatchtowerDelegate)
+	err = model.db.One("Key", channelIdentifier[:], d)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("watchtower delegate for channel %s not found", channelIdentifier.String())
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+// GetWatchtowerDelegateList :
+func (model *StormDB) GetWatchtowerDelegateList() (list []*models.WatchtowerDelegate, err error) {
+	err = model.db.All(&list)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return list, models.GeneratDBError(err)
+}
+
+// RemoveWatchtowerDelegate :
+func (model *StormDB) RemoveWatchtowerDelegate(channelIdentifier common.Hash) (err error) {
+	err = model.db.DeleteStruct(&models.WatchtowerDelegate{Key: channelIdentifier[:]})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return models.GeneratDBError(err)
+}
+
+func watchtowerAckKey(watchtowerURL string, channelIdentifier common.Hash) string {
+	return fmt.Sprintf("%s-%s", watchtowerURL, channelIdentifier.String())
+}
+
+// SaveWatchtowerAck :
+func (model *StormDB) SaveWatchtowerAck(ack *models.WatchtowerAck) (err error) {
+	ack.Key = watchtowerAckKey(ack.WatchtowerURL, ack.ChannelIdentifier)
+	ack.AckTime = time.Now().Unix()
+	err = model.db.Save(ack)
+	if err != nil {
+		err = fmt.Errorf("SaveWatchtowerAck err %s", err)
+	}
+	return models.GeneratDBError(err)
+}
+
+// GetWatchtowerAck :
+func (model *StormDB) GetWatchtowerAck(watchtowerURL string, channelIdentifier common.Hash) (ack *models.WatchtowerAck, err error) {
+	ack = new(models.WatchtowerAck)
+	err = model.db.One("Key", watchtowerAckKey(watchtowerURL, channelIdentifier), ack)
+	if err == storm.ErrNotFound {
+		return nil, rerr.ErrNotFound.Printf("ack of channel %s from %s not found", channelIdentifier.String(), watchtowerURL)
+	}
+	if err != nil {
+		return nil, models.GeneratDBError(err)
+	}
+	return
+}
+
+// GetWatchtowerAckList :
+func (model *StormDB) GetWatchtowerAckList() (list []*models.WatchtowerAck, err error) {
+	err = model.db.All(&list)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return list, models.GeneratDBError(err)
+}
+
+// RemoveWatchtowerAck :
+func (model *StormDB) RemoveWatchtowerAck(watchtowerURL string, channelIdentifier common.Hash) (err error) {
+	err = model.db.DeleteStruct(&models.WatchtowerAck{Key: watchtowerAckKey(watchtowerURL, channelIdentifier)})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return models.GeneratDBError(err)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SwapRate :
+// 做市商公布的兑换价格,收到 x 个 FromToken,支付 x*RateNumerator/RateDenominator 个 ToToken
+// MinAmount 和 MaxAmount 限制的是 FromToken 的数量,为空代表不限制
+type SwapRate struct {
+	Key             string         `json:"-" storm:"id"`
+	MarketMaker     common.Address `json:"market_maker"`
+	FromToken       common.Address `json:"from_token"`
+	ToToken         common.Address `json:"to_token"`
+	RateNumerator   *big.Int       `json:"rate_numerator"`
+	RateDenominator *big.Int       `json:"rate_denominator"`
+	MinAmount       *big.Int       `json:"min_amount"`
+	MaxAmount       *big.Int       `json:"max_amount"`
+	Signature       []byte         `json:"signature"` // used when advertise to pfs
+}
+
+// SwapRateKey :
+func SwapRateKey(fromToken, toToken common.Address) string {
+	return utils.Sha3(fromToken[:], toToken[:]).String()
+}
+
+// Quote returns how many ToToken the market maker pays for fromAmount FromToken
+func (sr *SwapRate) Quote(fromAmount *big.Int) (toAmount *big.Int, ok bool) {
+	if fromAmount == nil || fromAmount.Sign() <= 0 || sr.RateNumerator == nil || sr.RateDenominator == nil || sr.RateDenominator.Sign() <= 0 {
+		return nil, false
+	}
+	if sr.MinAmount != nil && fromAmount.Cmp(sr.MinAmount) < 0 {
+		return nil, false
+	}
+	if sr.MaxAmount != nil && sr.MaxAmount.Sign() > 0 && fromAmount.Cmp(sr.MaxAmount) > 0 {
+		return nil, false
+	}
+	toAmount = new(big.Int).Mul(fromAmount, sr.RateNumerator)
+	toAmount.Div(toAmount, sr.RateDenominator)
+	return toAmount, toAmount.Sign() > 0
+}
+
+func (sr *SwapRate) signData() []byte {
+	zeroIfNil := func(i *big.Int) *big.Int {
+		if i == nil {
+			return big.NewInt(0)
+		}
+		return i
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(sr.FromToken[:])
+	buf.Write(sr.ToToken[:])
+	buf.Write(utils.BigIntTo32Bytes(zeroIfNil(sr.RateNumerator)))
+	buf.Write(utils.BigIntTo32Bytes(zeroIfNil(sr.RateDenominator)))
+	buf.Write(utils.BigIntTo32Bytes(zeroIfNil(sr.MinAmount)))
+	buf.Write(utils.BigIntTo32Bytes(zeroIfNil(sr.MaxAmount)))
+	return buf.Bytes()
+}
+
+// Sign for pfs
+func (sr *SwapRate) Sign(key *ecdsa.PrivateKey) {
+	var err error
+	sr.Signature, err = utils.SignData(key, sr.signData())
+	if err != nil {
+		log.Crit(fmt.Sprintf("signDataFor SwapRate err %s", err))
+	}
+}
+
+// VerifySignature check the rate is signed by the market maker
+func (sr *SwapRate) VerifySignature() bool {
+	signer, err := utils.Ecrecover(utils.Sha3(sr.signData()), sr.Signature)
+	return err == nil && signer == sr.MarketMaker
+}
+
+func init() {
+	gob.Register(&SwapRate{})
+}
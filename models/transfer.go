@@ -1,9 +1,11 @@
 package models
 
 import (
+	"encoding/binary"
 	"encoding/gob"
 	"math/big"
 
+	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -20,8 +22,27 @@ type ReceivedTransfer struct {
 	Amount            *big.Int       `json:"amount"`
 	Data              string         `json:"data"`
 	TimeStamp         int64          `json:"time_stamp" storm:"index"`
+	/*
+		直接交易没有 LockSecretHash,使用 DirectTransferLockSecretHash 生成,
+		收发双方根据通道和 nonce 计算出的值相同,可以唯一标识这笔交易
+	*/
+	LockSecretHash      common.Hash   `json:"lock_secret_hash"`
+	LockSecretHashBytes []byte        `json:"-" storm:"index"`
+	RefundOf            common.Hash   `json:"refund_of,omitempty"` // 这是一笔退款,对应我发出的原交易的 LockSecretHash
+	Refunds             []common.Hash `json:"refunds,omitempty"`   // 我为这笔交易发起的退款交易的 LockSecretHash
 }
 
 func init() {
 	gob.Register(&ReceivedTransfer{})
 }
+
+/*
+DirectTransferLockSecretHash 直接交易的标识,由通道和交易的 nonce 唯一确定,
+发起方记录 SentTransferDetail 和接收方记录 ReceivedTransfer 时使用同一个值
+*/
+func DirectTransferLockSecretHash(channelIdentifier common.Hash, openBlockNumber int64, nonce uint64) common.Hash {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], uint64(openBlockNumber))
+	binary.BigEndian.PutUint64(buf[8:], nonce)
+	return utils.Sha3([]byte("DirectTransfer"), channelIdentifier[:], buf)
+}
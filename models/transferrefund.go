@@ -0,0 +1,33 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+RefundDataPrefix 退款交易通过附加信息携带原交易的 LockSecretHash,格式为 refund:0x...:用户附加信息,
+这样收发双方都可以在交易记录中关联到原交易
+*/
+const RefundDataPrefix = "refund:"
+
+//MakeRefundData returns the data of a refund transfer for original transfer `refundOf`
+func MakeRefundData(refundOf common.Hash, data string) string {
+	return RefundDataPrefix + refundOf.String() + ":" + data
+}
+
+//ParseRefundData returns the original transfer's LockSecretHash and user's data if data is made by MakeRefundData
+func ParseRefundData(data string) (refundOf common.Hash, userData string, ok bool) {
+	if !strings.HasPrefix(data, RefundDataPrefix) {
+		return
+	}
+	ss := strings.SplitN(data[len(RefundDataPrefix):], ":", 2)
+	if len(ss) != 2 || len(ss[0]) != 2*common.HashLength+2 {
+		return
+	}
+	refundOf = common.HexToHash(ss[0])
+	userData = ss[1]
+	ok = true
+	return
+}
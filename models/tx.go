@@ -35,6 +35,9 @@ const (
 	TXInfoTypeWithdraw           = "Withdraw"
 	TXInfoTypeApproveDeposit     = "ApproveDeposit"
 	TXInfoTypeRegisterSecret     = "RegisterSecret"
+
+	// 批量打开通道时一次 approve 所有通道的金额
+	TXInfoTypeApproveBatchDeposit = "ApproveBatchDeposit"
 )
 
 // TXInfo 记录已经提交到公链节点的tx信息
@@ -53,6 +56,9 @@ type TXInfo struct {
 	PackTime          int64          `json:"pack_time"`         // tx打包时间戳
 	GasPrice          uint64         `json:"gas_price"`
 	GasUsed           uint64         `json:"gas_used"` // 消耗的gas
+	Nonce             uint64         `json:"nonce"`
+	RawTX             []byte         `json:"-"`                  // rlp 编码的已签名 tx,卡住的时候用来重新签名替换
+	ReplacedTXHashes  []common.Hash  `json:"replaced_tx_hashes"` // 被提高 gas price 替换掉的 tx,其中任何一个都可能被打包
 }
 
 // String :
@@ -81,6 +87,9 @@ func (ti *TXInfo) ToTXInfoSerialization() *TXInfoSerialization {
 		PackTime:          ti.PackTime,
 		GasPrice:          ti.GasPrice,
 		GasUsed:           ti.GasUsed,
+		Nonce:             ti.Nonce,
+		RawTX:             ti.RawTX,
+		ReplacedTXHashes:  ti.ReplacedTXHashes,
 	}
 }
 
@@ -100,6 +109,9 @@ type TXInfoSerialization struct {
 	PackTime          int64         `storm:"index"`
 	GasPrice          uint64
 	GasUsed           uint64
+	Nonce             uint64
+	RawTX             []byte
+	ReplacedTXHashes  []common.Hash
 }
 
 // ToTXInfo :
@@ -119,6 +131,9 @@ func (tis *TXInfoSerialization) ToTXInfo() *TXInfo {
 		PackTime:          tis.PackTime,
 		GasPrice:          tis.GasPrice,
 		GasUsed:           tis.GasUsed,
+		Nonce:             tis.Nonce,
+		RawTX:             tis.RawTX,
+		ReplacedTXHashes:  tis.ReplacedTXHashes,
 	}
 }
 
@@ -141,6 +156,12 @@ type DepositTXParams struct {
 	SettleTimeout      uint64         `json:"settle_timeout"`
 }
 
+// BatchDepositTXParams 保存在ApproveBatchDepositTX的TXParams中,approve成功以后依次发起其中的每个deposit
+type BatchDepositTXParams struct {
+	TokenAddress common.Address     `json:"token_address"`
+	Deposits     []*DepositTXParams `json:"deposits"`
+}
+
 // ChannelCloseOrChannelUpdateBalanceProofTXParams 关闭通道或者UpdateBalanceProof的参数,两种操作复用,根据上层TXInfo中的Type区分
 type ChannelCloseOrChannelUpdateBalanceProofTXParams struct {
 	TokenAddress       common.Address `json:"token_address"`
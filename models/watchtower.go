@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/gob"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+WatchtowerDelegate 手机节点委托给我(watchtower)的通道,Content 是它最新上传的 ChannelFor3rd,
+其他字段是我观察到的链上状态和我已经替它做过的操作
+*/
+/*
+ *	WatchtowerDelegate : a channel delegated to me (as a watchtower) by a mobile node, Content is the latest ChannelFor3rd it uploaded,
+ *	other fields are the on-chain state I have observed and what I have done for it.
+ */
+type WatchtowerDelegate struct {
+	Key               []byte         `json:"-" storm:"id"` // ChannelIdentifier
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	OpenBlockNumber   int64          `json:"open_block_number"`
+	TokenAddress      common.Address `json:"token_address"`
+	Delegator         common.Address `json:"delegator"` // 委托方,通道参与者
+	PartnerAddress    common.Address `json:"partner_address"`
+	Nonce             uint64         `json:"nonce"` // Content 中 UpdateTransfer 的 nonce,只接受更大的
+	Content           string         `json:"-"`     // json 编码的 ChannelFor3rd
+	FeeLockSecretHash common.Hash    `json:"fee_lock_secret_hash"`
+	CreateTime        int64          `json:"create_time"`
+	UpdateTime        int64          `json:"update_time"`
+	// 链上状态
+	ClosedBlock       int64          `json:"closed_block"`
+	ClosingAddress    common.Address `json:"closing_address"`
+	SettleBlock       int64          `json:"settle_block"`
+	SettleTimeout     int64          `json:"settle_timeout"`
+	PartnerProofReady bool           `json:"partner_proof_ready"` // 链上 partner 的 balance proof 就是 Content 中的,可以 unlock 了
+	UpdateSubmitted   bool           `json:"update_submitted"`
+	RegisteredSecrets []common.Hash  `json:"registered_secrets"` // LockSecretHash
+	UnlockedLocks     []common.Hash  `json:"unlocked_locks"`     // lock hash
+	Punished          bool           `json:"punished"`
+}
+
+/*
+WatchtowerAck 我把通道委托给 watchtower 以后,它已经确认的最新数据
+*/
+/*
+ *	WatchtowerAck : the latest delegation of a channel acknowledged by a watchtower.
+ */
+type WatchtowerAck struct {
+	Key               string         `json:"-" storm:"id"` // WatchtowerURL-ChannelIdentifier
+	WatchtowerURL     string         `json:"watchtower_url"`
+	WatchtowerAddress common.Address `json:"watchtower_address"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	OpenBlockNumber   int64          `json:"open_block_number"`
+	Nonce             uint64         `json:"nonce"`                // 已确认的 partner balance proof 的 nonce
+	ContentHash       common.Hash    `json:"content_hash"`         // 已确认的 ChannelFor3rd 的 hash,nonce 不变时也可能知道了新的密码
+	FeeLockSecretHash common.Hash    `json:"fee_lock_secret_hash"` // 委托这个通道支付的费用,已经付费但是还没有确认时 ContentHash 为空
+	AckTime           int64          `json:"ack_time"`
+}
+
+func init() {
+	gob.Register(&WatchtowerDelegate{})
+	gob.Register(&WatchtowerAck{})
+}
@@ -24,19 +24,22 @@ func NewGraph() *Graph {
 	return new
 }
 
-// CloneGraph clone this graph
+// CloneGraph clone this graph, arcs are copied, so changing the clone doesn't affect this graph
 func (g *Graph) CloneGraph() *Graph {
 	new := &Graph{}
 	new.mapping = map[string]int{}
 	for k, v := range g.mapping {
 		new.mapping[k] = v
 	}
+	new.usingMap = g.usingMap
+	new.highestMapIndex = g.highestMapIndex
 	for _, v := range g.Verticies {
 		newv := v
-		new.Verticies = append(new.Verticies, newv)
+		newv.arcs = make(map[int]int64, len(v.arcs))
 		for k2, v2 := range v.arcs {
 			newv.arcs[k2] = v2
 		}
+		new.Verticies = append(new.Verticies, newv)
 	}
 	return new
 }
@@ -0,0 +1,145 @@
+package dijkstra
+
+import "sort"
+
+//maskedArc an arc removed from the graph temporarily
+type maskedArc struct {
+	from, to int
+	distance int64
+}
+
+//maskArc removes arc from->to and remembers it in masked so that it can be restored
+func (g *Graph) maskArc(from, to int, masked []maskedArc) []maskedArc {
+	d, ok := g.Verticies[from].GetArc(to)
+	if !ok {
+		return masked
+	}
+	g.Verticies[from].DeleteArc(to)
+	return append(masked, maskedArc{from, to, d})
+}
+
+//restoreArcs puts all the masked arcs back
+func (g *Graph) restoreArcs(masked []maskedArc) {
+	for _, a := range masked {
+		g.Verticies[a.from].AddArc(a.to, a.distance)
+	}
+}
+
+/*
+KShortest calculates at most k loop-free shortest paths from src to dest with Yen's algorithm,
+paths are ordered by distance, then by hops.
+arcs are masked during the search and restored before returning, so g is not changed, but g must not be used by others meanwhile.
+*/
+func (g *Graph) KShortest(src, dest, k int) (paths []BestPath, err error) {
+	if k <= 0 {
+		return
+	}
+	first, err := g.Shortest(src, dest)
+	if err != nil {
+		return
+	}
+	paths = append(paths, first)
+	var candidates []BestPath
+	for len(paths) < k {
+		last := paths[len(paths)-1]
+		for i := 0; i < len(last.Path)-1; i++ {
+			spurNode := last.Path[i]
+			rootPath := last.Path[:i+1]
+			var masked []maskedArc
+			//don't take the same next arc as the found paths sharing this root
+			for _, p := range paths {
+				if len(p.Path) > i+1 && equalPath(p.Path[:i+1], rootPath) {
+					masked = g.maskArc(p.Path[i], p.Path[i+1], masked)
+				}
+			}
+			//keep the path loop-free, a node without any arc out can never be on the path to dest
+			for _, n := range rootPath[:i] {
+				for to := range g.Verticies[n].arcs {
+					masked = g.maskArc(n, to, masked)
+				}
+			}
+			spur, err2 := g.Shortest(spurNode, dest)
+			g.restoreArcs(masked)
+			if err2 != nil {
+				continue
+			}
+			candidate := BestPath{
+				Distance: g.pathDistance(rootPath) + spur.Distance,
+				Path:     append(append([]int{}, rootPath[:i]...), spur.Path...),
+			}
+			if !containsPath(paths, candidate.Path) && !containsPath(candidates, candidate.Path) {
+				candidates = append(candidates, candidate)
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].Distance != candidates[j].Distance {
+				return candidates[i].Distance < candidates[j].Distance
+			}
+			return len(candidates[i].Path) < len(candidates[j].Path)
+		})
+		paths = append(paths, candidates[0])
+		candidates = candidates[1:]
+	}
+	return
+}
+
+/*
+ShortestByFirstHop calculates the shortest path from src to dest through each neighbor of src,
+returns at most k of them ordered by distance, no two paths share the same first hop.
+it is the same as keeping only the first path of each first hop in the result of KShortest, but much cheaper.
+arcs are masked during the search and restored before returning, so g is not changed, but g must not be used by others meanwhile.
+*/
+func (g *Graph) ShortestByFirstHop(src, dest, k int) (paths []BestPath, err error) {
+	var masked []maskedArc
+	defer func() {
+		g.restoreArcs(masked)
+	}()
+	for len(paths) < k {
+		p, err2 := g.Shortest(src, dest)
+		if err2 != nil {
+			if len(paths) == 0 {
+				err = err2
+			}
+			return
+		}
+		if len(p.Path) < 2 {
+			err = ErrNoPath
+			return
+		}
+		paths = append(paths, p)
+		masked = g.maskArc(src, p.Path[1], masked)
+	}
+	return
+}
+
+func (g *Graph) pathDistance(path []int) (distance int64) {
+	for i := 0; i < len(path)-1; i++ {
+		d, _ := g.Verticies[path[i]].GetArc(path[i+1])
+		distance += d
+	}
+	return
+}
+
+func equalPath(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPath(paths []BestPath, path []int) bool {
+	for _, p := range paths {
+		if equalPath(p.Path, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package dijkstra
+
+import (
+	"reflect"
+	"testing"
+)
+
+//the example graph of Yen's algorithm on wikipedia, C=0 D=1 E=2 F=3 G=4 H=5
+func yenGraph() *Graph {
+	g := NewGraph()
+	for i := 0; i < 6; i++ {
+		g.AddVertex(i)
+	}
+	arcs := [][3]int64{
+		{0, 1, 3}, {0, 2, 2},
+		{1, 3, 4},
+		{2, 1, 1}, {2, 3, 2}, {2, 4, 3},
+		{3, 4, 2}, {3, 5, 1},
+		{4, 5, 2},
+	}
+	for _, a := range arcs {
+		err := g.AddArc(int(a[0]), int(a[1]), a[2])
+		if err != nil {
+			panic(err)
+		}
+	}
+	return g
+}
+
+func arcsOf(g *Graph) (arcs []map[int]int64) {
+	for _, v := range g.Verticies {
+		arcs = append(arcs, v.arcs)
+	}
+	return
+}
+
+func TestKShortest(t *testing.T) {
+	g := yenGraph()
+	paths, err := g.KShortest(0, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []BestPath{
+		{5, []int{0, 2, 3, 5}},
+		{7, []int{0, 2, 4, 5}},
+		{8, []int{0, 1, 3, 5}},
+	}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Errorf("expect %v, got %v", expect, paths)
+	}
+	//g must not be changed, all the masked arcs are restored
+	if !reflect.DeepEqual(arcsOf(g), arcsOf(yenGraph())) {
+		t.Error("graph changed by KShortest")
+	}
+	all, err := g.KShortest(0, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Distance < all[i-1].Distance {
+			t.Errorf("paths not ordered %v", all)
+		}
+	}
+	if len(all) != 7 {
+		t.Errorf("expect 7 loop-free paths, got %d %v", len(all), all)
+	}
+	_, err = g.KShortest(5, 0, 1)
+	if err != ErrNoPath {
+		t.Errorf("expect ErrNoPath, got %v", err)
+	}
+}
+
+func TestCloneGraph(t *testing.T) {
+	g := yenGraph()
+	g2 := g.CloneGraph()
+	g2.Verticies[0].SetWeight(100)
+	g2.Verticies[2].DeleteArc(1)
+	if d, ok := g.Verticies[0].GetArc(2); !ok || d != 2 {
+		t.Error("changing clone should not affect the original graph")
+	}
+	if _, ok := g.Verticies[2].GetArc(1); !ok {
+		t.Error("changing clone should not affect the original graph")
+	}
+}
+
+func TestShortestByFirstHop(t *testing.T) {
+	g := yenGraph()
+	paths, err := g.ShortestByFirstHop(0, 5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//C-E-F-H and C-D-F-H, the other paths share first hop with them
+	expect := []BestPath{
+		{5, []int{0, 2, 3, 5}},
+		{8, []int{0, 1, 3, 5}},
+	}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Errorf("expect %v, got %v", expect, paths)
+	}
+	//the same as the first path of each first hop in KShortest
+	all, err := g.KShortest(0, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var firstOfHop []BestPath
+	seen := make(map[int]bool)
+	for _, p := range all {
+		if !seen[p.Path[1]] {
+			seen[p.Path[1]] = true
+			firstOfHop = append(firstOfHop, p)
+		}
+	}
+	if !reflect.DeepEqual(paths, firstOfHop) {
+		t.Errorf("expect %v, got %v", firstOfHop, paths)
+	}
+	paths, err = g.ShortestByFirstHop(0, 5, 1)
+	if err != nil || len(paths) != 1 {
+		t.Errorf("expect 1 path, got %v %v", paths, err)
+	}
+	//masked arcs are restored
+	if !reflect.DeepEqual(arcsOf(g), arcsOf(yenGraph())) {
+		t.Error("graph changed by ShortestByFirstHop")
+	}
+	_, err = g.ShortestByFirstHop(5, 0, 1)
+	if err != ErrNoPath {
+		t.Errorf("expect ErrNoPath, got %v", err)
+	}
+}
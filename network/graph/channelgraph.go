@@ -5,8 +5,6 @@ import (
 
 	"fmt"
 
-	"sort"
-
 	"strings"
 
 	"math/big"
@@ -175,16 +173,141 @@ func (cg *ChannelGraph) HasChannel(source, target common.Address) bool {
 	if !ok {
 		return false
 	}
-	_, err := cg.g.Shortest(sourceIndex, targetIndex)
+	_, err := cg.g.CloneGraph().Shortest(sourceIndex, targetIndex)
 	return err == nil
 }
 
 var errAddressNotFoundInGraph = errors.New("address not found in channelgraph")
 
+const (
+	//MaxRoutes 本地路由最多返回的路径数
+	MaxRoutes = 5
+	//每一跳的基础权重,费用相同时选择跳数少的
+	hopWeight = 1
+	//可靠性为0的节点额外的权重是转账金额的 1/unreliableDivisor
+	unreliableDivisor = 100
+	//防止权重累加溢出
+	maxWeight = int64(1) << 50
+)
+
+//RouteScorer 提供本地路由使用的通道成功可能性和节点可靠性
+// RouteScorer : success probability of channels and reliability of nodes used by local routing
+type RouteScorer interface {
+	//ChannelProbability returns probability between 0 and 1 that amount can be sent from -> to
+	ChannelProbability(tokenAddress, from, to common.Address, amount *big.Int) float64
+	//NodeReliability returns probability between 0 and 1 that node forwards a transfer successfully
+	NodeReliability(node common.Address) float64
+}
+
+//Path a path found by local routing
+type Path struct {
+	Nodes  []common.Address //all the nodes after source, the last one is target
+	Weight int64
+	Fee    *big.Int //fee charged by all the mediators
+}
+
+func saturatedWeight(w *big.Int) int64 {
+	if w.Cmp(big.NewInt(maxWeight)) > 0 {
+		return maxWeight
+	}
+	return w.Int64()
+}
+
 /*
-ShortestPath returns the shortestpath weight from source to target.  make sure only be called in one thread.
+snapshot 为一次查询构造带权重的图,不修改 cg.g:
+A->B 的权重为 A 收取的费用(source 不收费) + A->B 以及 B 可能失败的惩罚 + hopWeight,
+我们自己余额不足的通道和 exclude 中的节点都被去掉
+*/
+/*
+ *	snapshot : build a weighted graph for one query, cg.g is not changed.
+ *	weight of A->B is fee charged by A (source charges nothing) + penalty of A->B or B failing + hopWeight,
+ *	our channels without enough balance and nodes in exclude are removed.
+ */
+func (cg *ChannelGraph) snapshot(source common.Address, amount *big.Int, exclude map[common.Address]bool, feeCharger fee.Charger, scorer RouteScorer) (g *dijkstra.Graph, fees map[common.Address]*big.Int) {
+	g = cg.g.CloneGraph()
+	fees = make(map[common.Address]*big.Int)
+	for i := range g.Verticies {
+		v := &g.Verticies[i]
+		from := cg.index2address[v.ID]
+		neighbors, _ := g.GetAllNeighbors(i)
+		if exclude[from] {
+			for _, n := range neighbors {
+				v.DeleteArc(n)
+			}
+			continue
+		}
+		chargeFee := big.NewInt(0)
+		if from != source {
+			chargeFee = feeCharger.GetNodeChargeFee(from, cg.TokenAddress, amount)
+		}
+		fees[from] = chargeFee
+		for _, n := range neighbors {
+			to := cg.index2address[n]
+			if exclude[to] || !cg.canTransfer(from, to, amount) {
+				v.DeleteArc(n)
+				continue
+			}
+			w := new(big.Int).Add(chargeFee, big.NewInt(hopWeight))
+			if scorer != nil {
+				reliability := scorer.NodeReliability(to)
+				if from != cg.OurAddress {
+					reliability *= scorer.ChannelProbability(cg.TokenAddress, from, to, amount)
+				}
+				if reliability < 1 && reliability >= 0 {
+					penalty := new(big.Int).Mul(amount, big.NewInt(int64((1-reliability)*1000)))
+					penalty.Div(penalty, big.NewInt(1000*unreliableDivisor))
+					w.Add(w, penalty)
+				}
+			}
+			v.AddArc(n, saturatedWeight(w))
+		}
+	}
+	return
+}
+
+//canTransfer our channels use the real balance, other channels are only penalized by scorer
+func (cg *ChannelGraph) canTransfer(from, to common.Address, amount *big.Int) bool {
+	if from == cg.OurAddress {
+		c := cg.PartenerAddress2Channel[to]
+		return c != nil && c.CanTransfer() && amount.Cmp(c.Distributable()) <= 0
+	}
+	return true
+}
+
+/*
+ShortestPath returns the shortestpath weight from source to target. it runs on a snapshot, cg.g is not changed.
 */
 func (cg *ChannelGraph) ShortestPath(source, target common.Address, amount *big.Int, feeCharger fee.Charger) (totalWeight int64, err error) {
+	if source == target {
+		if _, ok := cg.address2index[source]; !ok {
+			err = errAddressNotFoundInGraph
+		}
+		return
+	}
+	paths, err := cg.GetKShortestPaths(source, target, amount, 1, EmptyExlude, feeCharger, nil)
+	if err != nil {
+		return
+	}
+	return paths[0].Weight, nil
+}
+
+/*
+GetKShortestPaths returns at most k loop-free paths from source to target ordered by weight,
+nodes in exclude are not used.
+*/
+func (cg *ChannelGraph) GetKShortestPaths(source, target common.Address, amount *big.Int, k int, exclude map[common.Address]bool, feeCharger fee.Charger, scorer RouteScorer) (paths []*Path, err error) {
+	return cg.getPaths(source, target, amount, k, exclude, feeCharger, scorer, false)
+}
+
+/*
+getPaths 在一次查询的快照上找路径,snapshot 本身就是副本,搜索时只是临时屏蔽其中的边.
+distinctFirstHop 为 true 时每个第一跳只返回最短的一条路径,重试时换的才是另一个通道.
+*/
+/*
+ *	getPaths : find paths on the snapshot of one query, snapshot is a copy already, arcs of it are only masked during the search.
+ *	if distinctFirstHop is true, only the shortest path of each first hop is returned, so a retry really uses another channel.
+ */
+func (cg *ChannelGraph) getPaths(source, target common.Address, amount *big.Int, k int, exclude map[common.Address]bool, feeCharger fee.Charger, scorer RouteScorer, distinctFirstHop bool) (paths []*Path, err error) {
 	sourceIndex, ok := cg.address2index[source]
 	if !ok {
 		err = errAddressNotFoundInGraph
@@ -196,28 +319,35 @@ func (cg *ChannelGraph) ShortestPath(source, target common.Address, amount *big.
 		return
 	}
 	if sourceIndex == targetIndex {
-		return 0, nil
+		err = dijkstra.ErrNoPath
+		return
 	}
-	var g2 *dijkstra.Graph
-	if false { //make sure only be called in one thread.
-		g2 = cg.g.CloneGraph()
+	g, fees := cg.snapshot(source, amount, exclude, feeCharger, scorer)
+	var bestPaths []dijkstra.BestPath
+	if distinctFirstHop {
+		bestPaths, err = g.ShortestByFirstHop(sourceIndex, targetIndex, k)
 	} else {
-		g2 = cg.g
-	}
-	for _, v := range g2.Verticies {
-		w := feeCharger.GetNodeChargeFee(cg.index2address[v.ID], cg.TokenAddress, amount).Int64()
-		log.Trace(fmt.Sprintf(fmt.Sprintf("setfee node=%s,fee=%d", cg.index2address[v.ID].String(), w)))
-		if w > 0 { //for no fee policy, all nodes charge 0 ,so use the shortest path first.
-			v.SetWeight(w) // from v's fee is w.
-		}
+		bestPaths, err = g.KShortest(sourceIndex, targetIndex, k)
 	}
-	//log.Trace(fmt.Sprintf("g2=%s", utils.StringInterface(g2, 20)))
-	//log.Trace(fmt.Sprintf("index2address=%s", utils.StringInterface(cg.index2address, 5)))
-	path, err := g2.Shortest(sourceIndex, targetIndex)
 	if err != nil {
 		return
 	}
-	return path.Distance, nil
+	for _, bp := range bestPaths {
+		p := &Path{
+			Weight: bp.Distance,
+			Fee:    big.NewInt(0),
+		}
+		for i, index := range bp.Path[1:] {
+			addr := cg.index2address[index]
+			p.Nodes = append(p.Nodes, addr)
+			//target charges nothing
+			if i < len(bp.Path)-2 {
+				p.Fee.Add(p.Fee, fees[addr])
+			}
+		}
+		paths = append(paths, p)
+	}
+	return
 }
 
 //RemoveChannel remove a channel from graph,and i'm a participant of this channel
@@ -272,105 +402,45 @@ func (cg *ChannelGraph) getNeighbours() []common.Address {
 	return neighbours
 }
 
-type neighborWeight struct {
-	neighbor common.Address
-	weight   int64 //nerghbor to target's hops
-}
-type neighborWeightList []*neighborWeight
-
-func (nw neighborWeightList) Len() int {
-	return len(nw)
-}
-func (nw neighborWeightList) Less(i, j int) bool {
-	return nw[i].weight < nw[j].weight
-}
-func (nw neighborWeightList) Swap(i, j int) {
-	var temp *neighborWeight
-	temp = nw[i]
-	nw[i] = nw[j]
-	nw[j] = temp
-}
-
 /*
-all the neighbors that can reach target
-they are ordered by hops to the target
+GetBestRoutes returns at most MaxRoutes routes to target ordered by weight, each route has the full path.
+每个路由的第一跳都不同.权重由沿途节点的收费,通道容量估计和节点可靠性决定,不在线的邻居和 excludeAddresses 中的节点不会被使用.
 */
-func (cg *ChannelGraph) orderedNeighbours(ourAddress, targetAddress common.Address, amount *big.Int, charger fee.Charger) neighborWeightList {
-
-	neighbors := cg.getNeighbours()
-	var nws neighborWeightList
-	for _, n := range neighbors {
-		w, err := cg.ShortestPath(n, targetAddress, amount, charger)
-		if err != nil {
-			continue
-		}
-		nws = append(nws, &neighborWeight{n, w})
-	}
-	sort.Sort(nws)
-	return nws
-}
-
 /*
-GetBestRoutes returns all neighbor nodes order by weight from it to target.
-我们现在的路由算法应该是有历史记忆的最短路径/最小费用算法.
-跳过所有已经走过的路径.
-*/
-/*
- *	GetBestRoutes :function to return all neighbor nodes order by weight from it to target.
+ *	GetBestRoutes : function to return at most MaxRoutes routes to target ordered by weight, each route has the full path.
+ *	no two routes share the same first hop.
  *
- *	Note that the routing algorithm we currently use should be the shortest-path/minimized-fee algorithm with history record,
- *	which circumvents all routes that have been iterated.
+ *	Weight is decided by fee of nodes on the path, capacity estimate of channels and reliability of nodes,
+ *	neighbors offline and nodes in excludeAddresses are not used. scorer can be nil.
  */
 func (cg *ChannelGraph) GetBestRoutes(nodesStatus NodesStatusGetter, ourAddress common.Address,
-	targetAdress common.Address, amount *big.Int, targetAmount *big.Int, excludeAddresses map[common.Address]bool, feeCharger fee.Charger) (onlineNodes []*route.State) {
-	/*
-
-	   XXX: consider using multiple channels for a single transfer. Useful
-	   for cases were the `amount` is larger than what is available
-	   individually in any of the channels.
-
-	   One possible approach is to _not_ filter these channels based on the
-	   distributable amount, but to sort them based on available balance and
-	   let the task use as many as required to finish the transfer.
-
-	*/
-	nws := cg.orderedNeighbours(ourAddress, targetAdress, amount, feeCharger)
-	if len(nws) == 0 {
-		log.Info(fmt.Sprintf("no routes avaiable from %s to %s", utils.APex(ourAddress), utils.APex(targetAdress)))
+	targetAdress common.Address, amount *big.Int, targetAmount *big.Int, excludeAddresses map[common.Address]bool, feeCharger fee.Charger, scorer RouteScorer) (onlineNodes []*route.State) {
+	exclude := make(map[common.Address]bool)
+	for addr, v := range excludeAddresses {
+		exclude[addr] = v
+	}
+	for _, n := range cg.getNeighbours() {
+		deviceType, isOnline := nodesStatus.GetNetworkStatus(n)
+		if !isOnline || (deviceType == xmpptransport.TypeMobile && n != targetAdress) {
+			log.Debug(fmt.Sprintf("partener %s network ignored.. isOnline:%v,deviceType:%s", utils.APex(n), isOnline, deviceType))
+			exclude[n] = true
+		}
+	}
+	paths, err := cg.getPaths(ourAddress, targetAdress, amount, MaxRoutes, exclude, feeCharger, scorer, true)
+	if err != nil {
+		log.Info(fmt.Sprintf("no routes avaiable from %s to %s, err %s", utils.APex(ourAddress), utils.APex(targetAdress), err))
 		return
 	}
-	//log.Trace(fmt.Sprintf("nws=%s", utils.StringInterface(nws, 5)))
-	for _, nw := range nws {
-		c := cg.GetPartenerAddress2Channel(nw.neighbor)
+	for _, p := range paths {
+		hop := p.Nodes[0]
+		c := cg.GetPartenerAddress2Channel(hop)
 		if c == nil {
 			log.Error(fmt.Sprintf("GetPartenerAddress2Channel returns nil ,but %s should have channel with %s on token %s",
-				utils.APex2(cg.OurAddress), utils.APex2(nw.neighbor), utils.APex2(cg.TokenAddress)))
+				utils.APex2(cg.OurAddress), utils.APex2(hop), utils.APex2(cg.TokenAddress)))
 			continue
 		}
-		//don't send the message backwards
-		if excludeAddresses[nw.neighbor] {
-			continue
-		}
-		if !c.CanTransfer() {
-			log.Debug(fmt.Sprintf("channel %s-%s cannot transfer ,ignoring ..", utils.APex(ourAddress), utils.APex(nw.neighbor)))
-			continue
-		}
-		if amount.Cmp(c.Distributable()) > 0 {
-			log.Debug(fmt.Sprintf("channel %s-%s doesn't have enough funds[%d],ignoring...", utils.APex(ourAddress), utils.APex(nw.neighbor), amount))
-			continue
-		}
-		deviceType, isOnline := nodesStatus.GetNetworkStatus(nw.neighbor)
-		if !isOnline || (deviceType == xmpptransport.TypeMobile && nw.neighbor != targetAdress) {
-			log.Debug(fmt.Sprintf("partener %s network ignored.. isOnline:%v,deviceType:%s", utils.APex(nw.neighbor), isOnline, deviceType))
-			continue
-		}
-		routeState := Channel2RouteState(c, nw.neighbor, targetAmount, feeCharger, []common.Address{})
-		if routeState.Fee.Cmp(utils.BigInt0) > 0 {
-			routeState.TotalFee = big.NewInt(int64(nw.weight))
-		} else { //no fee policy,
-			routeState.TotalFee = utils.BigInt0
-		}
-
+		routeState := Channel2RouteState(c, hop, targetAmount, feeCharger, p.Nodes)
+		routeState.TotalFee = p.Fee
 		onlineNodes = append(onlineNodes, routeState)
 	}
 	return
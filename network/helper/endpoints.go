@@ -0,0 +1,487 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/network/netshare"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+//健康分的调整
+const (
+	scoreMax     = 10
+	scoreMin     = -100
+	scoreSuccess = 1
+	scoreFailure = -5
+	scoreLagging = -3
+	scoreFault   = -20
+)
+
+/*
+rpcEndpoint 配置的一个公链节点,
+健康分越高越优先使用,请求成功加分,出错,落后,和其他节点返回的结果不一致都会扣分
+*/
+type rpcEndpoint struct {
+	url         string
+	client      *ethclient.Client
+	score       int
+	blockNumber int64 //最近一次查询到的最新块
+	lagging     bool  //最新块落后其他节点超过 params.EthRPCMaxLagBlocks
+	faults      int   //quorum 查询中和多数节点结果不一致的次数
+	lastErr     error
+}
+
+func (e *rpcEndpoint) addScore(delta int) {
+	e.score += delta
+	if e.score > scoreMax {
+		e.score = scoreMax
+	}
+	if e.score < scoreMin {
+		e.score = scoreMin
+	}
+}
+
+func (e *rpcEndpoint) healthy() bool {
+	return e.client != nil && e.lastErr == nil && !e.lagging
+}
+
+//EndpointStatus 公链节点的健康状况
+type EndpointStatus struct {
+	URL         string `json:"url"`
+	Current     bool   `json:"current"`
+	Connected   bool   `json:"connected"`
+	Score       int    `json:"score"`
+	BlockNumber int64  `json:"block_number"`
+	Lagging     bool   `json:"lagging"`
+	Faults      int    `json:"faults"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+//parseEndpoints eth-rpc-endpoint 可以配置多个节点,用逗号分隔
+func parseEndpoints(rawurl string) (endpoints []*rpcEndpoint) {
+	for _, u := range strings.Split(rawurl, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			endpoints = append(endpoints, &rpcEndpoint{url: u})
+		}
+	}
+	if len(endpoints) == 0 {
+		endpoints = append(endpoints, &rpcEndpoint{url: rawurl})
+	}
+	return
+}
+
+/*
+SetQuorum HeaderByNumber 和 FilterLogs 至少需要 quorum 个节点返回相同的结果才采用,
+不一致的节点会被扣分,0 和 1 表示不校验,只使用当前节点
+*/
+func (c *SafeEthClient) SetQuorum(quorum int) error {
+	if quorum > len(c.endpoints) {
+		return fmt.Errorf("eth rpc quorum %d is larger than the number of endpoints %d", quorum, len(c.endpoints))
+	}
+	c.quorum = quorum
+	return nil
+}
+
+//QuorumEnabled 关键查询是否需要多个节点交叉校验
+func (c *SafeEthClient) QuorumEnabled() bool {
+	return c.quorum > 1
+}
+
+//Endpoints 所有配置的公链节点的健康状况
+func (c *SafeEthClient) Endpoints() (status []*EndpointStatus) {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	for _, e := range c.endpoints {
+		s := &EndpointStatus{
+			URL:         e.url,
+			Current:     e == c.current,
+			Connected:   e.client != nil,
+			Score:       e.score,
+			BlockNumber: e.blockNumber,
+			Lagging:     e.lagging,
+			Faults:      e.faults,
+		}
+		if e.lastErr != nil {
+			s.LastError = e.lastErr.Error()
+		}
+		status = append(status, s)
+	}
+	return
+}
+
+func (c *SafeEthClient) markResult(e *rpcEndpoint, err error) {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	e.lastErr = err
+	if err != nil {
+		e.addScore(scoreFailure)
+	} else {
+		e.addScore(scoreSuccess)
+	}
+}
+
+func (c *SafeEthClient) markFault(e *rpcEndpoint, what string) {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	e.faults++
+	e.addScore(scoreFault)
+	log.Warn(fmt.Sprintf("eth rpc endpoint %s returned a different result for %s from the majority, faults=%d", e.url, what, e.faults))
+}
+
+//markLagging 最新块落后 best 太多的节点不再使用
+func (c *SafeEthClient) markLagging(best int64) {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	for _, e := range c.endpoints {
+		if e.client == nil || e.lastErr != nil {
+			continue
+		}
+		lagging := e.blockNumber+params.EthRPCMaxLagBlocks < best
+		if lagging && !e.lagging {
+			log.Warn(fmt.Sprintf("eth rpc endpoint %s lags behind, block number %d, best %d", e.url, e.blockNumber, best))
+		}
+		e.lagging = lagging
+		if lagging {
+			e.addScore(scoreLagging)
+		}
+	}
+}
+
+func (c *SafeEthClient) dialEndpoint(e *rpcEndpoint) (client *ethclient.Client, err error) {
+	c.elock.Lock()
+	client = e.client
+	c.elock.Unlock()
+	if client != nil {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	client, err = ethclient.DialContext(ctx, e.url)
+	cancelFunc()
+	if err != nil {
+		c.markResult(e, err)
+		return
+	}
+	c.elock.Lock()
+	e.client = client
+	c.elock.Unlock()
+	return
+}
+
+func (c *SafeEthClient) closeEndpoint(e *rpcEndpoint) {
+	c.elock.Lock()
+	client := e.client
+	e.client = nil
+	c.elock.Unlock()
+	if client != nil {
+		client.Close()
+	}
+}
+
+//rankedEndpoints 按照健康分从高到低排序,落后的排在最后,分数相同的按照配置的顺序
+func (c *SafeEthClient) rankedEndpoints() []*rpcEndpoint {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	es := make([]*rpcEndpoint, len(c.endpoints))
+	copy(es, c.endpoints)
+	sort.SliceStable(es, func(i, j int) bool {
+		if es[i].lagging != es[j].lagging {
+			return !es[i].lagging
+		}
+		return es[i].score > es[j].score
+	})
+	return es
+}
+
+func (c *SafeEthClient) getCurrent() *rpcEndpoint {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	return c.current
+}
+
+//use 切换到节点 e,之后的请求都发给它
+func (c *SafeEthClient) use(e *rpcEndpoint, client *ethclient.Client) {
+	c.lock.Lock()
+	c.Client = client
+	c.lock.Unlock()
+	c.elock.Lock()
+	old := c.current
+	c.current = e
+	c.elock.Unlock()
+	if old != nil && old != e {
+		log.Warn(fmt.Sprintf("eth rpc endpoint switched from %s to %s", old.url, e.url))
+		//关闭旧连接,在旧节点上的订阅会出错,订阅者重新订阅就会使用新节点
+		c.closeEndpoint(old)
+	}
+}
+
+//connectBest 按照健康分依次尝试,使用第一个可用的节点
+func (c *SafeEthClient) connectBest() (err error) {
+	err = errNotConnectd
+	for _, e := range c.rankedEndpoints() {
+		var client *ethclient.Client
+		client, err = c.dialEndpoint(e)
+		if err != nil {
+			continue
+		}
+		err = checkConnectStatus(client)
+		c.markResult(e, err)
+		if err != nil {
+			c.closeEndpoint(e)
+			continue
+		}
+		c.use(e, client)
+		return nil
+	}
+	return
+}
+
+/*
+healthLoop 配置了多个公链节点时,定期查询每个节点的最新块,
+正在使用的节点出错或者落后时切换到健康分最高的节点.
+所有节点都不可用时由 RecoverDisconnect 处理
+*/
+func (c *SafeEthClient) healthLoop() {
+	ticker := time.NewTicker(params.EthRPCHealthCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkHealth()
+		case <-c.quitChan:
+			return
+		}
+	}
+}
+
+func (c *SafeEthClient) checkHealth() {
+	var best int64
+	for _, e := range c.endpoints {
+		client, err := c.dialEndpoint(e)
+		if err != nil {
+			continue
+		}
+		ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+		h, err := client.HeaderByNumber(ctx, nil)
+		cancelFunc()
+		c.markResult(e, err)
+		if err != nil {
+			if e != c.getCurrent() {
+				c.closeEndpoint(e)
+			}
+			continue
+		}
+		c.elock.Lock()
+		e.blockNumber = h.Number.Int64()
+		c.elock.Unlock()
+		if h.Number.Int64() > best {
+			best = h.Number.Int64()
+		}
+	}
+	c.markLagging(best)
+	if c.Status != netshare.Connected {
+		return
+	}
+	cur := c.getCurrent()
+	c.elock.Lock()
+	ok := cur == nil || cur.healthy()
+	c.elock.Unlock()
+	if ok {
+		return
+	}
+	for _, e := range c.rankedEndpoints() {
+		c.elock.Lock()
+		client, healthy := e.client, e.healthy()
+		c.elock.Unlock()
+		if e != cur && healthy {
+			c.use(e, client)
+			return
+		}
+	}
+}
+
+type endpointResult struct {
+	endpoint *rpcEndpoint
+	key      common.Hash //用来比较结果是否相同
+	result   interface{}
+	err      error
+}
+
+//callAll 同时向所有已经连接的节点发起请求
+func (c *SafeEthClient) callAll(call func(client *ethclient.Client) (result interface{}, key common.Hash, err error)) (results []*endpointResult) {
+	wg := sync.WaitGroup{}
+	c.elock.Lock()
+	for _, e := range c.endpoints {
+		if e.client == nil {
+			continue
+		}
+		r := &endpointResult{endpoint: e}
+		results = append(results, r)
+		wg.Add(1)
+		go func(client *ethclient.Client) {
+			defer wg.Done()
+			r.result, r.key, r.err = call(client)
+		}(e.client)
+	}
+	c.elock.Unlock()
+	wg.Wait()
+	for _, r := range results {
+		c.markResult(r.endpoint, r.err)
+	}
+	return
+}
+
+/*
+quorumResult 票数最多并且至少有 quorum 个节点返回的结果才采用,
+和采用的结果不一致的节点记录一次错误,可能是节点作恶,也可能是落后
+*/
+func (c *SafeEthClient) quorumResult(what string, results []*endpointResult) (result interface{}, err error) {
+	votes := make(map[common.Hash]int)
+	for _, r := range results {
+		if r.err == nil {
+			votes[r.key]++
+		}
+	}
+	var key common.Hash
+	max, tie := 0, false
+	for k, n := range votes {
+		if n > max {
+			key, max, tie = k, n, false
+		} else if n == max {
+			tie = true
+		}
+	}
+	if max < c.quorum || tie {
+		return nil, rerr.ErrSpectrumQuorum.Append(fmt.Sprintf("%s: %d endpoints answered with %d different results, quorum=%d",
+			what, len(results), len(votes), c.quorum))
+	}
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		if r.key == key {
+			result = r.result
+			continue
+		}
+		c.markFault(r.endpoint, what)
+	}
+	return
+}
+
+/*
+quorumHead 各节点的最新块本来就可能不同,取至少 quorum 个节点都已经有的最高块,
+同时记录每个节点的最新块,并标记落后太多的节点
+*/
+func (c *SafeEthClient) quorumHead(ctx context.Context) (int64, error) {
+	results := c.callAll(func(client *ethclient.Client) (interface{}, common.Hash, error) {
+		h, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return nil, utils.EmptyHash, err
+		}
+		return h.Number.Int64(), utils.EmptyHash, nil
+	})
+	var numbers []int64
+	for _, r := range results {
+		if r.err != nil {
+			continue
+		}
+		c.elock.Lock()
+		r.endpoint.blockNumber = r.result.(int64)
+		c.elock.Unlock()
+		numbers = append(numbers, r.result.(int64))
+	}
+	if len(numbers) < c.quorum {
+		return 0, rerr.ErrSpectrumQuorum.Append(fmt.Sprintf("latest header: only %d endpoints answered, quorum=%d", len(numbers), c.quorum))
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] > numbers[j]
+	})
+	c.markLagging(numbers[0])
+	return numbers[c.quorum-1], nil
+}
+
+/*
+quorumHeaderByNumber 查询最新块时,先取 quorumHead,再比较这个块的 hash
+*/
+func (c *SafeEthClient) quorumHeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		head, err := c.quorumHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		number = big.NewInt(head)
+	}
+	results := c.callAll(func(client *ethclient.Client) (interface{}, common.Hash, error) {
+		h, err := client.HeaderByNumber(ctx, number)
+		if err != nil {
+			return nil, utils.EmptyHash, err
+		}
+		return h, h.Hash(), nil
+	})
+	r, err := c.quorumResult(fmt.Sprintf("header %s", number), results)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*types.Header), nil
+}
+
+/*
+quorumFilterLogs ToBlock 不超过 quorumHead,还没有同步到 ToBlock 的节点返回的日志本来就少,
+不参与比较,否则会被当成作恶
+*/
+func (c *SafeEthClient) quorumFilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	head, err := c.quorumHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if q.ToBlock == nil || q.ToBlock.Int64() > head {
+		q.ToBlock = big.NewInt(head)
+	}
+	if q.FromBlock != nil && q.FromBlock.Cmp(q.ToBlock) > 0 {
+		return nil, rerr.ErrSpectrumQuorum.Append(fmt.Sprintf("logs from %s: quorum head is %d", q.FromBlock, head))
+	}
+	results := c.callAll(func(client *ethclient.Client) (interface{}, common.Hash, error) {
+		logs, err := client.FilterLogs(ctx, q)
+		if err != nil {
+			return nil, utils.EmptyHash, err
+		}
+		return logs, logsKey(logs), nil
+	})
+	r, err := c.quorumResult(fmt.Sprintf("logs %s-%s", q.FromBlock, q.ToBlock), c.syncedResults(results, q.ToBlock.Int64()))
+	if err != nil {
+		return nil, err
+	}
+	return r.([]types.Log), nil
+}
+
+//syncedResults 只保留最新块不低于 number 的节点的结果
+func (c *SafeEthClient) syncedResults(results []*endpointResult, number int64) (synced []*endpointResult) {
+	c.elock.Lock()
+	defer c.elock.Unlock()
+	for _, r := range results {
+		if r.endpoint.blockNumber >= number {
+			synced = append(synced, r)
+		}
+	}
+	return
+}
+
+func logsKey(logs []types.Log) common.Hash {
+	var data [][]byte
+	for _, l := range logs {
+		data = append(data, l.BlockHash[:], l.TxHash[:], big.NewInt(int64(l.Index)).Bytes())
+	}
+	return utils.Sha3(data...)
+}
@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	es := parseEndpoints("ws://127.0.0.1:5555, http://127.0.0.1:8545,")
+	assert.EqualValues(t, 2, len(es))
+	assert.EqualValues(t, "http://127.0.0.1:8545", es[1].url)
+	es = parseEndpoints("/root/geth.ipc")
+	assert.EqualValues(t, 1, len(es))
+}
+
+func TestQuorumResult(t *testing.T) {
+	c := &SafeEthClient{endpoints: parseEndpoints("a,b,c")}
+	assert.NotNil(t, c.SetQuorum(4))
+	assert.Nil(t, c.SetQuorum(2))
+	good, bad := utils.NewRandomHash(), utils.NewRandomHash()
+	results := []*endpointResult{
+		{endpoint: c.endpoints[0], key: good, result: 1},
+		{endpoint: c.endpoints[1], key: bad, result: 2},
+		{endpoint: c.endpoints[2], key: good, result: 1},
+	}
+	r, err := c.quorumResult("test", results)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, r)
+	assert.EqualValues(t, 1, c.endpoints[1].faults)
+	assert.EqualValues(t, scoreFault, c.endpoints[1].score)
+	//说谎的节点排在最后
+	assert.EqualValues(t, c.endpoints[1], c.rankedEndpoints()[2])
+
+	results[2].err = errors.New("timeout")
+	_, err = c.quorumResult("test", results)
+	assert.NotNil(t, err)
+}
+
+func TestMarkLagging(t *testing.T) {
+	c := &SafeEthClient{endpoints: parseEndpoints("a,b")}
+	for i, e := range c.endpoints {
+		e.client = &ethclient.Client{}
+		e.blockNumber = int64(100 - i*10)
+	}
+	c.markLagging(100)
+	assert.False(t, c.endpoints[0].lagging)
+	assert.True(t, c.endpoints[1].lagging)
+	assert.True(t, c.endpoints[0].healthy())
+	assert.False(t, c.endpoints[1].healthy())
+	assert.EqualValues(t, c.endpoints[0], c.rankedEndpoints()[0])
+}
+
+func TestSyncedResults(t *testing.T) {
+	c := &SafeEthClient{endpoints: parseEndpoints("a,b,c")}
+	assert.Nil(t, c.SetQuorum(2))
+	logs, fewer := utils.NewRandomHash(), utils.NewRandomHash()
+	results := []*endpointResult{
+		{endpoint: c.endpoints[0], key: logs},
+		{endpoint: c.endpoints[1], key: fewer},
+		{endpoint: c.endpoints[2], key: logs},
+	}
+	c.endpoints[0].blockNumber = 100
+	c.endpoints[1].blockNumber = 98
+	c.endpoints[2].blockNumber = 101
+	assert.EqualValues(t, 3, len(c.syncedResults(results, 98)))
+	//b 还没有同步到 100,返回的日志少,不应该被当成作恶
+	synced := c.syncedResults(results, 100)
+	assert.EqualValues(t, []*endpointResult{results[0], results[2]}, synced)
+	_, err := c.quorumResult("logs", synced)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, c.endpoints[1].faults)
+}
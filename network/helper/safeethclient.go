@@ -32,32 +32,41 @@ type SafeEthClient struct {
 	Status     netshare.Status
 	StatusChan chan netshare.Status
 	quitChan   chan struct{}
+	endpoints  []*rpcEndpoint //配置的所有公链节点,Client 是其中正在使用的一个
+	current    *rpcEndpoint
+	elock      sync.Mutex //保护 endpoints 的状态和 current
+	quorum     int
 }
 
-//NewSafeClient create safeclient
+/*
+NewSafeClient create safeclient
+rawurl 可以是逗号分隔的多个节点,按照健康分使用其中一个,出错或者落后时自动切换
+*/
 func NewSafeClient(rawurl string) (*SafeEthClient, error) {
 	c := &SafeEthClient{
 		ReConnect:  make(map[string]chan struct{}),
 		url:        rawurl,
 		StatusChan: make(chan netshare.Status, 10),
 		quitChan:   make(chan struct{}),
+		endpoints:  parseEndpoints(rawurl),
 	}
-	var err error
-	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
-	c.Client, err = ethclient.DialContext(ctx, rawurl)
-	cancelFunc()
-	if err == nil && checkConnectStatus(c.Client) == nil {
+	if c.connectBest() == nil {
 		c.changeStatus(netshare.Connected)
 	} else {
 		go c.RecoverDisconnect()
 	}
+	if len(c.endpoints) > 1 {
+		go c.healthLoop()
+	}
 	return c, nil
 }
 
 //Close connection when destroy photon service
 func (c *SafeEthClient) Close() {
 	if c.Client != nil {
-		c.Client.Close()
+		for _, e := range c.endpoints {
+			c.closeEndpoint(e)
+		}
 		c.changeStatus(netshare.Closed)
 	}
 	close(c.quitChan)
@@ -91,12 +100,13 @@ func (c *SafeEthClient) changeStatus(newStatus netshare.Status) {
 	}
 }
 
-//RecoverDisconnect try to reconnect with geth after a restart of geth
+//RecoverDisconnect try to reconnect with geth after a restart of geth,配置了多个节点时换一个可用的
 func (c *SafeEthClient) RecoverDisconnect() {
 	var err error
-	var client *ethclient.Client
 	c.changeStatus(netshare.Reconnecting)
-	if c.Client != nil {
+	if cur := c.getCurrent(); cur != nil {
+		c.closeEndpoint(cur)
+	} else if c.Client != nil {
 		c.Client.Close()
 	}
 	for {
@@ -107,15 +117,9 @@ func (c *SafeEthClient) RecoverDisconnect() {
 		default:
 			//never block
 		}
-		ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
-		client, err = ethclient.DialContext(ctx, c.url)
-		cancelFunc()
-		if err == nil {
-			err = checkConnectStatus(client)
-		}
+		err = c.connectBest()
 		if err == nil {
 			//reconnect ok
-			c.Client = client
 			c.changeStatus(netshare.Connected)
 			c.lock.Lock()
 			var keys []string
@@ -166,6 +170,9 @@ func (c *SafeEthClient) HeaderByHash(ctx context.Context, hash common.Hash) (*ty
 // HeaderByNumber returns a block header from the current canonical chain. If number is
 // nil, the latest known header is returned.
 func (c *SafeEthClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if c.QuorumEnabled() {
+		return c.quorumHeaderByNumber(ctx, number)
+	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if c.Client == nil {
@@ -296,6 +303,9 @@ func (c *SafeEthClient) NonceAt(ctx context.Context, account common.Address, blo
 
 //FilterLogs wrapper of FilterLogs
 func (c *SafeEthClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if c.QuorumEnabled() {
+		return c.quorumFilterLogs(ctx, q)
+	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if c.Client == nil {
@@ -144,6 +144,8 @@ type PhotonProtocol struct {
 	receiveChan chan []byte
 	log         log.Logger
 	isReceiving bool
+	//回复过 AckTrampolineVersion 的节点,可以给它发送带 trampoline 洋葱的 MediatedTransfer
+	trampolinePeers map[common.Address]bool
 }
 
 // NewPhotonProtocol create PhotonProtocol
@@ -457,6 +459,25 @@ func (p *PhotonProtocol) CreateAck(echohash common.Hash) *encoding.Ack {
 	return encoding.NewAck(p.nodeAddr, echohash)
 }
 
+/*
+SetTrampolinePeer 记录 addr 能够处理 trampoline 洋葱,Ack 没有签名,被冒充的后果只是交易被退回
+*/
+func (p *PhotonProtocol) SetTrampolinePeer(addr common.Address) {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	if p.trampolinePeers == nil {
+		p.trampolinePeers = make(map[common.Address]bool)
+	}
+	p.trampolinePeers[addr] = true
+}
+
+//SupportsTrampoline returns true if addr has ever replied an ack of AckTrampolineVersion
+func (p *PhotonProtocol) SupportsTrampoline(addr common.Address) bool {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	return p.trampolinePeers[addr]
+}
+
 // GetNetworkStatus return `addr` node's network status
 func (p *PhotonProtocol) GetNetworkStatus(addr common.Address) (deviceType string, isOnline bool) {
 	return p.Transport.NodeStatus(addr)
@@ -521,6 +542,9 @@ func (p *PhotonProtocol) receiveInternal(data []byte) {
 	if messager.Cmd() == encoding.AckCmdID { //some one may be waiting p ack
 		ackMsg := messager.(*encoding.Ack)
 		p.log.Debug(fmt.Sprintf("receive ack ,EchoHash=%s", utils.HPex(ackMsg.Echo)))
+		if ackMsg.Version >= encoding.AckTrampolineVersion {
+			p.SetTrampolinePeer(ackMsg.Sender)
+		}
 		p.mapLock.Lock()
 		msgState, ok := p.SentHashesToChannel[ackMsg.Echo]
 		if ok && msgState.Success == false {
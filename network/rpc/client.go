@@ -71,6 +71,11 @@ type BlockChainService struct {
 	TXInfoDao         models.TXInfoDao
 	pendingTXInfoChan chan *models.TXInfo
 	quitChan          chan error
+	//GasOracle gives gas price of tx sent by us
+	GasOracle  *GasPriceOracle
+	nonceLock  sync.Mutex
+	nextNonce  uint64
+	nonceValid bool
 }
 
 //NewBlockChainService create BlockChainService
@@ -86,9 +91,11 @@ func NewBlockChainService(privateKey *ecdsa.PrivateKey, registryAddress common.A
 		TXInfoDao:           txInfoDao,
 		pendingTXInfoChan:   make(chan *models.TXInfo, 10), // TODO 这里缓冲区多大合适???
 		quitChan:            make(chan error),
+		GasOracle:           NewGasPriceOracle(client),
 	}
 	// remove gas limit config and let it calculate automatically
 	//bcs.Auth.GasLimit = uint64(params.GasLimit)
+	// gas price and nonce of every tx are given by SendTx
 	bcs.Auth.GasPrice = big.NewInt(params.DefaultGasPrice)
 
 	_, err = bcs.Registry(registryAddress, client.Status == netshare.Connected)
@@ -158,8 +165,8 @@ func (bcs *BlockChainService) Registry(address common.Address, hasConnectChain b
 			registry:         s,
 			RegisteredSecret: make(map[common.Hash]*sync.Mutex),
 		}
-		// 1. 启动pendingTXInfoListenLoop
-		go bcs.pendingTXInfoListenLoop()
+		// 1. 启动txManagerLoop
+		go bcs.txManagerLoop()
 		// 2. 获取所有pending状态的tx,并注册到监听中
 		var pendingTXs []*models.TXInfo
 		pendingTXs, err = bcs.TXInfoDao.GetTXInfoList(utils.EmptyHash, 0, utils.EmptyAddress, "", models.TXInfoStatusPending)
@@ -197,50 +204,22 @@ func (bcs *BlockChainService) SyncProgress() (sp *ethereum.SyncProgress, err err
 	return bcs.Client.SyncProgress(context.Background())
 }
 
-// RegisterPendingTXInfo 记录Pending状态的tx,并在独立线程中轮询该tx的receipt,并更新结果到db
+// RegisterPendingTXInfo 记录Pending状态的tx,由txManagerLoop轮询该tx的receipt,卡住的时候提高gas price替换,并更新结果到db
 func (bcs *BlockChainService) RegisterPendingTXInfo(txInfo *models.TXInfo) {
 	bcs.pendingTXInfoChan <- txInfo
 }
 
-/*
-pending状态的tx执行结果监控线程,常驻线程,启动时启动
-*/
-func (bcs *BlockChainService) pendingTXInfoListenLoop() {
-	log.Info("goroutine of pendingTXInfoListenLoop start")
-	for {
-		select {
-		case err := <-bcs.quitChan:
-			if err != nil {
-				log.Error("pendingTXInfoListenLoop quit because err = %s", err.Error())
-			}
-			return
-		case txInfo := <-bcs.pendingTXInfoChan:
-			// 针对每个进来的tx,启动一个线程来监控其执行状态
-			go bcs.checkPendingTXDone(txInfo)
-		}
-	}
-}
-
-func (bcs *BlockChainService) checkPendingTXDone(pendingTXInfo *models.TXInfo) {
-	defer rpanic.PanicRecover("checkPendingTXDone")
-	if pendingTXInfo.Status != models.TXInfoStatusPending {
-		log.Warn("checkPendingTXDone got tx with status=%s, maybe something wrong", pendingTXInfo.Status)
-		return
-	}
-	// 1. 等待tx执行完成
-	receipt, err := waitMined(context.Background(), bcs.Client, pendingTXInfo.TXHash)
-	if err != nil {
-		err = rerr.ErrTxWaitMined.AppendError(err)
-		log.Error(err.Error())
-		return
-	}
-	// 2. 获取packBlockNumber
+//onTXMined tx 已经被打包,记录结果并通知上层
+func (bcs *BlockChainService) onTXMined(pendingTXInfo *models.TXInfo, receipt *types.Receipt) {
+	defer rpanic.PanicRecover("onTXMined")
+	var err error
+	// 1. 获取packBlockNumber
 	var packBlockNumber int64
 	if len(receipt.Logs) > 0 {
 		packBlockNumber = int64(receipt.Logs[0].BlockNumber)
 	}
 	var savedTxInfo *models.TXInfo
-	// 3. 处理
+	// 2. 处理
 	if receipt.Status != types.ReceiptStatusSuccessful {
 		// 失败处理
 		// a.记录状态到数据库
@@ -273,49 +252,44 @@ func (bcs *BlockChainService) checkPendingTXDone(pendingTXInfo *models.TXInfo) {
 			break
 		}
 		// 发起deposit操作
-		proxy, err := bcs.TokenNetwork(depositParams.TokenAddress)
+		_, err = bcs.depositAfterApprove(&depositParams)
 		if err != nil {
 			log.Error(err.Error())
-			break
 		}
-		//log.Info(fmt.Sprintf("RegistryProxy proxy=%s", utils.StringInterface(proxy, 5)))
-		tx, err := proxy.GetContract().Deposit(bcs.Auth, depositParams.TokenAddress, depositParams.ParticipantAddress, depositParams.PartnerAddress, depositParams.Amount, depositParams.SettleTimeout)
+	case models.TXInfoTypeApproveBatchDeposit: //一次approve了所有通道的金额,连续发起每个通道的deposit
+		var batchParams models.BatchDepositTXParams
+		err = json.Unmarshal([]byte(pendingTXInfo.TXParams), &batchParams)
 		if err != nil {
 			log.Error(err.Error())
 			break
 		}
-		// 保存TXInfo并注册到bcs中监控其执行结果
-		channelID := utils.CalcChannelID(depositParams.TokenAddress, bcs.RegistryProxy.Address, depositParams.ParticipantAddress, depositParams.PartnerAddress)
-		txInfo, err := bcs.TXInfoDao.NewPendingTXInfo(tx, models.TXInfoTypeDeposit, channelID, 0, &depositParams)
-		if err != nil {
-			log.Error(err.Error())
-			break
+		for _, depositParams := range batchParams.Deposits {
+			_, err = bcs.depositAfterApprove(depositParams)
+			if err != nil {
+				log.Error(fmt.Sprintf("batch deposit partner=%s err %s", utils.APex2(depositParams.PartnerAddress), err))
+			}
 		}
-		bcs.RegisterPendingTXInfo(txInfo)
 	}
 }
 
-// 修改bind.WaitMined()而来,只改了参数格式,不影响功能
-func waitMined(ctx context.Context, b bind.DeployBackend, txHash common.Hash) (*types.Receipt, error) {
-	queryTicker := time.NewTicker(time.Second)
-	defer queryTicker.Stop()
-
-	logger := log.New("hash", txHash)
-	for {
-		receipt, err := b.TransactionReceipt(ctx, txHash)
-		if receipt != nil {
-			return receipt, nil
-		}
-		if err != nil {
-			//logger.Trace("Receipt retrieval failed", "err", err)
-		} else {
-			logger.Trace("Transaction not yet mined")
-		}
-		// Wait for the next round.
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-queryTicker.C:
-		}
+//depositAfterApprove approve 已经打包成功,发起 deposit,不等待打包
+func (bcs *BlockChainService) depositAfterApprove(depositParams *models.DepositTXParams) (txInfo *models.TXInfo, err error) {
+	proxy, err := bcs.TokenNetwork(depositParams.TokenAddress)
+	if err != nil {
+		return
+	}
+	tx, err := bcs.SendTx(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return proxy.GetContract().Deposit(opts, depositParams.TokenAddress, depositParams.ParticipantAddress, depositParams.PartnerAddress, depositParams.Amount, depositParams.SettleTimeout)
+	})
+	if err != nil {
+		return
 	}
+	// 保存TXInfo并注册到bcs中监控其执行结果
+	channelID := utils.CalcChannelID(depositParams.TokenAddress, bcs.RegistryProxy.Address, depositParams.ParticipantAddress, depositParams.PartnerAddress)
+	txInfo, err = bcs.TXInfoDao.NewPendingTXInfo(tx, models.TXInfoTypeDeposit, channelID, 0, depositParams)
+	if err != nil {
+		return
+	}
+	bcs.RegisterPendingTXInfo(txInfo)
+	return
 }
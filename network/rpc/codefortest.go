@@ -48,11 +48,21 @@ func (dao *FakeTXINfoDao) UpdateTXInfoStatus(txHash common.Hash, status models.T
 	return
 }
 
+// UpdateTXInfoHash :
+func (dao *FakeTXINfoDao) UpdateTXInfoHash(oldTXHash, newTXHash common.Hash, gasPrice uint64, rawTX []byte) (txInfo *models.TXInfo, err error) {
+	return
+}
+
 // GetTXInfoList :
 func (dao *FakeTXINfoDao) GetTXInfoList(channelIdentifier common.Hash, openBlockNumber int64, tokenAddress common.Address, txType models.TXInfoType, status models.TXInfoStatus) (list []*models.TXInfo, err error) {
 	return
 }
 
+// GetTXInfoListByCallTime :
+func (dao *FakeTXINfoDao) GetTXInfoListByCallTime(from, to int64) (list []*models.TXInfo, err error) {
+	return
+}
+
 func init() {
 	if encoding.IsTest {
 		keybin, err := hex.DecodeString(os.Getenv("KEY1"))
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//errEstimateOnly 估计 gas 时在签名阶段中止,tx 不会被发送
+var errEstimateOnly = errors.New("estimate only")
+
+/*
+EstimateTX 估计 send 中的合约调用需要的 gas,send 和 SendTx 中的一样使用合约绑定.
+合约绑定在签名之前调用 EstimateGas,我们在签名时拿到 gas 并中止,tx 不会被发送.
+gasPrice 是这种类型的 tx 现在发送时会使用的 gas price
+*/
+func (bcs *BlockChainService) EstimateTX(txType models.TXInfoType, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (gas uint64, gasPrice *big.Int, err error) {
+	if bcs.GasOracle.sampleCount() == 0 {
+		bcs.GasOracle.Sample()
+	}
+	gasPrice = bcs.GasOracle.GasPrice(deadlineTXTypes[txType])
+	opts := *bcs.Auth
+	//设置了 nonce 绑定就不会去公链节点获取,也不会占用本地的 nonce
+	opts.Nonce = big.NewInt(0)
+	opts.GasPrice = gasPrice
+	opts.GasLimit = 0
+	opts.Signer = func(signer types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		gas = tx.Gas()
+		return nil, errEstimateOnly
+	}
+	_, err = send(&opts)
+	if err == errEstimateOnly {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/network/helper"
+	"github.com/MetaLife-Protocol/SuperNode/network/netshare"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+)
+
+const (
+	gasPriceSampleSize       = 100
+	normalGasPricePercentile = 60
+	urgentGasPricePercentile = 90
+)
+
+/*
+GasPriceOracle 记录最近 SuggestGasPrice 的结果,按照百分位给出 gas price,
+普通的 tx 使用中间偏上的价格,快到截止块的 tx 使用最高的那部分价格.
+没有任何采样的时候使用 params.DefaultGasPrice, 任何时候都不超过 params.MaxGasPrice
+*/
+type GasPriceOracle struct {
+	client  *helper.SafeEthClient
+	lock    sync.Mutex
+	samples []*big.Int
+	next    int
+}
+
+//NewGasPriceOracle create GasPriceOracle
+func NewGasPriceOracle(client *helper.SafeEthClient) *GasPriceOracle {
+	return &GasPriceOracle{
+		client: client,
+	}
+}
+
+//Sample 从公链节点获取一次建议的 gas price
+func (o *GasPriceOracle) Sample() {
+	if o.client == nil || o.client.Status != netshare.Connected {
+		return
+	}
+	price, err := o.client.SuggestGasPrice(GetQueryConext())
+	if err != nil {
+		log.Warn(fmt.Sprintf("SuggestGasPrice err %s", err))
+		return
+	}
+	o.addSample(price)
+}
+
+func (o *GasPriceOracle) addSample(price *big.Int) {
+	if price == nil || price.Sign() <= 0 {
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(o.samples) < gasPriceSampleSize {
+		o.samples = append(o.samples, price)
+		return
+	}
+	o.samples[o.next] = price
+	o.next = (o.next + 1) % gasPriceSampleSize
+}
+
+func (o *GasPriceOracle) sampleCount() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.samples)
+}
+
+//Percentile 最近采样中第 percentile 百分位的 gas price
+func (o *GasPriceOracle) Percentile(percentile int) *big.Int {
+	o.lock.Lock()
+	sorted := make([]*big.Int, len(o.samples))
+	copy(sorted, o.samples)
+	o.lock.Unlock()
+	if len(sorted) == 0 {
+		return big.NewInt(params.DefaultGasPrice)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Cmp(sorted[j]) < 0
+	})
+	if percentile < 0 {
+		percentile = 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	price := sorted[(len(sorted)-1)*percentile/100]
+	if price.Cmp(params.MaxGasPrice) > 0 {
+		price = params.MaxGasPrice
+	}
+	return new(big.Int).Set(price)
+}
+
+//GasPrice 发送 tx 时使用的 gas price
+func (o *GasPriceOracle) GasPrice(urgent bool) *big.Int {
+	if urgent {
+		return o.Percentile(urgentGasPricePercentile)
+	}
+	return o.Percentile(normalGasPricePercentile)
+}
+
+/*
+replacementGasPrice 替换卡住的 tx 时使用的 gas price,
+至少要在原来的基础上提高 GasPriceBumpPercent,如果 oracle 给出的价格更高就用 oracle 的,
+返回值不大于 old 说明已经到上限了,不能再替换
+*/
+func replacementGasPrice(old, suggested *big.Int, urgent bool) *big.Int {
+	bump := int64(params.GasPriceBumpPercent)
+	if urgent {
+		bump = params.UrgentGasPriceBumpPercent
+	}
+	price := new(big.Int).Mul(old, big.NewInt(bump))
+	price.Div(price, big.NewInt(100))
+	if suggested != nil && suggested.Cmp(price) > 0 {
+		price.Set(suggested)
+	}
+	if price.Cmp(params.MaxGasPrice) > 0 {
+		price.Set(params.MaxGasPrice)
+	}
+	return price
+}
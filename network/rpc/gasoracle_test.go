@@ -0,0 +1,32 @@
+package rpc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasPriceOraclePercentile(t *testing.T) {
+	o := NewGasPriceOracle(nil)
+	assert.EqualValues(t, big.NewInt(params.DefaultGasPrice), o.GasPrice(false))
+	for i := 1; i <= gasPriceSampleSize+10; i++ {
+		o.addSample(big.NewInt(int64(i)))
+	}
+	assert.EqualValues(t, gasPriceSampleSize, o.sampleCount())
+	//最早的 10 个采样已经被覆盖了
+	assert.EqualValues(t, big.NewInt(11), o.Percentile(0))
+	assert.EqualValues(t, big.NewInt(110), o.Percentile(100))
+	assert.True(t, o.GasPrice(true).Cmp(o.GasPrice(false)) > 0)
+	o.addSample(new(big.Int).Mul(params.MaxGasPrice, big.NewInt(2)))
+	assert.EqualValues(t, params.MaxGasPrice, o.Percentile(100))
+}
+
+func TestReplacementGasPrice(t *testing.T) {
+	old := big.NewInt(100)
+	assert.EqualValues(t, big.NewInt(125), replacementGasPrice(old, big.NewInt(50), false))
+	assert.EqualValues(t, big.NewInt(150), replacementGasPrice(old, big.NewInt(50), true))
+	assert.EqualValues(t, big.NewInt(300), replacementGasPrice(old, big.NewInt(300), false))
+	assert.EqualValues(t, params.MaxGasPrice, replacementGasPrice(params.MaxGasPrice, nil, true))
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
 	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
 //SecretRegistryProxy proxy of secret registry
@@ -42,7 +44,9 @@ func (s *SecretRegistryProxy) RegisterSecret(secret common.Hash) (err error) {
 		err = rerr.ErrSecretAlreadyRegistered.Errorf("secret %s,secret hash=%s  already registered", secret.String(), utils.ShaSecret(secret[:]).String())
 		return
 	}
-	tx, err := s.registry.RegisterSecret(s.bcs.Auth, secret)
+	tx, err := s.bcs.SendTx(models.TXInfoTypeRegisterSecret, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return s.registry.RegisterSecret(opts, secret)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
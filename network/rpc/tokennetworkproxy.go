@@ -17,6 +17,7 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
 //RegistryProxy 只是为了表达方便,兼容以前代码,todo 完全去掉registry信息
@@ -94,7 +95,9 @@ func (t *TokenNetworkProxy) newChannelAndDepositByApprove(token *TokenProxy, par
 	log.Info(fmt.Sprintf("newChannelAndDepositByApprove participant=%s,partner=%s,settletimeout=%d,amount=%s,token=%s",
 		utils.APex2(participantAddress), utils.APex2(partnerAddress), settleTimeout, amount, utils.APex2(t.token),
 	))
-	tx, err := token.Token.Approve(t.bcs.Auth, t.Address, amount)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeApproveDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return token.Token.Approve(opts, t.Address, amount)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -183,10 +186,11 @@ func (t *TokenNetworkProxy) newChannelAndDepositOnSMTToken(tokenAddress common.A
 		return rerr.ContractCallError(err)
 	}
 	data := makeNewChannelAndDepositData(participantAddress, partnerAddress, settleTimeout)
-	// 在Auth中设置金额,不用t.bcs.Auth,避免影响其他交易
-	auth := bind.NewKeyedTransactor(t.bcs.PrivKey)
-	auth.Value = amount
-	tx, err := smtTokenProxy.BuyAndTransfer(auth, data)
+	// 金额只设置在这一次调用的 opts 中,不影响其他交易
+	tx, err := t.bcs.SendTx(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		opts.Value = amount
+		return smtTokenProxy.BuyAndTransfer(opts, data)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -248,6 +252,108 @@ func (t *TokenNetworkProxy) NewChannelAndDepositAsync(participantAddress, partne
 	return t.newChannelAndDepositByApprove(token, participantAddress, partnerAddress, settleTimeout, amount)
 }
 
+/*
+NewChannelsAndDepositAsync 批量创建通道并存款,和 NewChannelAndDepositAsync 一样只等交易进入缓冲池,
+SendTx 在本地分配 nonce,所以这些交易可以连续发送,不用等前一个打包.
+存款方式由 TokenProxy.DepositMode 估计 gas 确定,不用真实的交易试探:
+1. SMTToken 以及支持 fallback 或者 approveAndCall 的 token,每个通道一个 tx
+2. 其他 token 一次 approve 所有通道的金额,approve 打包成功以后在 onTXMined 中连续发起每个通道的 deposit
+txHashes 和 errs 与 deposits 一一对应,第二种情况下 txHash 是共同的 approve tx,
+每个通道 deposit 的结果都可以按 channel identifier 在 TXInfo 中查询
+*/
+func (t *TokenNetworkProxy) NewChannelsAndDepositAsync(deposits []*models.DepositTXParams) (txHashes []common.Hash, errs []error) {
+	txHashes = make([]common.Hash, len(deposits))
+	errs = make([]error, len(deposits))
+	failAll := func(err error) {
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	token, err := t.bcs.Token(t.token)
+	if err != nil {
+		failAll(rerr.ContractCallError(err))
+		return
+	}
+	name, err := token.Token.Name(nil)
+	if err != nil {
+		failAll(rerr.ContractCallError(err))
+		return
+	}
+	var send func(opts *bind.TransactOpts, d *models.DepositTXParams, data []byte) (*types.Transaction, error)
+	if name == params.SMTTokenName {
+		smtTokenProxy, err := smttoken.NewSMTToken(t.token, t.bcs.Client)
+		if err != nil {
+			failAll(rerr.ContractCallError(err))
+			return
+		}
+		send = func(opts *bind.TransactOpts, d *models.DepositTXParams, data []byte) (*types.Transaction, error) {
+			opts.Value = d.Amount
+			return smtTokenProxy.BuyAndTransfer(opts, data)
+		}
+	} else {
+		switch token.DepositMode(t.Address, deposits) {
+		case DepositModeFallback:
+			send = func(opts *bind.TransactOpts, d *models.DepositTXParams, data []byte) (*types.Transaction, error) {
+				return token.Token.Transfer(opts, t.Address, d.Amount, data)
+			}
+		case DepositModeApproveAndCall:
+			send = func(opts *bind.TransactOpts, d *models.DepositTXParams, data []byte) (*types.Transaction, error) {
+				return token.Token.ApproveAndCall(opts, t.Address, d.Amount, data)
+			}
+		default:
+			txHash, err := t.approveBatchDeposit(token, deposits)
+			for i := range deposits {
+				txHashes[i], errs[i] = txHash, err
+			}
+			return
+		}
+	}
+	for i, d := range deposits {
+		data := makeNewChannelAndDepositData(d.ParticipantAddress, d.PartnerAddress, int(d.SettleTimeout))
+		tx, err := t.bcs.SendTx(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			return send(opts, d, data)
+		})
+		if err != nil {
+			errs[i] = rerr.ContractCallError(err)
+			continue
+		}
+		channelID := utils.CalcChannelID(d.TokenAddress, t.bcs.RegistryProxy.Address, d.ParticipantAddress, d.PartnerAddress)
+		txInfo, err := t.bcs.TXInfoDao.NewPendingTXInfo(tx, models.TXInfoTypeDeposit, channelID, 0, d)
+		if err != nil {
+			errs[i] = rerr.ContractCallError(err)
+			continue
+		}
+		t.bcs.RegisterPendingTXInfo(txInfo)
+		txHashes[i] = tx.Hash()
+	}
+	return
+}
+
+//approveBatchDeposit 一次 approve 所有通道的金额,deposit 的参数保存在 approve 的 TXInfo 中
+func (t *TokenNetworkProxy) approveBatchDeposit(token *TokenProxy, deposits []*models.DepositTXParams) (txHash common.Hash, err error) {
+	total := big.NewInt(0)
+	for _, d := range deposits {
+		total.Add(total, d.Amount)
+	}
+	log.Info(fmt.Sprintf("approveBatchDeposit channels=%d,amount=%s,token=%s", len(deposits), total, utils.APex2(t.token)))
+	tx, err := t.bcs.SendTx(models.TXInfoTypeApproveBatchDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return token.Token.Approve(opts, t.Address, total)
+	})
+	if err != nil {
+		return txHash, rerr.ContractCallError(err)
+	}
+	txParams := &models.BatchDepositTXParams{
+		TokenAddress: t.token,
+		Deposits:     deposits,
+	}
+	txInfo, err := t.bcs.TXInfoDao.NewPendingTXInfo(tx, models.TXInfoTypeApproveBatchDeposit, utils.EmptyHash, 0, txParams)
+	if err != nil {
+		return txHash, rerr.ContractCallError(err)
+	}
+	t.bcs.RegisterPendingTXInfo(txInfo)
+	return tx.Hash(), nil
+}
+
 /*GetChannelInfo Returns the channel specific data.
 @param participant1 Address of one of the channel participants.
 @param participant2 Address of the other channel participant.
@@ -266,6 +372,11 @@ func (t *TokenNetworkProxy) GetChannelParticipantInfo(participant, partner commo
 	return
 }
 
+//QueryUnlockedLocks returns whether the lock sent by participant has been unlocked on chain
+func (t *TokenNetworkProxy) QueryUnlockedLocks(participant, partner common.Address, lockHash common.Hash) (unlocked bool, err error) {
+	return t.ch.QueryUnlockedLocks(t.bcs.getQueryOpts(), t.token, participant, partner, lockHash)
+}
+
 //GetContract return contract
 func (t *TokenNetworkProxy) GetContract() *contracts.TokensNetwork {
 	return t.ch
@@ -273,7 +384,9 @@ func (t *TokenNetworkProxy) GetContract() *contracts.TokensNetwork {
 
 //CloseChannel close channel
 func (t *TokenNetworkProxy) CloseChannel(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
-	tx, err := t.GetContract().PrepareSettle(t.bcs.Auth, t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeClose, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().PrepareSettle(opts, t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -308,7 +421,9 @@ func (t *TokenNetworkProxy) CloseChannel(partnerAddr common.Address, transferAmo
 
 //CloseChannelAsync close channel async 认为只要交易进入了缓冲池中,肯定会成功.
 func (t *TokenNetworkProxy) CloseChannelAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
-	tx, err := t.GetContract().PrepareSettle(t.bcs.Auth, t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeClose, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().PrepareSettle(opts, t.token, partnerAddr, transferAmount, locksRoot, uint64(nonce), extraHash, signature)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -347,7 +462,9 @@ func (t *TokenNetworkProxy) CloseChannelAsync(partnerAddr common.Address, transf
 
 //UpdateBalanceProof update balance proof of partner
 func (t *TokenNetworkProxy) UpdateBalanceProof(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
-	tx, err := t.GetContract().UpdateBalanceProof(t.bcs.Auth, t.token, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeUpdateBalanceProof, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().UpdateBalanceProof(opts, t.token, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -394,9 +511,38 @@ func (t *TokenNetworkProxy) UpdateBalanceProofAsync(partnerAddr common.Address,
 	return
 }
 
+//UpdateBalanceProofDelegate update balance proof of partner on behalf of participant, used by watchtower, openBlockNumber is recorded in TXInfo to track the result
+func (t *TokenNetworkProxy) UpdateBalanceProofDelegate(participantAddr, partnerAddr common.Address, openBlockNumber int64, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, partnerSignature, participantSignature []byte) (err error) {
+	tx, err := t.bcs.SendTx(models.TXInfoTypeUpdateBalanceProof, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().UpdateBalanceProofDelegate(opts, t.token, partnerAddr, participantAddr, transferAmount, locksRoot, nonce, extraHash, partnerSignature, participantSignature)
+	})
+	if err != nil {
+		return rerr.ContractCallError(err)
+	}
+	// 保存TXInfo并注册到bcs中监控其执行结果
+	channelID := utils.CalcChannelID(t.token, t.Address, participantAddr, partnerAddr)
+	txInfo, err := t.bcs.TXInfoDao.NewPendingTXInfo(tx, models.TXInfoTypeUpdateBalanceProof, channelID, openBlockNumber, &models.ChannelCloseOrChannelUpdateBalanceProofTXParams{
+		TokenAddress:       t.token,
+		ParticipantAddress: participantAddr,
+		PartnerAddress:     partnerAddr,
+		TransferAmount:     transferAmount,
+		LocksRoot:          locksRoot,
+		Nonce:              nonce,
+		ExtraHash:          extraHash,
+		Signature:          partnerSignature,
+	})
+	if err != nil {
+		return rerr.ContractCallError(err)
+	}
+	t.bcs.RegisterPendingTXInfo(txInfo)
+	return nil
+}
+
 //Unlock a partner's lock
 func (t *TokenNetworkProxy) Unlock(partnerAddr common.Address, transferAmount *big.Int, lock *mtree.Lock, proof []byte) (err error) {
-	tx, err := t.GetContract().Unlock(t.bcs.Auth, t.token, partnerAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeUnlock, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().Unlock(opts, t.token, partnerAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -439,9 +585,38 @@ func (t *TokenNetworkProxy) UnlockAsync(partnerAddr common.Address, transferAmou
 	return
 }
 
+//UnlockDelegate unlock a lock of partner on behalf of participant, used by watchtower, openBlockNumber is recorded in TXInfo to track the result
+func (t *TokenNetworkProxy) UnlockDelegate(participantAddr, partnerAddr common.Address, openBlockNumber int64, transferAmount *big.Int, lock *mtree.Lock, proof []byte, participantSignature []byte) (err error) {
+	tx, err := t.bcs.SendTx(models.TXInfoTypeUnlock, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().UnlockDelegate(opts, t.token, partnerAddr, participantAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof, participantSignature)
+	})
+	if err != nil {
+		return rerr.ContractCallError(err)
+	}
+	// 保存TXInfo并注册到bcs中监控其执行结果
+	channelID := utils.CalcChannelID(t.token, t.Address, participantAddr, partnerAddr)
+	txInfo, err := t.bcs.TXInfoDao.NewPendingTXInfo(tx, models.TXInfoTypeUnlock, channelID, openBlockNumber, &models.UnlockTXParams{
+		TokenAddress:       t.token,
+		ParticipantAddress: participantAddr,
+		PartnerAddress:     partnerAddr,
+		TransferAmount:     transferAmount,
+		Expiration:         big.NewInt(lock.Expiration),
+		Amount:             lock.Amount,
+		LockSecretHash:     lock.LockSecretHash,
+		Proof:              proof,
+	})
+	if err != nil {
+		return rerr.ContractCallError(err)
+	}
+	t.bcs.RegisterPendingTXInfo(txInfo)
+	return nil
+}
+
 //SettleChannel settle a channel
 func (t *TokenNetworkProxy) SettleChannel(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (err error) {
-	tx, err := t.GetContract().Settle(t.bcs.Auth, t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeSettle, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().Settle(opts, t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -475,7 +650,9 @@ func (t *TokenNetworkProxy) SettleChannel(p1Addr, p2Addr common.Address, p1Amoun
 
 //SettleChannelAsync settle a channel async 进入缓冲池就认为成功了
 func (t *TokenNetworkProxy) SettleChannelAsync(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (err error) {
-	tx, err := t.GetContract().Settle(t.bcs.Auth, t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeSettle, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().Settle(opts, t.token, p1Addr, p1Amount, p1Locksroot, p2Addr, p2Amount, p2Locksroot)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -513,9 +690,11 @@ func (t *TokenNetworkProxy) SettleChannelAsync(p1Addr, p2Addr common.Address, p1
 //Withdraw  to  a channel
 func (t *TokenNetworkProxy) Withdraw(p1Addr, p2Addr common.Address, p1Balance,
 	p1Withdraw *big.Int, p1Signature, p2Signature []byte) (err error) {
-	tx, err := t.GetContract().WithDraw(t.bcs.Auth, t.token, p1Addr, p2Addr, p1Balance, p1Withdraw,
-		p1Signature, p2Signature,
-	)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeWithdraw, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().WithDraw(opts, t.token, p1Addr, p2Addr, p1Balance, p1Withdraw,
+			p1Signature, p2Signature,
+		)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -560,7 +739,9 @@ func (t *TokenNetworkProxy) WithdrawAsync(p1Addr, p2Addr common.Address, p1Balan
 
 //PunishObsoleteUnlock  to  a channel
 func (t *TokenNetworkProxy) PunishObsoleteUnlock(beneficiary, cheater common.Address, lockhash, extraHash common.Hash, cheaterSignature []byte) (err error) {
-	tx, err := t.GetContract().PunishObsoleteUnlock(t.bcs.Auth, t.token, beneficiary, cheater, lockhash, extraHash, cheaterSignature)
+	tx, err := t.bcs.SendTx(models.TXInfoTypePunish, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().PunishObsoleteUnlock(opts, t.token, beneficiary, cheater, lockhash, extraHash, cheaterSignature)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -603,7 +784,9 @@ func (t *TokenNetworkProxy) PunishObsoleteUnlockAsync(beneficiary, cheater commo
 
 //CooperativeSettle  settle  a channel
 func (t *TokenNetworkProxy) CooperativeSettle(p1Addr, p2Addr common.Address, p1Balance, p2Balance *big.Int, p1Signature, p2Signatue []byte) (err error) {
-	tx, err := t.GetContract().CooperativeSettle(t.bcs.Auth, t.token, p1Addr, p1Balance, p2Addr, p2Balance, p1Signature, p2Signatue)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeCooperateSettle, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.GetContract().CooperativeSettle(opts, t.token, p1Addr, p1Balance, p2Addr, p2Balance, p1Signature, p2Signatue)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
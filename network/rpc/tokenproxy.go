@@ -17,13 +17,46 @@ import (
 )
 
 //TokenProxy proxy of ERC20 token
-//todo test if support ApproveAndCall ,ERC223 etc
 type TokenProxy struct {
 	Address common.Address
 	bcs     *BlockChainService
 	Token   *contracts.Token
 }
 
+//DepositMode token 支持的创建通道并存款的方式
+type DepositMode int
+
+/* #nosec */
+const (
+	DepositModeApprove        DepositMode = iota // 先 approve 再 deposit,需要两个 tx
+	DepositModeFallback                          // ERC223 transfer(address,uint256,bytes)
+	DepositModeApproveAndCall                    // approveAndCall(address,uint256,bytes)
+)
+
+/*
+DepositMode 不发送交易,估计 gas 检查 token 是否支持用 fallback 或者 approveAndCall 创建通道并存款,优先使用 fallback.
+deposits 依次检查直到有一个可以执行,这样某个通道自己的问题不会改变其他通道的存款方式,都不能执行时只能先 approve
+*/
+func (t *TokenProxy) DepositMode(spender common.Address, deposits []*models.DepositTXParams) DepositMode {
+	for _, d := range deposits {
+		data := makeNewChannelAndDepositData(d.ParticipantAddress, d.PartnerAddress, int(d.SettleTimeout))
+		_, _, err := t.bcs.EstimateTX(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			return t.Token.Transfer(opts, spender, d.Amount, data)
+		})
+		if err == nil {
+			return DepositModeFallback
+		}
+		_, _, err = t.bcs.EstimateTX(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			return t.Token.ApproveAndCall(opts, spender, d.Amount, data)
+		})
+		if err == nil {
+			return DepositModeApproveAndCall
+		}
+		log.Trace(fmt.Sprintf("token %s can not deposit to %s in one tx, err %s", utils.APex2(t.Address), utils.APex2(d.PartnerAddress), err))
+	}
+	return DepositModeApprove
+}
+
 // TotalSupply total amount of tokens
 func (t *TokenProxy) TotalSupply() (*big.Int, error) {
 	return t.Token.TotalSupply(t.bcs.getQueryOpts())
@@ -56,7 +89,9 @@ func (t *TokenProxy) Allowance(owner, spender common.Address) (int64, error) {
 // @param _value The amount of wei to be approved for transfer
 //注意此函数并不会等待打包成功才返回,只要交易进入缓冲池就返回
 func (t *TokenProxy) Approve(spender common.Address, value *big.Int) (err error) {
-	tx, err := t.Token.Approve(t.bcs.Auth, spender, value)
+	tx, err := t.bcs.SendTx("", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.Token.Approve(opts, spender, value)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -83,7 +118,9 @@ func (t *TokenProxy) Transfer(spender common.Address, value *big.Int) (err error
 	if err != nil {
 		return
 	}
-	tx, err := t.Token.TransferFrom(t.bcs.Auth, t.bcs.Auth.From, spender, value)
+	tx, err := t.bcs.SendTx("", func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.Token.TransferFrom(opts, t.bcs.Auth.From, spender, value)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -111,7 +148,9 @@ func (t *TokenProxy) TransferAsync(spender common.Address, value *big.Int) (resu
 
 //TransferWithFallback ERC223 TokenFallback,进入缓冲池以后就认为不可能会失败,不等待打包
 func (t *TokenProxy) TransferWithFallback(to common.Address, value *big.Int, extraData []byte, txParams *models.DepositTXParams) (err error) {
-	tx, err := t.Token.Transfer(t.bcs.Auth, to, value, extraData)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.Token.Transfer(opts, to, value, extraData)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
@@ -145,7 +184,9 @@ func (t *TokenProxy) TransferWithFallback(to common.Address, value *big.Int, ext
 
 //ApproveAndCall ERC20 extend,进入缓冲池以后就认为不可能会失败,不等待打包
 func (t *TokenProxy) ApproveAndCall(spender common.Address, value *big.Int, extraData []byte, txParams *models.DepositTXParams) (err error) {
-	tx, err := t.Token.ApproveAndCall(t.bcs.Auth, spender, value, extraData)
+	tx, err := t.bcs.SendTx(models.TXInfoTypeDeposit, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return t.Token.ApproveAndCall(opts, spender, value, extraData)
+	})
 	if err != nil {
 		return rerr.ContractCallError(err)
 	}
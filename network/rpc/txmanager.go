@@ -0,0 +1,291 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/internal/rpanic"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/netshare"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+/*
+自己发起的 tx 的管理:
+1. nonce 由本地分配,并发发送多个 tx 的时候不会从公链节点的 pending state 拿到相同的 nonce
+2. gas price 由 GasPriceOracle 给出,不再固定为 params.DefaultGasPrice
+3. 所有 pending 的 tx 在一个线程中轮询,长时间没有被打包的 tx 用相同的 nonce 提高 gas price 重新发送,
+有截止块的 tx(UpdateBalanceProof,Unlock,Punish,RegisterSecret)等待的时间更短,快到截止块时提价更多
+4. 重启以后从数据库中恢复 pending 的 tx,保存的已签名 tx 用于继续替换
+*/
+/*
+ *	Management of tx sent by ourselves :
+ *	1. nonces are assigned locally, so concurrent sends never get the same nonce from the pending state of the node.
+ *	2. gas price is given by GasPriceOracle instead of the fixed params.DefaultGasPrice.
+ *	3. all pending tx are polled in one goroutine, a tx not mined for a long time is resent with the same nonce and a higher gas price.
+ *	tx with a deadline (UpdateBalanceProof,Unlock,Punish,RegisterSecret) waits for a shorter time and bumps more when the deadline is near.
+ *	4. pending tx are recovered from db after restart, the saved signed tx is used to go on replacing.
+ */
+
+//deadlineTXTypes 这些 tx 如果在截止块之前没有被打包,会损失资金
+var deadlineTXTypes = map[models.TXInfoType]bool{
+	models.TXInfoTypeUpdateBalanceProof: true,
+	models.TXInfoTypeUnlock:             true,
+	models.TXInfoTypePunish:             true,
+	models.TXInfoTypeRegisterSecret:     true,
+}
+
+//nonce 被其他 tx 用掉以后,最多再等这么多轮看看被替换的 tx 有没有被打包
+const maxNonceUsedChecks = 20
+
+type pendingTX struct {
+	info           *models.TXInfo
+	sentTime       time.Time // 最近一次发送的时间
+	deadline       int64     // 截止块,0 表示没有或者未知
+	deadlineLoaded bool
+	nonceUsedCheck int
+}
+
+//txChannelParams 各种通道操作 tx 参数中都有的部分,用来找到对应的通道
+type txChannelParams struct {
+	TokenAddress       common.Address `json:"token_address"`
+	ParticipantAddress common.Address `json:"participant_address"`
+	PartnerAddress     common.Address `json:"partner_address"`
+	Beneficiary        common.Address `json:"beneficiary"`
+	Cheater            common.Address `json:"cheater"`
+}
+
+/*
+SendTx 自己发起的合约调用都通过这里发送, send 中使用传入的 opts 调用合约.
+nonce 分配和发送在同一个锁中进行,发送失败的话 nonce 没有被用掉,下次重新从公链节点获取
+*/
+func (bcs *BlockChainService) SendTx(txType models.TXInfoType, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (tx *types.Transaction, err error) {
+	bcs.nonceLock.Lock()
+	defer bcs.nonceLock.Unlock()
+	nonce, err := bcs.Client.PendingNonceAt(GetQueryConext(), bcs.NodeAddress)
+	if err != nil {
+		return
+	}
+	if bcs.nonceValid && bcs.nextNonce > nonce {
+		nonce = bcs.nextNonce
+	}
+	if bcs.GasOracle.sampleCount() == 0 {
+		bcs.GasOracle.Sample()
+	}
+	opts := *bcs.Auth
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+	opts.GasPrice = bcs.GasOracle.GasPrice(deadlineTXTypes[txType])
+	tx, err = send(&opts)
+	if err != nil {
+		bcs.nonceValid = false
+		return
+	}
+	bcs.nextNonce = nonce + 1
+	bcs.nonceValid = true
+	log.Trace(fmt.Sprintf("SendTx type=%s txhash=%s nonce=%d gasPrice=%s", txType, tx.Hash().String(), nonce, tx.GasPrice()))
+	return
+}
+
+/*
+txManagerLoop pending 状态的 tx 的监控线程,常驻线程,启动时启动
+*/
+func (bcs *BlockChainService) txManagerLoop() {
+	log.Info("goroutine of txManagerLoop start")
+	ticker := time.NewTicker(params.TXCheckInterval)
+	defer ticker.Stop()
+	var pendings []*pendingTX
+	for {
+		select {
+		case err := <-bcs.quitChan:
+			if err != nil {
+				log.Error(fmt.Sprintf("txManagerLoop quit because err = %s", err.Error()))
+			}
+			return
+		case txInfo := <-bcs.pendingTXInfoChan:
+			if txInfo == nil {
+				continue
+			}
+			if txInfo.Status != models.TXInfoStatusPending {
+				log.Warn(fmt.Sprintf("txManagerLoop got tx with status=%s, maybe something wrong", txInfo.Status))
+				continue
+			}
+			pendings = append(pendings, &pendingTX{
+				info:     txInfo,
+				sentTime: time.Unix(txInfo.CallTime, 0),
+			})
+		case <-ticker.C:
+			if bcs.Client.Status != netshare.Connected {
+				continue
+			}
+			bcs.GasOracle.Sample()
+			if len(pendings) == 0 {
+				continue
+			}
+			h, err := bcs.Client.HeaderByNumber(GetQueryConext(), nil)
+			if err != nil {
+				log.Warn(fmt.Sprintf("txManagerLoop HeaderByNumber err %s", err))
+				continue
+			}
+			blockNumber := h.Number.Int64()
+			var left []*pendingTX
+			for _, p := range pendings {
+				if !bcs.checkPendingTX(p, blockNumber) {
+					left = append(left, p)
+				}
+			}
+			pendings = left
+		}
+	}
+}
+
+/*
+checkPendingTX 返回 true 表示这个 tx 已经有结果了,不需要再监控
+*/
+func (bcs *BlockChainService) checkPendingTX(p *pendingTX, blockNumber int64) (done bool) {
+	defer rpanic.PanicRecover("checkPendingTX")
+	receipt, err := bcs.Client.TransactionReceipt(GetQueryConext(), p.info.TXHash)
+	if err == nil && receipt != nil {
+		go bcs.onTXMined(p.info, receipt)
+		return true
+	}
+	if len(p.info.RawTX) == 0 {
+		//升级之前发送的 tx,没有办法替换,只能等待
+		return false
+	}
+	minedNonce, err := bcs.Client.NonceAt(GetQueryConext(), bcs.NodeAddress, nil)
+	if err != nil {
+		return false
+	}
+	if minedNonce > p.info.Nonce {
+		//nonce 已经被用掉了,看看是不是被替换掉的 tx 被打包了
+		for _, h := range p.info.ReplacedTXHashes {
+			receipt, err = bcs.Client.TransactionReceipt(GetQueryConext(), h)
+			if err != nil || receipt == nil {
+				continue
+			}
+			gasPrice := p.info.GasPrice
+			if minedTX, _, err2 := bcs.Client.TransactionByHash(GetQueryConext(), h); err2 == nil {
+				gasPrice = minedTX.GasPrice().Uint64()
+			}
+			txInfo, err := bcs.TXInfoDao.UpdateTXInfoHash(p.info.TXHash, h, gasPrice, nil)
+			if err != nil {
+				log.Error(err.Error())
+				txInfo = p.info
+			}
+			go bcs.onTXMined(txInfo, receipt)
+			return true
+		}
+		p.nonceUsedCheck++
+		if p.nonceUsedCheck < maxNonceUsedChecks {
+			return false
+		}
+		log.Error(fmt.Sprintf("tx[txHash=%s,type=%s] nonce %d was used by another tx", p.info.TXHash.String(), p.info.Type, p.info.Nonce))
+		savedTxInfo, err := bcs.TXInfoDao.UpdateTXInfoStatus(p.info.TXHash, models.TXInfoStatusFailed, 0, 0)
+		if err != nil {
+			log.Error(err.Error())
+			savedTxInfo = p.info
+		}
+		bcs.NotifyHandler.NotifyContractCallTXInfo(savedTxInfo)
+		return true
+	}
+	urgent := false
+	timeout := params.TXStuckTimeout
+	if deadlineTXTypes[p.info.Type] {
+		timeout = params.CriticalTXStuckTimeout
+		deadline := bcs.txDeadline(p)
+		if deadline > 0 && deadline-blockNumber <= params.TXUrgentBlocks {
+			urgent = true
+			timeout = params.CriticalTXStuckTimeout / 2
+		}
+	}
+	if time.Since(p.sentTime) < timeout {
+		return false
+	}
+	bcs.replaceTX(p, urgent)
+	return false
+}
+
+/*
+txDeadline 有截止块的 tx 在通道关闭以后,必须在 settle 之前被打包.
+RegisterSecret 的截止块是锁的过期块,这里拿不到,只使用更短的超时时间
+*/
+func (bcs *BlockChainService) txDeadline(p *pendingTX) int64 {
+	if p.deadlineLoaded {
+		return p.deadline
+	}
+	p.deadlineLoaded = true
+	if p.info.Type == models.TXInfoTypeRegisterSecret {
+		return 0
+	}
+	var cp txChannelParams
+	err := json.Unmarshal([]byte(p.info.TXParams), &cp)
+	if err != nil {
+		return 0
+	}
+	participant, partner := cp.ParticipantAddress, cp.PartnerAddress
+	if p.info.Type == models.TXInfoTypePunish {
+		participant, partner = cp.Beneficiary, cp.Cheater
+	}
+	tn, err := bcs.TokenNetwork(cp.TokenAddress)
+	if err != nil {
+		return 0
+	}
+	_, settleBlockNumber, _, state, _, err := tn.GetChannelInfo(participant, partner)
+	if err != nil || state != contracts.ChannelStateClosed {
+		return 0
+	}
+	p.deadline = int64(settleBlockNumber)
+	return p.deadline
+}
+
+/*
+replaceTX 用相同的 nonce 和更高的 gas price 重新签名并发送
+*/
+func (bcs *BlockChainService) replaceTX(p *pendingTX, urgent bool) {
+	p.sentTime = time.Now()
+	var tx types.Transaction
+	err := rlp.DecodeBytes(p.info.RawTX, &tx)
+	if err != nil || tx.To() == nil {
+		log.Error(fmt.Sprintf("replaceTX %s decode raw tx err %v", p.info.TXHash.String(), err))
+		return
+	}
+	gasPrice := replacementGasPrice(tx.GasPrice(), bcs.GasOracle.GasPrice(urgent), urgent)
+	if gasPrice.Cmp(tx.GasPrice()) <= 0 {
+		log.Warn(fmt.Sprintf("tx[txHash=%s,type=%s] stuck, but gas price %s reaches max", p.info.TXHash.String(), p.info.Type, tx.GasPrice()))
+		return
+	}
+	newTX, err := bcs.Auth.Signer(types.NewEIP155Signer(tx.ChainId()), bcs.NodeAddress,
+		types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), gasPrice, tx.Data()))
+	if err != nil {
+		log.Error(fmt.Sprintf("replaceTX %s sign err %s", p.info.TXHash.String(), err))
+		return
+	}
+	err = bcs.Client.SendTransaction(GetCallContext(), newTX)
+	if err != nil {
+		//可能旧的 tx 已经被打包了,下一轮再检查
+		log.Warn(fmt.Sprintf("replaceTX %s err %s", p.info.TXHash.String(), err))
+		return
+	}
+	rawTX, err := rlp.EncodeToBytes(newTX)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	txInfo, err := bcs.TXInfoDao.UpdateTXInfoHash(p.info.TXHash, newTX.Hash(), gasPrice.Uint64(), rawTX)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	log.Info(fmt.Sprintf("tx[type=%s,nonce=%d] stuck, replaced %s by %s gasPrice %s->%s urgent=%v",
+		p.info.Type, p.info.Nonce, utils.HPex(p.info.TXHash), utils.HPex(newTX.Hash()), tx.GasPrice(), gasPrice, urgent))
+	p.info = txInfo
+}
@@ -2,6 +2,7 @@ package params
 
 import (
 	"crypto/ecdsa"
+	"math/big"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -68,13 +69,33 @@ type Config struct {
 	EnableHealthCheck         bool //send ping periodically?
 	XMPPServer                string
 	IsMeshNetwork             bool   //is mesh now?
-	PfsHost                   string // pathfinder server host
+	PfsHost                   string // pathfinder server host,多个用逗号分隔,按顺序使用
+	PfsServerListen           string // 不为空时在这个地址启动内置的 pfs 服务,例如 127.0.0.1:9001
+	AutoRebalanceLow          int    // 通道中我方余额占比低于这个百分比时自动再平衡,0表示不启用
+	AutoRebalanceHigh         int    // 通道中我方余额占比高于这个百分比时自动再平衡
+	AutoRebalanceFeePercent   int64  // 自动再平衡最多支付 amount/AutoRebalanceFeePercent 的手续费,0表示不支付手续费
+	EnableTrampoline          bool   // 为轻节点提供 trampoline 服务,替它们计算剩下的路由
+	TrampolineFeePercent      int64  // 作为 trampoline 时在通道手续费之外额外收取 amount/TrampolineFeePercent,0表示不额外收费
+	EnableWatchtower          bool   // 接受手机节点的委托,替它们看守通道
+	WatchtowerFee             int64  // 第一次委托一个通道需要预先支付的 token 数量,0表示免费
 	HTTPUsername              string
 	HTTPPassword              string
 	PubAddress                common.Address
 	RewardPeriod              int
 	PubApiHost                string
 
+	Watchtowers      []string // 自动把我的通道委托给这些 watchtower,格式是 address@url,例如 0x3bc7726c489e617571792ac0cd8b70df8a5d0e22@http://127.0.0.1:5001
+	WatchtowerPayFee int64    // 第一次委托一个通道时给每个 watchtower 支付的 token 数量,和 watchtower 的 WatchtowerFee 一致,0表示不付费
+
+	EthRPCQuorum int // eth-rpc-endpoint 配置了多个节点时,HeaderByNumber 和 FilterLogs 至少需要这么多节点返回相同的结果,0表示不校验
+
+	CheckpointFile   string         // 第一次启动时导入这个快照文件,从快照的块开始处理事件
+	CheckpointURL    string         // 第一次启动时从这个 supernode 获取快照,例如 http://127.0.0.1:5001
+	CheckpointSigner common.Address // 只接受这个地址签名的快照
+
+	AutoSettle          bool     // 通道关闭以后自动 unlock 和 settle
+	AutoUnlockMinAmount *big.Int // 金额低于这个值的锁不值得花费 gas 去 unlock,nil表示全部 unlock
+
 	TokensPerLike        int64
 	EffectiveLikesPerDay int
 
@@ -32,3 +32,18 @@ const NameChannelCooperativeSettled = "ChannelCooperativeSettled"
 
 //NameSecretRevealed name from contract
 const NameSecretRevealed = "SecretRevealed"
+
+//ContractEventNames all events photon listens to
+var ContractEventNames = []string{
+	NameTokenNetworkCreated,
+	NameChannelOpenedAndDeposit,
+	NameChannelNewDeposit,
+	NameChannelWithdraw,
+	NameChannelClosed,
+	NameChannelPunished,
+	NameChannelUnlocked,
+	NameBalanceProofUpdated,
+	NameChannelSettled,
+	NameChannelCooperativeSettled,
+	NameSecretRevealed,
+}
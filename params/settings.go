@@ -2,6 +2,8 @@ package params
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"math/big"
@@ -19,6 +21,42 @@ const DefaultGasLimit = 3141592 //den's gasLimit.
 //DefaultGasPrice from ethereum
 const DefaultGasPrice = params.Shannon * 20
 
+//MaxGasPrice gas price oracle 和替换卡住的 tx 时 gas price 的上限
+var MaxGasPrice = big.NewInt(params.Shannon * 500)
+
+//GasPriceBumpPercent 替换卡住的 tx 时 gas price 至少提高到原来的百分比,以太坊节点要求至少提高 10%
+const GasPriceBumpPercent = 125
+
+//UrgentGasPriceBumpPercent 距离截止块很近的 tx 替换时 gas price 提高到原来的百分比
+const UrgentGasPriceBumpPercent = 150
+
+//TXStuckTimeout 自己发起的 tx 这么久还没有被打包,就提高 gas price 重新发送
+var TXStuckTimeout = 3 * time.Minute
+
+//CriticalTXStuckTimeout UpdateBalanceProof,Unlock,Punish,RegisterSecret 这些有截止块的 tx 卡住的超时时间
+var CriticalTXStuckTimeout = time.Minute
+
+//TXUrgentBlocks 有截止块的 tx 距离截止块不足这么多块时,使用最高百分位的 gas price,替换得更快,提价更多
+const TXUrgentBlocks = 30
+
+//TXCheckInterval 轮询 pending tx 的间隔
+var TXCheckInterval = 3 * time.Second
+
+//WatchtowerPushRetryMin 推送给 watchtower 失败以后第一次重试的间隔,以后每次加倍
+var WatchtowerPushRetryMin = 5 * time.Second
+
+//WatchtowerPushRetryMax 推送给 watchtower 失败以后重试的最大间隔
+var WatchtowerPushRetryMax = 30 * time.Minute
+
+//WatchtowerMaxUnlocks 一个通道最多委托这么多个 unlock,每个 unlock 都可能需要 watchtower 付 gas
+var WatchtowerMaxUnlocks = 50
+
+//WatchtowerPayFeeTimeout 给 watchtower 付费的转账最多等待这么长时间
+var WatchtowerPayFeeTimeout = 3 * time.Minute
+
+//WatchtowerPushCheckInterval 检查所有通道是否需要推送给 watchtower 的间隔,防止漏掉通知
+var WatchtowerPushCheckInterval = 30 * time.Second
+
 //defaultProtocolRetiesBeforeBackoff
 const defaultProtocolRetiesBeforeBackoff = 5
 const defaultProtocolRhrottleCapacity = 10.
@@ -176,6 +214,12 @@ var DefaultEthRPCPollPeriodForTest = 500 * time.Millisecond
 // DefaultEthRPCPollPeriod :
 var DefaultEthRPCPollPeriod = 7500 * time.Millisecond
 
+// EventSubscribeRetryInterval : 订阅新块和合约事件失败以后,间隔多久再重新订阅,期间轮询
+var EventSubscribeRetryInterval = time.Minute
+
+// EventSubscribeIdlePeriod : 订阅期间超过这么久没有收到新块,主动查询一次,防止连接静默断开
+var EventSubscribeIdlePeriod = 30 * time.Second
+
 // TestPrivateChainID :
 var TestPrivateChainID int64 = 8888
 
@@ -185,18 +229,83 @@ var TestPrivateChainID2 int64 = 7888
 // EthRPCTimeout :
 var EthRPCTimeout = 3 * time.Second
 
+// EthRPCHealthCheckPeriod : 配置了多个公链节点时,多久检查一次每个节点的健康状况
+var EthRPCHealthCheckPeriod = 15 * time.Second
+
+// EthRPCMaxLagBlocks : 公链节点的最新块落后其他节点超过这么多块就认为它落后了,不再使用
+var EthRPCMaxLagBlocks int64 = 3
+
 // ContractVersionPrefix :
 var ContractVersionPrefix = "0.6"
 
-// EnableForkConfirm : 事件延迟确认开关
-var EnableForkConfirm = false
-
 // ForkConfirmNumber : 分叉确认块数量,BlockNumber < 最新块-ForkConfirmNumber的事件被认为无分叉的风险
 var ForkConfirmNumber int64 = 17
 
+// EventConfirmBlocks : 每种链上事件的确认块数,事件所在块之后至少有这么多块才会交给 photon 处理,没有设置的为0,收到就处理
+var EventConfirmBlocks = map[string]int64{}
+
+// ForkConfirmEvents : --enable-fork-confirm 时需要延迟确认的事件,open,deposit,withdraw 以及注册密码,
+// 否则在出现恶意分叉的情况下,中间节点有损失资金的风险
+var ForkConfirmEvents = []string{NameChannelOpenedAndDeposit, NameChannelNewDeposit, NameChannelWithdraw, NameSecretRevealed}
+
+// GetEventConfirmBlocks : eventName 事件需要的确认块数
+func GetEventConfirmBlocks(eventName string) int64 {
+	return EventConfirmBlocks[eventName]
+}
+
+// ParseEventConfirmBlocks : 解析 ChannelClosed=6,SecretRevealed=17 格式的确认块数
+func ParseEventConfirmBlocks(s string) error {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.Split(item, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("event confirm blocks %s format err", item)
+		}
+		name := strings.TrimSpace(kv[0])
+		known := false
+		for _, n := range ContractEventNames {
+			if n == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event %s", name)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("event confirm blocks %s format err", item)
+		}
+		EventConfirmBlocks[name] = n
+	}
+	return nil
+}
+
+// MaxEventConfirmBlocks : 所有事件中最大的确认块数
+func MaxEventConfirmBlocks() (max int64) {
+	for _, n := range EventConfirmBlocks {
+		if n > max {
+			max = n
+		}
+	}
+	return
+}
+
 // MaxTransferDataLen : 交易附件信息最大长度
 var MaxTransferDataLen = 256
 
+// MaxTrampolines : 一笔交易最多经过的 trampoline 节点数
+const MaxTrampolines = 3
+
+// TrampolineMaxHops : trampoline 节点自己计算的路由最多的跳数,发起方按这个跳数为每个 trampoline 预留锁的时间
+const TrampolineMaxHops = 5
+
+// MaxTrampolineOnionLen : trampoline 洋葱的最大长度,每层是 20 字节的下一跳地址加上 ECIES 的 113 字节开销
+const MaxTrampolineOnionLen = MaxTrampolines * (20 + 65 + 16 + 32)
+
 // SMTTokenName SMTToken名,固定
 const SMTTokenName = "SMTToken"
 
@@ -0,0 +1,96 @@
+package pfsproxy
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 连续失败这么多次以后断开
+	breakerFailureThreshold = 3
+	// 第一次断开的时间,之后每次半开尝试失败都加倍
+	breakerMinOpenDuration = 10 * time.Second
+	breakerMaxOpenDuration = 5 * time.Minute
+)
+
+// circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+/*
+circuitBreaker 记录一个 pfs host 的连接状态:
+closed 正常使用,连续失败 breakerFailureThreshold 次以后 open,
+open 期间不再向它发请求,到期以后 half-open,只允许一个请求尝试,成功则 closed,失败则再次 open 并加倍等待时间
+*/
+type circuitBreaker struct {
+	failures     int
+	openDuration time.Duration
+	openUntil    time.Time
+	lock         sync.Mutex
+}
+
+func (cb *circuitBreaker) isOpen() bool {
+	return cb.failures >= breakerFailureThreshold
+}
+
+//allow returns whether a request can be sent now, only one trial is allowed in half-open state
+func (cb *circuitBreaker) allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.isOpen() {
+		return true
+	}
+	now := time.Now()
+	if now.Before(cb.openUntil) {
+		return false
+	}
+	//block others until the trial finishes
+	cb.openUntil = now.Add(cb.openDuration)
+	return true
+}
+
+//onSuccess returns true if the breaker was open
+func (cb *circuitBreaker) onSuccess() (recovered bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	recovered = cb.isOpen()
+	cb.failures = 0
+	cb.openDuration = 0
+	return
+}
+
+//onFailure returns true if the breaker becomes open just now
+func (cb *circuitBreaker) onFailure() (opened bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	wasOpen := cb.isOpen()
+	cb.failures++
+	if !cb.isOpen() {
+		return false
+	}
+	if !wasOpen {
+		cb.openDuration = breakerMinOpenDuration
+	} else {
+		cb.openDuration *= 2
+		if cb.openDuration > breakerMaxOpenDuration {
+			cb.openDuration = breakerMaxOpenDuration
+		}
+	}
+	cb.openUntil = time.Now().Add(cb.openDuration)
+	return !wasOpen
+}
+
+func (cb *circuitBreaker) state() string {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.isOpen() {
+		return breakerClosed
+	}
+	if time.Now().Before(cb.openUntil) {
+		return breakerOpen
+	}
+	return breakerHalfOpen
+}
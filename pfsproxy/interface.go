@@ -54,4 +54,14 @@ type PfsProxy interface {
 		get fee rate by channel
 	*/
 	GetChannelFee(channelIdentifier common.Hash) (feeConstant *big.Int, feePercent int64, err error)
+
+	/*
+		market maker advertises its token swap rates
+	*/
+	SetSwapRates(rates []*models.SwapRate) (err error)
+
+	/*
+		get swap rates from fromToken to toToken advertised by all market makers
+	*/
+	GetSwapRates(fromToken, toToken common.Address) (rates []*models.SwapRate, err error)
 }
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"math/big"
@@ -78,7 +79,7 @@ type balanceProof struct {
 	Signature         []byte      `json:"signature"`
 }
 
-func (p *submitBalancePayload) sign(key *ecdsa.PrivateKey) []byte {
+func (p *submitBalancePayload) signData() []byte {
 	var err error
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.BigEndian, p.BalanceProof.Nonce)
@@ -93,7 +94,12 @@ func (p *submitBalancePayload) sign(key *ecdsa.PrivateKey) []byte {
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
-	p.BalanceSignature, err = utils.SignData(key, buf.Bytes())
+	return buf.Bytes()
+}
+
+func (p *submitBalancePayload) sign(key *ecdsa.PrivateKey) []byte {
+	var err error
+	p.BalanceSignature, err = utils.SignData(key, p.signData())
 	if err != nil {
 		log.Crit(fmt.Sprintf("signDataFor submitBalancePayload err %s", err))
 	}
@@ -182,10 +188,12 @@ func (p *findPathPayload) sign(key *ecdsa.PrivateKey) []byte {
 
 // FindPathResponse :
 type FindPathResponse struct {
-	PathID  int      `json:"path_id"`
-	PathHop int      `json:"path_hop"`
-	Fee     *big.Int `json:"fee"`
-	Result  []string `json:"result"`
+	PathID        int      `json:"path_id"`
+	PathHop       int      `json:"path_hop"`
+	Fee           *big.Int `json:"fee"`
+	Result        []string `json:"result"`
+	RevealTimeout int      `json:"reveal_timeout,omitempty"` // 路由提示中的节点要求的最大 reveal timeout
+	Trampolines   []string `json:"trampolines,omitempty"`    // Result 之后由这些 trampoline 节点计算剩下的路由,Result 的最后一个节点就是第一个 trampoline
 }
 
 // GetPath get path array
@@ -197,6 +205,15 @@ func (fpr *FindPathResponse) GetPath() []common.Address {
 	return p
 }
 
+// GetTrampolines get trampoline array
+func (fpr *FindPathResponse) GetTrampolines() []common.Address {
+	var p []common.Address
+	for _, s := range fpr.Trampolines {
+		p = append(p, common.HexToAddress(s))
+	}
+	return p
+}
+
 /*
 FindPath : find path
 */
@@ -242,18 +259,50 @@ func (pfg *pfsClient) FindPath(peerFrom, peerTo, token common.Address, amount *b
 	return
 }
 
+var (
+	nonceLock sync.Mutex
+	lastNonce uint64
+)
+
+/*
+nextNonce 提交给 pfs 的收费策略和兑换价格的 nonce,pfs 只接受比上次大的,防止旧的签名数据被重放.
+使用当前时间,重启以后也不会变小
+*/
+func nextNonce() uint64 {
+	nonceLock.Lock()
+	defer nonceLock.Unlock()
+	n := uint64(time.Now().UnixNano())
+	if n <= lastNonce {
+		n = lastNonce + 1
+	}
+	lastNonce = n
+	return n
+}
+
 // setFeePayload :
 type setFeePayload struct {
 	FeeConstant *big.Int `json:"fee_constant"`
 	FeePercent  int64    `json:"fee_percent"`
+	Nonce       uint64   `json:"nonce"`
 	Signature   []byte   `json:"signature"`
 }
 
+func (p *setFeePayload) toFeeSetting() *models.FeeSetting {
+	return &models.FeeSetting{
+		FeeConstant: p.FeeConstant,
+		FeePercent:  p.FeePercent,
+		Nonce:       p.Nonce,
+		Signature:   p.Signature,
+	}
+}
+
 func (p *setFeePayload) sign(key *ecdsa.PrivateKey) []byte {
 	var err error
+	p.Nonce = nextNonce()
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.BigEndian, p.FeePercent)
 	_, err = buf.Write(utils.BigIntTo32Bytes(p.FeeConstant))
+	err = binary.Write(buf, binary.BigEndian, p.Nonce)
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
@@ -278,7 +327,7 @@ func (pfg *pfsClient) SetFeePolicy(fp *models.FeePolicy) (err error) {
 	if pfg.host == "" || pfg.privateKey == nil {
 		return ErrNotInit
 	}
-	fp.Sign(pfg.privateKey)
+	fp.Sign(pfg.privateKey, nextNonce())
 	req := &req{
 		FullURL: pfg.host + "/pfs/1/feerate/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
 		Method:  http.MethodPut,
@@ -491,6 +540,113 @@ func (pfg *pfsClient) GetChannelFee(channelIdentifier common.Hash) (feeConstant
 	return resp.FeeConstant, resp.FeePercent, nil
 }
 
+/*
+SetSwapRates : advertise token swap rates of this node, all the old rates will be replaced
+*/
+func (pfg *pfsClient) SetSwapRates(rates []*models.SwapRate) (err error) {
+	if pfg.host == "" || pfg.privateKey == nil {
+		return ErrNotInit
+	}
+	for _, sr := range rates {
+		sr.Sign(pfg.privateKey)
+	}
+	payload := &setSwapRatesPayload{
+		Rates: rates,
+		Nonce: nextNonce(),
+	}
+	payload.sign(pfg.privateKey)
+	req := &req{
+		FullURL: pfg.host + "/pfs/1/swap_rate/" + crypto.PubkeyToAddress(pfg.privateKey.PublicKey).String(),
+		Method:  http.MethodPut,
+		Payload: marshal(payload),
+		Timeout: time.Second * 10,
+	}
+	statusCode, body, err := req.Invoke()
+	log.Debug(req.ToString())
+	if err != nil {
+		log.Error(fmt.Sprintf("PfgAPI SetSwapRates %s err :%s", req.FullURL, err))
+		return
+	}
+	if statusCode != 200 {
+		err = fmt.Errorf("PfgAPI SetSwapRates %s err : http status=%d body=%s", req.FullURL, statusCode, string(body))
+		log.Error(err.Error())
+		return
+	}
+	return nil
+}
+
+// setSwapRatesPayload : Signature covers the nonce and the signatures of all the rates, so an empty list is signed too
+type setSwapRatesPayload struct {
+	Rates     []*models.SwapRate `json:"rates"`
+	Nonce     uint64             `json:"nonce"`
+	Signature []byte             `json:"signature"`
+}
+
+func (p *setSwapRatesPayload) signData() []byte {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian, p.Nonce)
+	for _, sr := range p.Rates {
+		_, err = buf.Write(sr.Signature)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("signData err %s", err))
+	}
+	return buf.Bytes()
+}
+
+func (p *setSwapRatesPayload) sign(key *ecdsa.PrivateKey) []byte {
+	var err error
+	p.Signature, err = utils.SignData(key, p.signData())
+	if err != nil {
+		log.Crit(fmt.Sprintf("signDataFor setSwapRatesPayload err %s", err))
+	}
+	return p.Signature
+}
+
+func (p *setSwapRatesPayload) verifySignature(signer common.Address) bool {
+	addr, err := utils.Ecrecover(utils.Sha3(p.signData()), p.Signature)
+	return err == nil && addr == signer
+}
+
+/*
+GetSwapRates : get swap rates advertised by market makers, rates with invalid signature are dropped
+*/
+func (pfg *pfsClient) GetSwapRates(fromToken, toToken common.Address) (rates []*models.SwapRate, err error) {
+	if pfg.host == "" || pfg.privateKey == nil {
+		err = ErrNotInit
+		return
+	}
+	req := &req{
+		FullURL: pfg.host + "/pfs/1/swap_rate/" + fromToken.String() + "/" + toToken.String(),
+		Method:  http.MethodGet,
+		Timeout: time.Second * 10,
+	}
+	statusCode, body, err := req.Invoke()
+	log.Debug(req.ToString())
+	if err != nil {
+		log.Error(fmt.Sprintf("PfgAPI GetSwapRates %s err :%s", req.FullURL, err))
+		return
+	}
+	if statusCode != 200 {
+		err = fmt.Errorf("PfgAPI GetSwapRates %s err : http status=%d body=%s", req.FullURL, statusCode, string(body))
+		log.Error(err.Error())
+		return
+	}
+	var all []*models.SwapRate
+	err = json.Unmarshal(body, &all)
+	if err != nil {
+		return
+	}
+	for _, sr := range all {
+		if sr.FromToken != fromToken || sr.ToToken != toToken || !sr.VerifySignature() {
+			log.Warn(fmt.Sprintf("PfgAPI GetSwapRates ignore invalid rate %s", marshal(sr)))
+			continue
+		}
+		rates = append(rates, sr)
+	}
+	return
+}
+
 func marshal(v interface{}) string {
 	p, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,538 @@
+package pfsproxy
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// 查询路径结果的缓存时间
+	pathCacheTTL = 10 * time.Second
+	// 所有 pfs 都连不上的时候,使用不超过这个时间的缓存结果
+	pathCacheMaxStale = 2 * time.Minute
+	// 检查断开的 pfs 是否恢复以及重发积压的 balance proof 的间隔
+	healthCheckInterval = 10 * time.Second
+)
+
+type pfsHost struct {
+	host           string
+	client         *pfsClient
+	breaker        *circuitBreaker
+	feePolicyStale bool          //fee policy failed to submit to this host
+	swapRatesStale bool          //swap rates failed to submit to this host
+	balanceStale   bool          //there may be balance proofs queued in db for this host
+	pendingFees    []*pendingFee //account, token and channel fee updates failed to submit, in submit order
+	syncing        int32
+}
+
+//pendingFee is a fee update waiting for replay, only the latest one of each key is kept
+type pendingFee struct {
+	key string
+	f   func(c *pfsClient) error
+}
+
+type pathCacheEntry struct {
+	resp []FindPathResponse
+	time time.Time
+}
+
+/*
+ResilientPfsProxy 同时使用多个 pfs:
+1. 查询类的请求按顺序使用第一个可用的 pfs,连接失败的 pfs 由 circuitBreaker 暂时跳过,后台定期检查是否恢复
+2. balance proof,收费设置和兑换价格提交给所有的 pfs,
+	balance proof 提交失败的保存在数据库中,收费设置和兑换价格保存在内存中,等对应的 pfs 恢复以后按原来的顺序重新提交.
+	内存中记录每个 pfs 是否有积压的 balance proof,没有的时候健康检查不读数据库
+3. FindPath 的结果缓存 pathCacheTTL,所有 pfs 都连不上时使用不超过 pathCacheMaxStale 的缓存结果
+*/
+/*
+ *	ResilientPfsProxy : use multiple pfs hosts.
+ *	1. queries go to the first available host, hosts failed to connect are skipped by a circuit breaker for a while,
+ *		and a background loop checks if they come back.
+ *	2. balance proofs, fee settings and swap rates are submitted to all hosts, failed balance proofs are kept in db,
+ *		fee settings and swap rates are kept in memory, they are replayed in the original order when the host comes back.
+ *		whether a host has queued balance proofs is tracked in memory, so the health check doesn't read db when there are none.
+ *	3. FindPath results are cached for pathCacheTTL, when no host is reachable, cached results no older than
+ *		pathCacheMaxStale are used.
+ */
+type ResilientPfsProxy struct {
+	hosts     []*pfsHost
+	dao       models.PfsSubmissionQueueDao
+	cache     map[string]*pathCacheEntry
+	feePolicy *models.FeePolicy
+	swapRates []*models.SwapRate
+	lock      sync.Mutex
+	quitChan  chan struct{}
+	stopOnce  sync.Once
+}
+
+/*
+NewResilientPfsProxy hosts 为逗号分隔的多个 pfs 地址,dao 为 nil 时不保存提交失败的 balance proof
+*/
+func NewResilientPfsProxy(hosts string, privateKey *ecdsa.PrivateKey, dao models.PfsSubmissionQueueDao) *ResilientPfsProxy {
+	p := &ResilientPfsProxy{
+		dao:      dao,
+		cache:    make(map[string]*pathCacheEntry),
+		quitChan: make(chan struct{}),
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		p.hosts = append(p.hosts, &pfsHost{
+			host:         host,
+			client:       &pfsClient{host: host, privateKey: privateKey},
+			breaker:      &circuitBreaker{},
+			balanceStale: dao != nil, //may be left by last run
+		})
+	}
+	return p
+}
+
+// Start background health check
+func (p *ResilientPfsProxy) Start() {
+	go p.healthCheckLoop()
+}
+
+// Stop can be called more than once
+func (p *ResilientPfsProxy) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.quitChan)
+	})
+}
+
+//isConnectError only connection errors make a host fail over, other errors are answers from pfs
+func isConnectError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == ErrConnect {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	if e, ok := err.(rerr.StandardError); ok && e.ErrorCode == rerr.ErrPFS.ErrorCode {
+		return true
+	}
+	return false
+}
+
+func (p *ResilientPfsProxy) call(h *pfsHost, f func(c *pfsClient) error) error {
+	if !h.breaker.allow() {
+		return ErrConnect
+	}
+	err := f(h.client)
+	if isConnectError(err) {
+		if h.breaker.onFailure() {
+			log.Warn(fmt.Sprintf("pfs %s is unavailable, skip it for a while, err %s", h.host, err))
+		}
+		return err
+	}
+	if h.breaker.onSuccess() {
+		log.Info(fmt.Sprintf("pfs %s comes back", h.host))
+		go p.syncHost(h)
+	}
+	return err
+}
+
+//failover tries hosts in order until one answers
+func (p *ResilientPfsProxy) failover(f func(c *pfsClient) error) (err error) {
+	err = ErrConnect
+	for _, h := range p.hosts {
+		err = p.call(h, f)
+		if !isConnectError(err) {
+			return
+		}
+	}
+	return
+}
+
+//broadcast sends to all hosts, returns nil if any host accepts, failed are the hosts can't be connected
+func (p *ResilientPfsProxy) broadcast(f func(c *pfsClient) error) (accepted, failed []*pfsHost, err error) {
+	err = ErrConnect
+	for _, h := range p.hosts {
+		err2 := p.call(h, f)
+		if err2 == nil {
+			accepted = append(accepted, h)
+			continue
+		}
+		if isConnectError(err2) {
+			failed = append(failed, h)
+		} else {
+			err = err2
+		}
+	}
+	if len(accepted) > 0 {
+		err = nil
+	}
+	return
+}
+
+/*
+syncHost 重发 pfs 不可用期间积压的 balance proof,收费设置和兑换价格
+*/
+func (p *ResilientPfsProxy) syncHost(h *pfsHost) {
+	if !atomic.CompareAndSwapInt32(&h.syncing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&h.syncing, 0)
+	p.replayBalance(h)
+	p.lock.Lock()
+	fp, feePolicyStale := p.feePolicy, h.feePolicyStale
+	rates, swapRatesStale := p.swapRates, h.swapRatesStale
+	p.lock.Unlock()
+	if feePolicyStale && fp != nil {
+		err := p.call(h, func(c *pfsClient) error { return c.SetFeePolicy(fp) })
+		if isConnectError(err) {
+			return
+		}
+		p.lock.Lock()
+		h.feePolicyStale = false
+		p.lock.Unlock()
+	}
+	if !p.replayFees(h) {
+		return
+	}
+	if swapRatesStale {
+		err := p.call(h, func(c *pfsClient) error { return c.SetSwapRates(rates) })
+		if !isConnectError(err) {
+			p.lock.Lock()
+			h.swapRatesStale = false
+			p.lock.Unlock()
+		}
+	}
+}
+
+//replayFees 按原来的顺序重发提交失败的收费设置,返回 false 表示 pfs 又连不上了
+func (p *ResilientPfsProxy) replayFees(h *pfsHost) bool {
+	for {
+		p.lock.Lock()
+		if len(h.pendingFees) == 0 {
+			p.lock.Unlock()
+			return true
+		}
+		pf := h.pendingFees[0]
+		p.lock.Unlock()
+		err := p.call(h, pf.f)
+		if isConnectError(err) {
+			return false
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("pfs %s rejected replayed %s err %s", h.host, pf.key, err))
+		}
+		p.lock.Lock()
+		//the queue may be changed while calling
+		if len(h.pendingFees) > 0 && h.pendingFees[0] == pf {
+			h.pendingFees = h.pendingFees[1:]
+		}
+		p.lock.Unlock()
+	}
+}
+
+//queueFee 记录提交失败的收费设置,同一个 key 只保留最新的
+func (p *ResilientPfsProxy) queueFee(hosts []*pfsHost, key string, f func(c *pfsClient) error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, h := range hosts {
+		for i, pf := range h.pendingFees {
+			if pf.key == key {
+				h.pendingFees = append(h.pendingFees[:i:i], h.pendingFees[i+1:]...)
+				break
+			}
+		}
+		h.pendingFees = append(h.pendingFees, &pendingFee{key: key, f: f})
+	}
+}
+
+//setFee 提交给所有的 pfs,连不上的等它恢复以后再提交;已经有积压的 pfs 也要排队,保证重发时不会被旧的覆盖
+func (p *ResilientPfsProxy) setFee(key string, f func(c *pfsClient) error) (err error) {
+	accepted, failed, err := p.broadcast(f)
+	p.lock.Lock()
+	for _, h := range accepted {
+		if len(h.pendingFees) > 0 || h.feePolicyStale {
+			failed = append(failed, h)
+		}
+	}
+	p.lock.Unlock()
+	p.queueFee(failed, key, f)
+	return
+}
+
+func (p *ResilientPfsProxy) replayBalance(h *pfsHost) {
+	if p.dao == nil {
+		return
+	}
+	p.lock.Lock()
+	stale := h.balanceStale
+	h.balanceStale = false
+	p.lock.Unlock()
+	if !stale {
+		return
+	}
+	list, err := p.dao.GetPfsBalanceSubmissions(h.host)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetPfsBalanceSubmissions err %s", err))
+		p.markBalanceStale(h)
+		return
+	}
+	for i, s := range list {
+		err = p.call(h, func(c *pfsClient) error {
+			return c.SubmitBalance(s.Nonce, s.TransferAmount, s.LockAmount, s.OpenBlockNumber, s.Locksroot, s.ChannelIdentifier, s.AdditionHash, s.ProofSigner, s.Signature)
+		})
+		if isConnectError(err) {
+			log.Warn(fmt.Sprintf("replay balance proof to pfs %s stopped, %d left", h.host, len(list)-i))
+			p.markBalanceStale(h)
+			return
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf("pfs %s rejected replayed balance proof of channel %s err %s", h.host, utils.HPex(s.ChannelIdentifier), err))
+		}
+		err = p.dao.RemovePfsBalanceSubmission(h.host, s.ChannelIdentifier, s.OpenBlockNumber, s.Nonce)
+		if err != nil {
+			log.Error(fmt.Sprintf("RemovePfsBalanceSubmission err %s", err))
+		}
+	}
+	if len(list) > 0 {
+		log.Info(fmt.Sprintf("replay %d balance proofs to pfs %s", len(list), h.host))
+	}
+}
+
+func (p *ResilientPfsProxy) markBalanceStale(h *pfsHost) {
+	p.lock.Lock()
+	h.balanceStale = true
+	p.lock.Unlock()
+}
+
+func (p *ResilientPfsProxy) healthCheckLoop() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quitChan:
+			return
+		case <-ticker.C:
+		}
+		for _, h := range p.hosts {
+			switch h.breaker.state() {
+			case breakerHalfOpen:
+				//any answer from pfs means it's alive, call will sync it
+				_ = p.call(h, func(c *pfsClient) error {
+					_, _, err := c.GetAccountFee()
+					return err
+				})
+			case breakerClosed:
+				p.syncHost(h)
+			}
+		}
+	}
+}
+
+/*
+SubmitBalance 提交给所有的 pfs,连不上的保存下来等它恢复以后再提交,
+所有的 pfs 都连不上时返回 ErrConnect
+*/
+func (p *ResilientPfsProxy) SubmitBalance(nonce uint64, transferAmount, lockAmount *big.Int, openBlockNumber int64, locksroot, channelIdentifier, additionHash common.Hash, proofSigner common.Address, signature []byte) error {
+	accepted, failed, err := p.broadcast(func(c *pfsClient) error {
+		return c.SubmitBalance(nonce, transferAmount, lockAmount, openBlockNumber, locksroot, channelIdentifier, additionHash, proofSigner, signature)
+	})
+	if p.dao == nil {
+		return err
+	}
+	//older ones are useless now
+	for _, h := range accepted {
+		err2 := p.dao.RemovePfsBalanceSubmission(h.host, channelIdentifier, openBlockNumber, nonce)
+		if err2 != nil {
+			log.Error(fmt.Sprintf("RemovePfsBalanceSubmission err %s", err2))
+		}
+	}
+	for _, h := range failed {
+		err2 := p.dao.SavePfsBalanceSubmission(&models.PfsBalanceSubmission{
+			Host:              h.host,
+			ChannelIdentifier: channelIdentifier,
+			Nonce:             nonce,
+			TransferAmount:    transferAmount,
+			LockAmount:        lockAmount,
+			OpenBlockNumber:   openBlockNumber,
+			Locksroot:         locksroot,
+			AdditionHash:      additionHash,
+			ProofSigner:       proofSigner,
+			Signature:         signature,
+			CreateTime:        time.Now().Unix(),
+		})
+		if err2 != nil {
+			log.Error(fmt.Sprintf("SavePfsBalanceSubmission err %s", err2))
+		}
+		p.markBalanceStale(h)
+	}
+	return err
+}
+
+func pathCacheKey(peerFrom, peerTo, token common.Address, amount *big.Int, isInitiator bool) string {
+	return fmt.Sprintf("%s-%s-%s-%s-%v", peerFrom.String(), peerTo.String(), token.String(), amount, isInitiator)
+}
+
+/*
+copyFindPathResponses 缓存里保存和返回的都是副本,调用者修改返回的结果不会影响缓存
+*/
+func copyFindPathResponses(resp []FindPathResponse) []FindPathResponse {
+	if resp == nil {
+		return nil
+	}
+	cp := make([]FindPathResponse, len(resp))
+	for i, r := range resp {
+		cp[i] = r
+		if r.Fee != nil {
+			cp[i].Fee = new(big.Int).Set(r.Fee)
+		}
+		cp[i].Result = append([]string(nil), r.Result...)
+		cp[i].Trampolines = append([]string(nil), r.Trampolines...)
+	}
+	return cp
+}
+
+/*
+FindPath 优先使用缓存的结果
+*/
+func (p *ResilientPfsProxy) FindPath(peerFrom, peerTo, token common.Address, amount *big.Int, isInitiator bool) (resp []FindPathResponse, err error) {
+	key := pathCacheKey(peerFrom, peerTo, token, amount, isInitiator)
+	p.lock.Lock()
+	entry := p.cache[key]
+	p.lock.Unlock()
+	if entry != nil && time.Since(entry.time) < pathCacheTTL {
+		return copyFindPathResponses(entry.resp), nil
+	}
+	err = p.failover(func(c *pfsClient) (err error) {
+		resp, err = c.FindPath(peerFrom, peerTo, token, amount, isInitiator)
+		return
+	})
+	if isConnectError(err) && entry != nil && time.Since(entry.time) < pathCacheMaxStale {
+		log.Warn(fmt.Sprintf("no pfs available, use path found %s ago", time.Since(entry.time)))
+		return copyFindPathResponses(entry.resp), nil
+	}
+	if err != nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for k, e := range p.cache {
+		if time.Since(e.time) >= pathCacheMaxStale {
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = &pathCacheEntry{resp: copyFindPathResponses(resp), time: time.Now()}
+	return
+}
+
+/*
+SetFeePolicy 提交给所有的 pfs,连不上的等它恢复以后再提交
+*/
+func (p *ResilientPfsProxy) SetFeePolicy(fp *models.FeePolicy) (err error) {
+	p.lock.Lock()
+	p.feePolicy = fp
+	p.lock.Unlock()
+	_, failed, err := p.broadcast(func(c *pfsClient) error {
+		return c.SetFeePolicy(fp)
+	})
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, h := range p.hosts {
+		h.feePolicyStale = false
+		//the whole policy replaces all fee updates before it
+		h.pendingFees = nil
+	}
+	for _, h := range failed {
+		h.feePolicyStale = true
+	}
+	return
+}
+
+// SetAccountFee :
+func (p *ResilientPfsProxy) SetAccountFee(feeConstant *big.Int, feePercent int64) (err error) {
+	return p.setFee("account fee", func(c *pfsClient) error {
+		return c.SetAccountFee(feeConstant, feePercent)
+	})
+}
+
+// GetAccountFee :
+func (p *ResilientPfsProxy) GetAccountFee() (feeConstant *big.Int, feePercent int64, err error) {
+	err = p.failover(func(c *pfsClient) (err error) {
+		feeConstant, feePercent, err = c.GetAccountFee()
+		return
+	})
+	return
+}
+
+// SetTokenFee :
+func (p *ResilientPfsProxy) SetTokenFee(feeConstant *big.Int, feePercent int64, tokenAddress common.Address) (err error) {
+	return p.setFee("token fee "+tokenAddress.String(), func(c *pfsClient) error {
+		return c.SetTokenFee(feeConstant, feePercent, tokenAddress)
+	})
+}
+
+// GetTokenFee :
+func (p *ResilientPfsProxy) GetTokenFee(tokenAddress common.Address) (feeConstant *big.Int, feePercent int64, err error) {
+	err = p.failover(func(c *pfsClient) (err error) {
+		feeConstant, feePercent, err = c.GetTokenFee(tokenAddress)
+		return
+	})
+	return
+}
+
+// SetChannelFee :
+func (p *ResilientPfsProxy) SetChannelFee(feeConstant *big.Int, feePercent int64, channelIdentifier common.Hash) (err error) {
+	return p.setFee("channel fee "+channelIdentifier.String(), func(c *pfsClient) error {
+		return c.SetChannelFee(feeConstant, feePercent, channelIdentifier)
+	})
+}
+
+// GetChannelFee :
+func (p *ResilientPfsProxy) GetChannelFee(channelIdentifier common.Hash) (feeConstant *big.Int, feePercent int64, err error) {
+	err = p.failover(func(c *pfsClient) (err error) {
+		feeConstant, feePercent, err = c.GetChannelFee(channelIdentifier)
+		return
+	})
+	return
+}
+
+/*
+SetSwapRates 提交给所有的 pfs,连不上的等它恢复以后再提交
+*/
+func (p *ResilientPfsProxy) SetSwapRates(rates []*models.SwapRate) (err error) {
+	p.lock.Lock()
+	p.swapRates = rates
+	p.lock.Unlock()
+	_, failed, err := p.broadcast(func(c *pfsClient) error {
+		return c.SetSwapRates(rates)
+	})
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, h := range p.hosts {
+		h.swapRatesStale = false
+	}
+	for _, h := range failed {
+		h.swapRatesStale = true
+	}
+	return
+}
+
+// GetSwapRates :
+func (p *ResilientPfsProxy) GetSwapRates(fromToken, toToken common.Address) (rates []*models.SwapRate, err error) {
+	err = p.failover(func(c *pfsClient) (err error) {
+		rates, err = c.GetSwapRates(fromToken, toToken)
+		return
+	})
+	return
+}
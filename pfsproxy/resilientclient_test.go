@@ -0,0 +1,196 @@
+package pfsproxy
+
+import (
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+)
+
+func newTestServerDB(t *testing.T, name string) *Server {
+	dbPath := path.Join(os.TempDir(), name)
+	err := os.RemoveAll(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(codefortest.NewTestDB(dbPath), nil)
+}
+
+//freeAddr returns a local address nobody listens on now
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &circuitBreaker{}
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		if cb.onFailure() {
+			t.Error("should not open before threshold")
+		}
+	}
+	if !cb.onFailure() || cb.state() != breakerOpen || cb.allow() {
+		t.Error("should open after threshold")
+	}
+	cb.openUntil = cb.openUntil.Add(-breakerMinOpenDuration)
+	if cb.state() != breakerHalfOpen || !cb.allow() {
+		t.Error("should allow a trial after open duration")
+	}
+	if cb.allow() {
+		t.Error("only one trial is allowed in half-open state")
+	}
+	cb.onFailure()
+	if cb.openDuration != 2*breakerMinOpenDuration {
+		t.Errorf("open duration should double, got %s", cb.openDuration)
+	}
+	if !cb.onSuccess() || cb.state() != breakerClosed {
+		t.Error("should close after success")
+	}
+}
+
+func TestResilientPfsProxy(t *testing.T) {
+	params.ChainID = big.NewInt(8888)
+	s1 := newTestServerDB(t, "testpfs1.db")
+	s2 := newTestServerDB(t, "testpfs2.db")
+	defer s1.dao.CloseDB()
+	defer s2.dao.CloseDB()
+	tokenAddress := utils.NewRandomAddress()
+	alice, bob := newTestAccount(), newTestAccount()
+	ch := utils.CalcChannelID(tokenAddress, utils.EmptyAddress, alice.Address, bob.Address)
+	for _, s := range []*Server{s1, s2} {
+		err := s.dao.NewNonParticipantChannel(tokenAddress, ch, alice.Address, bob.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.OnDeposit(ch, alice.Address, big.NewInt(100))
+		s.OnDeposit(ch, bob.Address, big.NewInt(100))
+	}
+	err := s2.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Stop()
+	// s1 is down
+	addr1 := freeAddr(t)
+	host1 := "http://" + addr1
+	p := NewResilientPfsProxy(host1+", http://"+s2.Addr(), alice.PrivateKey, s2.dao)
+	routes, err := p.FindPath(alice.Address, bob.Address, tokenAddress, big.NewInt(50), true)
+	if err != nil || len(routes) != 1 {
+		t.Fatalf("should fail over to the second pfs, err %v", err)
+	}
+	//callers may modify the result, the cache must not change
+	routes[0].Result[0] = utils.NewRandomAddress().String()
+	routes[0].Fee.SetInt64(100)
+	// bob has paid 30 to alice
+	nonce := uint64(1)
+	transferAmount := big.NewInt(30)
+	additionHash := utils.NewRandomHash()
+	bp := createPartnerBalanceProof(bob, transferAmount, utils.EmptyHash, additionHash, nonce, big.NewInt(0), ch)
+	err = p.SubmitBalance(nonce, transferAmount, big.NewInt(0), 0, utils.EmptyHash, ch, additionHash, bob.Address, bp.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := s2.dao.GetPfsBalanceSubmissions(host1)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("balance proof should be queued for the first pfs, err %v", err)
+	}
+	// s1 comes back
+	err = s1.Start(addr1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Stop()
+	p.syncHost(p.hosts[0])
+	list, err = s2.dao.GetPfsBalanceSubmissions(host1)
+	if err != nil || len(list) != 0 {
+		t.Fatalf("queue should be empty after replay, err %v", err)
+	}
+	_, _, err = s1.FindPath(alice.Address, bob.Address, tokenAddress, big.NewInt(120), true)
+	if err != nil {
+		t.Errorf("the first pfs should know the replayed balance proof, err %v", err)
+	}
+	// cached
+	s2.Stop()
+	s1.Stop()
+	routes2, err := p.FindPath(alice.Address, bob.Address, tokenAddress, big.NewInt(50), true)
+	if err != nil || len(routes2) != 1 {
+		t.Fatalf("should use cached path, err %v", err)
+	}
+	if routes2[0].Result[0] != bob.Address.String() || routes2[0].Fee.Sign() != 0 {
+		t.Errorf("cached path should not be modified by callers, got %v", routes2[0])
+	}
+}
+
+type countingQueueDao struct {
+	models.PfsSubmissionQueueDao
+	gets int
+}
+
+func (d *countingQueueDao) GetPfsBalanceSubmissions(host string) (list []*models.PfsBalanceSubmission, err error) {
+	d.gets++
+	return d.PfsSubmissionQueueDao.GetPfsBalanceSubmissions(host)
+}
+
+func TestResilientPfsProxyReplayFees(t *testing.T) {
+	s := newTestServerDB(t, "testpfs3.db")
+	defer s.dao.CloseDB()
+	alice := newTestAccount()
+	tokenAddress, channelIdentifier := utils.NewRandomAddress(), utils.NewRandomHash()
+	addr := freeAddr(t)
+	dao := &countingQueueDao{PfsSubmissionQueueDao: s.dao}
+	p := NewResilientPfsProxy("http://"+addr, alice.PrivateKey, dao)
+	// pfs is down, updates are queued and only the latest one of each key is kept
+	if p.SetTokenFee(big.NewInt(1), 100, tokenAddress) == nil ||
+		p.SetChannelFee(big.NewInt(2), 200, channelIdentifier) == nil ||
+		p.SetTokenFee(big.NewInt(3), 300, tokenAddress) == nil {
+		t.Fatal("should fail when pfs is down")
+	}
+	h := p.hosts[0]
+	if len(h.pendingFees) != 2 || h.pendingFees[0].key != "channel fee "+channelIdentifier.String() {
+		t.Fatalf("pending fees wrong %d", len(h.pendingFees))
+	}
+	err := s.Start(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	// open duration is over
+	h.breaker = &circuitBreaker{}
+	p.syncHost(h)
+	if len(h.pendingFees) != 0 {
+		t.Error("pending fees should be replayed")
+	}
+	feeConstant, feePercent, err := p.GetTokenFee(tokenAddress)
+	if err != nil || feeConstant.Int64() != 3 || feePercent != 300 {
+		t.Errorf("token fee should be the latest one, err %v", err)
+	}
+	feeConstant, feePercent, err = p.GetChannelFee(channelIdentifier)
+	if err != nil || feeConstant.Int64() != 2 || feePercent != 200 {
+		t.Errorf("channel fee should be replayed, err %v", err)
+	}
+	// queue in db is read once after start, and never again until a submission fails
+	if dao.gets != 1 {
+		t.Errorf("queue should be read once, got %d", dao.gets)
+	}
+	p.syncHost(h)
+	p.syncHost(h)
+	if dao.gets != 1 {
+		t.Errorf("queue should not be read when nothing is queued, got %d", dao.gets)
+	}
+	p.Stop()
+	p.Stop()
+}
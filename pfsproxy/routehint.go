@@ -0,0 +1,158 @@
+package pfsproxy
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+路由提示:
+pfs 不知道没有提交过 balance proof 的私有通道,路由时也会跳过手机节点,所以经过超级节点转给手机用户的交易经常找不到路由.
+接收方(或者替它生成收款信息的超级节点)把到自己的最后几跳告诉发起方,每一跳包括付款节点,通道,付款节点的收费和 reveal timeout,
+发起方先找到第一跳付款节点的路由,再把提示的几跳接在后面.
+一条提示 []*RouteHint 依次为 h0,h1,...,hn,对应的路径是 ... -> h0.NodeAddress -> h1.NodeAddress -> ... -> hn.NodeAddress -> 接收方
+*/
+/*
+ *	Route hints :
+ *	pfs doesn't know private channels which never submit balance proofs, and mobile nodes are skipped when routing,
+ *	so transfers to mobile users behind a supernode often fail to find a route.
+ *	receiver (or the supernode creating an invoice for it) tells the initiator the last hops to itself, each hop has its payer node, channel,
+ *	fee and reveal timeout of the payer, the initiator finds a route to the payer of the first hop and appends the hinted hops.
+ *	a hint []*RouteHint h0,h1,...,hn means path ... -> h0.NodeAddress -> h1.NodeAddress -> ... -> hn.NodeAddress -> receiver
+ */
+
+// RouteHint : 到接收方的最后几跳中的一跳
+type RouteHint struct {
+	NodeAddress       common.Address     `json:"node_address"` // 这一跳的付款方
+	ChannelIdentifier common.Hash        `json:"channel_identifier"`
+	FeeSetting        *models.FeeSetting `json:"fee_setting"` // NodeAddress 从这个通道转出时的收费
+	RevealTimeout     int                `json:"reveal_timeout"`
+}
+
+// Fee : NodeAddress 转发 amount 收取的手续费
+func (h *RouteHint) Fee(amount *big.Int) *big.Int {
+	fee := big.NewInt(0)
+	if h.FeeSetting == nil {
+		return fee
+	}
+	if h.FeeSetting.FeePercent > 0 {
+		fee.Div(amount, big.NewInt(h.FeeSetting.FeePercent))
+	}
+	if h.FeeSetting.FeeConstant != nil && h.FeeSetting.FeeConstant.Sign() > 0 {
+		fee.Add(fee, h.FeeSetting.FeeConstant)
+	}
+	return fee
+}
+
+// ValidateRouteHints :
+func ValidateRouteHints(hints [][]*RouteHint) error {
+	for _, hint := range hints {
+		if len(hint) == 0 {
+			return rerr.ErrArgumentError.Append("empty route hint")
+		}
+		for _, h := range hint {
+			if h == nil || h.NodeAddress == utils.EmptyAddress {
+				return rerr.ErrArgumentError.Append("route hint without node address")
+			}
+			if h.RevealTimeout < 0 || (h.FeeSetting != nil && (h.FeeSetting.FeePercent < 0 || (h.FeeSetting.FeeConstant != nil && h.FeeSetting.FeeConstant.Sign() < 0))) {
+				return rerr.ErrArgumentError.Printf("invalid route hint of %s", utils.APex2(h.NodeAddress))
+			}
+		}
+	}
+	return nil
+}
+
+/*
+MergeRouteHint 把 peerFrom 到提示入口节点 hint[0].NodeAddress 的路由 toEntry 和提示拼接成到 peerTo 的路由,
+peerFrom 就是入口节点时 toEntry 为 nil.路径有环时返回 false
+*/
+func MergeRouteHint(toEntry *FindPathResponse, peerFrom, peerTo common.Address, hint []*RouteHint, amount *big.Int) (resp FindPathResponse, ok bool) {
+	resp.Fee = big.NewInt(0)
+	var path []common.Address
+	if toEntry != nil {
+		path = toEntry.GetPath()
+		if toEntry.Fee != nil {
+			resp.Fee.Add(resp.Fee, toEntry.Fee)
+		}
+	}
+	for i, h := range hint {
+		if i > 0 {
+			path = append(path, h.NodeAddress)
+		}
+		//发起方不收费
+		if h.NodeAddress != peerFrom {
+			resp.Fee.Add(resp.Fee, h.Fee(amount))
+		}
+		if h.RevealTimeout > resp.RevealTimeout {
+			resp.RevealTimeout = h.RevealTimeout
+		}
+	}
+	path = append(path, peerTo)
+	seen := map[common.Address]bool{peerFrom: true}
+	for _, addr := range path {
+		if seen[addr] {
+			return
+		}
+		seen[addr] = true
+		resp.Result = append(resp.Result, addr.String())
+	}
+	resp.PathHop = len(path)
+	return resp, true
+}
+
+/*
+FindPathWithRouteHints 向 pfs 查询到 peerTo 的路由和到每条提示入口节点的路由,拼接以后按手续费从低到高返回
+*/
+func FindPathWithRouteHints(proxy PfsProxy, peerFrom, peerTo, token common.Address, amount *big.Int, hints [][]*RouteHint) (resp []FindPathResponse, err error) {
+	if err = ValidateRouteHints(hints); err != nil {
+		return
+	}
+	paths, err := proxy.FindPath(peerFrom, peerTo, token, amount, true)
+	//pfs 的结果可能被缓存,不能修改
+	for _, p := range paths {
+		if p.Fee != nil {
+			resp = append(resp, p)
+		}
+	}
+	if err != nil {
+		if len(hints) == 0 {
+			return
+		}
+		log.Info(fmt.Sprintf("find path to %s err %s, try route hints", utils.APex2(peerTo), err))
+		err = nil
+	}
+	for _, hint := range hints {
+		entry := hint[0].NodeAddress
+		var toEntry *FindPathResponse
+		if entry != peerFrom {
+			paths, err2 := proxy.FindPath(peerFrom, entry, token, amount, true)
+			if err2 != nil || len(paths) == 0 {
+				log.Info(fmt.Sprintf("find path to route hint entry %s err %v", utils.APex2(entry), err2))
+				continue
+			}
+			toEntry = &paths[0]
+		}
+		r, ok := MergeRouteHint(toEntry, peerFrom, peerTo, hint, amount)
+		if ok {
+			resp = append(resp, r)
+		}
+	}
+	if len(resp) == 0 {
+		err = rerr.ErrNoAvailabeRoute.Printf("no route to %s with %d route hints", utils.APex2(peerTo), len(hints))
+		return
+	}
+	sort.SliceStable(resp, func(i, j int) bool {
+		return resp[i].Fee.Cmp(resp[j].Fee) < 0
+	})
+	for i := range resp {
+		resp[i].PathID = i
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package pfsproxy
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestFindPathWithRouteHints(t *testing.T) {
+	params.ChainID = big.NewInt(8888)
+	s := newTestServerDB(t, "testpfshint.db")
+	defer s.dao.CloseDB()
+	tokenAddress := utils.NewRandomAddress()
+	alice, bob := newTestAccount(), newTestAccount()
+	// carol is a mobile node behind bob, pfs doesn't know the channel bob-carol
+	carol := utils.NewRandomAddress()
+	ch := utils.CalcChannelID(tokenAddress, utils.EmptyAddress, alice.Address, bob.Address)
+	err := s.dao.NewNonParticipantChannel(tokenAddress, ch, alice.Address, bob.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnDeposit(ch, alice.Address, big.NewInt(100))
+	s.OnDeposit(ch, bob.Address, big.NewInt(100))
+	err = s.Start("127.0.0.1:19004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	p := NewPfsProxy("http://127.0.0.1:19004", alice.PrivateKey)
+	_, err = FindPathWithRouteHints(p, alice.Address, carol, tokenAddress, big.NewInt(50), nil)
+	if err == nil {
+		t.Fatal("pfs should not know carol")
+	}
+	hints := [][]*RouteHint{{{
+		NodeAddress:       bob.Address,
+		ChannelIdentifier: utils.NewRandomHash(),
+		FeeSetting:        &models.FeeSetting{FeeConstant: big.NewInt(1), FeePercent: 10},
+		RevealTimeout:     40,
+	}}}
+	routes, err := FindPathWithRouteHints(p, alice.Address, carol, tokenAddress, big.NewInt(50), hints)
+	if err != nil || len(routes) != 1 {
+		t.Fatalf("should find path with route hint, err %v", err)
+	}
+	r := routes[0]
+	path := r.GetPath()
+	if len(path) != 2 || path[0] != bob.Address || path[1] != carol || r.PathHop != 2 {
+		t.Errorf("wrong path %v", r.Result)
+	}
+	if r.Fee.Int64() != 6 || r.RevealTimeout != 40 {
+		t.Errorf("wrong fee %s or reveal timeout %d", r.Fee, r.RevealTimeout)
+	}
+	// i'm the entry of the hint and charge nothing
+	r, ok := MergeRouteHint(nil, bob.Address, carol, hints[0], big.NewInt(50))
+	if !ok || r.Fee.Sign() != 0 || len(r.Result) != 1 {
+		t.Errorf("wrong route %v", r)
+	}
+	// loop
+	_, ok = MergeRouteHint(&FindPathResponse{Result: []string{bob.Address.String()}}, alice.Address, carol,
+		[]*RouteHint{{NodeAddress: bob.Address}, {NodeAddress: alice.Address}}, big.NewInt(50))
+	if ok {
+		t.Error("path with loop should be rejected")
+	}
+	if ValidateRouteHints([][]*RouteHint{{{NodeAddress: common.Address{}}}}) == nil {
+		t.Error("hint without node should be invalid")
+	}
+}
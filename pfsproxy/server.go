@@ -0,0 +1,582 @@
+package pfsproxy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/encoding"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+Server 内置的 pfs 服务,提供与 pfsClient 调用的相同的 http 接口:
+1. 网络拓扑来自本地保存的所有通道(NonParticipantChannelDao)
+2. 通道押金来自链上事件,没有记录的通过 DepositGetter 到链上查询
+3. 通道余额来自各节点提交的对方的 balance proof
+4. 收费策略和兑换价格来自各节点提交的签名数据
+*/
+/*
+ *	Server : built-in path finding service, serves the same http api consumed by pfsClient.
+ *	1. topology comes from all the channels saved locally (NonParticipantChannelDao)
+ *	2. deposits come from chain events, unknown ones are queried on chain via DepositGetter
+ *	3. balances come from the partner's balance proof submitted by each node
+ *	4. fee policies and swap rates come from signed data submitted by each node
+ */
+type Server struct {
+	dao        models.Dao
+	getDeposit DepositGetter
+	server     *http.Server
+	listener   net.Listener
+	lock       sync.Mutex
+}
+
+//http 连接的超时,防止慢客户端一直占用连接
+const (
+	serverReadTimeout  = 10 * time.Second
+	serverWriteTimeout = 30 * time.Second
+	serverIdleTimeout  = 2 * time.Minute
+)
+
+// DepositGetter query deposit of participant in channel with partner on chain
+type DepositGetter func(tokenAddress, participant, partner common.Address) (deposit *big.Int, err error)
+
+// NewServer :
+func NewServer(dao models.Dao, getDeposit DepositGetter) *Server {
+	return &Server{
+		dao:        dao,
+		getDeposit: getDeposit,
+	}
+}
+
+// Start listen on addr, such as 127.0.0.1:9001, port 0 means any free port, see Addr
+func (s *Server) Start(addr string) (err error) {
+	api := rest.NewApi()
+	api.Use(rest.DefaultProdStack...)
+	router, err := rest.MakeRouter(
+		rest.Put("/pfs/1/:peer/balance", s.submitBalance),
+		rest.Post("/pfs/1/paths", s.findPath),
+		rest.Put("/pfs/1/feerate/:peer", s.setFeePolicy),
+		rest.Put("/pfs/1/account_rate/:peer", s.setAccountFee),
+		rest.Get("/pfs/1/account_rate/:peer", s.getAccountFee),
+		rest.Put("/pfs/1/token_rate/:token/:peer", s.setTokenFee),
+		rest.Get("/pfs/1/token_rate/:token/:peer", s.getTokenFee),
+		rest.Put("/pfs/1/channel_rate/:channel/:peer", s.setChannelFee),
+		rest.Get("/pfs/1/channel_rate/:channel/:peer", s.getChannelFee),
+		//go-json-rest requires the same placeholder name with get swap_rate, :from is the peer
+		rest.Put("/pfs/1/swap_rate/:from", s.setSwapRates),
+		rest.Get("/pfs/1/swap_rate/:from/:to", s.getSwapRates),
+	)
+	if err != nil {
+		return
+	}
+	api.SetApp(router)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:      api.MakeHandler(),
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+		IdleTimeout:  serverIdleTimeout,
+	}
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error(fmt.Sprintf("pfs server on %s err %s", addr, err))
+		}
+	}()
+	log.Info(fmt.Sprintf("pfs server start on %s", addr))
+	return nil
+}
+
+// Addr returns the address listened on, only valid after Start
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Stop :
+func (s *Server) Stop() {
+	if s.server == nil {
+		return
+	}
+	err := s.server.Shutdown(context.Background())
+	if err != nil {
+		log.Error(fmt.Sprintf("pfs server shutdown err %s", err))
+	}
+}
+
+/*
+OnDeposit 链上押金变化
+*/
+func (s *Server) OnDeposit(channelIdentifier common.Hash, participant common.Address, deposit *big.Int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p := s.getParticipant(channelIdentifier, participant)
+	p.Deposit = new(big.Int).Set(deposit)
+	s.saveParticipant(p)
+}
+
+/*
+OnWithdraw 取现以后通道重新打开,双方的押金为取现后的余额,之前的 balance proof 作废
+*/
+func (s *Server) OnWithdraw(channelIdentifier common.Hash, openBlockNumber int64, participant1 common.Address, balance1 *big.Int, participant2 common.Address, balance2 *big.Int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range []*models.PfsChannelParticipant{
+		{ChannelIdentifier: channelIdentifier, OpenBlockNumber: openBlockNumber, Participant: participant1, Deposit: balance1},
+		{ChannelIdentifier: channelIdentifier, OpenBlockNumber: openBlockNumber, Participant: participant2, Deposit: balance2},
+	} {
+		s.saveParticipant(p)
+	}
+}
+
+func (s *Server) getParticipant(channelIdentifier common.Hash, participant common.Address) *models.PfsChannelParticipant {
+	p, err := s.dao.GetPfsChannelParticipant(channelIdentifier, participant)
+	if err != nil {
+		p = &models.PfsChannelParticipant{
+			ChannelIdentifier: channelIdentifier,
+			Participant:       participant,
+		}
+	}
+	return p
+}
+
+func (s *Server) saveParticipant(p *models.PfsChannelParticipant) {
+	err := s.dao.SavePfsChannelParticipant(p)
+	if err != nil {
+		log.Error(fmt.Sprintf("SavePfsChannelParticipant err %s", err))
+	}
+}
+
+/*
+submitBalance peer 提交的是对方(ProofSigner)签名的 balance proof,记录的是 ProofSigner 转给 peer 的金额.
+balance proof 的签名必须是 ProofSigner 的,并且 nonce 必须比已知的大,否则任何人都可以伪造对方的转账金额
+*/
+func (s *Server) submitBalance(w rest.ResponseWriter, r *rest.Request) {
+	peer := common.HexToAddress(r.PathParam("peer"))
+	payload := &submitBalancePayload{}
+	err := r.DecodeJsonPayload(payload)
+	if err != nil || payload.BalanceProof == nil || payload.BalanceProof.TransferAmount == nil || payload.LockAmount == nil {
+		rest.Error(w, fmt.Sprintf("invalid balance proof payload %v", err), http.StatusBadRequest)
+		return
+	}
+	signer, err := utils.Ecrecover(utils.Sha3(payload.signData()), payload.BalanceSignature)
+	if err != nil || signer != peer {
+		rest.Error(w, "illegal signature of balance message, for participant", http.StatusBadRequest)
+		return
+	}
+	bp := payload.BalanceProof
+	proofSigner, err := utils.Ecrecover(utils.Sha3(encoding.BalanceProofSignData(bp.TransferAmount, bp.Locksroot, bp.Nonce, bp.AdditionHash, bp.ChannelIdentifier, bp.OpenBlockNumber)), bp.Signature)
+	if err != nil || proofSigner != payload.ProofSigner {
+		rest.Error(w, "illegal signature of balance proof, for proof signer", http.StatusBadRequest)
+		return
+	}
+	_, p1, p2, err := s.dao.GetNonParticipantChannelByID(bp.ChannelIdentifier)
+	if err != nil {
+		rest.Error(w, fmt.Sprintf("channel %s not found", bp.ChannelIdentifier.String()), http.StatusBadRequest)
+		return
+	}
+	if !(peer == p1 && payload.ProofSigner == p2) && !(peer == p2 && payload.ProofSigner == p1) {
+		rest.Error(w, "peer and proof signer are not participants of this channel", http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p := s.getParticipant(bp.ChannelIdentifier, payload.ProofSigner)
+	if bp.OpenBlockNumber < p.OpenBlockNumber || (bp.OpenBlockNumber == p.OpenBlockNumber && bp.Nonce <= p.Nonce) {
+		rest.Error(w, "balance proof is older than the known one", http.StatusBadRequest)
+		return
+	}
+	p.OpenBlockNumber = bp.OpenBlockNumber
+	p.Nonce = bp.Nonce
+	p.TransferAmount = bp.TransferAmount
+	p.LockAmount = payload.LockAmount
+	s.saveParticipant(p)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) findPath(w rest.ResponseWriter, r *rest.Request) {
+	payload := &findPathPayload{}
+	err := r.DecodeJsonPayload(payload)
+	if err != nil || payload.SendAmount == nil || payload.SendAmount.Sign() <= 0 {
+		rest.Error(w, fmt.Sprintf("invalid find path payload %v", err), http.StatusBadRequest)
+		return
+	}
+	path, fee, err := s.FindPath(payload.PeerFrom, payload.PeerTo, payload.TokenAddress, payload.SendAmount, payload.PeerFromChargeFee)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := &FindPathResponse{
+		PathID:  0,
+		PathHop: len(path),
+		Fee:     fee,
+	}
+	for _, addr := range path {
+		resp.Result = append(resp.Result, addr.String())
+	}
+	writeJSON(w, []*FindPathResponse{resp})
+}
+
+// edge is a direction of a channel
+type edge struct {
+	channelIdentifier common.Hash
+	to                common.Address
+	capacity          *big.Int
+}
+
+/*
+FindPath 找到从 peerFrom 到 peerTo 收费最少的路径,收费相同的选择跳数少的,
+每个中间节点按照它的收费策略对 amount 收费,chargeFrom 为 true 时 peerFrom 也收费.
+返回的路径不包含 peerFrom
+*/
+func (s *Server) FindPath(peerFrom, peerTo, tokenAddress common.Address, amount *big.Int, chargeFrom bool) (path []common.Address, totalFee *big.Int, err error) {
+	graph, err := s.buildGraph(tokenAddress)
+	if err != nil {
+		return
+	}
+	type nodeState struct {
+		fee  *big.Int
+		hops int
+		prev common.Address
+		done bool
+	}
+	states := map[common.Address]*nodeState{
+		peerFrom: {fee: big.NewInt(0)},
+	}
+	for {
+		//pick the unvisited node with least fee
+		var cur common.Address
+		var curState *nodeState
+		for addr, st := range states {
+			if st.done {
+				continue
+			}
+			if curState == nil || st.fee.Cmp(curState.fee) < 0 || (st.fee.Cmp(curState.fee) == 0 && st.hops < curState.hops) {
+				cur, curState = addr, st
+			}
+		}
+		if curState == nil {
+			break
+		}
+		curState.done = true
+		if cur == peerTo {
+			break
+		}
+		for _, e := range graph[cur] {
+			if e.capacity.Cmp(amount) < 0 {
+				continue
+			}
+			fee := new(big.Int).Set(curState.fee)
+			if cur != peerFrom || chargeFrom {
+				fee.Add(fee, s.nodeChargeFee(cur, tokenAddress, e.channelIdentifier, amount))
+			}
+			st, ok := states[e.to]
+			if ok && (st.done || st.fee.Cmp(fee) < 0 || (st.fee.Cmp(fee) == 0 && st.hops <= curState.hops+1)) {
+				continue
+			}
+			states[e.to] = &nodeState{fee: fee, hops: curState.hops + 1, prev: cur}
+		}
+	}
+	st, ok := states[peerTo]
+	if !ok || !st.done || peerTo == peerFrom {
+		err = fmt.Errorf("no path from %s to %s for amount %s", utils.APex2(peerFrom), utils.APex2(peerTo), amount)
+		return
+	}
+	for addr := peerTo; addr != peerFrom; addr = states[addr].prev {
+		path = append([]common.Address{addr}, path...)
+	}
+	return path, st.fee, nil
+}
+
+/*
+buildGraph 根据本地保存的通道和余额构造有向图,
+A->B 的容量为 A的押金 - A转给B的 + B转给A的 - A锁定给B的.
+没有记录的押金先在锁外到链上查询,拿到锁以后再构造图,不能在持有锁时访问链
+*/
+func (s *Server) buildGraph(tokenAddress common.Address) (graph map[common.Address][]*edge, err error) {
+	channels, participants, err := s.dao.GetAllNonParticipantChannelIdentifiersByToken(tokenAddress)
+	if err != nil {
+		return
+	}
+	deposits := s.queryDeposits(tokenAddress, channels, participants)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	graph = make(map[common.Address][]*edge)
+	for i, ch := range channels {
+		p1 := s.getParticipant(ch, participants[2*i])
+		p2 := s.getParticipant(ch, participants[2*i+1])
+		for _, p := range []*models.PfsChannelParticipant{p1, p2} {
+			//OnDeposit may have saved it after the query
+			deposit, ok := deposits[models.PfsChannelParticipantKey(ch, p.Participant)]
+			if p.Deposit == nil && ok {
+				p.Deposit = deposit
+				s.saveParticipant(p)
+			}
+		}
+		graph[p1.Participant] = append(graph[p1.Participant], &edge{ch, p2.Participant, capacity(p1, p2)})
+		graph[p2.Participant] = append(graph[p2.Participant], &edge{ch, p1.Participant, capacity(p2, p1)})
+	}
+	//make path finding result stable
+	for _, edges := range graph {
+		sort.Slice(edges, func(i, j int) bool {
+			return edges[i].to.Hex() < edges[j].to.Hex()
+		})
+	}
+	return
+}
+
+/*
+queryDeposits 到链上查询没有记录押金的参与方,key 为 PfsChannelParticipantKey
+*/
+func (s *Server) queryDeposits(tokenAddress common.Address, channels []common.Hash, participants []common.Address) map[string]*big.Int {
+	deposits := make(map[string]*big.Int)
+	if s.getDeposit == nil {
+		return deposits
+	}
+	for i, ch := range channels {
+		for j := 0; j < 2; j++ {
+			participant, partner := participants[2*i+j], participants[2*i+1-j]
+			p, err := s.dao.GetPfsChannelParticipant(ch, participant)
+			if err == nil && p.Deposit != nil {
+				continue
+			}
+			deposit, err := s.getDeposit(tokenAddress, participant, partner)
+			if err != nil {
+				log.Warn(fmt.Sprintf("pfs server get deposit of %s in channel %s err %s", utils.APex2(participant), utils.HPex(ch), err))
+				continue
+			}
+			deposits[models.PfsChannelParticipantKey(ch, participant)] = deposit
+		}
+	}
+	return deposits
+}
+
+func capacity(from, to *models.PfsChannelParticipant) *big.Int {
+	c := new(big.Int)
+	if from.Deposit != nil {
+		c.Add(c, from.Deposit)
+	}
+	if from.TransferAmount != nil {
+		c.Sub(c, from.TransferAmount)
+	}
+	if from.LockAmount != nil {
+		c.Sub(c, from.LockAmount)
+	}
+	if to.TransferAmount != nil {
+		c.Add(c, to.TransferAmount)
+	}
+	return c
+}
+
+/*
+nodeChargeFee 与 FeeModule 相同,优先 channel,其次 token,最后 account,没有提交过收费策略的节点按默认收费
+*/
+func (s *Server) nodeChargeFee(node, tokenAddress common.Address, channelIdentifier common.Hash, amount *big.Int) *big.Int {
+	fp := s.getFeePolicy(node)
+	fs, ok := fp.ChannelFeeMap[channelIdentifier]
+	if !ok {
+		fs, ok = fp.TokenFeeMap[tokenAddress]
+	}
+	if !ok {
+		fs = fp.AccountFee
+	}
+	fee := big.NewInt(0)
+	if fs.FeePercent > 0 {
+		fee.Div(amount, big.NewInt(fs.FeePercent))
+	}
+	if fs.FeeConstant != nil && fs.FeeConstant.Sign() > 0 {
+		fee.Add(fee, fs.FeeConstant)
+	}
+	return fee
+}
+
+func (s *Server) getFeePolicy(node common.Address) *models.FeePolicy {
+	fp, err := s.dao.GetPfsFeePolicy(node)
+	if err != nil || fp.AccountFee == nil {
+		return models.NewDefaultFeePolicy()
+	}
+	if fp.TokenFeeMap == nil {
+		fp.TokenFeeMap = make(map[common.Address]*models.FeeSetting)
+	}
+	if fp.ChannelFeeMap == nil {
+		fp.ChannelFeeMap = make(map[common.Hash]*models.FeeSetting)
+	}
+	return fp
+}
+
+/*
+setFeePolicy 整体替换收费策略,所有设置的 nonce 都必须比已保存的大
+*/
+func (s *Server) setFeePolicy(w rest.ResponseWriter, r *rest.Request) {
+	peer := common.HexToAddress(r.PathParam("peer"))
+	fp := &models.FeePolicy{}
+	err := r.DecodeJsonPayload(fp)
+	if err != nil || !fp.VerifySignature(peer) {
+		rest.Error(w, fmt.Sprintf("invalid fee policy %v", err), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	minNonce, _ := fp.Nonces()
+	_, lastNonce := s.getFeePolicy(peer).Nonces()
+	if minNonce <= lastNonce {
+		rest.Error(w, "fee policy is older than the known one", http.StatusBadRequest)
+		return
+	}
+	s.saveFeePolicy(w, peer, fp)
+}
+
+/*
+updateFeeSetting 单独设置 account,token 或者 channel 的收费,nonce 必须比已保存的大
+*/
+func (s *Server) updateFeeSetting(w rest.ResponseWriter, r *rest.Request, update func(fp *models.FeePolicy, fs *models.FeeSetting)) {
+	peer := common.HexToAddress(r.PathParam("peer"))
+	payload := &setFeePayload{}
+	err := r.DecodeJsonPayload(payload)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fs := payload.toFeeSetting()
+	if !fs.VerifySignature(peer) {
+		rest.Error(w, "illegal signature of fee setting", http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fp := s.getFeePolicy(peer)
+	if _, lastNonce := fp.Nonces(); fs.Nonce <= lastNonce {
+		rest.Error(w, "fee setting is older than the known one", http.StatusBadRequest)
+		return
+	}
+	update(fp, fs)
+	s.saveFeePolicy(w, peer, fp)
+}
+
+func (s *Server) saveFeePolicy(w rest.ResponseWriter, peer common.Address, fp *models.FeePolicy) {
+	err := s.dao.SavePfsFeePolicy(peer, fp)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) setAccountFee(w rest.ResponseWriter, r *rest.Request) {
+	s.updateFeeSetting(w, r, func(fp *models.FeePolicy, fs *models.FeeSetting) {
+		fp.AccountFee = fs
+	})
+}
+
+func (s *Server) setTokenFee(w rest.ResponseWriter, r *rest.Request) {
+	tokenAddress := common.HexToAddress(r.PathParam("token"))
+	s.updateFeeSetting(w, r, func(fp *models.FeePolicy, fs *models.FeeSetting) {
+		fp.TokenFeeMap[tokenAddress] = fs
+	})
+}
+
+func (s *Server) setChannelFee(w rest.ResponseWriter, r *rest.Request) {
+	channelIdentifier := common.HexToHash(r.PathParam("channel"))
+	s.updateFeeSetting(w, r, func(fp *models.FeePolicy, fs *models.FeeSetting) {
+		fp.ChannelFeeMap[channelIdentifier] = fs
+	})
+}
+
+func writeFeeSetting(w rest.ResponseWriter, fs *models.FeeSetting, ok bool) {
+	if !ok {
+		rest.NotFound(w, nil)
+		return
+	}
+	writeJSON(w, &getFeeResponse{
+		FeeConstant: fs.FeeConstant,
+		FeePercent:  fs.FeePercent,
+	})
+}
+
+func (s *Server) getAccountFee(w rest.ResponseWriter, r *rest.Request) {
+	fp := s.getFeePolicy(common.HexToAddress(r.PathParam("peer")))
+	writeFeeSetting(w, fp.AccountFee, true)
+}
+
+func (s *Server) getTokenFee(w rest.ResponseWriter, r *rest.Request) {
+	fp := s.getFeePolicy(common.HexToAddress(r.PathParam("peer")))
+	fs, ok := fp.TokenFeeMap[common.HexToAddress(r.PathParam("token"))]
+	writeFeeSetting(w, fs, ok)
+}
+
+func (s *Server) getChannelFee(w rest.ResponseWriter, r *rest.Request) {
+	fp := s.getFeePolicy(common.HexToAddress(r.PathParam("peer")))
+	fs, ok := fp.ChannelFeeMap[common.HexToHash(r.PathParam("channel"))]
+	writeFeeSetting(w, fs, ok)
+}
+
+/*
+setSwapRates 替换做市商的所有兑换价格,nonce 必须比已保存的大
+*/
+func (s *Server) setSwapRates(w rest.ResponseWriter, r *rest.Request) {
+	peer := common.HexToAddress(r.PathParam("from"))
+	payload := &setSwapRatesPayload{}
+	err := r.DecodeJsonPayload(payload)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !payload.verifySignature(peer) {
+		rest.Error(w, "illegal signature of swap rates", http.StatusBadRequest)
+		return
+	}
+	for _, sr := range payload.Rates {
+		if sr.MarketMaker != peer || !sr.VerifySignature() {
+			rest.Error(w, "illegal signature of swap rate", http.StatusBadRequest)
+			return
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lastNonce, err := s.dao.GetPfsSwapRatesNonce(peer)
+	if err == nil && payload.Nonce <= lastNonce {
+		rest.Error(w, "swap rates are older than the known ones", http.StatusBadRequest)
+		return
+	}
+	err = s.dao.SavePfsSwapRates(peer, payload.Nonce, payload.Rates)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getSwapRates(w rest.ResponseWriter, r *rest.Request) {
+	rates, err := s.dao.GetPfsSwapRates(common.HexToAddress(r.PathParam("from")), common.HexToAddress(r.PathParam("to")))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rates == nil {
+		rates = []*models.SwapRate{}
+	}
+	writeJSON(w, rates)
+}
+
+func writeJSON(w rest.ResponseWriter, v interface{}) {
+	err := w.WriteJson(v)
+	if err != nil {
+		log.Warn(fmt.Sprintf("pfs server writejson err %s", err))
+	}
+}
@@ -0,0 +1,229 @@
+package pfsproxy
+
+import (
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestAccount() codefortest.TestAccount {
+	key, addr := utils.MakePrivateKeyAddress()
+	return codefortest.TestAccount{
+		Address:    addr,
+		PrivateKey: key,
+	}
+}
+
+func TestServer(t *testing.T) {
+	params.ChainID = big.NewInt(8888)
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	tokenAddress := utils.NewRandomAddress()
+	alice, bob, carol, dave := newTestAccount(), newTestAccount(), newTestAccount(), newTestAccount()
+	s := NewServer(dao, nil)
+	err := s.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	host := "http://" + s.Addr()
+	// alice-bob-carol and alice-dave-carol
+	channels := make(map[[2]common.Address]common.Hash)
+	for _, pair := range [][2]codefortest.TestAccount{{alice, bob}, {bob, carol}, {alice, dave}, {dave, carol}} {
+		ch := utils.CalcChannelID(tokenAddress, utils.EmptyAddress, pair[0].Address, pair[1].Address)
+		err = dao.NewNonParticipantChannel(tokenAddress, ch, pair[0].Address, pair[1].Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.OnDeposit(ch, pair[0].Address, big.NewInt(100))
+		s.OnDeposit(ch, pair[1].Address, big.NewInt(100))
+		channels[[2]common.Address{pair[0].Address, pair[1].Address}] = ch
+	}
+	// bob charges more than dave
+	err = NewPfsProxy(host, bob.PrivateKey).SetAccountFee(big.NewInt(5), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeConstant, _, err := NewPfsProxy(host, bob.PrivateKey).GetAccountFee()
+	if err != nil || feeConstant.Int64() != 5 {
+		t.Fatalf("get account fee err %v, fee=%s", err, feeConstant)
+	}
+	c := NewPfsProxy(host, alice.PrivateKey)
+	routes, err := c.FindPath(alice.Address, carol.Address, tokenAddress, big.NewInt(50), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || len(routes[0].GetPath()) != 2 || routes[0].GetPath()[0] != dave.Address {
+		t.Fatalf("expect path through dave, got %v", routes)
+	}
+	// dave has paid 80 to carol, so dave->carol can't carry 50 any more
+	ch := channels[[2]common.Address{dave.Address, carol.Address}]
+	nonce := uint64(1)
+	transferAmount := big.NewInt(80)
+	additionHash := utils.NewRandomHash()
+	// carol can't forge a balance proof of dave
+	forged := createPartnerBalanceProof(carol, transferAmount, utils.EmptyHash, additionHash, nonce, big.NewInt(0), ch)
+	err = NewPfsProxy(host, carol.PrivateKey).SubmitBalance(nonce, transferAmount, big.NewInt(0), 0, utils.EmptyHash, ch, additionHash, dave.Address, forged.Signature)
+	if err == nil {
+		t.Error("balance proof not signed by proof signer should be rejected")
+	}
+	bp := createPartnerBalanceProof(dave, transferAmount, utils.EmptyHash, additionHash, nonce, big.NewInt(0), ch)
+	err = NewPfsProxy(host, carol.PrivateKey).SubmitBalance(nonce, transferAmount, big.NewInt(0), 0, utils.EmptyHash, ch, additionHash, dave.Address, bp.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// resubmit the same balance proof should fail
+	err = NewPfsProxy(host, carol.PrivateKey).SubmitBalance(nonce, transferAmount, big.NewInt(0), 0, utils.EmptyHash, ch, additionHash, dave.Address, bp.Signature)
+	if err == nil {
+		t.Error("old balance proof should be rejected")
+	}
+	path, fee, err := s.FindPath(alice.Address, carol.Address, tokenAddress, big.NewInt(50), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(path) != 2 || path[0] != bob.Address || fee.Int64() != 5 {
+		t.Errorf("expect path through bob with fee 5, got %v fee=%s", path, fee)
+	}
+	_, _, err = s.FindPath(alice.Address, carol.Address, tokenAddress, big.NewInt(150), false)
+	if err == nil {
+		t.Error("should have no path for amount larger than capacity")
+	}
+}
+
+func putPayload(t *testing.T, url string, payload interface{}) int {
+	statusCode, _, err := (&req{
+		FullURL: url,
+		Method:  http.MethodPut,
+		Payload: marshal(payload),
+		Timeout: time.Second * 10,
+	}).Invoke()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statusCode
+}
+
+func TestServerRejectOldNonce(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	alice := newTestAccount()
+	s := NewServer(dao, nil)
+	err := s.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	host := "http://" + s.Addr()
+
+	accountURL := host + "/pfs/1/account_rate/" + alice.Address.String()
+	fs1 := &setFeePayload{FeeConstant: big.NewInt(5)}
+	fs1.sign(alice.PrivateKey)
+	fs2 := &setFeePayload{FeeConstant: big.NewInt(6)}
+	fs2.sign(alice.PrivateKey)
+	if code := putPayload(t, accountURL, fs1); code != http.StatusOK {
+		t.Fatalf("set account fee status %d", code)
+	}
+	if code := putPayload(t, accountURL, fs2); code != http.StatusOK {
+		t.Fatalf("set account fee status %d", code)
+	}
+	for _, fs := range []*setFeePayload{fs1, fs2} {
+		if code := putPayload(t, accountURL, fs); code != http.StatusBadRequest {
+			t.Errorf("replayed fee setting should be rejected, status %d", code)
+		}
+	}
+
+	feeRateURL := host + "/pfs/1/feerate/" + alice.Address.String()
+	fp := models.NewDefaultFeePolicy()
+	fp.Sign(alice.PrivateKey, fs2.Nonce)
+	if code := putPayload(t, feeRateURL, fp); code != http.StatusBadRequest {
+		t.Errorf("fee policy with old nonce should be rejected, status %d", code)
+	}
+	fp.Sign(alice.PrivateKey, nextNonce())
+	if code := putPayload(t, feeRateURL, fp); code != http.StatusOK {
+		t.Fatalf("set fee policy status %d", code)
+	}
+	if code := putPayload(t, feeRateURL, fp); code != http.StatusBadRequest {
+		t.Errorf("replayed fee policy should be rejected, status %d", code)
+	}
+	if code := putPayload(t, accountURL, fs2); code != http.StatusBadRequest {
+		t.Errorf("fee setting older than the fee policy should be rejected, status %d", code)
+	}
+
+	swapRateURL := host + "/pfs/1/swap_rate/" + alice.Address.String()
+	sr := &models.SwapRate{
+		MarketMaker:     alice.Address,
+		FromToken:       utils.NewRandomAddress(),
+		ToToken:         utils.NewRandomAddress(),
+		RateNumerator:   big.NewInt(1),
+		RateDenominator: big.NewInt(1),
+	}
+	sr.Sign(alice.PrivateKey)
+	sr1 := &setSwapRatesPayload{Rates: []*models.SwapRate{sr}, Nonce: nextNonce()}
+	sr1.sign(alice.PrivateKey)
+	sr2 := &setSwapRatesPayload{Nonce: nextNonce()}
+	sr2.sign(alice.PrivateKey)
+	if code := putPayload(t, swapRateURL, &setSwapRatesPayload{Nonce: nextNonce()}); code != http.StatusBadRequest {
+		t.Errorf("unsigned swap rates should be rejected, status %d", code)
+	}
+	if code := putPayload(t, swapRateURL, sr1); code != http.StatusOK {
+		t.Fatalf("set swap rates status %d", code)
+	}
+	if code := putPayload(t, swapRateURL, sr2); code != http.StatusOK {
+		t.Fatalf("set swap rates status %d", code)
+	}
+	//the cleared rates can't be brought back by replaying the old submission
+	if code := putPayload(t, swapRateURL, sr1); code != http.StatusBadRequest {
+		t.Errorf("replayed swap rates should be rejected, status %d", code)
+	}
+	rates, err := dao.GetPfsSwapRates(sr.FromToken, sr.ToToken)
+	if err != nil || len(rates) != 0 {
+		t.Errorf("expect no swap rates, got %v err %v", rates, err)
+	}
+}
+
+func TestBuildGraphQueryDepositWithoutLock(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	tokenAddress := utils.NewRandomAddress()
+	alice, bob := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch := utils.CalcChannelID(tokenAddress, utils.EmptyAddress, alice, bob)
+	err := dao.NewNonParticipantChannel(tokenAddress, ch, alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *Server
+	queried := 0
+	s = NewServer(dao, func(token, participant, partner common.Address) (*big.Int, error) {
+		queried++
+		locked := make(chan struct{})
+		go func() {
+			s.lock.Lock()
+			s.lock.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(time.Second):
+			t.Error("deposit should be queried without holding the lock")
+		}
+		return big.NewInt(100), nil
+	})
+	path, _, err := s.FindPath(alice, bob, tokenAddress, big.NewInt(50), false)
+	if err != nil || len(path) != 1 || path[0] != bob {
+		t.Fatalf("expect path to bob, got %v err %v", path, err)
+	}
+	_, _, err = s.FindPath(bob, alice, tokenAddress, big.NewInt(50), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queried != 2 {
+		t.Errorf("deposits should be queried once per participant, queried %d times", queried)
+	}
+}
@@ -144,7 +144,14 @@ type Service struct {
 	EthConnectionStatus                   chan netshare.Status
 	ChanHistoryContractEventsDealComplete chan struct{}
 	BuildInfo                             *BuildInfo
-	ChanSubmitBalanceProofToPFS           chan *channel.Channel // 供submitBalanceProofToPfsLoop线程使用
+	ChanSubmitBalanceProofToPFS           chan *channel.Channel         // 供submitBalanceProofToPfsLoop线程使用
+	Key2EscrowHold                        map[string]*models.EscrowHold //尚未处理完毕的托管支付
+	ExpirationPolicy                      *ExpirationPolicy             //根据链上状况动态计算锁过期时间和 reveal timeout
+	PfsServer                             *pfsproxy.Server              //内置的 pfs 服务,没有启用时为 nil
+	MissionControl                        *MissionControl               //根据交易结果估计通道容量和节点可靠性,用于选择路由
+	Watchtower                            *Watchtower                   //替手机节点看守通道,没有启用时为 nil
+	WatchtowerPusher                      *WatchtowerPusher             //把我的通道推送给 watchtower,没有配置时为 nil
+	SettleScheduler                       *SettleScheduler              //通道关闭以后自动 unlock 和 settle,没有启用时为 nil
 }
 
 //NewPhotonService create photon service
@@ -179,6 +186,21 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 		ChanHistoryContractEventsDealComplete: make(chan struct{}),
 		BuildInfo:                             new(BuildInfo),
 		ChanSubmitBalanceProofToPFS:           make(chan *channel.Channel, 100),
+		Key2EscrowHold:                        make(map[string]*models.EscrowHold),
+		ExpirationPolicy:                      NewExpirationPolicy(dao, config.RevealTimeout),
+	}
+	rs.MissionControl = NewMissionControl(dao)
+	if config.EnableWatchtower {
+		rs.Watchtower = newWatchtower(rs)
+	}
+	if len(config.Watchtowers) > 0 {
+		rs.WatchtowerPusher, err = newWatchtowerPusher(rs, config.Watchtowers)
+		if err != nil {
+			return
+		}
+	}
+	if config.AutoSettle {
+		rs.SettleScheduler = newSettleScheduler(rs, config.AutoUnlockMinAmount)
 	}
 	rs.BlockNumber.Store(int64(0))
 	rs.MessageHandler = newPhotonMessageHandler(rs)
@@ -208,12 +230,20 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 	if err != nil {
 		return
 	}
+	err = rs.loadEscrowHolds()
+	if err != nil {
+		return
+	}
 	rs.BlockChainEvents = blockchain.NewBlockChainEvents(chain.Client, chain, rs.dao)
+	// built-in pfs server
+	if config.PfsServerListen != "" {
+		rs.PfsServer = pfsproxy.NewServer(dao, rs.getChannelDepositOnChain)
+	}
 	// fee module
 	if config.EnableMediationFee {
 		// pathfinder
 		if config.PfsHost != "" {
-			rs.PfsProxy = pfsproxy.NewPfsProxy(config.PfsHost, rs.PrivateKey)
+			rs.PfsProxy = pfsproxy.NewResilientPfsProxy(config.PfsHost, rs.PrivateKey, dao)
 		}
 		rs.FeePolicy, err = NewFeeModule(dao, rs.PfsProxy)
 		if err != nil {
@@ -240,6 +270,12 @@ func (rs *Service) Start() (err error) {
 	*/
 	n := rs.dao.GetLatestBlockNumber()
 	rs.BlockNumber.Store(n)
+	if rs.PfsServer != nil {
+		err = rs.PfsServer.Start(rs.Config.PfsServerListen)
+		if err != nil {
+			return
+		}
+	}
 	err = rs.registerRegistry()
 	if err != nil {
 		return
@@ -248,6 +284,10 @@ func (rs *Service) Start() (err error) {
 	rs.Protocol.Start(false)
 	//restore 一定要在历史事件处理之前进行,比如链上注册密码事件,需要相应的statemanager发送unlock消息
 	rs.restore()
+	//watchtower 要在主循环之前启动,否则历史事件会阻塞主循环
+	if rs.Watchtower != nil {
+		go rs.Watchtower.loop()
+	}
 	go func() {
 		if rs.Config.ConditionQuit.RandomQuit {
 			go func() {
@@ -307,7 +347,15 @@ func (rs *Service) Start() (err error) {
 	/*
 		启动定时提交balance_proof到pfs的线程
 	*/
+	if p, ok := rs.PfsProxy.(*pfsproxy.ResilientPfsProxy); ok {
+		p.Start()
+	}
 	go rs.submitBalanceProofToPfsLoop()
+	go rs.refreshDynamicFeeLoop()
+	go rs.autoRebalanceLoop()
+	if rs.WatchtowerPusher != nil {
+		go rs.WatchtowerPusher.loop()
+	}
 	//
 	rs.isStarting = false
 	rs.startNeighboursHealthCheck()
@@ -471,6 +519,12 @@ func CalculateFee(feeSetting int64, amount *big.Int) *big.Int {
 func (rs *Service) Stop() {
 	log.Info("photon service stop...")
 	close(rs.quitChan)
+	if rs.PfsServer != nil {
+		rs.PfsServer.Stop()
+	}
+	if p, ok := rs.PfsProxy.(*pfsproxy.ResilientPfsProxy); ok {
+		p.Stop()
+	}
 	rs.Protocol.StopAndWait()
 	rs.BlockChainEvents.Stop()
 	rs.Chain.Client.Close()
@@ -518,6 +572,9 @@ func (rs *Service) loop() {
 			// contract events from block chain
 		case st, ok = <-rs.BlockChainEvents.StateChangeChannel:
 			if ok {
+				if rs.Watchtower != nil {
+					rs.Watchtower.OnStateChange(st)
+				}
 				blockStateChange, ok2 := st.(*transfer.BlockStateChange)
 				if ok2 {
 					rs.handleBlockNumber(blockStateChange)
@@ -639,6 +696,11 @@ func (rs *Service) handleBlockNumber(st *transfer.BlockStateChange) {
 			}
 		}
 	}
+	rs.handleEscrowOnBlock(st.BlockNumber)
+	if rs.SettleScheduler != nil {
+		rs.SettleScheduler.onBlock(st.BlockNumber)
+	}
+	rs.ExpirationPolicy.OnNewBlock(st.BlockNumber)
 	rs.dao.SaveLatestBlockNumber(st.BlockNumber)
 	return
 }
@@ -913,7 +975,7 @@ Do a direct tranfer with target.
        are required to complete the transfer (from the payer's perspective),
        whereas the mediated transfer requires 6 messages.
 */
-func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amount *big.Int, data string) (result *utils.AsyncResult) {
+func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amount *big.Int, data, localData string) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
 	g := rs.getToken2ChannelGraph(tokenAddress)
 	if g == nil {
@@ -951,13 +1013,14 @@ func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amou
 		Data:              data,
 	}
 	/*
-		对于DirectTransfer,生成一个假的LockSecretHash,
-		用于发起方在这里记录发起的交易状态,后续UpdateTransferStatus会更新DB中的值
+		对于DirectTransfer,根据通道和 nonce 生成一个LockSecretHash,
+		用于发起方在这里记录发起的交易状态,后续UpdateTransferStatus会更新DB中的值,
+		接收方记录 ReceivedTransfer 时生成的值与此相同
 	*/
-	tr.FakeLockSecretHash = utils.NewRandomHash()
+	tr.FakeLockSecretHash = models.DirectTransferLockSecretHash(tr.ChannelIdentifier, tr.OpenBlockNumber, tr.Nonce)
 	log.Trace(fmt.Sprintf("send direct transfer, use fake lockSecertHash %s to trace transfer status", tr.FakeLockSecretHash.String()))
 	// 构造SentTransferDetail
-	rs.dao.NewSentTransferDetail(tokenAddress, target, amount, data, true, tr.FakeLockSecretHash)
+	rs.dao.NewSentTransferDetail(tokenAddress, target, amount, localData, true, tr.FakeLockSecretHash)
 	//rs.dao.NewTransferStatus(tokenAddress, tr.FakeLockSecretHash)
 	err = rs.sendAsync(directChannel.PartnerState.Address, tr)
 	if err != nil {
@@ -1010,6 +1073,7 @@ Calls:
  */
 func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Address, amount *big.Int, lockSecretHash common.Hash, expiration int64, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, stateManager *transfer.StateManager) {
 	var availableRoutes []*route.State
+	var hintRevealTimeout int
 	//var err error
 	//targetAmount := new(big.Int).Sub(amount, fee)
 	result = utils.NewAsyncResult()
@@ -1023,7 +1087,7 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 		// 当前为不支持收费的网络下时,使用本地路由
 		if rs.PfsProxy == nil {
 			log.Trace("get available routes without fee from local channel graph")
-			availableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, amount, graph.EmptyExlude, rs)
+			availableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, amount, graph.EmptyExlude, rs, rs.MissionControl)
 		} else {
 			log.Trace("get available routes to partner from local channel graph")
 			ch := rs.getChannel(tokenAddress, target)
@@ -1048,8 +1112,22 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 			r := route.NewState(ch, path.GetPath())
 			//r.Fee = rs.FeePolicy.GetNodeChargeFee(partnerAddress, tokenAddress, amount) // 发起方不收取手续费
 			r.TotalFee = path.Fee
+			if err := rs.setTrampolineRoute(r, target, path.GetTrampolines()); err != nil {
+				log.Warn(fmt.Sprintf("ignore route %s, %s", utils.StringInterface(path.Result, 2), err))
+				continue
+			}
+			// 路由提示中的 reveal timeout 不可信,锁放不进第一跳通道的 settle timeout 时放弃这条路由
+			if path.RevealTimeout > 0 && !rs.ExpirationPolicy.FitsSettleTimeout(routePathLength(r), path.RevealTimeout, ch.SettleTimeout) {
+				log.Warn(fmt.Sprintf("ignore route %s, reveal timeout %d of route hint is too large for settle timeout %d",
+					utils.StringInterface(path.Result, 2), path.RevealTimeout, ch.SettleTimeout))
+				continue
+			}
 			availableRoutes = append(availableRoutes, r)
+			if path.RevealTimeout > hintRevealTimeout {
+				hintRevealTimeout = path.RevealTimeout
+			}
 		}
+		rs.MissionControl.SortRoutes(tokenAddress, amount, availableRoutes)
 	}
 	log.Trace(fmt.Sprintf("availableRoutes=%s", utils.StringInterface(availableRoutes, 3)))
 	if len(availableRoutes) <= 0 {
@@ -1060,6 +1138,14 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 		result.Result <- rerr.ErrNotAllowMediatedTransfer
 		return
 	}
+	/*
+		调用者没有指定过期时间时,根据路径长度和链上状况计算
+	*/
+	// When caller doesn't specify expiration, compute it from path length and chain conditions.
+	pathLength := maxPathLength(availableRoutes)
+	if expiration == 0 {
+		expiration = rs.GetBlockNumber() + int64(rs.ExpirationPolicy.LockTimeoutWithRevealTimeout(pathLength, hintRevealTimeout))
+	}
 	/*
 		when user specified fee, for test or other purpose.
 	*/
@@ -1105,6 +1191,7 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 	}
 	rs.Transfer2StateManager[smkey] = stateManager
 	rs.Transfer2Result[smkey] = result
+	rs.saveExpirationRecord(models.ExpirationRoleInitiator, tokenAddress, lockSecretHash, pathLength, expiration, rs.ExpirationPolicy.RevealTimeout())
 	//rs.dao.AddStateManager(stateManager)
 	rs.StateMachineEventHandler.dispatch(stateManager, initInitiator)
 	return
@@ -1114,7 +1201,11 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 1. user start a mediated transfer
 2. user start a mediated transfer with secret
 */
-func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, secret common.Hash, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult) {
+/*
+startMediatedTransfer data 是发送出去的附加信息, localData 保存在本地的 SentTransferDetail 中,
+data 加密时 localData 是明文,因为发送方解不开发给 target 的密文
+*/
+func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, secret common.Hash, data, localData string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult) {
 	lockSecretHash := utils.EmptyHash
 	if secret != utils.EmptyHash {
 		lockSecretHash = utils.ShaSecret(secret.Bytes())
@@ -1143,7 +1234,7 @@ func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, am
 	/*
 		发起方在这里记录发起的交易状态,后续UpdateTransferStatus会更新DB中的值
 	*/
-	rs.dao.NewSentTransferDetail(tokenAddress, target, amount, data, false, lockSecretHash)
+	rs.dao.NewSentTransferDetail(tokenAddress, target, amount, localData, false, lockSecretHash)
 	//rs.dao.NewTransferStatus(tokenAddress, lockSecretHash)
 	result, _ = rs.startMediatedTransferInternal(tokenAddress, target, amount, lockSecretHash, 0, secret, data, routeInfo)
 	result.LockSecretHash = lockSecretHash
@@ -1203,7 +1294,7 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 			}
 			exclude := graph.MakeExclude(msg.Sender, msg.Initiator)
 			g := rs.getToken2ChannelGraph(ch.TokenAddress) //must exist
-			avaiableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, msg.Target, amount, msg.PaymentAmount, exclude, rs)
+			avaiableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, msg.Target, amount, msg.PaymentAmount, exclude, rs, rs.MissionControl)
 		} else {
 			// 获取下一跳的通道
 			myIndexInPath := -1
@@ -1213,16 +1304,29 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 					break
 				}
 			}
-			if myIndexInPath == -1 {
+			if myIndexInPath == len(msg.Path)-1 && len(msg.TrampolineOnion) > 0 {
+				// 我是 trampoline,自己计算剩下的路由,找不到的话 mediator 会把交易退回
+				avaiableRoutes = rs.trampolineRoutes(msg, ch)
+			} else if myIndexInPath == -1 || myIndexInPath == len(msg.Path)-1 {
 				log.Error("can not found myself in msg.Path")
 				return
+			} else {
+				nextChan := rs.getChannel(ch.TokenAddress, msg.Path[myIndexInPath+1])
+				if nextChan == nil {
+					// 没有可用的路由,mediator 会把交易退回
+					log.Error(fmt.Sprintf("no channel with next hop %s in msg.Path", utils.APex2(msg.Path[myIndexInPath+1])))
+				} else if len(msg.TrampolineOnion) > 0 && !rs.Protocol.SupportsTrampoline(nextChan.PartnerState.Address) {
+					// 下一跳处理不了 trampoline 洋葱,mediator 会把交易退回
+					log.Warn(fmt.Sprintf("next hop %s can not handle trampoline onion", utils.APex2(nextChan.PartnerState.Address)))
+				} else {
+					// 构造路由,手续费根据TargetAmount在下家通道中的费率计算
+					availableRoute := route.NewState(nextChan, msg.Path)
+					targetAmount := new(big.Int).Sub(msg.PaymentAmount, msg.Fee)
+					availableRoute.Fee = rs.FeePolicy.GetNodeChargeFee(nextChan.PartnerState.Address, nextChan.TokenAddress, targetAmount)
+					availableRoute.TrampolineOnion = msg.TrampolineOnion
+					avaiableRoutes = append(avaiableRoutes, availableRoute)
+				}
 			}
-			nextChan := rs.getChannel(ch.TokenAddress, msg.Path[myIndexInPath+1])
-			// 构造路由,手续费根据TargetAmount在下家通道中的费率计算
-			availableRoute := route.NewState(nextChan, msg.Path)
-			targetAmount := new(big.Int).Sub(msg.PaymentAmount, msg.Fee)
-			availableRoute.Fee = rs.FeePolicy.GetNodeChargeFee(nextChan.PartnerState.Address, nextChan.TokenAddress, targetAmount)
-			avaiableRoutes = append(avaiableRoutes, availableRoute)
 		}
 
 		//ourAddress := rs.NodeAddress
@@ -1239,6 +1343,8 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 		//	//log.Trace(fmt.Sprintf("g=%s", utils.StringInterface(g, 7)))
 		//	avaiableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, targetAddr, amount, targetAmount, exclude, rs)
 		//}
+		revealTimeout := rs.applyRevealTimeout(append(avaiableRoutes, fromRoute)...)
+		rs.saveExpirationRecord(models.ExpirationRoleMediator, tokenAddress, msg.LockSecretHash, hopsAfter(msg.Path, rs.NodeAddress), msg.Expiration, revealTimeout)
 		routesState := route.NewRoutesState(avaiableRoutes)
 		blockNumber := rs.GetBlockNumber()
 		initMediator := &mediatedtransfer.ActionInitMediatorStateChange{
@@ -1260,6 +1366,10 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 //receive a MediatedTransfer, i'm the target
 func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *channel.Channel) {
 	smkey := utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:])
+	if msg.Initiator == rs.NodeAddress {
+		//再平衡,我同时是发起方
+		smkey = circularTargetKey(smkey)
+	}
 	stateManager := rs.Transfer2StateManager[smkey]
 	/*
 		第一次收到这个密码,
@@ -1293,6 +1403,8 @@ func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *ch
 		return
 	}
 	fromRoute := graph.Channel2RouteState(fromChannel, msg.Sender, msg.PaymentAmount, rs, msg.Path)
+	revealTimeout := rs.applyRevealTimeout(fromRoute)
+	rs.saveExpirationRecord(models.ExpirationRoleTarget, ch.TokenAddress, msg.LockSecretHash, 0, msg.Expiration, revealTimeout)
 	fromTransfer := mediatedtransfer.LockedTransferFromMessage(msg, ch.TokenAddress)
 	initTarget := &mediatedtransfer.ActionInitTargetStateChange{
 		OurAddress:  rs.NodeAddress,
@@ -1582,6 +1694,17 @@ process user's token swap maker request
 todo? save and restore
 */
 func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResult) {
+	rs.listenTokenSwapMaker(tokenswap)
+	routeInfo := rs.getTokenSwapRouteInfo(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, tokenswap.RouteInfo)
+	result, _ = rs.startMediatedTransferInternal(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, tokenswap.LockSecretHash, 0, tokenswap.Secret, "", routeInfo)
+	return
+}
+
+/*
+listenTokenSwapMaker 收到 taker 的交易以后才允许发送密码.
+两笔交易都可能经过中间节点, PaymentAmount 包含剩余的手续费, 扣除以后必须和报价完全一致
+*/
+func (rs *Service) listenTokenSwapMaker(tokenswap *TokenSwap) {
 	var lockSecretHash common.Hash
 	var hasReceiveTakerMediatedTransfer bool
 	var sentMtrHook SentMediatedTransferListener
@@ -1600,7 +1723,7 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 		return false
 	}
 	sentMtrHook = func(mtr *encoding.MediatedTransfer) (remove bool) {
-		if mtr.LockSecretHash == tokenswap.LockSecretHash && rs.getTokenForChannelIdentifier(mtr.ChannelIdentifier) == tokenswap.FromToken && mtr.Target == tokenswap.ToNodeAddress && mtr.PaymentAmount.Cmp(tokenswap.FromAmount) == 0 {
+		if mtr.LockSecretHash == tokenswap.LockSecretHash && rs.getTokenForChannelIdentifier(mtr.ChannelIdentifier) == tokenswap.FromToken && mtr.Target == tokenswap.ToNodeAddress && tokenSwapTargetAmount(mtr).Cmp(tokenswap.FromAmount) == 0 {
 			if lockSecretHash != utils.EmptyHash {
 				log.Info(fmt.Sprintf("tokenswap maker select new path ,because of different hash lock"))
 				delete(rs.SecretRequestPredictorMap, lockSecretHash) //old hashlock is invalid,just  remove
@@ -1614,7 +1737,7 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 		/*
 			recevive taker's mediated transfer , the transfer must use argument of tokenswap and have the same hashlock
 		*/
-		if mtr.LockSecretHash == tokenswap.LockSecretHash && lockSecretHash == mtr.LockSecretHash && rs.getTokenForChannelIdentifier(mtr.ChannelIdentifier) == tokenswap.ToToken && mtr.Target == tokenswap.FromNodeAddress && mtr.PaymentAmount.Cmp(tokenswap.ToAmount) == 0 {
+		if mtr.LockSecretHash == tokenswap.LockSecretHash && lockSecretHash == mtr.LockSecretHash && rs.getTokenForChannelIdentifier(mtr.ChannelIdentifier) == tokenswap.ToToken && mtr.Target == tokenswap.FromNodeAddress && tokenSwapTargetAmount(mtr).Cmp(tokenswap.ToAmount) == 0 {
 			hasReceiveTakerMediatedTransfer = true
 			delete(rs.SentMediatedTransferListenerMap, &sentMtrHook)
 			return true
@@ -1623,8 +1746,6 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 	}
 	rs.SentMediatedTransferListenerMap[&sentMtrHook] = true
 	rs.ReceivedMediatedTrasnferListenerMap[&receiveMtrHook] = true
-	result, _ = rs.startMediatedTransferInternal(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, tokenswap.LockSecretHash, 0, tokenswap.Secret, "", tokenswap.RouteInfo)
-	return
 }
 
 /*
@@ -1636,7 +1757,7 @@ func (rs *Service) messageTokenSwapTaker(msg *encoding.MediatedTransfer, tokensw
 	var hasReceiveRevealSecret bool
 	var stateManager *transfer.StateManager
 	if msg.LockSecretHash != tokenswap.LockSecretHash ||
-		msg.PaymentAmount.Cmp(tokenswap.FromAmount) != 0 ||
+		tokenSwapTargetAmount(msg).Cmp(tokenswap.FromAmount) != 0 ||
 		msg.Initiator != tokenswap.FromNodeAddress ||
 		rs.getTokenForChannelIdentifier(msg.ChannelIdentifier) != tokenswap.FromToken ||
 		msg.Target != tokenswap.ToNodeAddress {
@@ -1677,7 +1798,8 @@ func (rs *Service) messageTokenSwapTaker(msg *encoding.MediatedTransfer, tokensw
 		taker and maker may have direct channels on these two tokens.
 	*/
 	takerExpiration := msg.Expiration - int64(rs.Config.RevealTimeout)
-	result, stateManager := rs.startMediatedTransferInternal(tokenswap.ToToken, tokenswap.FromNodeAddress, tokenswap.ToAmount, tokenswap.LockSecretHash, takerExpiration, utils.EmptyHash, "", tokenswap.RouteInfo)
+	routeInfo := rs.getTokenSwapRouteInfo(tokenswap.ToToken, tokenswap.FromNodeAddress, tokenswap.ToAmount, tokenswap.RouteInfo)
+	result, stateManager := rs.startMediatedTransferInternal(tokenswap.ToToken, tokenswap.FromNodeAddress, tokenswap.ToAmount, tokenswap.LockSecretHash, takerExpiration, utils.EmptyHash, "", routeInfo)
 	if stateManager == nil {
 		log.Error(fmt.Sprintf("taker tokenwap error %s", <-result.Result))
 		return false
@@ -1687,6 +1809,46 @@ func (rs *Service) messageTokenSwapTaker(msg *encoding.MediatedTransfer, tokensw
 	return true
 }
 
+/*
+getTokenSwapRouteInfo 用户没有指定路由的时候,向PFS查询路由,这样 token swap 的两笔交易都可以经过中间节点.
+没有PFS时,startMediatedTransferInternal 会使用本地的 ChannelGraph 查找路由
+*/
+func (rs *Service) getTokenSwapRouteInfo(token, target common.Address, amount *big.Int, routeInfo []pfsproxy.FindPathResponse) []pfsproxy.FindPathResponse {
+	if len(routeInfo) > 0 || rs.PfsProxy == nil {
+		return routeInfo
+	}
+	paths, err := rs.PfsProxy.FindPath(rs.NodeAddress, target, token, amount, true)
+	if err != nil {
+		log.Warn(fmt.Sprintf("token swap find path from pfs err %s, only direct channel can be used", err))
+		return routeInfo
+	}
+	return paths
+}
+
+/*
+submitSwapRatesToPFS 做市商向PFS公布自己的兑换价格
+*/
+func (rs *Service) submitSwapRatesToPFS() (err error) {
+	if rs.PfsProxy == nil {
+		return
+	}
+	rates, err := rs.dao.GetSwapRateList()
+	if err != nil {
+		return
+	}
+	return rs.PfsProxy.SetSwapRates(rates)
+}
+
+/*
+tokenSwapTargetAmount 交易经过中间节点时, PaymentAmount 包含剩余的手续费, 兑换的数量必须和报价完全一致
+*/
+func tokenSwapTargetAmount(mtr *encoding.MediatedTransfer) *big.Int {
+	if mtr.Fee == nil {
+		return mtr.PaymentAmount
+	}
+	return new(big.Int).Sub(mtr.PaymentAmount, mtr.Fee)
+}
+
 /*
 process taker's token swap
 only mark, if i receive a valid mediated transfer, then start token swap
@@ -1697,7 +1859,6 @@ func (rs *Service) tokenSwapTaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 	key := swapKey{
 		LockSecretHash: tokenswap.LockSecretHash,
 		FromToken:      tokenswap.FromToken,
-		FromAmount:     tokenswap.FromAmount.String(),
 	}
 	rs.SwapKey2TokenSwap[key] = tokenswap
 	return
@@ -1849,6 +2010,11 @@ func (rs *Service) handleEthRPCConnectionOK() {
 			log.Error(fmt.Sprintf("set fee policy to pfs err =%s", err.Error()))
 		}
 	}
+	// 重连时上传 token swap 报价给PFS
+	err = rs.submitSwapRatesToPFS()
+	if err != nil {
+		log.Error(fmt.Sprintf("set swap rates to pfs err =%s", err.Error()))
+	}
 	// 重连或启动时，刷新所有通道状态信息到pfs
 	for _, cg := range rs.Token2ChannelGraph {
 		for _, ch := range cg.ChannelIdentifier2Channel {
@@ -1868,9 +2034,9 @@ func (rs *Service) handleReq(req *apiReq) {
 	case transferReqName: //mediated transfer only
 		r := req.Req.(*transferReq)
 		if r.IsDirectTransfer {
-			result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount, r.Data)
+			result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount, r.Data, r.LocalData)
 		} else {
-			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Secret, r.Data, r.RouteInfo)
+			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Secret, r.Data, r.LocalData, r.RouteInfo)
 		}
 	case newChannelReqName:
 		r := req.Req.(*newChannelReq)
@@ -1927,6 +2093,30 @@ func (rs *Service) handleReq(req *apiReq) {
 	case forceUnlockReqName:
 		r := req.Req.(*forceUnlockReq)
 		result = rs.forceUnlock(r)
+	case newEscrowReqName:
+		r := req.Req.(*escrowReq)
+		result = rs.newEscrow(r)
+	case approveEscrowReqName:
+		r := req.Req.(*escrowReq)
+		result = rs.approveEscrow(r)
+	case cancelEscrowReqName:
+		r := req.Req.(*escrowReq)
+		result = rs.cancelEscrow(r)
+	case escrowWebhookReqName:
+		r := req.Req.(*escrowReq)
+		result = rs.escrowWebhook(r)
+	case refundReqName:
+		r := req.Req.(*refundReq)
+		result = rs.refund(r)
+	case rebalanceReqName:
+		r := req.Req.(*rebalanceReq)
+		result = rs.rebalance(r)
+	case quoteTransferReqName:
+		r := req.Req.(*quoteTransferReq)
+		result = rs.quoteTransfer(r)
+	case batchOpenChannelReqName:
+		r := req.Req.(*batchOpenChannelReq)
+		result = rs.batchOpenChannels(r)
 	default:
 		panic("unkown req")
 	}
@@ -1955,18 +2145,30 @@ func (rs *Service) UpdateChannelAndSaveAck(c *channel.Channel, tag interface{})
 	if err != nil {
 		log.Error(fmt.Sprintf("UpdateChannelAndSaveAck %s", err))
 	}
+	rs.notifyWatchtowerPusher(cs)
+}
+
+//notifyWatchtowerPusher 对方的 balance proof 可能变化了,需要推送给 watchtower
+func (rs *Service) notifyWatchtowerPusher(c *channeltype.Serialization) {
+	if rs.WatchtowerPusher != nil {
+		rs.WatchtowerPusher.Notify(c.ChannelIdentifier.ChannelIdentifier)
+	}
 }
 
 //UpdateChannel 数据库中更新通道状态,同时通知App
 func (rs *Service) UpdateChannel(c *channeltype.Serialization, tx models.TX) error {
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(c))
-	return rs.dao.UpdateChannel(c, tx)
+	err := rs.dao.UpdateChannel(c, tx)
+	rs.notifyWatchtowerPusher(c)
+	return err
 }
 
 //UpdateChannelNoTx  数据库更新,同时通知App,与updateChannelState的区别就在于回调函数的
 func (rs *Service) UpdateChannelNoTx(c *channeltype.Serialization) error {
 	rs.NotifyHandler.NotifyChannelStatus(channeltype.ChannelSerialization2ChannelDataDetail(c))
-	return rs.dao.UpdateChannelNoTx(c)
+	err := rs.dao.UpdateChannelNoTx(c)
+	rs.notifyWatchtowerPusher(c)
+	return err
 }
 
 //UpdateChannelState 数据库更新,同时通知app
@@ -2022,6 +2224,29 @@ func (rs *Service) findAllChannelsByLockSecretHash(lockSecretHash common.Hash) (
 	return
 }
 
+/*
+refreshDynamicFeeLoop 启用动态收费时,定时检查实际收费是否变化,变化后重新提交给 pfs
+*/
+func (rs *Service) refreshDynamicFeeLoop() {
+	fm, ok := rs.FeePolicy.(*FeeModule)
+	if !ok || rs.PfsProxy == nil {
+		return
+	}
+	ticker := time.NewTicker(dynamicFeeRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := fm.RefreshDynamicFee()
+			if err != nil {
+				log.Warn(fmt.Sprintf("refresh dynamic fee to pfs err %s", err))
+			}
+		case <-rs.quitChan:
+			return
+		}
+	}
+}
+
 func (rs *Service) submitBalanceProofToPfsLoop() {
 	log.Trace("submitBalanceProofToPfsLoop start...")
 	for {
@@ -2047,24 +2272,9 @@ func (rs *Service) submitBalanceProofToPfsLoop() {
 			bpPartner.Signature,
 		)
 		if err == pfsproxy.ErrConnect {
-			log.Warn(fmt.Sprintf("connect to pfs err when submit BalanceProof of channel %s, retry 3 times...", ch.ChannelIdentifier.ChannelIdentifier.String()))
-			// 网络错误,重发3次
-			for i := 0; i < 3; i++ {
-				err = rs.PfsProxy.SubmitBalance(
-					bpPartner.Nonce,
-					bpPartner.TransferAmount,
-					ch.Outstanding(),
-					ch.ChannelIdentifier.OpenBlockNumber,
-					bpPartner.LocksRoot,
-					ch.ChannelIdentifier.ChannelIdentifier,
-					bpPartner.MessageHash,
-					ch.PartnerState.Address,
-					bpPartner.Signature,
-				)
-				if err == pfsproxy.ErrConnect {
-					continue
-				}
-			}
+			// 已经保存,pfs 恢复以后会重新提交
+			log.Warn(fmt.Sprintf("no pfs available when submit BalanceProof of channel %s, it will be replayed later", ch.ChannelIdentifier.ChannelIdentifier.String()))
+			continue
 		}
 		if err != nil {
 			log.Error(err.Error())
@@ -2098,6 +2308,16 @@ func (rs *Service) submitBalanceProofToPfs(ch *channel.Channel) {
 	//}
 }
 
+//getChannelDepositOnChain for built-in pfs server, which doesn't know deposits of channels opened before it starts
+func (rs *Service) getChannelDepositOnChain(tokenAddress, participant, partner common.Address) (deposit *big.Int, err error) {
+	tokenNetwork, err := rs.Chain.TokenNetwork(tokenAddress)
+	if err != nil {
+		return
+	}
+	deposit, _, _, err = tokenNetwork.GetChannelParticipantInfo(participant, partner)
+	return
+}
+
 func (rs *Service) getBestRoutesFromPfs(peerFrom, peerTo, token common.Address, amount *big.Int, isInitiator bool) (routes []*route.State, err error) {
 	var paths []pfsproxy.FindPathResponse
 	paths, err = rs.PfsProxy.FindPath(peerFrom, peerTo, token, amount, isInitiator)
@@ -2118,6 +2338,8 @@ func (rs *Service) getBestRoutesFromPfs(peerFrom, peerTo, token common.Address,
 		r.TotalFee = path.Fee
 		routes = append(routes, r)
 	}
+	//pfs 不知道哪些路由最近失败过
+	rs.MissionControl.SortRoutes(token, amount, routes)
 	return
 }
 func (rs *Service) forceUnlock(req *forceUnlockReq) (result *utils.AsyncResult) {
@@ -23,6 +23,7 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/models"
 	"github.com/MetaLife-Protocol/SuperNode/network"
+	"github.com/MetaLife-Protocol/SuperNode/network/helper"
 	"github.com/MetaLife-Protocol/SuperNode/network/netshare"
 	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
 	"github.com/MetaLife-Protocol/SuperNode/rerr"
@@ -141,6 +142,30 @@ func (r *API) DepositAndOpenChannel(tokenAddress, partnerAddress common.Address,
 	return
 }
 
+/*
+BatchOpenChannels 一次和多个节点创建通道并存款,每个通道的参数单独检查,有错误的通道在结果中报告,其他通道照常提交.
+和 DepositAndOpenChannel 一样不等待交易打包,之后按每个通道的 channel identifier 查询 TXInfo
+*/
+func (r *API) BatchOpenChannels(tokenAddress common.Address, entries []*BatchOpenEntry) (results []*BatchOpenResult, err error) {
+	if len(entries) == 0 {
+		err = rerr.ErrArgumentError.Append("no channel to open")
+		return
+	}
+	if err = r.checkSmcStatus(); err != nil {
+		return
+	}
+	result := r.Photon.batchOpenChannelClient(&batchOpenChannelReq{
+		TokenAddress: tokenAddress,
+		Entries:      entries,
+	})
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	results = result.Tag.([]*BatchOpenResult)
+	return
+}
+
 /*
 TokenSwapAndWait Start an atomic swap operation by sending a MediatedTransfer with
     `maker_amount` of `maker_token` to `taker_address`. Only proceed when a
@@ -219,6 +244,168 @@ func (r *API) ExpectTokenSwap(lockSecretHash string, makerToken, takerToken, mak
 	return nil
 }
 
+// SetSwapRate 做市商设置兑换价格,保存以后重新提交全部价格给PFS,提交失败时恢复原来的价格
+func (r *API) SetSwapRate(sr *models.SwapRate) (err error) {
+	if sr.FromToken == sr.ToToken {
+		return rerr.ErrArgumentError.Append("from_token and to_token must be different")
+	}
+	if sr.RateNumerator == nil || sr.RateNumerator.Sign() <= 0 || sr.RateDenominator == nil || sr.RateDenominator.Sign() <= 0 {
+		return rerr.ErrArgumentError.Append("rate_numerator and rate_denominator must be positive")
+	}
+	if sr.MinAmount != nil && sr.MaxAmount != nil && sr.MaxAmount.Sign() > 0 && sr.MinAmount.Cmp(sr.MaxAmount) > 0 {
+		return rerr.ErrArgumentError.Append("min_amount is bigger than max_amount")
+	}
+	sr.MarketMaker = r.Photon.NodeAddress
+	sr.Key = models.SwapRateKey(sr.FromToken, sr.ToToken)
+	old, err := r.getSwapRate(sr.FromToken, sr.ToToken)
+	if err != nil {
+		return
+	}
+	err = r.Photon.dao.SaveSwapRate(sr)
+	if err != nil {
+		return
+	}
+	err = r.Photon.submitSwapRatesToPFS()
+	if err != nil {
+		r.restoreSwapRate(sr.FromToken, sr.ToToken, old)
+	}
+	return
+}
+
+// RemoveSwapRate 不再提供该兑换,提交PFS失败时恢复该价格
+func (r *API) RemoveSwapRate(fromToken, toToken common.Address) (err error) {
+	old, err := r.getSwapRate(fromToken, toToken)
+	if err != nil {
+		return
+	}
+	err = r.Photon.dao.RemoveSwapRate(fromToken, toToken)
+	if err != nil {
+		return
+	}
+	err = r.Photon.submitSwapRatesToPFS()
+	if err != nil {
+		r.restoreSwapRate(fromToken, toToken, old)
+	}
+	return
+}
+
+func (r *API) getSwapRate(fromToken, toToken common.Address) (sr *models.SwapRate, err error) {
+	list, err := r.Photon.dao.GetSwapRateList()
+	if err != nil {
+		return
+	}
+	key := models.SwapRateKey(fromToken, toToken)
+	for _, s := range list {
+		if s.Key == key {
+			return s, nil
+		}
+	}
+	return
+}
+
+//restoreSwapRate PFS 没有接受新的价格,本地也不能改变,old 为 nil 说明原来没有该兑换
+func (r *API) restoreSwapRate(fromToken, toToken common.Address, old *models.SwapRate) {
+	var err error
+	if old == nil {
+		err = r.Photon.dao.RemoveSwapRate(fromToken, toToken)
+	} else {
+		err = r.Photon.dao.SaveSwapRate(old)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("restore swap rate %s->%s err %s", utils.APex2(fromToken), utils.APex2(toToken), err))
+	}
+}
+
+// GetSwapRates 本节点作为做市商公布的全部兑换价格
+func (r *API) GetSwapRates() ([]*models.SwapRate, error) {
+	return r.Photon.dao.GetSwapRateList()
+}
+
+// SwapQuote 一个做市商的报价, FromFee 是支付给做市商路由上的手续费, ToFee 为做市商付款路由上的手续费,由做市商承担
+type SwapQuote struct {
+	MarketMaker common.Address              `json:"market_maker"`
+	FromToken   common.Address              `json:"from_token"`
+	ToToken     common.Address              `json:"to_token"`
+	FromAmount  *big.Int                    `json:"from_amount"`
+	ToAmount    *big.Int                    `json:"to_amount"`
+	FromFee     *big.Int                    `json:"from_fee"`
+	ToFee       *big.Int                    `json:"to_fee"`
+	RouteInfo   []pfsproxy.FindPathResponse `json:"route_info"` // 付款给做市商的路由,可以直接用于 token swap
+}
+
+func minFeePath(routes []pfsproxy.FindPathResponse) (fee *big.Int) {
+	for _, p := range routes {
+		if p.Fee == nil {
+			continue
+		}
+		if fee == nil || p.Fee.Cmp(fee) < 0 {
+			fee = p.Fee
+		}
+	}
+	if fee == nil {
+		fee = big.NewInt(0)
+	}
+	return
+}
+
+/*
+GetSwapQuote 从PFS获取做市商公布的价格,只返回双向都有路由的报价,按照实际到账数量从多到少排序
+*/
+func (r *API) GetSwapQuote(fromToken, toToken common.Address, fromAmount *big.Int) (quotes []*SwapQuote, err error) {
+	pfs := r.Photon.PfsProxy
+	if pfs == nil {
+		err = rerr.ErrPFSNotConfigured.Append("photon start without param '--pfs', can not get swap quote")
+		return
+	}
+	if fromAmount == nil || fromAmount.Sign() <= 0 {
+		err = rerr.ErrArgumentError.Append("amount must be positive")
+		return
+	}
+	rates, err := pfs.GetSwapRates(fromToken, toToken)
+	if err != nil {
+		return
+	}
+	quotes = []*SwapQuote{}
+	for _, sr := range rates {
+		if sr.MarketMaker == r.Photon.NodeAddress {
+			continue
+		}
+		toAmount, ok := sr.Quote(fromAmount)
+		if !ok {
+			continue
+		}
+		fromRoutes, err2 := pfs.FindPath(r.Photon.NodeAddress, sr.MarketMaker, fromToken, fromAmount, true)
+		if err2 != nil || len(fromRoutes) == 0 {
+			log.Info(fmt.Sprintf("no %s path to market maker %s, err=%v", utils.APex2(fromToken), utils.APex2(sr.MarketMaker), err2))
+			continue
+		}
+		toRoutes, err2 := pfs.FindPath(sr.MarketMaker, r.Photon.NodeAddress, toToken, toAmount, true)
+		if err2 != nil || len(toRoutes) == 0 {
+			log.Info(fmt.Sprintf("no %s path from market maker %s, err=%v", utils.APex2(toToken), utils.APex2(sr.MarketMaker), err2))
+			continue
+		}
+		quotes = append(quotes, &SwapQuote{
+			MarketMaker: sr.MarketMaker,
+			FromToken:   fromToken,
+			ToToken:     toToken,
+			FromAmount:  fromAmount,
+			ToAmount:    toAmount,
+			FromFee:     minFeePath(fromRoutes),
+			ToFee:       minFeePath(toRoutes),
+			RouteInfo:   fromRoutes,
+		})
+	}
+	//到账数量相同的时候,手续费少的优先
+	sort.SliceStable(quotes, func(i, j int) bool {
+		c := quotes[i].ToAmount.Cmp(quotes[j].ToAmount)
+		if c != 0 {
+			return c > 0
+		}
+		return quotes[i].FromFee.Cmp(quotes[j].FromFee) < 0
+	})
+	return
+}
+
 //GetNodeNetworkState Returns the currently network status of `node_address
 func (r *API) GetNodeNetworkState(nodeAddress common.Address) (deviceType string, isOnline bool) {
 	return r.Photon.Protocol.GetNetworkStatus(nodeAddress)
@@ -255,8 +442,8 @@ func (r *API) GetTokenTokenNetorks() (tokens []string) {
 }
 
 //Transfer transfer and wait
-func (r *API) Transfer(token common.Address, amount *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
-	result, err = r.TransferInternal(token, amount, target, secret, isDirectTransfer, data, routeInfo)
+func (r *API) Transfer(token common.Address, amount *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool, data string, isDataEncrypt bool, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
+	result, err = r.TransferInternal(token, amount, target, secret, isDirectTransfer, data, isDataEncrypt, routeInfo)
 	if err != nil {
 		return
 	}
@@ -274,8 +461,8 @@ func (r *API) Transfer(token common.Address, amount *big.Int, target common.Addr
 }
 
 // TransferAsync :
-func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
-	result, err = r.TransferInternal(tokenAddress, amount, target, secret, isDirectTransfer, data, routeInfo)
+func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, isDataEncrypt bool, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
+	result, err = r.TransferInternal(tokenAddress, amount, target, secret, isDirectTransfer, data, isDataEncrypt, routeInfo)
 	if err != nil {
 		return
 	}
@@ -288,14 +475,117 @@ func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, target
 	return result, err
 }
 
-//TransferInternal :
-func (r *API) TransferInternal(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
+/*
+TransferInternal :
+isDataEncrypt 为 true 时 data 用 target 的公钥加密后发送,本地的 SentTransferDetail 保存明文.
+长度限制针对的是实际发送的 data,加密增加 113 字节并且做了 base64 编码,明文最多 74 字节
+*/
+func (r *API) TransferInternal(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, isDataEncrypt bool, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
 	log.Debug(fmt.Sprintf("initiating transfer initiator=%s target=%s token=%s amount=%d secret=%s,currentblock=%d",
 		r.Photon.NodeAddress.String(), target.String(), tokenAddress.String(), amount, secret.String(), r.Photon.GetBlockNumber()))
-	result = r.Photon.transferAsyncClient(tokenAddress, amount, target, secret, isDirectTransfer, data, routeInfo)
+	localData := data
+	if isDataEncrypt {
+		data, err = r.EncryptTransferData(target, data)
+		if err != nil {
+			return
+		}
+	}
+	if len(data) > params.MaxTransferDataLen {
+		err = rerr.ErrArgumentError.Append(fmt.Sprintf("Invalid data, length %d of sent data must < %d", len(data), params.MaxTransferDataLen))
+		return
+	}
+	result = r.Photon.transferAsyncClient(tokenAddress, amount, target, secret, isDirectTransfer, data, localData, routeInfo)
+	return
+}
+
+/*
+EncryptTransferData :
+用 target 的公钥对交易附加信息做 ECIES 加密,中间节点只能看到密文,由 target 收到后自动解密.
+target 的公钥从它发给我们的签名消息中恢复,如果从未收到过 target 的消息,则无法加密.
+*/
+func (r *API) EncryptTransferData(target common.Address, data string) (encdata string, err error) {
+	if len(data) == 0 {
+		return
+	}
+	pubkey, err := r.Photon.dao.GetNodePubKey(target)
+	if err != nil {
+		err = rerr.ErrUnknownAddress.Append(fmt.Sprintf("public key of %s is unknown, cannot encrypt data", target.String()))
+		return
+	}
+	encdata, err = utils.EncryptData(pubkey, data)
+	if err != nil {
+		err = rerr.ErrArgumentError.AppendError(err)
+	}
 	return
 }
 
+// Refund : 把收到的交易 lockSecretHash 的部分或全部金额退还给原发起方,返回退款交易的 LockSecretHash
+func (r *API) Refund(tokenAddress common.Address, lockSecretHash common.Hash, amount *big.Int, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) (result *utils.AsyncResult, err error) {
+	result = r.Photon.refundClient(&refundReq{
+		TokenAddress:     tokenAddress,
+		LockSecretHash:   lockSecretHash,
+		Amount:           amount,
+		IsDirectTransfer: isDirectTransfer,
+		Data:             data,
+		RouteInfo:        routeInfo,
+	})
+	timeoutCh := time.After(300 * time.Millisecond)
+	select {
+	case <-timeoutCh:
+		return result, nil
+	case err = <-result.Result:
+	}
+	return result, err
+}
+
+// Rebalance : 通过一笔发给自己的交易,把 outPartner 通道中我方的余额转移到 inPartner 通道,手续费不超过 maxFee
+func (r *API) Rebalance(tokenAddress, outPartner, inPartner common.Address, amount, maxFee *big.Int) (result *utils.AsyncResult, err error) {
+	result = r.Photon.rebalanceClient(&rebalanceReq{
+		TokenAddress: tokenAddress,
+		OutPartner:   outPartner,
+		InPartner:    inPartner,
+		Amount:       amount,
+		MaxFee:       maxFee,
+	})
+	timeoutCh := time.After(300 * time.Millisecond)
+	select {
+	case <-timeoutCh:
+		return result, nil
+	case err = <-result.Result:
+	}
+	return result, err
+}
+
+//RefundInfo refunds of a received transfer
+type RefundInfo struct {
+	Original       *models.ReceivedTransfer     `json:"original"`
+	RefundedAmount *big.Int                     `json:"refunded_amount"`
+	Refunds        []*models.SentTransferDetail `json:"refunds"`
+}
+
+// GetRefundInfo : 查询收到的交易 lockSecretHash 的退款情况
+func (r *API) GetRefundInfo(tokenAddress common.Address, lockSecretHash common.Hash) (info *RefundInfo, err error) {
+	rt, err := r.Photon.dao.GetReceivedTransferByLockSecretHash(tokenAddress, lockSecretHash)
+	if err != nil {
+		return
+	}
+	info = &RefundInfo{
+		Original: rt,
+	}
+	info.RefundedAmount, info.Refunds = r.Photon.getRefunds(rt)
+	return
+}
+
+// GetExpirationRecords : 查询为交易动态选择的锁过期时间和 reveal timeout,lockSecretHash 为空时返回该 token 的所有记录
+func (r *API) GetExpirationRecords(tokenAddress common.Address, lockSecretHash common.Hash) (records []*models.ExpirationRecord, err error) {
+	return r.Photon.dao.GetExpirationRecords(tokenAddress, lockSecretHash)
+}
+
+// GetMissionControl : 查询根据交易结果得到的通道容量估计和节点可靠性
+func (r *API) GetMissionControl(tokenAddress common.Address) []*ChannelObservationInfo {
+	return r.Photon.MissionControl.GetObservations(tokenAddress)
+}
+
 // AllowRevealSecret :
 // 1. find state manager by lockSecretHash and tokenAddress
 // 2. check secret matches lockSecretHash or not
@@ -771,40 +1061,51 @@ func signBalanceProofFor3rd(c *channeltype.Serialization, privkey *ecdsa.Private
 		log.Error(fmt.Sprintf("PartnerBalanceProof is nil,must ber a error"))
 		return nil, rerr.ErrChannelBalanceProofNil.Append("empty PartnerBalanceProof")
 	}
+	dataToSign := balanceProofFor3rdData(c.ChannelIdentifier.ChannelIdentifier, c.ChannelIdentifier.OpenBlockNumber,
+		c.PartnerBalanceProof.TransferAmount, c.PartnerBalanceProof.LocksRoot, c.PartnerBalanceProof.Nonce)
+	return utils.SignData(privkey, dataToSign)
+}
+
+//balanceProofFor3rdData 委托第三方 UpdateBalanceProof 时需要签名的数据
+func balanceProofFor3rdData(channelIdentifier common.Hash, openBlockNumber int64, transferAmount *big.Int, locksRoot common.Hash, nonce uint64) []byte {
 	buf := new(bytes.Buffer)
-	_, err = buf.Write(params.ContractSignaturePrefix)
+	_, err := buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractBalanceProofDelegateMessageLength))
-	_, err = buf.Write(utils.BigIntTo32Bytes(c.PartnerBalanceProof.TransferAmount))
-	_, err = buf.Write(c.PartnerBalanceProof.LocksRoot[:])
-	err = binary.Write(buf, binary.BigEndian, c.PartnerBalanceProof.Nonce)
-	_, err = buf.Write(c.ChannelIdentifier.ChannelIdentifier[:])
-	err = binary.Write(buf, binary.BigEndian, c.ChannelIdentifier.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(transferAmount))
+	_, err = buf.Write(locksRoot[:])
+	err = binary.Write(buf, binary.BigEndian, nonce)
+	_, err = buf.Write(channelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, openBlockNumber)
 	_, err = buf.Write(utils.BigIntTo32Bytes(params.ChainID))
 	if err != nil {
 		log.Error(fmt.Sprintf("buf write error %s", err))
 	}
-	dataToSign := buf.Bytes()
-	return utils.SignData(privkey, dataToSign)
+	return buf.Bytes()
 }
 
 func signUnlockFor3rd(c *channeltype.Serialization, u *unlock, thirdAddress common.Address, privkey *ecdsa.PrivateKey) (sig []byte, err error) {
+	dataToSign := unlockFor3rdData(c.ChannelIdentifier.ChannelIdentifier, c.ChannelIdentifier.OpenBlockNumber,
+		c.PartnerBalanceProof.TransferAmount, thirdAddress, u.Lock)
+	return utils.SignData(privkey, dataToSign)
+}
+
+//unlockFor3rdData 委托第三方 unlock 时需要签名的数据,只有 thirdAddress 才能使用这个签名
+func unlockFor3rdData(channelIdentifier common.Hash, openBlockNumber int64, transferAmount *big.Int, thirdAddress common.Address, lock *mtree.Lock) []byte {
 	buf := new(bytes.Buffer)
-	_, err = buf.Write(params.ContractSignaturePrefix)
+	_, err := buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractUnlockDelegateProofMessageLength))
-	_, err = buf.Write(utils.BigIntTo32Bytes(c.PartnerBalanceProof.TransferAmount))
+	_, err = buf.Write(utils.BigIntTo32Bytes(transferAmount))
 	_, err = buf.Write(thirdAddress[:])
-	_, err = buf.Write(utils.BigIntTo32Bytes(big.NewInt(u.Lock.Expiration)))
-	_, err = buf.Write(utils.BigIntTo32Bytes(u.Lock.Amount))
-	_, err = buf.Write(u.Lock.LockSecretHash[:])
-	_, err = buf.Write(c.ChannelIdentifier.ChannelIdentifier[:])
-	err = binary.Write(buf, binary.BigEndian, c.ChannelIdentifier.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(big.NewInt(lock.Expiration)))
+	_, err = buf.Write(utils.BigIntTo32Bytes(lock.Amount))
+	_, err = buf.Write(lock.LockSecretHash[:])
+	_, err = buf.Write(channelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, openBlockNumber)
 	_, err = buf.Write(utils.BigIntTo32Bytes(params.ChainID))
 	if err != nil {
 		log.Error(fmt.Sprintf("buf write error %s", err))
-		return
 	}
-	dataToSign := buf.Bytes()
-	return utils.SignData(privkey, dataToSign)
+	return buf.Bytes()
 }
 
 //EventTransferSentSuccessWrapper wrapper
@@ -911,6 +1212,65 @@ func (r *API) CancelTransfer(lockSecretHash common.Hash, tokenAddress common.Add
 	return <-result.Result
 }
 
+// NewEscrow : 作为接收方登记托管条件,收到 lockSecretHash 对应的锁以后暂扣,直到批准或者取消
+// cancelBeforeBlocks 为0时使用 RevealTimeout
+func (r *API) NewEscrow(lockSecretHash common.Hash, tokenAddress common.Address, condition string, cancelBeforeBlocks int64) (h *models.EscrowHold, err error) {
+	result := r.Photon.escrowClient(newEscrowReqName, &escrowReq{
+		LockSecretHash:     lockSecretHash,
+		TokenAddress:       tokenAddress,
+		Condition:          condition,
+		CancelBeforeBlocks: cancelBeforeBlocks,
+	})
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	h = result.Tag.(*models.EscrowHold)
+	return
+}
+
+// ApproveEscrow : 条件满足,请求密码完成交易
+func (r *API) ApproveEscrow(lockSecretHash common.Hash, tokenAddress common.Address, reason string) error {
+	result := r.Photon.escrowClient(approveEscrowReqName, &escrowReq{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   tokenAddress,
+		Reason:         reason,
+	})
+	return <-result.Result
+}
+
+// CancelEscrow : 拒绝托管支付,通过 AnnounceDisposed 放弃锁
+func (r *API) CancelEscrow(lockSecretHash common.Hash, tokenAddress common.Address, reason string) error {
+	result := r.Photon.escrowClient(cancelEscrowReqName, &escrowReq{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   tokenAddress,
+		Reason:         reason,
+	})
+	return <-result.Result
+}
+
+// EscrowWebhook : oracle 凭 oracleToken 批准或者拒绝托管支付
+func (r *API) EscrowWebhook(lockSecretHash common.Hash, tokenAddress common.Address, oracleToken string, approve bool, reason string) error {
+	result := r.Photon.escrowClient(escrowWebhookReqName, &escrowReq{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   tokenAddress,
+		OracleToken:    oracleToken,
+		Approve:        approve,
+		Reason:         reason,
+	})
+	return <-result.Result
+}
+
+// GetEscrow :
+func (r *API) GetEscrow(lockSecretHash common.Hash, tokenAddress common.Address) (*models.EscrowHold, error) {
+	return r.Photon.dao.GetEscrowHold(tokenAddress, lockSecretHash)
+}
+
+// GetEscrowList :
+func (r *API) GetEscrowList(status models.EscrowStatus) ([]*models.EscrowHold, error) {
+	return r.Photon.dao.GetEscrowHoldList(status)
+}
+
 type balanceProof struct {
 	Nonce             uint64      `json:"nonce"`
 	TransferAmount    *big.Int    `json:"transfer_amount"`
@@ -1011,6 +1371,177 @@ func (r *API) FindPath(targetAddress, tokenAddress common.Address, amount *big.I
 	return
 }
 
+/*
+FindPathWithRouteHints 查询到 target 的路由,并把接收方给出的路由提示合并进来,
+没有 pfs 时只能使用入口节点是我自己或者和我有通道的提示
+*/
+func (r *API) FindPathWithRouteHints(targetAddress, tokenAddress common.Address, amount *big.Int, hints [][]*pfsproxy.RouteHint) (routes []pfsproxy.FindPathResponse, err error) {
+	if r.Photon.PfsProxy != nil {
+		return pfsproxy.FindPathWithRouteHints(r.Photon.PfsProxy, r.Photon.NodeAddress, targetAddress, tokenAddress, amount, hints)
+	}
+	err = pfsproxy.ValidateRouteHints(hints)
+	if err != nil {
+		return
+	}
+	for _, hint := range hints {
+		entry := hint[0].NodeAddress
+		var toEntry *pfsproxy.FindPathResponse
+		if entry != r.Photon.NodeAddress {
+			c, err2 := r.Photon.dao.GetChannel(tokenAddress, entry)
+			if err2 != nil || c.State != channeltype.StateOpened {
+				continue
+			}
+			toEntry = &pfsproxy.FindPathResponse{
+				Fee:    big.NewInt(0),
+				Result: []string{entry.String()},
+			}
+		}
+		merged, ok := pfsproxy.MergeRouteHint(toEntry, r.Photon.NodeAddress, targetAddress, hint, amount)
+		if ok {
+			merged.PathID = len(routes)
+			routes = append(routes, merged)
+		}
+	}
+	if len(routes) == 0 {
+		err = rerr.ErrNoAvailabeRoute.Append("no route hint can be used without pfs")
+	}
+	return
+}
+
+/*
+QuoteTransfer 试运行一笔交易,给出每条候选路由的手续费,跳数,过期块和是否可行,不锁定任何金额.
+有 pfs 或者路由提示时候选路由和 FindPathWithRouteHints 的结果一样,否则使用本地路由
+*/
+func (r *API) QuoteTransfer(targetAddress, tokenAddress common.Address, amount *big.Int, hints [][]*pfsproxy.RouteHint) (quote *TransferQuote, err error) {
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		err = rerr.ErrInvalidAmount
+		return
+	}
+	if targetAddress == r.Photon.NodeAddress {
+		err = rerr.ErrArgumentError.Append("can not quote a transfer to myself")
+		return
+	}
+	var routeInfo []pfsproxy.FindPathResponse
+	if r.Photon.PfsProxy != nil || len(hints) > 0 {
+		routeInfo, err = r.FindPathWithRouteHints(targetAddress, tokenAddress, amount, hints)
+		if err != nil {
+			return
+		}
+	}
+	result := r.Photon.quoteTransferClient(&quoteTransferReq{
+		TokenAddress: tokenAddress,
+		Target:       targetAddress,
+		Amount:       amount,
+		RouteInfo:    routeInfo,
+	})
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	quote = result.Tag.(*TransferQuote)
+	return
+}
+
+/*
+GetRouteHints 生成收款时附带的路由提示:
+target 为空或者是我自己时,提示发起方经过哪些邻居转给我,邻居的收费未知,按默认收费估计;
+否则我是 target 的超级节点,提示发起方经过我转给 target,使用我在这个通道上的实际收费
+*/
+func (r *API) GetRouteHints(tokenAddress, targetAddress common.Address) (hints [][]*pfsproxy.RouteHint, err error) {
+	if targetAddress != utils.EmptyAddress && targetAddress != r.Photon.NodeAddress {
+		c, err2 := r.Photon.dao.GetChannel(tokenAddress, targetAddress)
+		if err2 != nil || c.State != channeltype.StateOpened {
+			err = rerr.ErrChannelNotFound.Printf("no opened channel with %s", utils.APex2(targetAddress))
+			return
+		}
+		h := &pfsproxy.RouteHint{
+			NodeAddress:       r.Photon.NodeAddress,
+			ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+			FeeSetting:        &models.FeeSetting{FeeConstant: big.NewInt(0)},
+			RevealTimeout:     r.Photon.ExpirationPolicy.RevealTimeout(),
+		}
+		if fm, ok := r.Photon.FeePolicy.(*FeeModule); ok {
+			h.FeeSetting = fm.ChannelFeeSetting(c)
+		}
+		hints = append(hints, []*pfsproxy.RouteHint{h})
+		return
+	}
+	channels, err := r.Photon.dao.GetChannelList(tokenAddress, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	for _, c := range channels {
+		if c.State != channeltype.StateOpened {
+			continue
+		}
+		// 邻居在通道中没有可用余额时无法转给我
+		if new(big.Int).Sub(c.PartnerBalance(), c.PartnerAmountLocked()).Sign() <= 0 {
+			continue
+		}
+		hints = append(hints, []*pfsproxy.RouteHint{{
+			NodeAddress:       c.PartnerAddress(),
+			ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+			FeeSetting:        models.NewDefaultFeePolicy().AccountFee,
+			RevealTimeout:     c.RevealTimeout,
+		}})
+	}
+	return
+}
+
+/*
+GetTrampolineRouteInfo 轻节点只指定 trampoline 和接收方,由 trampoline 计算剩下的路由.
+fee 是交易手续费的总预算,包括所有 trampoline 和它们找到的路由的手续费.
+返回的结果可以作为发起交易时的 route_info
+*/
+func (r *API) GetTrampolineRouteInfo(tokenAddress, targetAddress common.Address, amount, fee *big.Int, trampolines []common.Address) (routes []pfsproxy.FindPathResponse, err error) {
+	err = validateTrampolines(r.Photon.NodeAddress, targetAddress, trampolines)
+	if err != nil {
+		return
+	}
+	if fee == nil {
+		fee = big.NewInt(0)
+	}
+	if fee.Sign() < 0 {
+		err = rerr.ErrArgumentError.Append("fee must not be negative")
+		return
+	}
+	entry := trampolines[0]
+	var toEntry []pfsproxy.FindPathResponse
+	c, err := r.Photon.dao.GetChannel(tokenAddress, entry)
+	if err == nil && c.State == channeltype.StateOpened {
+		toEntry = append(toEntry, pfsproxy.FindPathResponse{
+			Fee:    big.NewInt(0),
+			Result: []string{entry.String()},
+		})
+	} else if r.Photon.PfsProxy != nil {
+		toEntry, err = r.Photon.PfsProxy.FindPath(r.Photon.NodeAddress, entry, tokenAddress, amount, true)
+		if err != nil {
+			return
+		}
+	} else {
+		err = rerr.ErrNoAvailabeRoute.Printf("no opened channel with trampoline %s", utils.APex2(entry))
+		return
+	}
+	err = nil
+	for _, p := range toEntry {
+		if p.Fee == nil || len(p.Result) == 0 || common.HexToAddress(p.Result[len(p.Result)-1]) != entry {
+			continue
+		}
+		tr := pfsproxy.FindPathResponse{
+			PathID:      len(routes),
+			PathHop:     len(p.Result),
+			Fee:         new(big.Int).Add(fee, p.Fee),
+			Result:      p.Result,
+			Trampolines: addressesToStrings(trampolines),
+		}
+		routes = append(routes, tr)
+	}
+	if len(routes) == 0 {
+		err = rerr.ErrNoAvailabeRoute.Printf("no route to trampoline %s", utils.APex2(entry))
+	}
+	return
+}
+
 // GetAllFeeChargeRecord :
 func (r *API) GetAllFeeChargeRecord() (resp interface{}, err error) {
 	type responce struct {
@@ -1045,6 +1576,7 @@ func (r *API) SystemStatus() (resp interface{}, err error) {
 	type systemStatus struct {
 		EthRPCEndpoint      string                            `json:"eth_rpc_endpoint"`
 		EthRPCStatus        string                            `json:"eth_rpc_status"` // disconnected, connected, closed, reconnecting
+		EthRPCEndpoints     []*helper.EndpointStatus          `json:"eth_rpc_endpoints"`
 		NodeAddress         string                            `json:"node_address"`
 		RegistryAddress     string                            `json:"registry_address"`
 		TokenToTokenNetwork map[common.Address]common.Address `json:"token_to_token_network"`
@@ -1069,6 +1601,7 @@ func (r *API) SystemStatus() (resp interface{}, err error) {
 	case netshare.Reconnecting:
 		data.EthRPCStatus = "reconnecting"
 	}
+	data.EthRPCEndpoints = r.Photon.Chain.Client.Endpoints()
 	data.NodeAddress = r.Photon.NodeAddress.String()
 	data.RegistryAddress = r.Photon.Chain.GetRegistryAddress().String()
 	// TokenToTokenNetwork
@@ -1327,6 +1860,75 @@ func (r *API) GetDaysIncome(tokenAddress common.Address, n int) (resp []*DaysInc
 	return
 }
 
+// GetFeeAnalytics 按通道和上下家统计 [fromTime,toTime) 之间的手续费收益,时间 <=0 表示不限制
+func (r *API) GetFeeAnalytics(tokenAddress common.Address, fromTime, toTime int64) (fa *FeeAnalytics, err error) {
+	if tokenAddress == utils.EmptyAddress {
+		err = rerr.ErrArgumentError.Append("token address can not be empty")
+		return
+	}
+	fa, err = r.Photon.getFeeAnalytics(tokenAddress, fromTime, toTime)
+	if err != nil {
+		err = rerr.ErrGeneralDBError.Append(err.Error())
+	}
+	return
+}
+
+/*
+WatchtowerDelegate 接受手机节点的委托,c3 必须是以我为第三方生成的 ChannelFor3rd,
+feeLockSecretHash 是委托方预先支付 watchtower 费用的那笔交易
+*/
+func (r *API) WatchtowerDelegate(c3 *ChannelFor3rd, feeLockSecretHash common.Hash) error {
+	if r.Photon.Watchtower == nil {
+		return rerr.ErrArgumentError.Append("watchtower not enabled")
+	}
+	return r.Photon.Watchtower.Delegate(c3, feeLockSecretHash)
+}
+
+// GetWatchtowerDelegates 所有正在看守的通道
+func (r *API) GetWatchtowerDelegates() (ds []*models.WatchtowerDelegate, err error) {
+	if r.Photon.Watchtower == nil {
+		err = rerr.ErrArgumentError.Append("watchtower not enabled")
+		return
+	}
+	return r.Photon.dao.GetWatchtowerDelegateList()
+}
+
+// GetWatchtowerAcks 我的通道被各个 watchtower 确认的情况
+func (r *API) GetWatchtowerAcks() (acks []*models.WatchtowerAck, err error) {
+	return r.Photon.dao.GetWatchtowerAckList()
+}
+
+// GetCheckpoint 我当前的通道网络快照,新节点可以从这里快速同步
+func (r *API) GetCheckpoint() (*models.Checkpoint, error) {
+	return r.Photon.makeCheckpoint()
+}
+
+// GetSettleQueue 已经关闭,等待自动 unlock 和 settle 的通道
+func (r *API) GetSettleQueue() (items []*SettleQueueItem, err error) {
+	if r.Photon.SettleScheduler == nil {
+		err = rerr.ErrArgumentError.Append("auto settle not enabled")
+		return
+	}
+	return r.Photon.SettleScheduler.Queue(), nil
+}
+
+/*
+EstimateChannelCost 估计和 partner 之间打开(或继续存款),关闭,取现,合作关闭以及 settle 通道的链上费用,
+amount 是存款或者取现的金额
+*/
+func (r *API) EstimateChannelCost(tokenAddress, partnerAddress common.Address, amount *big.Int) (costs []*TXCost, err error) {
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		err = rerr.ErrInvalidAmount
+		return
+	}
+	return r.Photon.estimateChannelCost(tokenAddress, partnerAddress, amount)
+}
+
+// GetGasSpent 我的 tx 累计消耗的 gas,channelIdentifier 为空时查询所有通道
+func (r *API) GetGasSpent(channelIdentifier common.Hash) (*GasSpentSummary, error) {
+	return r.Photon.getGasSpent(channelIdentifier)
+}
+
 // GetBuildInfo 获取当前版本信息
 func (r *API) GetBuildInfo() *BuildInfo {
 	return r.Photon.BuildInfo
@@ -0,0 +1,168 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/MetaLife-Protocol/SuperNode/network/graph"
+	"github.com/MetaLife-Protocol/SuperNode/network/xmpptransport"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+交易报价(试运行):
+在真正发起交易之前,按照发起交易时相同的方式得到候选路由(用户指定的路由,pfs 或者本地路由),
+本地路由的手续费由 FeeModule 计算,对每条路由给出手续费,跳数,锁的过期块,成功的可能性,
+并按照发起方选择路由时的条件(通道状态,第一跳的余额,第一跳是否在线)判断是否可行.
+不创建状态机,也不锁定任何金额.
+*/
+/*
+ *	Transfer quote (dry run) :
+ *	before really starting a transfer, candidate routes are got the same way as a transfer does (routes specified by user, pfs or local graph),
+ *	fee of local routes is calculated by FeeModule. For every route fee, hop count, lock expiration and success probability are given,
+ *	and it is checked with the same conditions the initiator uses to choose a route (channel state, balance and online status of the first hop).
+ *	No state manager is created and nothing is locked.
+ */
+
+//RouteQuote 一条候选路由的报价
+type RouteQuote struct {
+	PathID        int              `json:"path_id"`
+	Path          []common.Address `json:"path"` // 不包括我自己
+	HopCount      int              `json:"hop_count"`
+	Fee           *big.Int         `json:"fee"`
+	TotalAmount   *big.Int         `json:"total_amount"` // 第一跳需要锁定的金额,amount+fee
+	Expiration    int64            `json:"expiration"`   // 锁的过期块
+	RevealTimeout int              `json:"reveal_timeout"`
+	Probability   float64          `json:"probability"` // MissionControl 估计的成功可能性
+	Feasible      bool             `json:"feasible"`
+	Reason        string           `json:"reason,omitempty"` // 不可行的原因
+}
+
+//TransferQuote 一笔交易的报价,Routes 已经排好序,第一条就是发起交易时会首先尝试的
+type TransferQuote struct {
+	TokenAddress common.Address `json:"token_address"`
+	Target       common.Address `json:"target"`
+	Amount       *big.Int       `json:"amount"`
+	BlockNumber  int64          `json:"block_number"`
+	Routes       []*RouteQuote  `json:"routes"`
+}
+
+/*
+rankRouteQuotes 可行的排在前面,其他保持发起方尝试路由的顺序,发起方会跳过不可行的路由
+*/
+func rankRouteQuotes(quotes []*RouteQuote) {
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Feasible && !quotes[j].Feasible
+	})
+}
+
+/*
+quoteTransfer 必须在 service 的 goroutine 中执行,因为要访问 ChannelGraph 和通道状态
+*/
+func (rs *Service) quoteTransfer(req *quoteTransferReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	g := rs.getToken2ChannelGraph(req.TokenAddress)
+	if g == nil {
+		result.Result <- rerr.ErrTokenNotFound
+		return
+	}
+	var routes []*route.State
+	revealTimeouts := make(map[*route.State]int)
+	if len(req.RouteInfo) == 0 {
+		if rs.PfsProxy == nil {
+			routes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, req.Target, req.Amount, req.Amount, graph.EmptyExlude, rs, rs.MissionControl)
+		} else if ch := rs.getChannel(req.TokenAddress, req.Target); ch != nil {
+			r := route.NewState(ch, []common.Address{ch.PartnerState.Address})
+			r.TotalFee = utils.BigInt0
+			routes = append(routes, r)
+		}
+	} else {
+		for _, path := range req.RouteInfo {
+			if len(path.Result) == 0 {
+				continue
+			}
+			ch := rs.getChannel(req.TokenAddress, common.HexToAddress(path.Result[0]))
+			if ch == nil {
+				continue
+			}
+			r := route.NewState(ch, path.GetPath())
+			r.TotalFee = path.Fee
+			r.Trampolines = path.GetTrampolines()
+			if r.TotalFee == nil {
+				r.TotalFee = utils.BigInt0
+			}
+			routes = append(routes, r)
+			revealTimeouts[r] = path.RevealTimeout
+		}
+		//和发起方一样按成功的可能性排序,本地路由已经按权重排好了
+		rs.MissionControl.SortRoutes(req.TokenAddress, req.Amount, routes)
+	}
+	if len(routes) == 0 {
+		result.Result <- rerr.ErrNoAvailabeRoute
+		return
+	}
+	quote := &TransferQuote{
+		TokenAddress: req.TokenAddress,
+		Target:       req.Target,
+		Amount:       req.Amount,
+		BlockNumber:  rs.GetBlockNumber(),
+	}
+	for i, r := range routes {
+		quote.Routes = append(quote.Routes, rs.quoteRoute(i, r, req, revealTimeouts[r], quote.BlockNumber))
+	}
+	rankRouteQuotes(quote.Routes)
+	result.Tag = quote
+	result.Result <- nil
+	return
+}
+
+//quoteRoute 和 initiator 选择路由时使用相同的条件
+func (rs *Service) quoteRoute(pathID int, r *route.State, req *quoteTransferReq, hintRevealTimeout int, blockNumber int64) *RouteQuote {
+	q := &RouteQuote{
+		PathID:      pathID,
+		Path:        r.Path,
+		HopCount:    len(r.Path),
+		Fee:         new(big.Int).Set(r.TotalFee),
+		TotalAmount: new(big.Int).Add(req.Amount, r.TotalFee),
+		Probability: rs.MissionControl.routeProbability(req.TokenAddress, req.Amount, r.Path),
+	}
+	q.RevealTimeout = rs.ExpirationPolicy.RevealTimeout()
+	if hintRevealTimeout > q.RevealTimeout {
+		q.RevealTimeout = hintRevealTimeout
+	}
+	q.Expiration = blockNumber + int64(rs.ExpirationPolicy.LockTimeoutWithRevealTimeout(routePathLength(r), hintRevealTimeout))
+	//下一跳会把通道的 settle timeout 作为过期块的上限
+	if maxExpiration := blockNumber + int64(r.SettleTimeout()) - int64(params.DefaultRevealTimeout); q.Expiration > maxExpiration {
+		q.Expiration = maxExpiration
+	}
+	hop := r.HopNode()
+	deviceType, isOnline := rs.Protocol.GetNetworkStatus(hop)
+	onionErr := rs.setTrampolineRoute(r, req.Target, r.Trampolines)
+	switch {
+	case rs.Config.IsMeshNetwork:
+		q.Reason = rerr.ErrNotAllowMediatedTransfer.ErrorMsg
+	case onionErr != nil:
+		q.Reason = onionErr.Error()
+	case hintRevealTimeout > 0 && !rs.ExpirationPolicy.FitsSettleTimeout(routePathLength(r), hintRevealTimeout, r.SettleTimeout()):
+		q.Reason = fmt.Sprintf("reveal timeout %d of route hint is too large for settle timeout %d", hintRevealTimeout, r.SettleTimeout())
+	case !r.CanTransfer():
+		q.Reason = fmt.Sprintf("channel with %s is %s", utils.APex2(hop), r.StateName())
+	case r.AvailableBalance().Cmp(q.TotalAmount) < 0:
+		q.Reason = fmt.Sprintf("insufficient balance with %s, available=%s,need=%s", utils.APex2(hop), r.AvailableBalance(), q.TotalAmount)
+	case !isOnline:
+		q.Reason = fmt.Sprintf("%s is offline", utils.APex2(hop))
+	case deviceType == xmpptransport.TypeMobile && hop != req.Target:
+		q.Reason = fmt.Sprintf("%s is a mobile node and can not mediate", utils.APex2(hop))
+	case q.Probability == 0:
+		q.Reason = "learned capacity of some channel on the path is less than amount"
+	default:
+		q.Feasible = true
+	}
+	return q
+}
+
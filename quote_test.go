@@ -0,0 +1,108 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/network"
+	"github.com/MetaLife-Protocol/SuperNode/network/xmpptransport"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankRouteQuotes(t *testing.T) {
+	quotes := []*RouteQuote{
+		{PathID: 0, Fee: big.NewInt(10), Probability: 1, Feasible: false},
+		{PathID: 1, Fee: big.NewInt(30), Probability: 0.5, Feasible: true},
+		{PathID: 2, Fee: big.NewInt(20), Probability: 0.9, Feasible: true},
+		{PathID: 3, Fee: big.NewInt(10), Probability: 0.9, Feasible: true},
+		{PathID: 4, Fee: big.NewInt(5), Probability: 0, Feasible: false},
+	}
+	rankRouteQuotes(quotes)
+	var ids []int
+	for _, q := range quotes {
+		ids = append(ids, q.PathID)
+	}
+	//发起方的顺序不变,只是跳过不可行的
+	assert.EqualValues(t, []int{1, 2, 3, 0, 4}, ids)
+}
+
+type quoteTestTransport struct {
+	network.Transporter
+	offline map[common.Address]bool
+	mobile  map[common.Address]bool
+}
+
+func (t *quoteTestTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	deviceType = xmpptransport.TypeOtherDevice
+	if t.mobile[addr] {
+		deviceType = xmpptransport.TypeMobile
+	}
+	return deviceType, !t.offline[addr]
+}
+
+func TestQuoteRoute(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	transport := &quoteTestTransport{offline: make(map[common.Address]bool), mobile: make(map[common.Address]bool)}
+	rs := &Service{
+		Config:           &params.Config{},
+		Protocol:         &network.PhotonProtocol{Transport: transport},
+		MissionControl:   NewMissionControl(db),
+		ExpirationPolicy: NewExpirationPolicy(db, 5),
+	}
+	ch, _ := channel.MakeTestPairChannel()
+	ch.SettleTimeout = 100
+	hop, target := ch.PartnerState.Address, utils.NewRandomAddress()
+	//我方余额 330
+	newRoute := func(fee int64) *route.State {
+		r := route.NewState(ch, []common.Address{hop, target})
+		r.TotalFee = big.NewInt(fee)
+		return r
+	}
+	req := &quoteTransferReq{TokenAddress: ch.TokenAddress, Target: target, Amount: big.NewInt(300)}
+	const blockNumber = 1000
+
+	q := rs.quoteRoute(0, newRoute(30), req, 0, blockNumber)
+	assert.True(t, q.Feasible, q.Reason)
+	assert.EqualValues(t, big.NewInt(330), q.TotalAmount)
+	assert.Equal(t, 2, q.HopCount)
+	assert.Equal(t, 5, q.RevealTimeout)
+	// 2*5 + 2 hops * 2 blocks
+	assert.EqualValues(t, blockNumber+14, q.Expiration)
+
+	//手续费让第一跳的余额不够
+	q = rs.quoteRoute(0, newRoute(31), req, 0, blockNumber)
+	assert.False(t, q.Feasible)
+	assert.Contains(t, q.Reason, "insufficient balance")
+	//金额本身超过第一跳的余额
+	q = rs.quoteRoute(0, newRoute(0), &quoteTransferReq{TokenAddress: ch.TokenAddress, Target: target, Amount: big.NewInt(331)}, 0, blockNumber)
+	assert.False(t, q.Feasible)
+	assert.Contains(t, q.Reason, "insufficient balance")
+
+	//路由提示的 reveal timeout 让锁的过期块超出 settle timeout
+	q = rs.quoteRoute(0, newRoute(0), req, 40, blockNumber)
+	assert.True(t, q.Feasible, q.Reason)
+	assert.Equal(t, 40, q.RevealTimeout)
+	assert.EqualValues(t, blockNumber+100-params.DefaultRevealTimeout, q.Expiration)
+	q = rs.quoteRoute(0, newRoute(0), req, 50, blockNumber)
+	assert.False(t, q.Feasible)
+	assert.Contains(t, q.Reason, "reveal timeout 50")
+
+	transport.mobile[hop] = true
+	q = rs.quoteRoute(0, newRoute(0), req, 0, blockNumber)
+	assert.False(t, q.Feasible)
+	assert.Contains(t, q.Reason, "mobile")
+	transport.offline[hop] = true
+	q = rs.quoteRoute(0, newRoute(0), req, 0, blockNumber)
+	assert.False(t, q.Feasible)
+	assert.Contains(t, q.Reason, "offline")
+}
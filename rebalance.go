@@ -0,0 +1,294 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/encoding"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/initiator"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+再平衡:
+1. 从我方余额偏多的通道(OutPartner)发出一笔交易,经过网络中的其他节点,从我方余额偏少的通道(InPartner)回到自己,
+	通道总余额不变,只支付路上的手续费,总手续费不能超过 MaxFee
+2. 我同时是这笔交易的发起方和接收方,接收方的 StateManager 使用 circularTargetKey,
+	接收方向发起方请求密码以及发起方把密码告诉接收方都在本地完成,不发送消息
+3. 配置了 AutoRebalanceLow 和 AutoRebalanceHigh 以后,定时检查每个通道我方余额的占比,
+	在占比最高和最低的两个通道之间做再平衡,让它们回到范围之内
+*/
+/*
+ *	Rebalance :
+ *	1. send a transfer out through the channel with too much balance on our side (OutPartner), through other nodes,
+ *		back to ourselves through the channel with too little balance on our side (InPartner).
+ *		total balance doesn't change, we only pay fees on the path, which must not exceed MaxFee.
+ *	2. we are both initiator and target of the transfer, state manager of the target uses circularTargetKey,
+ *		secret request from target to initiator and reveal secret from initiator to target are handled locally without messages.
+ *	3. when AutoRebalanceLow and AutoRebalanceHigh are configured, our share of balance in every channel is checked periodically,
+ *		and the channels with the highest and lowest share are rebalanced back into the band.
+ */
+
+//自动再平衡检查的间隔
+const autoRebalanceInterval = 10 * time.Minute
+
+//circularTargetKey 再平衡时接收方的 StateManager 使用的 key,smkey 被发起方占用了
+func circularTargetKey(smkey common.Hash) common.Hash {
+	return utils.Sha3(smkey[:], []byte("circular-target"))
+}
+
+func (rs *Service) rebalance(req *rebalanceReq) (result *utils.AsyncResult) {
+	if req.Amount == nil || req.Amount.Cmp(utils.BigInt0) <= 0 {
+		return utils.NewAsyncResultWithError(rerr.ErrInvalidAmount.Append("rebalance amount must be positive"))
+	}
+	if req.MaxFee == nil {
+		req.MaxFee = big.NewInt(0)
+	}
+	if req.OutPartner == req.InPartner {
+		return utils.NewAsyncResultWithError(rerr.ErrArgumentError.Append("rebalance needs two different channels"))
+	}
+	outCh := rs.getChannel(req.TokenAddress, req.OutPartner)
+	inCh := rs.getChannel(req.TokenAddress, req.InPartner)
+	if outCh == nil || inCh == nil {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelNotFound.Printf("rebalance channel with %s or %s not found", utils.APex2(req.OutPartner), utils.APex2(req.InPartner)))
+	}
+	if !outCh.CanTransfer() || !inCh.CanTransfer() {
+		return utils.NewAsyncResultWithError(rerr.ErrChannelState.Append("rebalance channels must be opened"))
+	}
+	if new(big.Int).Add(req.Amount, req.MaxFee).Cmp(outCh.Distributable()) > 0 {
+		return utils.NewAsyncResultWithError(rerr.ErrInsufficientBalance.Printf("distributable %s of channel with %s is not enough", outCh.Distributable(), utils.APex2(req.OutPartner)))
+	}
+	routeInfo, err := rs.findRebalancePath(req.TokenAddress, req.OutPartner, req.InPartner, req.Amount, req.MaxFee)
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	log.Info(fmt.Sprintf("rebalance %s from %s to %s, path=%s,fee=%s", req.Amount, utils.APex2(req.OutPartner), utils.APex2(req.InPartner), routeInfo[0].Result, routeInfo[0].Fee))
+	return rs.startMediatedTransfer(req.TokenAddress, rs.NodeAddress, req.Amount, utils.EmptyHash, "", "", routeInfo)
+}
+
+/*
+findRebalancePath 路径为 OutPartner -> ... -> InPartner -> 我,中间的路径不能经过我自己
+有 pfs 时 OutPartner 到 InPartner 的路径和手续费来自 pfs, InPartner 转给我的手续费也问 pfs,
+没有 pfs 时使用本地路由,手续费是中间节点的加上 OutPartner 和 InPartner 的,同样不能超过 maxFee
+*/
+func (rs *Service) findRebalancePath(token, outPartner, inPartner common.Address, amount, maxFee *big.Int) (routeInfo []pfsproxy.FindPathResponse, err error) {
+	var middle []common.Address
+	fee := big.NewInt(0)
+	if rs.PfsProxy != nil {
+		var paths []pfsproxy.FindPathResponse
+		paths, err = rs.PfsProxy.FindPath(outPartner, inPartner, token, amount, false)
+		if err != nil {
+			return
+		}
+		for _, p := range paths {
+			if !containsAddress(p.GetPath(), rs.NodeAddress) && p.Fee != nil {
+				middle = p.GetPath()
+				fee.Add(fee, p.Fee)
+				break
+			}
+		}
+		if middle == nil {
+			err = rerr.ErrNoAvailabeRoute.Printf("no path from %s to %s without myself", utils.APex2(outPartner), utils.APex2(inPartner))
+			return
+		}
+		paths, err = rs.PfsProxy.FindPath(inPartner, rs.NodeAddress, token, amount, false)
+		if err != nil {
+			return
+		}
+		if len(paths) == 0 || len(paths[0].Result) != 1 || paths[0].Fee == nil {
+			err = rerr.ErrNoAvailabeRoute.Printf("%s cannot send %s to me directly", utils.APex2(inPartner), amount)
+			return
+		}
+		fee.Add(fee, paths[0].Fee)
+	} else {
+		g := rs.getToken2ChannelGraph(token)
+		exclude := map[common.Address]bool{rs.NodeAddress: true}
+		paths, err2 := g.GetKShortestPaths(outPartner, inPartner, amount, 1, exclude, rs, rs.MissionControl)
+		if err2 != nil {
+			err = rerr.ErrNoAvailabeRoute.AppendError(err2)
+			return
+		}
+		middle = paths[0].Nodes
+		//p.Fee 不包括起点 OutPartner 和终点 InPartner,它们在这条路径上也是中间节点
+		fee.Add(fee, paths[0].Fee)
+		fee.Add(fee, rs.GetNodeChargeFee(outPartner, token, amount))
+		fee.Add(fee, rs.GetNodeChargeFee(inPartner, token, amount))
+	}
+	if fee.Cmp(maxFee) > 0 {
+		err = rerr.ErrNoAvailabeRoute.Printf("rebalance fee %s exceeds max fee %s", fee, maxFee)
+		return
+	}
+	path := append([]common.Address{outPartner}, middle...)
+	path = append(path, rs.NodeAddress)
+	r := pfsproxy.FindPathResponse{
+		PathHop: len(path),
+		Fee:     fee,
+	}
+	for _, addr := range path {
+		r.Result = append(r.Result, addr.String())
+	}
+	routeInfo = append(routeInfo, r)
+	return
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+receiverStateManagerKey 发给接收方的消息对应的 StateManager,再平衡时接收方的 StateManager 使用 circularTargetKey
+*/
+func (rs *Service) receiverStateManagerKey(smkey common.Hash) common.Hash {
+	if rs.Transfer2StateManager[circularTargetKey(smkey)] != nil {
+		return circularTargetKey(smkey)
+	}
+	return smkey
+}
+
+/*
+onSelfSecretRequest 再平衡的接收方向发起方也就是我自己请求密码,直接交给发起方的 StateManager
+*/
+func (rs *Service) onSelfSecretRequest(e *mediatedtransfer.EventSendSecretRequest, token common.Address) {
+	sm := rs.Transfer2StateManager[utils.Sha3(e.LockSecretHash[:], token[:])]
+	if sm == nil || sm.Name != initiator.NameInitiatorTransition {
+		log.Error(fmt.Sprintf("secret request to myself for %s,but i'm not the initiator", utils.HPex(e.LockSecretHash)))
+		return
+	}
+	rs.StateMachineEventHandler.dispatch(sm, &mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         e.Amount,
+		LockSecretHash: e.LockSecretHash,
+		Sender:         rs.NodeAddress,
+	})
+}
+
+/*
+onSelfRevealSecret 再平衡的发起方把密码告诉接收方,也就是我自己
+*/
+func (rs *Service) onSelfRevealSecret(e *mediatedtransfer.EventSendRevealSecret, msg *encoding.RevealSecret) error {
+	sm := rs.Transfer2StateManager[circularTargetKey(utils.Sha3(e.LockSecretHash[:], e.Token[:]))]
+	if sm == nil {
+		return fmt.Errorf("reveal secret to myself for %s,but i'm not the target", utils.HPex(e.LockSecretHash))
+	}
+	rs.StateMachineEventHandler.dispatch(sm, &mediatedtransfer.ReceiveSecretRevealStateChange{
+		Secret:  e.Secret,
+		Sender:  rs.NodeAddress,
+		Message: msg,
+	})
+	return nil
+}
+
+/*
+autoRebalanceLoop 定时检查所有通道,每个 token 每次最多做一次再平衡,等它结束以后再做下一次
+*/
+func (rs *Service) autoRebalanceLoop() {
+	low, high := rs.Config.AutoRebalanceLow, rs.Config.AutoRebalanceHigh
+	if low <= 0 || high <= low || high >= 100 {
+		return
+	}
+	log.Info(fmt.Sprintf("auto rebalance channels into %d%%-%d%%", low, high))
+	ticker := time.NewTicker(autoRebalanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, req := range rs.autoRebalanceReqs(low, high) {
+				result := rs.rebalanceClient(req)
+				select {
+				case err := <-result.Result:
+					if err != nil {
+						log.Warn(fmt.Sprintf("auto rebalance on token %s err %s", utils.APex2(req.TokenAddress), err))
+					}
+				case <-rs.quitChan:
+					return
+				}
+			}
+		case <-rs.quitChan:
+			return
+		}
+	}
+}
+
+/*
+autoRebalanceReqs 对每个 token 找出我方余额占比最高且高于 high 的通道和占比最低且低于 low 的通道,
+转移的金额让两个通道中离范围中点更近的那个回到中点
+*/
+func (rs *Service) autoRebalanceReqs(low, high int) (reqs []*rebalanceReq) {
+	channels, err := rs.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetChannelList err %s", err))
+		return
+	}
+	type share struct {
+		c       *channeltype.Serialization
+		ours    *big.Int
+		total   *big.Int
+		percent int64
+	}
+	type bounds struct {
+		most, least *share
+	}
+	token2Bounds := make(map[common.Address]*bounds)
+	for _, c := range channels {
+		if c.State != channeltype.StateOpened {
+			continue
+		}
+		ours := new(big.Int).Sub(c.OurBalance(), c.OurAmountLocked())
+		total := new(big.Int).Add(ours, new(big.Int).Sub(c.PartnerBalance(), c.PartnerAmountLocked()))
+		if total.Sign() <= 0 {
+			continue
+		}
+		s := &share{c: c, ours: ours, total: total}
+		s.percent = new(big.Int).Div(new(big.Int).Mul(ours, big.NewInt(100)), total).Int64()
+		b := token2Bounds[c.TokenAddress()]
+		if b == nil {
+			b = &bounds{}
+			token2Bounds[c.TokenAddress()] = b
+		}
+		if s.percent > int64(high) && (b.most == nil || s.percent > b.most.percent) {
+			b.most = s
+		}
+		if s.percent < int64(low) && (b.least == nil || s.percent < b.least.percent) {
+			b.least = s
+		}
+	}
+	middle := big.NewInt(int64(low+high) / 2)
+	for token, b := range token2Bounds {
+		if b.most == nil || b.least == nil {
+			continue
+		}
+		//most 转出 ours-total*middle%, least 转入 total*middle%-ours
+		out := new(big.Int).Sub(b.most.ours, new(big.Int).Div(new(big.Int).Mul(b.most.total, middle), big.NewInt(100)))
+		in := new(big.Int).Sub(new(big.Int).Div(new(big.Int).Mul(b.least.total, middle), big.NewInt(100)), b.least.ours)
+		amount := out
+		if in.Cmp(out) < 0 {
+			amount = in
+		}
+		if amount.Sign() <= 0 {
+			continue
+		}
+		req := &rebalanceReq{
+			TokenAddress: token,
+			OutPartner:   b.most.c.PartnerAddress(),
+			InPartner:    b.least.c.PartnerAddress(),
+			Amount:       amount,
+			MaxFee:       big.NewInt(0),
+		}
+		if rs.Config.AutoRebalanceFeePercent > 0 {
+			req.MaxFee.Div(amount, big.NewInt(rs.Config.AutoRebalanceFeePercent))
+		}
+		reqs = append(reqs, req)
+	}
+	return
+}
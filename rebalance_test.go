@@ -0,0 +1,175 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/graph"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/initiator"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer/target"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRebalanceTestChannel(t *testing.T, db models.Dao, token, partner common.Address, ours, partners int64) {
+	h := utils.NewRandomHash()
+	c := channeltype.NewEmptySerialization()
+	c.ChannelIdentifier = &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3}
+	c.Key = h[:]
+	c.TokenAddressBytes = token[:]
+	c.PartnerAddressBytes = partner[:]
+	c.State = channeltype.StateOpened
+	c.OurContractBalance = big.NewInt(ours)
+	c.PartnerContractBalance = big.NewInt(partners)
+	err := db.NewChannel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoRebalanceReqs(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	rs := &Service{
+		dao:    db,
+		Config: &params.Config{AutoRebalanceFeePercent: 1000},
+	}
+	token := utils.NewRandomAddress()
+	b, c, d := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	newRebalanceTestChannel(t, db, token, b, 900, 100)
+	newRebalanceTestChannel(t, db, token, c, 50, 950)
+	newRebalanceTestChannel(t, db, token, d, 500, 500)
+	// nothing to do when all channels are within the band
+	assert.Len(t, rs.autoRebalanceReqs(1, 99), 0)
+
+	reqs := rs.autoRebalanceReqs(20, 80)
+	assert.Len(t, reqs, 1)
+	req := reqs[0]
+	assert.Equal(t, token, req.TokenAddress)
+	assert.Equal(t, b, req.OutPartner)
+	assert.Equal(t, c, req.InPartner)
+	// b moves 400 back to the middle, c needs 450
+	assert.EqualValues(t, 400, req.Amount.Int64())
+	assert.EqualValues(t, 0, req.MaxFee.Int64())
+}
+
+type rebalanceTestCharger struct{}
+
+func (c *rebalanceTestCharger) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return big.NewInt(2)
+}
+
+func TestFindRebalancePathLocal(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	me, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	out, middle, in := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	rs := &Service{
+		NodeAddress:        me,
+		FeePolicy:          &rebalanceTestCharger{},
+		MissionControl:     NewMissionControl(db),
+		Token2ChannelGraph: make(map[common.Address]*graph.ChannelGraph),
+	}
+	rs.Token2ChannelGraph[token] = graph.NewChannelGraph(me, token, []common.Address{me, out, out, middle, middle, in, in, me})
+	// out, middle and in all charge 2
+	_, err = rs.findRebalancePath(token, out, in, big.NewInt(10), big.NewInt(5))
+	assert.NotNil(t, err)
+	routeInfo, err := rs.findRebalancePath(token, out, in, big.NewInt(10), big.NewInt(6))
+	if assert.Nil(t, err) && assert.Len(t, routeInfo, 1) {
+		assert.Equal(t, []common.Address{out, middle, in, me}, routeInfo[0].GetPath())
+		assert.EqualValues(t, big.NewInt(6), routeInfo[0].Fee)
+	}
+}
+
+func TestCircularTargetKey(t *testing.T) {
+	smkey := utils.Sha3(utils.NewRandomHash().Bytes(), utils.NewRandomAddress().Bytes())
+	assert.Equal(t, circularTargetKey(smkey), circularTargetKey(smkey))
+	assert.NotEqual(t, smkey, circularTargetKey(smkey))
+	assert.NotEqual(t, smkey, circularTargetKey(circularTargetKey(smkey)))
+
+	rs := &Service{Transfer2StateManager: make(map[common.Hash]*transfer.StateManager)}
+	assert.Equal(t, smkey, rs.receiverStateManagerKey(smkey))
+	//再平衡时 unlock 交给接收方的 StateManager
+	rs.Transfer2StateManager[smkey] = &transfer.StateManager{Name: initiator.NameInitiatorTransition}
+	assert.Equal(t, smkey, rs.receiverStateManagerKey(smkey))
+	rs.Transfer2StateManager[circularTargetKey(smkey)] = &transfer.StateManager{Name: target.NameTargetTransition}
+	assert.Equal(t, circularTargetKey(smkey), rs.receiverStateManagerKey(smkey))
+}
+
+//newRebalanceTestStateManager 记录收到的 StateChange
+func newRebalanceTestStateManager(name string, lockSecretHash common.Hash, received *[]transfer.StateChange) *transfer.StateManager {
+	return transfer.NewStateManager(func(state transfer.State, stateChange transfer.StateChange) *transfer.TransitionResult {
+		*received = append(*received, stateChange)
+		return &transfer.TransitionResult{NewState: state}
+	}, nil, name, lockSecretHash, utils.EmptyAddress)
+}
+
+func TestRebalanceSelfMessages(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer db.CloseDB()
+	me, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	rs := &Service{
+		dao:                   db,
+		NodeAddress:           me,
+		Config:                &params.Config{},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+	}
+	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
+	lockSecretHash := utils.NewRandomHash()
+	smkey := utils.Sha3(lockSecretHash[:], token[:])
+	var initiatorReceived, targetReceived []transfer.StateChange
+	initiatorManager := newRebalanceTestStateManager(initiator.NameInitiatorTransition, lockSecretHash, &initiatorReceived)
+	targetManager := newRebalanceTestStateManager(target.NameTargetTransition, lockSecretHash, &targetReceived)
+
+	//接收方的 StateManager 不存在
+	reveal := &mediatedtransfer.EventSendRevealSecret{LockSecretHash: lockSecretHash, Token: token, Secret: utils.NewRandomHash(), Receiver: me}
+	assert.NotNil(t, rs.onSelfRevealSecret(reveal, nil))
+	//我不是发起方的时候不能把密码请求交给它
+	rs.Transfer2StateManager[smkey] = targetManager
+	rs.onSelfSecretRequest(&mediatedtransfer.EventSendSecretRequest{LockSecretHash: lockSecretHash, Amount: big.NewInt(10)}, token)
+	assert.Empty(t, targetReceived)
+
+	rs.Transfer2StateManager[smkey] = initiatorManager
+	rs.Transfer2StateManager[circularTargetKey(smkey)] = targetManager
+	rs.onSelfSecretRequest(&mediatedtransfer.EventSendSecretRequest{LockSecretHash: lockSecretHash, Amount: big.NewInt(10)}, token)
+	if assert.Len(t, initiatorReceived, 1) {
+		st := initiatorReceived[0].(*mediatedtransfer.ReceiveSecretRequestStateChange)
+		assert.Equal(t, me, st.Sender)
+		assert.Equal(t, lockSecretHash, st.LockSecretHash)
+		assert.EqualValues(t, big.NewInt(10), st.Amount)
+	}
+	assert.Nil(t, rs.onSelfRevealSecret(reveal, nil))
+	if assert.Len(t, targetReceived, 1) {
+		st := targetReceived[0].(*mediatedtransfer.ReceiveSecretRevealStateChange)
+		assert.Equal(t, me, st.Sender)
+		assert.Equal(t, reveal.Secret, st.Secret)
+	}
+
+	//接收方结束时删除的是 circularTargetKey,发起方的 StateManager 不受影响
+	err = rs.StateMachineEventHandler.OnEvent(&mediatedtransfer.EventRemoveStateManager{Key: smkey}, targetManager)
+	assert.Nil(t, err)
+	assert.Nil(t, rs.Transfer2StateManager[circularTargetKey(smkey)])
+	assert.Equal(t, initiatorManager, rs.Transfer2StateManager[smkey])
+	err = rs.StateMachineEventHandler.OnEvent(&mediatedtransfer.EventRemoveStateManager{Key: smkey}, initiatorManager)
+	assert.Nil(t, err)
+	assert.Empty(t, rs.Transfer2StateManager)
+}
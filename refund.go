@@ -0,0 +1,126 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+)
+
+/*
+退款:
+1. 收款方指定收到的一笔交易的 LockSecretHash,向原发起方发起一笔新的交易,可以部分退款
+2. 退款交易的附加信息中带有原交易的 LockSecretHash,双方的交易记录中都会关联到原交易
+3. 已经退款的总额(失败和取消的退款交易除外)不能超过原交易金额
+*/
+/*
+ *	Refund :
+ *	1. target specifies the LockSecretHash of a received transfer, and sends a new transfer back to the initiator, partial refund is allowed.
+ *	2. data of the refund transfer carries the LockSecretHash of the original, so both nodes link the refund to the original in their records.
+ *	3. the total refunded (except failed and canceled refunds) must not exceed the original amount.
+ */
+
+func (rs *Service) refund(req *refundReq) (result *utils.AsyncResult) {
+	rt, err := rs.dao.GetReceivedTransferByLockSecretHash(req.TokenAddress, req.LockSecretHash)
+	if err != nil {
+		return utils.NewAsyncResultWithError(rerr.ErrTransferNotFound.Printf("received transfer %s not found", req.LockSecretHash.String()))
+	}
+	if rt.RefundOf != utils.EmptyHash {
+		return utils.NewAsyncResultWithError(rerr.ErrArgumentError.Append("cannot refund a refund"))
+	}
+	if req.Amount == nil || req.Amount.Cmp(utils.BigInt0) <= 0 {
+		return utils.NewAsyncResultWithError(rerr.ErrInvalidAmount.Append("refund amount must be positive"))
+	}
+	refunded, _ := rs.getRefunds(rt)
+	if new(big.Int).Add(refunded, req.Amount).Cmp(rt.Amount) > 0 {
+		return utils.NewAsyncResultWithError(rerr.ErrInvalidAmount.Printf("refund %s too much, amount=%s,refunded=%s", req.Amount, rt.Amount, refunded))
+	}
+	data := models.MakeRefundData(rt.LockSecretHash, req.Data)
+	if len(data) > params.MaxTransferDataLen {
+		return utils.NewAsyncResultWithError(rerr.ErrArgumentError.Printf("refund data too long, length %d with refund prefix must < %d", len(data), params.MaxTransferDataLen))
+	}
+	if req.IsDirectTransfer {
+		result = rs.directTransferAsync(rt.TokenAddress, rt.FromAddress, req.Amount, data, data)
+	} else {
+		result = rs.startMediatedTransfer(rt.TokenAddress, rt.FromAddress, req.Amount, utils.EmptyHash, data, data, req.RouteInfo)
+	}
+	select {
+	case err = <-result.Result:
+		//put it back for the caller
+		result.Result <- err
+		if err != nil {
+			//失败的退款不计入退款总额
+			if result.LockSecretHash != utils.EmptyHash {
+				rs.dao.UpdateSentTransferDetailStatus(rt.TokenAddress, result.LockSecretHash, models.TransferStatusFailed, fmt.Sprintf("refund fail err=%s", err), nil)
+			}
+			return
+		}
+	default:
+	}
+	_, err = rs.dao.AddReceivedTransferRefund(rt.TokenAddress, rt.LockSecretHash, result.LockSecretHash)
+	if err != nil {
+		log.Error(fmt.Sprintf("AddReceivedTransferRefund err %s", err))
+	}
+	return
+}
+
+/*
+getRefunds returns refunds sent for received transfer rt, and the total amount of them except failed and canceled ones
+*/
+func (rs *Service) getRefunds(rt *models.ReceivedTransfer) (refunded *big.Int, refunds []*models.SentTransferDetail) {
+	refunded = big.NewInt(0)
+	for _, lockSecretHash := range rt.Refunds {
+		std, err := rs.dao.GetSentTransferDetail(rt.TokenAddress, lockSecretHash)
+		if err != nil {
+			log.Error(fmt.Sprintf("refund %s of %s not found", lockSecretHash.String(), rt.LockSecretHash.String()))
+			continue
+		}
+		refunds = append(refunds, std)
+		if std.Status == models.TransferStatusFailed || std.Status == models.TransferStatusCanceled {
+			continue
+		}
+		refunded.Add(refunded, std.Amount)
+	}
+	return
+}
+
+/*
+onReceiveRefund 收到了对方的退款,记录到我发出的原交易上
+*/
+func (rs *Service) onReceiveRefund(rt *models.ReceivedTransfer) {
+	std, err := rs.dao.GetSentTransferDetail(rt.TokenAddress, rt.RefundOf)
+	if err != nil {
+		log.Warn(fmt.Sprintf("receive refund %s from %s,but original transfer %s not found",
+			rt.Amount, utils.APex2(rt.FromAddress), rt.RefundOf.String()))
+		return
+	}
+	err = checkReceivedRefund(std, rt)
+	if err != nil {
+		log.Warn(fmt.Sprintf("ignore refund %s from %s of transfer %s, err %s",
+			rt.Amount, utils.APex2(rt.FromAddress), rt.RefundOf.String(), err))
+		return
+	}
+	_, err = rs.dao.AddSentTransferDetailRefundedAmount(rt.TokenAddress, rt.RefundOf, rt.Amount)
+	if err != nil {
+		log.Error(fmt.Sprintf("AddSentTransferDetailRefundedAmount err %s", err))
+	}
+}
+
+/*
+checkReceivedRefund 只有原交易的接收方可以退款,否则任何人都可以把别人的交易标记为已退款,
+累计的退款也不能超过原交易的金额
+*/
+func checkReceivedRefund(std *models.SentTransferDetail, rt *models.ReceivedTransfer) error {
+	if rt.FromAddress != std.TargetAddress {
+		return rerr.ErrArgumentError.Printf("refund from %s, but target of the transfer is %s", utils.APex2(rt.FromAddress), utils.APex2(std.TargetAddress))
+	}
+	refunded := new(big.Int).Add(rt.Amount, bigOrZero(std.RefundedAmount))
+	if refunded.Cmp(std.Amount) > 0 {
+		return rerr.ErrInvalidAmount.Printf("refunded %s more than amount %s", refunded, std.Amount)
+	}
+	return nil
+}
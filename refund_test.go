@@ -0,0 +1,88 @@
+package photon
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnReceiveRefund(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	token, target := utils.NewRandomAddress(), utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	dao.NewSentTransferDetail(token, target, big.NewInt(100), "", false, lockSecretHash)
+	refundedAmount := func() int64 {
+		std, err := dao.GetSentTransferDetail(token, lockSecretHash)
+		assert.Nil(t, err)
+		if std.RefundedAmount == nil {
+			return 0
+		}
+		return std.RefundedAmount.Int64()
+	}
+	refund := func(from common.Address, amount int64) {
+		rs.onReceiveRefund(&models.ReceivedTransfer{
+			TokenAddress:   token,
+			FromAddress:    from,
+			Amount:         big.NewInt(amount),
+			LockSecretHash: utils.NewRandomHash(),
+			RefundOf:       lockSecretHash,
+		})
+	}
+	//只有原交易的接收方可以退款
+	third := utils.NewRandomAddress()
+	refund(third, 10)
+	assert.EqualValues(t, 0, refundedAmount())
+	refund(target, 60)
+	assert.EqualValues(t, 60, refundedAmount())
+	//累计退款不能超过原交易金额
+	refund(target, 50)
+	assert.EqualValues(t, 60, refundedAmount())
+	refund(target, 40)
+	assert.EqualValues(t, 100, refundedAmount())
+}
+
+func TestRefund(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	token, initiator := utils.NewRandomAddress(), utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	dao.NewReceivedTransfer(2, utils.NewRandomHash(), 3, token, initiator, 3, big.NewInt(10), lockSecretHash, "")
+	refund := func(amount int64, data string) error {
+		result := rs.refund(&refundReq{
+			TokenAddress:   token,
+			LockSecretHash: lockSecretHash,
+			Amount:         big.NewInt(amount),
+			Data:           data,
+		})
+		return <-result.Result
+	}
+	//不存在的交易
+	result := rs.refund(&refundReq{TokenAddress: token, LockSecretHash: utils.NewRandomHash(), Amount: big.NewInt(1)})
+	assert.NotNil(t, <-result.Result)
+	assert.NotNil(t, refund(0, ""))
+	assert.NotNil(t, refund(11, ""))
+	//加上 refund 前缀以后超过了附加信息的最大长度
+	assert.NotNil(t, refund(1, strings.Repeat("a", params.MaxTransferDataLen-10)))
+}
+
+func TestParseRefundData(t *testing.T) {
+	refundOf := utils.NewRandomHash()
+	refundOf2, data, ok := models.ParseRefundData(models.MakeRefundData(refundOf, "sorry:out of stock"))
+	assert.True(t, ok)
+	assert.EqualValues(t, refundOf, refundOf2)
+	assert.EqualValues(t, "sorry:out of stock", data)
+	_, _, ok = models.ParseRefundData("refund:123:abc")
+	assert.False(t, ok)
+	_, _, ok = models.ParseRefundData("123")
+	assert.False(t, ok)
+}
@@ -12,10 +12,10 @@ import (
 request from user
 */
 //key for map, no pointer
+//amount is not part of the key, maker's transfer may be routed through mediators and carry remaining fee
 type swapKey struct {
 	LockSecretHash common.Hash
 	FromToken      common.Address
-	FromAmount     string //string of  big int
 }
 
 //TokenSwap for tokenswap api
@@ -49,6 +49,14 @@ const registerSecretReqName = "RegisterSecret"
 const getUnfinishedReceviedTransferReqName = "GetUnfinishedReceivedTransfer"
 const forceUnlockReqName = "ForceUnlock"
 const registerSecretOnChainReqName = "registerSecretOnChain"
+const newEscrowReqName = "NewEscrow"
+const approveEscrowReqName = "ApproveEscrow"
+const cancelEscrowReqName = "CancelEscrow"
+const escrowWebhookReqName = "EscrowWebhook"
+const refundReqName = "Refund"
+const rebalanceReqName = "Rebalance"
+const quoteTransferReqName = "QuoteTransfer"
+const batchOpenChannelReqName = "BatchOpenChannel"
 
 /*
 transfer api
@@ -60,6 +68,7 @@ type transferReq struct {
 	Secret           common.Hash
 	IsDirectTransfer bool
 	Data             string
+	LocalData        string //保存在本地 SentTransferDetail 中的 data,加密时是明文
 	RouteInfo        []pfsproxy.FindPathResponse
 }
 
@@ -138,7 +147,7 @@ Transfer `amount` between this node and `target`.
            - Network speed, making the transfer sufficiently fast so it doesn't
              expire.
 */
-func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string, routeInfo []pfsproxy.FindPathResponse) *utils.AsyncResult {
+func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data, localData string, routeInfo []pfsproxy.FindPathResponse) *utils.AsyncResult {
 	req := &apiReq{
 		ReqID: utils.RandomString(10),
 		Name:  transferReqName,
@@ -149,6 +158,7 @@ func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.
 			Secret:           secret,
 			IsDirectTransfer: isDirectTransfer,
 			Data:             data,
+			LocalData:        localData,
 			RouteInfo:        routeInfo,
 		},
 	}
@@ -362,3 +372,102 @@ func (rs *Service) registerSecretOnChainClient(secret common.Hash) *utils.AsyncR
 	}
 	return rs.sendReqClient(req)
 }
+
+/*
+escrow api
+*/
+type escrowReq struct {
+	LockSecretHash     common.Hash
+	TokenAddress       common.Address
+	Condition          string
+	CancelBeforeBlocks int64
+	OracleToken        string
+	Approve            bool
+	Reason             string
+}
+
+func (rs *Service) escrowClient(name string, r *escrowReq) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  name,
+		Req:   r,
+	}
+	return rs.sendReqClient(req)
+}
+
+/*
+refund api
+*/
+type refundReq struct {
+	TokenAddress     common.Address
+	LockSecretHash   common.Hash //LockSecretHash of the received transfer to refund
+	Amount           *big.Int
+	IsDirectTransfer bool
+	Data             string
+	RouteInfo        []pfsproxy.FindPathResponse
+}
+
+func (rs *Service) refundClient(r *refundReq) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  refundReqName,
+		Req:   r,
+	}
+	return rs.sendReqClient(req)
+}
+
+/*
+rebalance api
+*/
+type rebalanceReq struct {
+	TokenAddress common.Address
+	OutPartner   common.Address //channel with too much balance on our side
+	InPartner    common.Address //channel with too little balance on our side
+	Amount       *big.Int
+	MaxFee       *big.Int
+}
+
+func (rs *Service) rebalanceClient(r *rebalanceReq) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  rebalanceReqName,
+		Req:   r,
+	}
+	return rs.sendReqClient(req)
+}
+
+/*
+quote transfer api
+*/
+type quoteTransferReq struct {
+	TokenAddress common.Address
+	Target       common.Address
+	Amount       *big.Int
+	RouteInfo    []pfsproxy.FindPathResponse
+}
+
+func (rs *Service) quoteTransferClient(r *quoteTransferReq) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  quoteTransferReqName,
+		Req:   r,
+	}
+	return rs.sendReqClient(req)
+}
+
+/*
+batch open channel api
+*/
+type batchOpenChannelReq struct {
+	TokenAddress common.Address
+	Entries      []*BatchOpenEntry
+}
+
+func (rs *Service) batchOpenChannelClient(r *batchOpenChannelReq) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  batchOpenChannelReqName,
+		Req:   r,
+	}
+	return rs.sendReqClient(req)
+}
@@ -213,6 +213,8 @@ var (
 	ErrSpectrumSyncError = newError(2012, "ErrSpectrumSyncError")
 	//ErrSpectrumBlockError 本地已处理的块数和公链汇报块数不一致,比如我本地已经处理到了50000块,但是公链节点报告现在只有3000块
 	ErrSpectrumBlockError = newError(2013, "ErrSpectrumBlockError")
+	//ErrSpectrumQuorum 配置了多个公链节点,关键查询没有足够多的节点返回一致的结果
+	ErrSpectrumQuorum = newError(2014, "ErrSpectrumQuorum")
 	//ErrUnkownSpectrumRPCError 其他以太坊rpc错误
 	ErrUnkownSpectrumRPCError = newError(2999, "unkown spectrum rpc error")
 	/*ErrTokenNotFound Raised when token not found
@@ -237,6 +239,8 @@ var (
 	向PFS发起请求错误
 	*/
 	ErrPFS = newError(4000, "ErrorPFS")
+	//ErrPFSNotConfigured 启动时没有指定 --pfs,不能进行依赖PFS的操作
+	ErrPFSNotConfigured = newError(4001, "PFSNotConfigured")
 
 	/*
 		Channel Error
@@ -10,6 +10,7 @@ import (
 	"fmt"
 
 	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode"
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ant0ine/go-json-rest/rest"
@@ -222,6 +223,45 @@ func Deposit(w rest.ResponseWriter, r *rest.Request) {
 	return
 }
 
+/*
+batchDepositReq 一次和多个节点创建通道并存款
+*/
+type batchDepositReq struct {
+	TokenAddress string                   `json:"token_address"`
+	Channels     []*photon.BatchOpenEntry `json:"channels"` //每个通道的对方地址,存入金额和结算窗口,结算窗口为0时使用系统默认值
+}
+
+/*
+BatchDeposit open and fund channels with many partners in one request.
+every channel is reported in its own result, an invalid channel does not stop the others
+*/
+func BatchDeposit(w rest.ResponseWriter, r *rest.Request) {
+	var err error
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> BatchDeposit ,resp=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	req := &batchDepositReq{}
+	err = r.DecodeJsonPayload(req)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	tokenAddr, err := utils.HexToAddress(req.TokenAddress)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	results, err := API.BatchOpenChannels(tokenAddr, req.Channels)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(err)
+		return
+	}
+	resp = dto.NewSuccessAPIResponse(results)
+	return
+}
+
 /*
 CloseSettleChannel can do the following jobs:
 close channel
@@ -0,0 +1,21 @@
+package v1
+
+import (
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// GetCheckpoint : 我当前处理到的块的通道网络快照,由我签名,新节点第一次启动时可以从这里快速同步
+// GetCheckpoint : signed snapshot of the channel network at the block I have processed, new nodes can fast sync from it
+func GetCheckpoint(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetCheckpoint ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	cp, err := API.GetCheckpoint()
+	resp = dto.NewAPIResponse(err, cp)
+}
@@ -7,7 +7,10 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/dto"
 	"github.com/MetaLife-Protocol/SuperNode/log"
 	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
 )
 
 /*
@@ -33,3 +36,48 @@ func ContractCallTXQuery(w rest.ResponseWriter, r *rest.Request) {
 	list, err := API.ContractCallTXQuery(&req)
 	resp = dto.NewAPIResponse(err, list)
 }
+
+/*
+EstimateChannelCost estimate gas cost of open, close, withdraw, cooperative settle and settle channel with partner
+*/
+func EstimateChannelCost(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> EstimateChannelCost ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	partnerAddress, err := utils.HexToAddress(r.PathParam("partner"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	amount, ok := math.ParseBig256(r.PathParam("amount"))
+	if !ok {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError)
+		return
+	}
+	costs, err := API.EstimateChannelCost(tokenAddress, partnerAddress, amount)
+	resp = dto.NewAPIResponse(err, costs)
+}
+
+/*
+GetGasSpent gas spent by my txs, ?channel=0x... only for this channel
+*/
+func GetGasSpent(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetGasSpent ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	channelIdentifier := utils.EmptyHash
+	if ch := r.URL.Query().Get("channel"); ch != "" {
+		channelIdentifier = common.HexToHash(ch)
+	}
+	summary, err := API.GetGasSpent(channelIdentifier)
+	resp = dto.NewAPIResponse(err, summary)
+}
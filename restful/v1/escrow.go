@@ -0,0 +1,160 @@
+package v1
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// NewEscrow : 作为接收方登记托管条件
+// NewEscrow : register an escrow condition as target, the lock will be held until approved or canceled
+func NewEscrow(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> NewEscrow ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	type NewEscrowPayload struct {
+		LockSecretHash     string `json:"lock_secret_hash"`
+		TokenAddress       string `json:"token_address"`
+		Condition          string `json:"condition"`
+		CancelBeforeBlocks int64  `json:"cancel_before_blocks"`
+	}
+	var payload NewEscrowPayload
+	err := r.DecodeJsonPayload(&payload)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	lockSecretHash := common.HexToHash(payload.LockSecretHash)
+	if lockSecretHash == utils.EmptyHash {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.Append("lock_secret_hash is empty"))
+		return
+	}
+	tokenAddress, err := utils.HexToAddress(payload.TokenAddress)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	h, err := API.NewEscrow(lockSecretHash, tokenAddress, payload.Condition, payload.CancelBeforeBlocks)
+	resp = dto.NewAPIResponse(err, h)
+}
+
+type escrowDecisionPayload struct {
+	Reason string `json:"reason"`
+}
+
+func parseEscrowPathParams(r *rest.Request) (lockSecretHash common.Hash, tokenAddress common.Address, err error) {
+	lockSecretHash = common.HexToHash(r.PathParam("locksecrethash"))
+	tokenAddress, err = utils.HexToAddress(r.PathParam("token"))
+	return
+}
+
+// ApproveEscrow : 条件满足,继续完成交易
+func ApproveEscrow(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> ApproveEscrow ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	lockSecretHash, tokenAddress, err := parseEscrowPathParams(r)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	var payload escrowDecisionPayload
+	_ = r.DecodeJsonPayload(&payload) // reason is optional
+	err = API.ApproveEscrow(lockSecretHash, tokenAddress, payload.Reason)
+	resp = dto.NewAPIResponse(err, nil)
+}
+
+// CancelEscrow : 拒绝托管支付,锁退回给付款方
+func CancelEscrow(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> CancelEscrow ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	lockSecretHash, tokenAddress, err := parseEscrowPathParams(r)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	var payload escrowDecisionPayload
+	_ = r.DecodeJsonPayload(&payload) // reason is optional
+	err = API.CancelEscrow(lockSecretHash, tokenAddress, payload.Reason)
+	resp = dto.NewAPIResponse(err, nil)
+}
+
+// EscrowWebhook : oracle 回调
+// EscrowWebhook : callback for oracles, oracle_token returned by NewEscrow is required
+func EscrowWebhook(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> EscrowWebhook ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	type EscrowWebhookPayload struct {
+		LockSecretHash string `json:"lock_secret_hash"`
+		TokenAddress   string `json:"token_address"`
+		OracleToken    string `json:"oracle_token"`
+		Approve        bool   `json:"approve"`
+		Reason         string `json:"reason"`
+	}
+	var payload EscrowWebhookPayload
+	err := r.DecodeJsonPayload(&payload)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	tokenAddress, err := utils.HexToAddress(payload.TokenAddress)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	err = API.EscrowWebhook(common.HexToHash(payload.LockSecretHash), tokenAddress, payload.OracleToken, payload.Approve, payload.Reason)
+	resp = dto.NewAPIResponse(err, nil)
+}
+
+// GetEscrow :
+func GetEscrow(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetEscrow ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	lockSecretHash, tokenAddress, err := parseEscrowPathParams(r)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	h, err := API.GetEscrow(lockSecretHash, tokenAddress)
+	resp = dto.NewAPIResponse(err, h)
+}
+
+// GetEscrowList : ?status=1 只查询指定状态
+func GetEscrowList(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetEscrowList ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	status := models.EscrowStatusAll
+	if s := r.URL.Query().Get("status"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+			return
+		}
+		status = models.EscrowStatus(n)
+	}
+	list, err := API.GetEscrowList(status)
+	resp = dto.NewAPIResponse(err, list)
+}
@@ -75,10 +75,46 @@ func Start() {
 		rest.Post("/api/1/transfers/allowrevealsecret", AllowRevealSecret),
 		rest.Get("/api/1/getunfinishedreceivedtransfer/:tokenaddress/:locksecrethash", GetUnfinishedReceivedTransfer),
 		rest.Post("/api/1/registersecret", RegisterSecret),
+		/*
+			escrow, hold a received lock until approved
+		*/
+		rest.Post("/api/1/escrow", NewEscrow),
+		rest.Get("/api/1/escrow", GetEscrowList),
+		rest.Post("/api/1/escrow/webhook", EscrowWebhook),
+		rest.Get("/api/1/escrow/:token/:locksecrethash", GetEscrow),
+		rest.Post("/api/1/escrow/:token/:locksecrethash/approve", ApproveEscrow),
+		rest.Post("/api/1/escrow/:token/:locksecrethash/cancel", CancelEscrow),
+		/*
+			refund a received transfer
+		*/
+		rest.Post("/api/1/refund/:token/:locksecrethash", Refund),
+		rest.Get("/api/1/refund/:token/:locksecrethash", GetRefundInfo),
+		/*
+			rebalance channels by a circular transfer to ourselves
+		*/
+		rest.Post("/api/1/rebalance/:token", Rebalance),
+		/*
+			watch channels for mobile nodes
+		*/
+		rest.Post("/api/1/watchtower/delegate", WatchtowerDelegate),
+		rest.Get("/api/1/watchtower/delegates", GetWatchtowerDelegates),
+		rest.Get("/api/1/watchtower/acks", GetWatchtowerAcks),
+		/*
+			snapshot of channel network for fast sync of new nodes
+		*/
+		rest.Get("/api/1/checkpoint", GetCheckpoint),
+		/*
+			closed channels waiting for automatic unlock and settle
+		*/
+		rest.Get("/api/1/settle_queue", GetSettleQueue),
 		/*
 			token swap
 		*/
 		rest.Put("/api/1/token_swaps/:target/:locksecrethash", TokenSwap),
+		rest.Get("/api/1/token_swaps/rates", GetSwapRates),
+		rest.Put("/api/1/token_swaps/rates", SetSwapRate),
+		rest.Delete("/api/1/token_swaps/rates/:from_token/:to_token", RemoveSwapRate),
+		rest.Get("/api/1/token_swaps/quote/:from_token/:to_token/:amount", GetSwapQuote),
 		/*
 			accounts
 		*/
@@ -98,6 +134,7 @@ func Start() {
 			Deposit
 		*/
 		rest.Put("/api/1/deposit", Deposit),
+		rest.Put("/api/1/deposit/batch", BatchDeposit),
 		/*
 			tokens
 		*/
@@ -107,10 +144,17 @@ func Start() {
 			contract call tx
 		*/
 		rest.Post("/api/1/tx/query", ContractCallTXQuery),
+		rest.Get("/api/1/tx/estimate/:token/:partner/:amount", EstimateChannelCost),
+		rest.Get("/api/1/tx/gas", GetGasSpent),
 		/*
 			utils
 		*/
 		rest.Get("/api/1/path/:target_address/:token/:amount", FindPath),
+		rest.Post("/api/1/path/:target_address/:token/:amount", FindPathWithRouteHints),
+		rest.Get("/api/1/route_hints/:token", GetRouteHints),
+		rest.Get("/api/1/route_hints/:token/:target_address", GetRouteHints),
+		rest.Get("/api/1/quote/:target_address/:token/:amount", QuoteTransfer),
+		rest.Post("/api/1/quote/:target_address/:token/:amount", QuoteTransfer),
 		rest.Get("/api/1/secret", GetRandomSecret), // api to provide random secret and lockSecretHash pair
 		rest.Get("/api/1/version", GetBuildInfo),
 
@@ -126,6 +170,7 @@ func Start() {
 		*/
 		rest.Post("/api/1/income/details", GetIncomeDetails),
 		rest.Post("/api/1/income/days", GetDaysIncome),
+		rest.Post("/api/1/income/analytics", GetFeeAnalytics),
 
 		/*
 			test
@@ -167,6 +212,9 @@ func Start() {
 		rest.Get("/api/1/debug/force-unlock/:channel/:secret", ForceUnlock),
 		rest.Get("/api/1/debug/register-secret-onchain/:secret", RegisterSecretOnChain),
 		rest.Get("/api/1/debug/pfs/:channel", BalanceUpdateForPFS),
+		rest.Get("/api/1/debug/expiration/:token", GetExpirationRecords),
+		rest.Get("/api/1/debug/expiration/:token/:locksecrethash", GetExpirationRecords),
+		rest.Get("/api/1/debug/missioncontrol/:token", GetMissionControl),
 		rest.Post("/api/1/debug/notify_network_down", NotifyNetworkDown), // notify photon network down
 		rest.Get("/api/1/debug/shutdown", func(writer rest.ResponseWriter, request *rest.Request) {
 			API.Photon.Stop()
@@ -3,8 +3,10 @@ package v1
 import (
 	"fmt"
 
+	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
 	"github.com/MetaLife-Protocol/SuperNode/rerr"
 
+	"net/http"
 	"strconv"
 
 	"github.com/MetaLife-Protocol/SuperNode/dto"
@@ -146,6 +148,102 @@ func FindPath(w rest.ResponseWriter, r *rest.Request) {
 
 }
 
+// FindPathWithRouteHints :
+func FindPathWithRouteHints(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> FindPathWithRouteHints ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	targetAddress, err := utils.HexToAddress(r.PathParam("target_address"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	amount, ok := math.ParseBig256(r.PathParam("amount"))
+	if !ok {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError)
+		return
+	}
+	type findPathPayload struct {
+		RouteHints [][]*pfsproxy.RouteHint `json:"route_hints"`
+	}
+	var payload findPathPayload
+	err = r.DecodeJsonPayload(&payload)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	result, err := API.FindPathWithRouteHints(targetAddress, tokenAddress, amount, payload.RouteHints)
+	resp = dto.NewAPIResponse(err, result)
+}
+
+// GetRouteHints :
+func GetRouteHints(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetRouteHints ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	targetAddress := utils.EmptyAddress
+	if target := r.PathParam("target_address"); target != "" {
+		targetAddress, err = utils.HexToAddress(target)
+		if err != nil {
+			resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+			return
+		}
+	}
+	result, err := API.GetRouteHints(tokenAddress, targetAddress)
+	resp = dto.NewAPIResponse(err, result)
+}
+
+// QuoteTransfer : GET 只使用 pfs 或本地路由,POST 可以带上接收方给出的路由提示
+func QuoteTransfer(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> QuoteTransfer ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	targetAddress, err := utils.HexToAddress(r.PathParam("target_address"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	amount, ok := math.ParseBig256(r.PathParam("amount"))
+	if !ok {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError)
+		return
+	}
+	type quotePayload struct {
+		RouteHints [][]*pfsproxy.RouteHint `json:"route_hints"`
+	}
+	var payload quotePayload
+	if r.Method == http.MethodPost {
+		err = r.DecodeJsonPayload(&payload)
+		if err != nil {
+			resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+			return
+		}
+	}
+	result, err := API.QuoteTransfer(targetAddress, tokenAddress, amount, payload.RouteHints)
+	resp = dto.NewAPIResponse(err, result)
+}
+
 // GetAllFeeChargeRecord :
 func GetAllFeeChargeRecord(w rest.ResponseWriter, r *rest.Request) {
 	var resp *dto.APIResponse
@@ -168,6 +266,41 @@ func GetSystemStatus(w rest.ResponseWriter, r *rest.Request) {
 	resp = dto.NewAPIResponse(err, result)
 }
 
+// GetExpirationRecords : lock expiration and reveal timeout chosen for transfers
+func GetExpirationRecords(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetExpirationRecords ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	lockSecretHash := utils.EmptyHash
+	if r.PathParam("locksecrethash") != "" {
+		lockSecretHash = common.HexToHash(r.PathParam("locksecrethash"))
+	}
+	records, err := API.GetExpirationRecords(tokenAddress, lockSecretHash)
+	resp = dto.NewAPIResponse(err, records)
+}
+
+// GetMissionControl : channel capacity and node reliability learned from results of transfers
+func GetMissionControl(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetMissionControl ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	resp = dto.NewSuccessAPIResponse(API.GetMissionControl(tokenAddress))
+}
+
 // GetIncomeDetailsRequest :
 type GetIncomeDetailsRequest struct {
 	TokenAddress string `json:"token_address"`
@@ -218,6 +351,31 @@ func GetDaysIncome(w rest.ResponseWriter, r *rest.Request) {
 	resp = dto.NewAPIResponse(err, result)
 }
 
+// GetFeeAnalyticsRequest :
+type GetFeeAnalyticsRequest struct {
+	TokenAddress string `json:"token_address"`
+	FromTime     int64  `json:"from_time"`
+	ToTime       int64  `json:"to_time"`
+}
+
+// GetFeeAnalytics :
+func GetFeeAnalytics(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetFeeAnalytics ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	req := &GetFeeAnalyticsRequest{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	tokenAddress := common.HexToAddress(req.TokenAddress)
+	result, err := API.GetFeeAnalytics(tokenAddress, req.FromTime, req.ToTime)
+	resp = dto.NewAPIResponse(err, result)
+}
+
 //GetBuildInfo :
 func GetBuildInfo(w rest.ResponseWriter, r *rest.Request) {
 	var resp *dto.APIResponse
@@ -0,0 +1,60 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Rebalance : 通过一笔发给自己的交易,把我方余额从 out_partner 的通道转移到 in_partner 的通道
+// Rebalance : move our balance from the channel with out_partner to the channel with in_partner by a circular transfer to ourselves
+func Rebalance(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> Rebalance ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	type RebalancePayload struct {
+		OutPartner common.Address `json:"out_partner"`
+		InPartner  common.Address `json:"in_partner"`
+		Amount     *big.Int       `json:"amount"`
+		MaxFee     *big.Int       `json:"max_fee"`
+	}
+	if API.Photon.StopCreateNewTransfers {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrStopCreateNewTransfer)
+		return
+	}
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	var payload RebalancePayload
+	err = r.DecodeJsonPayload(&payload)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	if payload.Amount == nil || payload.Amount.Cmp(utils.BigInt0) <= 0 {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrInvalidAmount.Append("invalid amount"))
+		return
+	}
+	if payload.MaxFee != nil && payload.MaxFee.Sign() < 0 {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.Append("invalid max_fee"))
+		return
+	}
+	result, err := API.Rebalance(tokenAddress, payload.OutPartner, payload.InPartner, payload.Amount, payload.MaxFee)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(err)
+		return
+	}
+	resp = dto.NewSuccessAPIResponse(map[string]string{
+		"lockSecretHash": result.LockSecretHash.String(),
+	})
+}
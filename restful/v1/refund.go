@@ -0,0 +1,80 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Refund : 把收到的一笔交易部分或全部退还给原发起方
+// Refund : send part or all of a received transfer back to its initiator, the refund is linked to the original transfer
+func Refund(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> Refund ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	type RefundPayload struct {
+		Amount    *big.Int                    `json:"amount"`
+		IsDirect  bool                        `json:"is_direct,omitempty"`
+		Data      string                      `json:"data"`
+		RouteInfo []pfsproxy.FindPathResponse `json:"route_info"`
+	}
+	if API.Photon.StopCreateNewTransfers {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrStopCreateNewTransfer)
+		return
+	}
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	lockSecretHash := common.HexToHash(r.PathParam("locksecrethash"))
+	var payload RefundPayload
+	err = r.DecodeJsonPayload(&payload)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	if payload.Amount == nil || payload.Amount.Cmp(utils.BigInt0) <= 0 {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrInvalidAmount.Append("invalid amount"))
+		return
+	}
+	if len(payload.Data) > params.MaxTransferDataLen {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.Append("Invalid data, length must < 256"))
+		return
+	}
+	result, err := API.Refund(tokenAddress, lockSecretHash, payload.Amount, payload.IsDirect, payload.Data, payload.RouteInfo)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(err)
+		return
+	}
+	resp = dto.NewSuccessAPIResponse(map[string]string{
+		"lockSecretHash": result.LockSecretHash.String(),
+		"refund_of":      lockSecretHash.String(),
+	})
+}
+
+// GetRefundInfo : 查询收到的一笔交易的退款情况
+func GetRefundInfo(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetRefundInfo ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	tokenAddress, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	info, err := API.GetRefundInfo(tokenAddress, common.HexToHash(r.PathParam("locksecrethash")))
+	resp = dto.NewAPIResponse(err, info)
+}
@@ -0,0 +1,21 @@
+package v1
+
+import (
+	"fmt"
+
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// GetSettleQueue : 已经关闭,等待自动 unlock 和 settle 的通道
+// GetSettleQueue : closed channels waiting for automatic unlock and settle
+func GetSettleQueue(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetSettleQueue ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	items, err := API.GetSettleQueue()
+	resp = dto.NewAPIResponse(err, items)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/MetaLife-Protocol/SuperNode/models"
 	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
 	"github.com/MetaLife-Protocol/SuperNode/rerr"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/MetaLife-Protocol/SuperNode/utils"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
 )
 
 /*
@@ -97,3 +99,78 @@ func TokenSwap(w rest.ResponseWriter, r *rest.Request) {
 	}
 	resp = dto.NewAPIResponse(err, nil)
 }
+
+// GetSwapRates : 本节点作为做市商公布的兑换价格
+func GetSwapRates(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetSwapRates ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	rates, err := API.GetSwapRates()
+	resp = dto.NewAPIResponse(err, rates)
+}
+
+// SetSwapRate : 做市商设置兑换价格,收到 x 个 from_token 支付 x*rate_numerator/rate_denominator 个 to_token
+func SetSwapRate(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> SetSwapRate ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	sr := &models.SwapRate{}
+	err := r.DecodeJsonPayload(sr)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	err = API.SetSwapRate(sr)
+	resp = dto.NewAPIResponse(err, sr)
+}
+
+// RemoveSwapRate :
+func RemoveSwapRate(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> RemoveSwapRate ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	fromToken, err := utils.HexToAddress(r.PathParam("from_token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	toToken, err := utils.HexToAddress(r.PathParam("to_token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	err = API.RemoveSwapRate(fromToken, toToken)
+	resp = dto.NewAPIResponse(err, nil)
+}
+
+// GetSwapQuote : 查询用 amount 个 from_token 能兑换多少 to_token,需要启用 pfs
+func GetSwapQuote(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetSwapQuote ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	fromToken, err := utils.HexToAddress(r.PathParam("from_token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	toToken, err := utils.HexToAddress(r.PathParam("to_token"))
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	amount, ok := math.ParseBig256(r.PathParam("amount"))
+	if !ok {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.Append("invalid amount"))
+		return
+	}
+	quotes, err := API.GetSwapQuote(fromToken, toToken, amount)
+	resp = dto.NewAPIResponse(err, quotes)
+}
@@ -25,9 +25,13 @@ type TransferData struct {
 	Secret         string                      `json:"secret,omitempty"` // 当用户想使用自己指定的密码,而非随机密码时使用	// client can assign specific secret
 	LockSecretHash string                      `json:"lockSecretHash"`
 	IsDirect       bool                        `json:"is_direct,omitempty"`
-	Sync           bool                        `json:"sync,omitempty"` //是否同步
-	Data           string                      `json:"data"`           // 交易附加信息,长度不超过256
-	RouteInfo      []pfsproxy.FindPathResponse `json:"route_info"`     // 指定的路由信息
+	Sync           bool                        `json:"sync,omitempty"`            //是否同步
+	Data           string                      `json:"data"`                      // 交易附加信息,长度不超过256
+	IsDataEncrypt  bool                        `json:"is_data_encrypt,omitempty"` // 附加信息是否用接收方公钥加密,只有接收方能解密
+	RouteInfo      []pfsproxy.FindPathResponse `json:"route_info"`                // 指定的路由信息
+	RouteHints     [][]*pfsproxy.RouteHint     `json:"route_hints,omitempty"`     // 接收方给出的路由提示,合并到 RouteInfo 中
+	Trampolines    []common.Address            `json:"trampolines,omitempty"`     // 由这些 trampoline 计算剩下的路由,合并到 RouteInfo 中
+	TrampolineFee  *big.Int                    `json:"trampoline_fee,omitempty"`  // 使用 trampoline 时的手续费预算
 }
 
 /*
@@ -106,11 +110,29 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.Append("Invalid data, length must < 256"))
 		return
 	}
+	if len(req.RouteHints) > 0 && !req.IsDirect {
+		var routes []pfsproxy.FindPathResponse
+		routes, err = API.FindPathWithRouteHints(targetAddr, tokenAddr, req.Amount, req.RouteHints)
+		if err != nil {
+			resp = dto.NewExceptionAPIResponse(err)
+			return
+		}
+		req.RouteInfo = append(req.RouteInfo, routes...)
+	}
+	if len(req.Trampolines) > 0 && !req.IsDirect {
+		var routes []pfsproxy.FindPathResponse
+		routes, err = API.GetTrampolineRouteInfo(tokenAddr, targetAddr, req.Amount, req.TrampolineFee, req.Trampolines)
+		if err != nil {
+			resp = dto.NewExceptionAPIResponse(err)
+			return
+		}
+		req.RouteInfo = append(req.RouteInfo, routes...)
+	}
 	var result *utils.AsyncResult
 	if req.Sync {
-		result, err = API.Transfer(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect, req.Data, req.RouteInfo)
+		result, err = API.Transfer(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect, req.Data, req.IsDataEncrypt, req.RouteInfo)
 	} else {
-		result, err = API.TransferAsync(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), req.IsDirect, req.Data, req.RouteInfo)
+		result, err = API.TransferAsync(tokenAddr, req.Amount, targetAddr, common.HexToHash(req.Secret), req.IsDirect, req.Data, req.IsDataEncrypt, req.RouteInfo)
 	}
 	if err != nil {
 		resp = dto.NewExceptionAPIResponse(err)
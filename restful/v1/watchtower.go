@@ -0,0 +1,62 @@
+package v1
+
+import (
+	"fmt"
+
+	photon "github.com/MetaLife-Protocol/SuperNode"
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// WatchtowerDelegate : 手机节点把以我为第三方生成的 ChannelFor3rd 交给我,由我替它看守通道
+// WatchtowerDelegate : mobile nodes delegate their channels to me with ChannelFor3rd generated for me
+func WatchtowerDelegate(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> WatchtowerDelegate ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	type WatchtowerDelegatePayload struct {
+		Channel           *photon.ChannelFor3rd `json:"channel"`
+		FeeLockSecretHash common.Hash           `json:"fee_lock_secret_hash"`
+	}
+	var payload WatchtowerDelegatePayload
+	err := r.DecodeJsonPayload(&payload)
+	if err != nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.AppendError(err))
+		return
+	}
+	if payload.Channel == nil {
+		resp = dto.NewExceptionAPIResponse(rerr.ErrArgumentError.Append("channel is required"))
+		return
+	}
+	err = API.WatchtowerDelegate(payload.Channel, payload.FeeLockSecretHash)
+	resp = dto.NewAPIResponse(err, nil)
+}
+
+// GetWatchtowerDelegates : 所有正在看守的通道
+// GetWatchtowerDelegates : all channels I'm watching
+func GetWatchtowerDelegates(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetWatchtowerDelegates ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	list, err := API.GetWatchtowerDelegates()
+	resp = dto.NewAPIResponse(err, list)
+}
+
+// GetWatchtowerAcks : 我的通道被各个 watchtower 确认的 nonce
+// GetWatchtowerAcks : nonce of my channels acknowledged by each watchtower
+func GetWatchtowerAcks(w rest.ResponseWriter, r *rest.Request) {
+	var resp *dto.APIResponse
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> GetWatchtowerAcks ,err=%s", resp.ToFormatString()))
+		writejson(w, resp)
+	}()
+	list, err := API.GetWatchtowerAcks()
+	resp = dto.NewAPIResponse(err, list)
+}
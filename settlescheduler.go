@@ -0,0 +1,239 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+SettleScheduler 通道关闭以后,替用户完成链上的 unlock 和 settle:
+1. 对方的 balance proof 在链上以后,把金额不低于 AutoUnlockMinAmount 的锁合并成一批 unlock,
+	金额更小的锁花费的 gas 比拿回来的 token 还多,不处理. 失败以后隔 autoUnlockRetryBlocks 块重试
+2. 到达 GetSettleExpiration 再过 PunishBlockNumber 块以后自动 settle. settle 以后就不能再 unlock 了,
+	所以要等待中的 unlock 都结束,最多多等 autoSettleMaxWaitBlocks 块
+队列只在内存中,在 service 的主线程中随新块更新,重启以后从 StateClosed 的通道重新生成
+*/
+/*
+ *	SettleScheduler : unlock and settle on chain after a channel is closed, so that users don't have to.
+ *	1. after the partner's balance proof is on chain, locks of at least AutoUnlockMinAmount are unlocked in one batch,
+ *		smaller locks cost more gas than the tokens they bring back and are skipped. failed unlocks are retried after autoUnlockRetryBlocks.
+ *	2. settle is submitted PunishBlockNumber blocks after GetSettleExpiration. Nothing can be unlocked after settle,
+ *		so settle waits for pending unlocks, for at most autoSettleMaxWaitBlocks more blocks.
+ */
+
+const (
+	//两次 unlock 或 settle 尝试之间至少间隔的块数,也让 unlock 事件有时间处理完,settle 使用正确的 transfer amount
+	autoUnlockRetryBlocks = 20
+	//过了 settle 块以后,最多再为 unlock 等待这么多块
+	autoSettleMaxWaitBlocks = 1000
+)
+
+//settle 队列中通道的状态
+const (
+	SettleQueueStatusWaiting   = "waiting"   //等待对方的 balance proof 上链或者等待 settle 块
+	SettleQueueStatusUnlocking = "unlocking" //有 unlock 正在进行或者等待重试
+	SettleQueueStatusSettling  = "settling"  //已经提交 settle,等待链上事件
+)
+
+//SettleQueueItem 一个已经关闭,等待 settle 的通道
+type SettleQueueItem struct {
+	ChannelIdentifier   common.Hash    `json:"channel_identifier"`
+	TokenAddress        common.Address `json:"token_address"`
+	PartnerAddress      common.Address `json:"partner_address"`
+	ClosedBlock         int64          `json:"closed_block"`
+	SettleBlock         int64          `json:"settle_block"`
+	Status              string         `json:"status"`
+	PendingUnlocks      int            `json:"pending_unlocks"` //值得 unlock 但是还没有成功的锁
+	PendingUnlockAmount *big.Int       `json:"pending_unlock_amount"`
+	SkippedUnlocks      int            `json:"skipped_unlocks"` //金额太小,不值得 unlock 的锁
+	SkippedUnlockAmount *big.Int       `json:"skipped_unlock_amount"`
+	UnlockAttempts      int            `json:"unlock_attempts"`
+	LastError           string         `json:"last_error,omitempty"`
+	unlocking           bool
+	nextTryBlock        int64
+}
+
+//SettleScheduler settles closed channels automatically
+type SettleScheduler struct {
+	rs        *Service
+	minAmount *big.Int
+	items     map[common.Hash]*SettleQueueItem
+	lock      sync.Mutex
+}
+
+func newSettleScheduler(rs *Service, minAmount *big.Int) *SettleScheduler {
+	if minAmount == nil {
+		minAmount = big.NewInt(0)
+	}
+	return &SettleScheduler{
+		rs:        rs,
+		minAmount: minAmount,
+		items:     make(map[common.Hash]*SettleQueueItem),
+	}
+}
+
+//Queue 按 settle 块排序的队列
+func (s *SettleScheduler) Queue() (items []*SettleQueueItem) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, item := range s.items {
+		i := *item
+		items = append(items, &i)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].SettleBlock < items[j].SettleBlock
+	})
+	return
+}
+
+/*
+onBlock 必须在 service 的主线程中调用,和通道状态的变化串行
+*/
+func (s *SettleScheduler) onBlock(blockNumber int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	alive := make(map[common.Hash]bool)
+	for _, g := range s.rs.Token2ChannelGraph {
+		for id, c := range g.ChannelIdentifier2Channel {
+			if c.State != channeltype.StateClosed && c.State != channeltype.StateSettling {
+				continue
+			}
+			alive[id] = true
+			item := s.items[id]
+			if item == nil {
+				item = &SettleQueueItem{
+					ChannelIdentifier:   id,
+					TokenAddress:        c.TokenAddress,
+					PartnerAddress:      c.PartnerState.Address,
+					ClosedBlock:         c.ExternState.ClosedBlock,
+					SettleBlock:         c.GetSettleExpiration(blockNumber) + params.PunishBlockNumber,
+					Status:              SettleQueueStatusWaiting,
+					PendingUnlockAmount: big.NewInt(0),
+					SkippedUnlockAmount: big.NewInt(0),
+					//关闭时的 unlock 由事件直接发起,先不重复提交
+					nextTryBlock: blockNumber + autoUnlockRetryBlocks,
+				}
+				s.items[id] = item
+			}
+			if c.State == channeltype.StateSettling {
+				//用户自己调用了 settle,或者 settle 已经提交
+				item.Status = SettleQueueStatusSettling
+				continue
+			}
+			s.schedule(c, item, blockNumber)
+		}
+	}
+	for id := range s.items {
+		if !alive[id] {
+			delete(s.items, id)
+		}
+	}
+}
+
+func (s *SettleScheduler) schedule(c *channel.Channel, item *SettleQueueItem, blockNumber int64) {
+	if item.unlocking || blockNumber < item.nextTryBlock {
+		return
+	}
+	proofs := s.collectUnlocks(c, item)
+	if item.PendingUnlocks > 0 && blockNumber < item.SettleBlock+autoSettleMaxWaitBlocks {
+		if len(proofs) > 0 {
+			s.unlock(c, item, proofs)
+		} else {
+			item.Status = SettleQueueStatusWaiting
+		}
+		return
+	}
+	if blockNumber < item.SettleBlock {
+		item.Status = SettleQueueStatusWaiting
+		return
+	}
+	if item.PendingUnlocks > 0 {
+		log.Warn(fmt.Sprintf("auto settle channel %s with %d locks not unlocked, amount=%s",
+			utils.HPex(item.ChannelIdentifier), item.PendingUnlocks, item.PendingUnlockAmount))
+	}
+	result := s.rs.closeOrSettleChannel(item.ChannelIdentifier, settleChannelReqName)
+	err := <-result.Result
+	if err != nil {
+		item.LastError = err.Error()
+		item.nextTryBlock = blockNumber + autoUnlockRetryBlocks
+		log.Warn(fmt.Sprintf("auto settle channel %s err %s", utils.HPex(item.ChannelIdentifier), err))
+		return
+	}
+	item.Status = SettleQueueStatusSettling
+	item.LastError = ""
+	log.Info(fmt.Sprintf("auto settle channel %s at block %d", utils.HPex(item.ChannelIdentifier), blockNumber))
+}
+
+/*
+collectUnlocks 统计还没有 unlock 的锁,只有对方的 balance proof 已经在链上,并且和本地的 locksroot 一致时,
+unlock 才会成功,这时才返回需要提交的 proof
+*/
+func (s *SettleScheduler) collectUnlocks(c *channel.Channel, item *SettleQueueItem) (proofs []*channeltype.UnlockProof) {
+	id := c.ChannelIdentifier.ChannelIdentifier
+	worth, skipped := filterUnlockProofs(c.PartnerState.GetCanUnlockOnChainLocks(), s.minAmount, func(lockSecretHash common.Hash) bool {
+		return s.rs.dao.IsThisLockHasUnlocked(id, lockSecretHash) || s.rs.dao.IsLockSecretHashChannelIdentifierDisposed(lockSecretHash, id)
+	})
+	item.PendingUnlocks, item.PendingUnlockAmount = len(worth), sumLockAmount(worth)
+	item.SkippedUnlocks, item.SkippedUnlockAmount = len(skipped), sumLockAmount(skipped)
+	bp := c.PartnerState.BalanceProofState
+	if bp.ContractLocksRoot == utils.EmptyHash || bp.ContractLocksRoot != bp.LocksRoot {
+		return nil
+	}
+	return worth
+}
+
+//unlock 同一个通道的锁一起提交,结束以后才会开始下一批
+func (s *SettleScheduler) unlock(c *channel.Channel, item *SettleQueueItem, proofs []*channeltype.UnlockProof) {
+	item.unlocking = true
+	item.UnlockAttempts++
+	item.Status = SettleQueueStatusUnlocking
+	log.Info(fmt.Sprintf("auto unlock %d locks on channel %s, amount=%s", len(proofs), utils.HPex(item.ChannelIdentifier), item.PendingUnlockAmount))
+	result := c.ExternState.Unlock(proofs, c.PartnerState.BalanceProofState.ContractTransferAmount)
+	go func() {
+		err := <-result.Result
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		item.unlocking = false
+		item.nextTryBlock = s.rs.GetBlockNumber() + autoUnlockRetryBlocks
+		if err != nil {
+			item.LastError = err.Error()
+			log.Warn(fmt.Sprintf("auto unlock on channel %s err %s", utils.HPex(item.ChannelIdentifier), err))
+			return
+		}
+		item.LastError = ""
+	}()
+}
+
+/*
+filterUnlockProofs 去掉已经 unlock 或者声明放弃的锁,剩下的按金额分为值得 unlock 的和不值得的
+*/
+func filterUnlockProofs(proofs []*channeltype.UnlockProof, minAmount *big.Int, done func(lockSecretHash common.Hash) bool) (worth, skipped []*channeltype.UnlockProof) {
+	for _, p := range proofs {
+		if done(p.Lock.LockSecretHash) {
+			continue
+		}
+		if p.Lock.Amount.Cmp(minAmount) < 0 {
+			skipped = append(skipped, p)
+			continue
+		}
+		worth = append(worth, p)
+	}
+	return
+}
+
+func sumLockAmount(proofs []*channeltype.UnlockProof) *big.Int {
+	sum := big.NewInt(0)
+	for _, p := range proofs {
+		sum.Add(sum, p.Lock.Amount)
+	}
+	return sum
+}
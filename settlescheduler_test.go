@@ -0,0 +1,33 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mtree"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterUnlockProofs(t *testing.T) {
+	var proofs []*channeltype.UnlockProof
+	for _, amount := range []int64{10, 100, 1000} {
+		proofs = append(proofs, &channeltype.UnlockProof{
+			Lock: &mtree.Lock{Amount: big.NewInt(amount), LockSecretHash: utils.NewRandomHash()},
+		})
+	}
+	unlocked := proofs[2].Lock.LockSecretHash
+	worth, skipped := filterUnlockProofs(proofs, big.NewInt(100), func(lockSecretHash common.Hash) bool {
+		return lockSecretHash == unlocked
+	})
+	assert.EqualValues(t, []*channeltype.UnlockProof{proofs[1]}, worth)
+	assert.EqualValues(t, []*channeltype.UnlockProof{proofs[0]}, skipped)
+	assert.EqualValues(t, big.NewInt(100), sumLockAmount(worth))
+
+	worth, skipped = filterUnlockProofs(proofs, big.NewInt(0), func(common.Hash) bool { return false })
+	assert.EqualValues(t, 3, len(worth))
+	assert.EqualValues(t, 0, len(skipped))
+	assert.EqualValues(t, big.NewInt(1110), sumLockAmount(worth))
+}
@@ -0,0 +1,260 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/encoding"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/graph"
+	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type swapPathKey struct {
+	from, to, token common.Address
+}
+
+//swapTestPfs 只实现 token swap 用到的接口
+type swapTestPfs struct {
+	pfsproxy.PfsProxy
+	setErr    error
+	submitted []*models.SwapRate
+	rates     []*models.SwapRate
+	paths     map[swapPathKey][]pfsproxy.FindPathResponse
+	findErr   error
+}
+
+func (p *swapTestPfs) SetSwapRates(rates []*models.SwapRate) error {
+	if p.setErr != nil {
+		return p.setErr
+	}
+	p.submitted = rates
+	return nil
+}
+
+func (p *swapTestPfs) GetSwapRates(fromToken, toToken common.Address) ([]*models.SwapRate, error) {
+	return p.rates, nil
+}
+
+func (p *swapTestPfs) FindPath(peerFrom, peerTo, token common.Address, amount *big.Int, isInitiator bool) ([]pfsproxy.FindPathResponse, error) {
+	if p.findErr != nil {
+		return nil, p.findErr
+	}
+	return p.paths[swapPathKey{peerFrom, peerTo, token}], nil
+}
+
+func newSwapRate(marketMaker, fromToken, toToken common.Address, numerator, denominator int64) *models.SwapRate {
+	return &models.SwapRate{
+		MarketMaker:     marketMaker,
+		FromToken:       fromToken,
+		ToToken:         toToken,
+		RateNumerator:   big.NewInt(numerator),
+		RateDenominator: big.NewInt(denominator),
+	}
+}
+
+func TestSetSwapRateRollback(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	pfs := &swapTestPfs{}
+	api := &API{Photon: &Service{dao: dao, NodeAddress: utils.NewRandomAddress(), PfsProxy: pfs}}
+	fromToken, toToken := utils.NewRandomAddress(), utils.NewRandomAddress()
+
+	err := api.SetSwapRate(newSwapRate(common.Address{}, fromToken, toToken, 3, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pfs.submitted))
+	assert.Equal(t, api.Photon.NodeAddress, pfs.submitted[0].MarketMaker)
+
+	//PFS 没有接受,本地的价格也不能变
+	pfs.setErr = rerr.ErrPFS
+	err = api.SetSwapRate(newSwapRate(common.Address{}, fromToken, toToken, 5, 2))
+	assert.NotNil(t, err)
+	err = api.SetSwapRate(newSwapRate(common.Address{}, toToken, fromToken, 2, 3))
+	assert.NotNil(t, err)
+	err = api.RemoveSwapRate(fromToken, toToken)
+	assert.NotNil(t, err)
+	list, err := api.GetSwapRates()
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(list)) {
+		assert.Equal(t, fromToken, list[0].FromToken)
+		assert.EqualValues(t, big.NewInt(3), list[0].RateNumerator)
+	}
+
+	pfs.setErr = nil
+	err = api.RemoveSwapRate(fromToken, toToken)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pfs.submitted))
+	list, err = api.GetSwapRates()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestGetSwapQuote(t *testing.T) {
+	self := utils.NewRandomAddress()
+	fromToken, toToken := utils.NewRandomAddress(), utils.NewRandomAddress()
+	api := &API{Photon: &Service{NodeAddress: self}}
+	_, err := api.GetSwapQuote(fromToken, toToken, big.NewInt(100))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, rerr.ErrPFSNotConfigured.ErrorCode, err.(rerr.StandardError).ErrorCode)
+	}
+
+	expensive, cheap, noReturn, tooSmall := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	small := newSwapRate(tooSmall, fromToken, toToken, 2, 1)
+	small.MinAmount = big.NewInt(1000)
+	pfs := &swapTestPfs{
+		rates: []*models.SwapRate{
+			newSwapRate(expensive, fromToken, toToken, 3, 2),
+			newSwapRate(cheap, fromToken, toToken, 3, 2),
+			newSwapRate(noReturn, fromToken, toToken, 2, 1),
+			newSwapRate(self, fromToken, toToken, 2, 1),
+			small,
+		},
+		paths: make(map[swapPathKey][]pfsproxy.FindPathResponse),
+	}
+	route := func(fee int64) []pfsproxy.FindPathResponse {
+		return []pfsproxy.FindPathResponse{{Fee: big.NewInt(fee + 1)}, {Fee: big.NewInt(fee)}}
+	}
+	for mm, fee := range map[common.Address]int64{expensive: 5, cheap: 2, noReturn: 1, tooSmall: 1} {
+		pfs.paths[swapPathKey{self, mm, fromToken}] = route(fee)
+		if mm != noReturn {
+			pfs.paths[swapPathKey{mm, self, toToken}] = route(fee)
+		}
+	}
+	api.Photon.PfsProxy = pfs
+
+	quotes, err := api.GetSwapQuote(fromToken, toToken, big.NewInt(100))
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(quotes)) {
+		assert.Equal(t, cheap, quotes[0].MarketMaker)
+		assert.EqualValues(t, big.NewInt(150), quotes[0].ToAmount)
+		assert.EqualValues(t, big.NewInt(2), quotes[0].FromFee)
+		assert.EqualValues(t, big.NewInt(2), quotes[0].ToFee)
+		assert.Equal(t, 2, len(quotes[0].RouteInfo))
+		assert.Equal(t, expensive, quotes[1].MarketMaker)
+		assert.EqualValues(t, big.NewInt(5), quotes[1].FromFee)
+	}
+	_, err = api.GetSwapQuote(fromToken, toToken, big.NewInt(0))
+	assert.NotNil(t, err)
+}
+
+func TestGetTokenSwapRouteInfo(t *testing.T) {
+	self, target, token := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	rs := &Service{NodeAddress: self}
+	given := []pfsproxy.FindPathResponse{{PathID: 1}}
+	assert.Equal(t, given, rs.getTokenSwapRouteInfo(token, target, big.NewInt(10), given))
+	//没有PFS,交给本地的 ChannelGraph
+	assert.Empty(t, rs.getTokenSwapRouteInfo(token, target, big.NewInt(10), nil))
+
+	pfs := &swapTestPfs{paths: map[swapPathKey][]pfsproxy.FindPathResponse{
+		{self, target, token}: {{PathID: 2}, {PathID: 3}},
+	}}
+	rs.PfsProxy = pfs
+	assert.Equal(t, given, rs.getTokenSwapRouteInfo(token, target, big.NewInt(10), given))
+	assert.Equal(t, 2, len(rs.getTokenSwapRouteInfo(token, target, big.NewInt(10), nil)))
+	pfs.findErr = rerr.ErrPFS
+	assert.Empty(t, rs.getTokenSwapRouteInfo(token, target, big.NewInt(10), nil))
+}
+
+func TestListenTokenSwapMaker(t *testing.T) {
+	chFrom, _ := channel.MakeTestPairChannel()
+	chTo, _ := channel.MakeTestPairChannel()
+	maker, taker := utils.NewRandomAddress(), utils.NewRandomAddress()
+	rs := &Service{
+		NodeAddress:                         maker,
+		SecretRequestPredictorMap:           make(map[common.Hash]SecretRequestPredictor),
+		ReceivedMediatedTrasnferListenerMap: make(map[*ReceivedMediatedTrasnferListener]bool),
+		SentMediatedTransferListenerMap:     make(map[*SentMediatedTransferListener]bool),
+		Token2ChannelGraph:                  make(map[common.Address]*graph.ChannelGraph),
+	}
+	for _, ch := range []*channel.Channel{chFrom, chTo} {
+		rs.Token2ChannelGraph[ch.TokenAddress] = &graph.ChannelGraph{
+			ChannelIdentifier2Channel: map[common.Hash]*channel.Channel{ch.ChannelIdentifier.ChannelIdentifier: ch},
+		}
+	}
+	tokenswap := &TokenSwap{
+		LockSecretHash:  utils.NewRandomHash(),
+		FromToken:       chFrom.TokenAddress,
+		FromAmount:      big.NewInt(100),
+		FromNodeAddress: maker,
+		ToToken:         chTo.TokenAddress,
+		ToAmount:        big.NewInt(150),
+		ToNodeAddress:   taker,
+	}
+	rs.listenTokenSwapMaker(tokenswap)
+	mtr := func(ch *channel.Channel, target common.Address, amount, fee int64) *encoding.MediatedTransfer {
+		m := &encoding.MediatedTransfer{
+			LockSecretHash: tokenswap.LockSecretHash,
+			PaymentAmount:  big.NewInt(amount),
+			Fee:            big.NewInt(fee),
+			Target:         target,
+		}
+		m.ChannelIdentifier = ch.ChannelIdentifier.ChannelIdentifier
+		return m
+	}
+	onSent := func(m *encoding.MediatedTransfer) {
+		for f := range rs.SentMediatedTransferListenerMap {
+			(*f)(m)
+		}
+	}
+	onReceived := func(m *encoding.MediatedTransfer) (removed bool) {
+		for f := range rs.ReceivedMediatedTrasnferListenerMap {
+			if (*f)(m) {
+				delete(rs.ReceivedMediatedTrasnferListenerMap, f)
+				removed = true
+			}
+		}
+		return
+	}
+
+	//经过中间节点, PaymentAmount 包含手续费
+	onSent(mtr(chFrom, taker, 103, 0))
+	assert.Empty(t, rs.SecretRequestPredictorMap)
+	onSent(mtr(chFrom, taker, 103, 3))
+	secretRequestHook := rs.SecretRequestPredictorMap[tokenswap.LockSecretHash]
+	if !assert.NotNil(t, secretRequestHook) {
+		return
+	}
+	assert.True(t, secretRequestHook(&encoding.SecretRequest{}))
+
+	//taker 的交易到账和 ToAmount 不一致,不能发送密码
+	assert.False(t, onReceived(mtr(chTo, maker, 149, 0)))
+	assert.False(t, onReceived(mtr(chFrom, maker, 150, 0)))
+	//多付也不行
+	assert.False(t, onReceived(mtr(chTo, maker, 151, 0)))
+	assert.True(t, onReceived(mtr(chTo, maker, 152, 2)))
+	assert.Empty(t, rs.SentMediatedTransferListenerMap)
+	assert.False(t, secretRequestHook(&encoding.SecretRequest{}))
+	assert.Empty(t, rs.SecretRequestPredictorMap)
+}
+
+func TestMessageTokenSwapTakerAmount(t *testing.T) {
+	maker, taker := utils.NewRandomAddress(), utils.NewRandomAddress()
+	rs := &Service{NodeAddress: taker}
+	tokenswap := &TokenSwap{
+		LockSecretHash:  utils.NewRandomHash(),
+		FromToken:       utils.NewRandomAddress(),
+		FromAmount:      big.NewInt(100),
+		FromNodeAddress: maker,
+		ToToken:         utils.NewRandomAddress(),
+		ToAmount:        big.NewInt(150),
+		ToNodeAddress:   taker,
+	}
+	for _, amount := range [][2]int64{{99, 0}, {101, 0}, {103, 2}} {
+		msg := &encoding.MediatedTransfer{
+			LockSecretHash: tokenswap.LockSecretHash,
+			PaymentAmount:  big.NewInt(amount[0]),
+			Fee:            big.NewInt(amount[1]),
+			Initiator:      maker,
+			Target:         taker,
+		}
+		assert.False(t, rs.messageTokenSwapTaker(msg, tokenswap), "amount=%d,fee=%d", amount[0], amount[1])
+	}
+	assert.EqualValues(t, big.NewInt(100), tokenSwapTargetAmount(&encoding.MediatedTransfer{PaymentAmount: big.NewInt(103), Fee: big.NewInt(3)}))
+	assert.EqualValues(t, big.NewInt(100), tokenSwapTargetAmount(&encoding.MediatedTransfer{PaymentAmount: big.NewInt(100)}))
+}
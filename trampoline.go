@@ -0,0 +1,210 @@
+package photon
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/encoding"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/network/graph"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+Trampoline 路由:
+手机节点没有完整的网络拓扑,也不一定能访问 pfs,发起交易时只指定一个或几个超级节点(trampoline)和最终的接收方,
+MediatedTransfer 的 Path 只到第一个 trampoline,还需要经过的 trampoline 放在 TrampolineOnion 中.
+洋葱的每一层用这一层 trampoline 的公钥加密,内容是它的下一跳(下一个 trampoline 或者接收方)和里面一层,
+trampoline 收到以后剥开自己这一层,用本地路由或者 pfs 计算到下一跳的路由,除了通道的手续费还额外收取 trampoline 手续费.
+发起方给出的手续费是整个交易的预算,trampoline 的手续费加上它找到的路由的手续费不能超过剩下的预算.
+Path 没有加密,中间节点只能看到密文,不知道后面还有哪些 trampoline.
+带洋葱的消息是 version 2,只发给回复过 AckTrampolineVersion 的节点,老节点处理不了.
+*/
+/*
+ *	Trampoline routing :
+ *	mobile nodes don't have a full view of the network and may not access pfs, they only specify one or more
+ *	supernodes (trampolines) and the final target. Path of MediatedTransfer ends at the first trampoline,
+ *	TrampolineOnion holds the trampolines still to visit. Each layer is encrypted to its trampoline and holds the next hop
+ *	(next trampoline or target) and the inner layer. A trampoline peels its layer and computes the route to the next hop
+ *	from local graph or pfs, charging a trampoline fee besides the fee of the channel.
+ *	Fee given by the initiator is the budget of the whole transfer, fee of a trampoline plus fee of the route it finds must fit in what is left.
+ *	Path is not encrypted, mediators only see the ciphertext of the onion and don't know the trampolines after it.
+ *	Message with an onion is version 2, it's only sent to nodes which have replied an ack of AckTrampolineVersion.
+ */
+
+//trampolineFee 作为 trampoline 转发 amount 收取的手续费
+func (rs *Service) trampolineFee(partner, tokenAddress common.Address, amount *big.Int) *big.Int {
+	fee := new(big.Int).Set(rs.FeePolicy.GetNodeChargeFee(partner, tokenAddress, amount))
+	if rs.Config.TrampolineFeePercent > 0 {
+		fee.Add(fee, new(big.Int).Div(amount, big.NewInt(rs.Config.TrampolineFeePercent)))
+	}
+	return fee
+}
+
+/*
+trampolineRoutes 收到以我为 trampoline 的 MediatedTransfer,计算到下一个 trampoline 或者接收方的路由.
+pfs 的查询在 service 的 goroutine 中进行,pfsproxy 会缓存结果
+*/
+func (rs *Service) trampolineRoutes(msg *encoding.MediatedTransfer, ch *channel.Channel) (routes []*route.State) {
+	if !rs.Config.EnableTrampoline {
+		log.Warn(fmt.Sprintf("receive MediatedTransfer %s asking me to be trampoline, but trampoline is disabled", utils.HPex(msg.LockSecretHash)))
+		return
+	}
+	nextTarget, innerOnion, err := peelTrampolineOnion(rs.PrivateKey, msg.TrampolineOnion)
+	if err != nil {
+		log.Warn(fmt.Sprintf("receive MediatedTransfer %s with invalid trampoline onion %s", utils.HPex(msg.LockSecretHash), err))
+		return
+	}
+	if len(innerOnion) == 0 && nextTarget != msg.Target {
+		log.Warn(fmt.Sprintf("receive MediatedTransfer %s, I'm the last trampoline but next hop %s is not target",
+			utils.HPex(msg.LockSecretHash), utils.APex2(nextTarget)))
+		return
+	}
+	targetAmount := new(big.Int).Sub(msg.PaymentAmount, msg.Fee)
+	exclude := graph.MakeExclude(msg.Sender, msg.Initiator)
+	var paths []pfsproxy.FindPathResponse
+	if rs.PfsProxy != nil {
+		paths, err = rs.PfsProxy.FindPath(rs.NodeAddress, nextTarget, ch.TokenAddress, targetAmount, true)
+		if err != nil {
+			log.Error(fmt.Sprintf("trampoline find path to %s err %s", utils.APex2(nextTarget), err))
+			return
+		}
+	} else {
+		g := rs.getToken2ChannelGraph(ch.TokenAddress) //must exist
+		for _, r := range g.GetBestRoutes(rs.Protocol, rs.NodeAddress, nextTarget, targetAmount, targetAmount, exclude, rs, rs.MissionControl) {
+			paths = append(paths, pfsproxy.FindPathResponse{
+				Fee:    r.TotalFee,
+				Result: addressesToStrings(r.Path),
+			})
+		}
+	}
+	for _, p := range paths {
+		path := p.GetPath()
+		if len(path) == 0 || len(path) > params.TrampolineMaxHops || containsAnyAddress(path, exclude) || containsAddress(path, rs.NodeAddress) {
+			continue
+		}
+		nextChan := rs.getChannel(ch.TokenAddress, path[0])
+		if nextChan == nil {
+			continue
+		}
+		if len(innerOnion) > 0 && !rs.Protocol.SupportsTrampoline(path[0]) {
+			log.Info(fmt.Sprintf("trampoline route %s ignored, %s can not handle trampoline onion", p.Result, utils.APex2(path[0])))
+			continue
+		}
+		downstreamFee := p.Fee
+		if downstreamFee == nil {
+			downstreamFee = utils.BigInt0
+		}
+		fee := rs.trampolineFee(nextChan.PartnerState.Address, nextChan.TokenAddress, targetAmount)
+		if msg.Fee.Cmp(new(big.Int).Add(fee, downstreamFee)) < 0 {
+			log.Info(fmt.Sprintf("trampoline route %s ignored, fee left %s < trampoline fee %s + route fee %s",
+				p.Result, msg.Fee, fee, downstreamFee))
+			continue
+		}
+		r := route.NewState(nextChan, path)
+		r.Fee = fee
+		r.TrampolineOnion = innerOnion
+		routes = append(routes, r)
+	}
+	rs.MissionControl.SortRoutes(ch.TokenAddress, targetAmount, routes)
+	log.Trace(fmt.Sprintf("trampoline %s to %s routes=%s", utils.HPex(msg.LockSecretHash), utils.APex2(nextTarget), utils.StringInterface(routes, 3)))
+	return
+}
+
+/*
+setTrampolineRoute 发起方为经过 trampolines 的路由构造洋葱,第一跳处理不了洋葱或者不知道 trampoline 的公钥时不能使用这条路由
+*/
+func (rs *Service) setTrampolineRoute(r *route.State, target common.Address, trampolines []common.Address) error {
+	r.Trampolines = trampolines
+	if len(trampolines) == 0 {
+		return nil
+	}
+	if !rs.Protocol.SupportsTrampoline(r.HopNode()) {
+		return rerr.ErrNoAvailabeRoute.Printf("%s can not handle trampoline onion", utils.APex2(r.HopNode()))
+	}
+	pubkeys := make([][]byte, len(trampolines))
+	for i, t := range trampolines {
+		pubkey, err := rs.dao.GetNodePubKey(t)
+		if err != nil {
+			return rerr.ErrUnknownAddress.Append(fmt.Sprintf("public key of trampoline %s is unknown", t.String()))
+		}
+		pubkeys[i] = pubkey
+	}
+	onion, err := makeTrampolineOnion(pubkeys, trampolines, target)
+	if err != nil {
+		return err
+	}
+	r.TrampolineOnion = onion
+	return nil
+}
+
+/*
+makeTrampolineOnion 从最里面一层开始加密,第 i 层用 trampolines[i] 的公钥加密,内容是它的下一跳和里面一层
+*/
+func makeTrampolineOnion(pubkeys [][]byte, trampolines []common.Address, target common.Address) (onion []byte, err error) {
+	next := target
+	for i := len(trampolines) - 1; i >= 0; i-- {
+		onion, err = utils.EncryptBytes(pubkeys[i], append(next.Bytes(), onion...))
+		if err != nil {
+			return nil, rerr.ErrArgumentError.AppendError(err)
+		}
+		next = trampolines[i]
+	}
+	return
+}
+
+//peelTrampolineOnion 剥开我这一层,里面一层为空说明我是最后一个 trampoline
+func peelTrampolineOnion(privKey *ecdsa.PrivateKey, onion []byte) (next common.Address, inner []byte, err error) {
+	data, err := utils.DecryptBytes(privKey, onion)
+	if err != nil {
+		return
+	}
+	if len(data) < common.AddressLength {
+		err = errors.New("trampoline onion too short")
+		return
+	}
+	next = common.BytesToAddress(data[:common.AddressLength])
+	inner = data[common.AddressLength:]
+	return
+}
+
+func addressesToStrings(addrs []common.Address) (ss []string) {
+	for _, addr := range addrs {
+		ss = append(ss, addr.String())
+	}
+	return
+}
+
+func containsAnyAddress(path []common.Address, addrs map[common.Address]bool) bool {
+	for _, addr := range path {
+		if addrs[addr] {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+validateTrampolines trampoline 不能重复,不能是我自己或者接收方
+*/
+func validateTrampolines(our, target common.Address, trampolines []common.Address) error {
+	if len(trampolines) == 0 || len(trampolines) > params.MaxTrampolines {
+		return rerr.ErrArgumentError.Printf("number of trampolines must be in [1,%d]", params.MaxTrampolines)
+	}
+	seen := graph.MakeExclude(our, target, utils.EmptyAddress)
+	for _, t := range trampolines {
+		if seen[t] {
+			return rerr.ErrArgumentError.Printf("invalid trampoline %s", utils.APex2(t))
+		}
+		seen[t] = true
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package photon
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/encoding"
+	"github.com/MetaLife-Protocol/SuperNode/network"
+	"github.com/MetaLife-Protocol/SuperNode/network/graph"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/pfsproxy"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/route"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+type trampolineTestPfs struct {
+	pfsproxy.PfsProxy
+	paths map[common.Address][]pfsproxy.FindPathResponse
+}
+
+func (p *trampolineTestPfs) FindPath(peerFrom, peerTo, token common.Address, amount *big.Int, isInitiator bool) ([]pfsproxy.FindPathResponse, error) {
+	return p.paths[peerTo], nil
+}
+
+func newTrampolineTestKey() (*ecdsa.PrivateKey, common.Address, []byte) {
+	key, _ := crypto.GenerateKey()
+	return key, crypto.PubkeyToAddress(key.PublicKey), crypto.FromECDSAPub(&key.PublicKey)
+}
+
+func TestValidateTrampolines(t *testing.T) {
+	our, target := utils.NewRandomAddress(), utils.NewRandomAddress()
+	t1, t2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	assert.Nil(t, validateTrampolines(our, target, []common.Address{t1}))
+	assert.Nil(t, validateTrampolines(our, target, []common.Address{t1, t2}))
+	assert.NotNil(t, validateTrampolines(our, target, nil))
+	assert.NotNil(t, validateTrampolines(our, target, []common.Address{t1, t1}))
+	assert.NotNil(t, validateTrampolines(our, target, []common.Address{our}))
+	assert.NotNil(t, validateTrampolines(our, target, []common.Address{t1, target}))
+	assert.NotNil(t, validateTrampolines(our, target, []common.Address{utils.EmptyAddress}))
+	assert.NotNil(t, validateTrampolines(our, target, []common.Address{t1, t2, utils.NewRandomAddress(), utils.NewRandomAddress()}))
+}
+
+func TestTrampolineOnion(t *testing.T) {
+	key1, t1, pub1 := newTrampolineTestKey()
+	key2, t2, pub2 := newTrampolineTestKey()
+	key3, t3, pub3 := newTrampolineTestKey()
+	target := utils.NewRandomAddress()
+	onion, err := makeTrampolineOnion([][]byte{pub1, pub2, pub3}, []common.Address{t1, t2, t3}, target)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, len(onion) <= params.MaxTrampolineOnionLen)
+	//只有这一层的 trampoline 能剥开
+	_, _, err = peelTrampolineOnion(key2, onion)
+	assert.NotNil(t, err)
+	next, inner, err := peelTrampolineOnion(key1, onion)
+	assert.Nil(t, err)
+	assert.Equal(t, t2, next)
+	next, inner, err = peelTrampolineOnion(key2, inner)
+	assert.Nil(t, err)
+	assert.Equal(t, t3, next)
+	next, inner, err = peelTrampolineOnion(key3, inner)
+	assert.Nil(t, err)
+	assert.Equal(t, target, next)
+	assert.Empty(t, inner)
+
+	_, err = makeTrampolineOnion([][]byte{{1, 2, 3}}, []common.Address{t1}, target)
+	assert.NotNil(t, err)
+}
+
+func TestSetTrampolineRoute(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	ch, _ := channel.MakeTestPairChannel()
+	hop := ch.PartnerState.Address
+	rs := &Service{dao: dao, Protocol: &network.PhotonProtocol{}}
+	key1, t1, pub1 := newTrampolineTestKey()
+	_, t2, pub2 := newTrampolineTestKey()
+	target := utils.NewRandomAddress()
+
+	r := route.NewState(ch, []common.Address{hop, t1})
+	assert.Nil(t, rs.setTrampolineRoute(r, target, nil))
+	assert.Empty(t, r.TrampolineOnion)
+	//第一跳处理不了洋葱
+	assert.NotNil(t, rs.setTrampolineRoute(r, target, []common.Address{t1, t2}))
+	rs.Protocol.SetTrampolinePeer(hop)
+	//不知道 trampoline 的公钥
+	assert.NotNil(t, rs.setTrampolineRoute(r, target, []common.Address{t1, t2}))
+	assert.Nil(t, dao.SaveNodePubKey(t1, pub1))
+	assert.Nil(t, dao.SaveNodePubKey(t2, pub2))
+	assert.Nil(t, rs.setTrampolineRoute(r, target, []common.Address{t1, t2}))
+	assert.Equal(t, []common.Address{t1, t2}, r.Trampolines)
+	next, inner, err := peelTrampolineOnion(key1, r.TrampolineOnion)
+	assert.Nil(t, err)
+	assert.Equal(t, t2, next)
+	assert.NotEmpty(t, inner)
+}
+
+func TestTrampolineRoutes(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	in, _ := channel.MakeTestPairChannel()
+	chA, _ := channel.MakeTestPairChannel()
+	chB, _ := channel.MakeTestPairChannel()
+	a, b := chA.PartnerState.Address, chB.PartnerState.Address
+	myKey, me, myPub := newTrampolineTestKey()
+	key2, t2, pub2 := newTrampolineTestKey()
+	target, sender, initiator := utils.NewRandomAddress(), in.PartnerState.Address, utils.NewRandomAddress()
+	pfs := &trampolineTestPfs{paths: map[common.Address][]pfsproxy.FindPathResponse{
+		t2: {
+			{Result: addressesToStrings([]common.Address{a, t2}), Fee: big.NewInt(15)},
+			//超出手续费预算
+			{Result: addressesToStrings([]common.Address{b, t2}), Fee: big.NewInt(25)},
+			//经过发送方
+			{Result: addressesToStrings([]common.Address{a, sender, t2})},
+			//经过我自己
+			{Result: addressesToStrings([]common.Address{b, me, t2})},
+			//和第一跳没有通道
+			{Result: addressesToStrings([]common.Address{utils.NewRandomAddress(), t2})},
+			//太长
+			{Result: addressesToStrings([]common.Address{a, utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), t2})},
+		},
+		target: {
+			{Result: addressesToStrings([]common.Address{b, target})},
+		},
+	}}
+	rs := &Service{
+		dao:                dao,
+		NodeAddress:        me,
+		PrivateKey:         myKey,
+		Config:             &params.Config{TrampolineFeePercent: 100},
+		PfsProxy:           pfs,
+		Protocol:           &network.PhotonProtocol{},
+		FeePolicy:          &NoFeePolicy{},
+		MissionControl:     NewMissionControl(dao),
+		Token2ChannelGraph: make(map[common.Address]*graph.ChannelGraph),
+	}
+	rs.Token2ChannelGraph[in.TokenAddress] = &graph.ChannelGraph{
+		PartenerAddress2Channel: map[common.Address]*channel.Channel{a: chA, b: chB},
+	}
+	onion, err := makeTrampolineOnion([][]byte{myPub, pub2}, []common.Address{me, t2}, target)
+	if !assert.Nil(t, err) {
+		return
+	}
+	//剩下 30 的手续费, trampoline 收取 1000/100
+	msg := &encoding.MediatedTransfer{
+		PaymentAmount: big.NewInt(1030),
+		Fee:           big.NewInt(30),
+		Target:        target,
+		Initiator:     initiator,
+	}
+	msg.Sender = sender
+	msg.SetTrampolineOnion(onion)
+
+	assert.Empty(t, rs.trampolineRoutes(msg, in))
+	rs.Config.EnableTrampoline = true
+	//下一跳处理不了洋葱
+	assert.Empty(t, rs.trampolineRoutes(msg, in))
+	rs.Protocol.SetTrampolinePeer(a)
+	rs.Protocol.SetTrampolinePeer(b)
+	routes := rs.trampolineRoutes(msg, in)
+	if assert.Equal(t, 1, len(routes)) {
+		assert.Equal(t, a, routes[0].HopNode())
+		assert.Equal(t, []common.Address{a, t2}, routes[0].Path)
+		assert.EqualValues(t, big.NewInt(10), routes[0].Fee)
+		next, inner, err := peelTrampolineOnion(key2, routes[0].TrampolineOnion)
+		assert.Nil(t, err)
+		assert.Equal(t, target, next)
+		assert.Empty(t, inner)
+	}
+
+	//不是给我的洋葱
+	msg.SetTrampolineOnion(routes[0].TrampolineOnion)
+	assert.Empty(t, rs.trampolineRoutes(msg, in))
+
+	//我是最后一个 trampoline,下一跳必须是接收方
+	onion, _ = makeTrampolineOnion([][]byte{myPub}, []common.Address{me}, t2)
+	msg.SetTrampolineOnion(onion)
+	assert.Empty(t, rs.trampolineRoutes(msg, in))
+	onion, _ = makeTrampolineOnion([][]byte{myPub}, []common.Address{me}, target)
+	msg.SetTrampolineOnion(onion)
+	routes = rs.trampolineRoutes(msg, in)
+	if assert.Equal(t, 1, len(routes)) {
+		assert.Equal(t, b, routes[0].HopNode())
+		assert.Empty(t, routes[0].TrampolineOnion)
+	}
+}
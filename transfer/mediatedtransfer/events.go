@@ -27,22 +27,24 @@ type EventSendMediatedTransfer struct {
 	// no matter which channel received a mediated transfer, I have to send another mediated transfer,
 	// because which channel receives MediatedTransfer and leads me to send a new Transfer
 	// If I am the transfer initiator, then FromChannel should be null.
-	FromChannel common.Hash
-	Path        []common.Address //2019-03 消息升级后,带全路径path
+	FromChannel     common.Hash
+	Path            []common.Address //2019-03 消息升级后,带全路径path
+	TrampolineOnion []byte           //Path 之后还需要经过的 trampoline 节点,加密的
 }
 
 //NewEventSendMediatedTransfer create EventSendMediatedTransfer
 func NewEventSendMediatedTransfer(transfer *LockedTransferState, receiver common.Address, path []common.Address) *EventSendMediatedTransfer {
 	return &EventSendMediatedTransfer{
-		Token:          transfer.Token,
-		Amount:         new(big.Int).Set(transfer.Amount),
-		LockSecretHash: transfer.LockSecretHash,
-		Initiator:      transfer.Initiator,
-		Target:         transfer.Target,
-		Expiration:     transfer.Expiration,
-		Receiver:       receiver,
-		Fee:            transfer.Fee,
-		Path:           path,
+		Token:           transfer.Token,
+		Amount:          new(big.Int).Set(transfer.Amount),
+		LockSecretHash:  transfer.LockSecretHash,
+		Initiator:       transfer.Initiator,
+		Target:          transfer.Target,
+		Expiration:      transfer.Expiration,
+		Receiver:        receiver,
+		Fee:             transfer.Fee,
+		Path:            path,
+		TrampolineOnion: transfer.TrampolineOnion,
 	}
 }
 
@@ -235,6 +237,22 @@ type EventSaveFeeChargeRecord struct {
 	BlockNumber    int64          `json:"block_number"`
 }
 
+/*
+EventRouteFinished 发起方一条路由尝试的结果,成功或者收到了 AnnounceDisposed,用于学习路径上通道的可用性
+*/
+/*
+ *	EventRouteFinished : result of a route tried by the initiator, success or AnnounceDisposed received,
+ *	used to learn availability of channels on the path.
+ */
+type EventRouteFinished struct {
+	Token    common.Address
+	Path     []common.Address // 不包含发起方	// initiator is not included
+	Amount   *big.Int         // 发给第一跳的金额	// amount sent to the first hop
+	Success  bool
+	Reason   string
+	Reporter common.Address // 失败时发送 AnnounceDisposed 的节点	// node which sent AnnounceDisposed on failure
+}
+
 func init() {
 	gob.Register(&EventSendMediatedTransfer{})
 	gob.Register(&EventSendRevealSecret{})
@@ -247,4 +265,5 @@ func init() {
 	gob.Register(&EventUnlockFailed{})
 	gob.Register(&EventWithdrawSuccess{})
 	gob.Register(&EventWithdrawFailed{})
+	gob.Register(&EventRouteFinished{})
 }
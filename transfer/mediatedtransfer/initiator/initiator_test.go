@@ -199,7 +199,7 @@ func TestStateWaitUnlockValid(t *testing.T) {
 		Sender: mediatorAddress,
 	}
 	events := sm.Dispatch(stateChange)
-	assert(t, len(events), 4)
+	assert(t, len(events), 5)
 	var EventSendBalanceProof *mediatedtransfer.EventSendBalanceProof
 	var EventTransferSentSuccess *transfer.EventTransferSentSuccess
 	var EventUnlockSuccess *mediatedtransfer.EventUnlockSuccess
@@ -292,9 +292,14 @@ func TestRefundTransferNextRoute(t *testing.T) {
 	sm := transfer.NewStateManager(StateTransition, currentState, NameInitiatorTransition, utils.ShaSecret([]byte("3")), utils.NewRandomAddress())
 
 	events := sm.Dispatch(stateChange)
-	assert(t, len(events), 2)
+	assert(t, len(events), 3)
 	_, ok := events[0].(*mediatedtransfer.EventSendMediatedTransfer)
 	assert(t, ok, true, "No mediated transfer event emitted, should have tried a new route")
+	routeFailed, ok := events[2].(*mediatedtransfer.EventRouteFinished)
+	assert(t, ok, true)
+	assert(t, routeFailed.Success, false)
+	assert(t, routeFailed.Amount, priorState.Transfer.Amount)
+	assert(t, routeFailed.Reporter, mediatorAddress)
 	assert(t, sm.CurrentState != nil, true)
 	//assert(t, currentState.Routes.CanceledRoutes[0], priorState.Route)
 }
@@ -326,7 +331,7 @@ func TestRefundTransferNoMoreRoutes(t *testing.T) {
 	sm := transfer.NewStateManager(StateTransition, currentState, NameInitiatorTransition, utils.ShaSecret([]byte("3")), utils.NewRandomAddress())
 
 	events := sm.Dispatch(stateChange)
-	assert(t, len(events), 3)
+	assert(t, len(events), 4)
 	_, ok := events[0].(*transfer.EventTransferSentFailed)
 	assert(t, ok, true)
 	assert(t, sm.CurrentState == nil, true)
@@ -129,16 +129,17 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		lockExpiration = state.Transfer.Expiration
 	}
 	tr := &mt.LockedTransferState{
-		TargetAmount:   state.Transfer.TargetAmount,
-		Amount:         new(big.Int).Add(state.Transfer.TargetAmount, tryRoute.TotalFee),
-		Token:          state.Transfer.Token,
-		Initiator:      state.Transfer.Initiator,
-		Target:         state.Transfer.Target,
-		Expiration:     lockExpiration,
-		LockSecretHash: state.LockSecretHash,
-		Secret:         state.Secret,
-		Fee:            tryRoute.TotalFee,
-		Data:           state.Transfer.Data,
+		TargetAmount:    state.Transfer.TargetAmount,
+		Amount:          new(big.Int).Add(state.Transfer.TargetAmount, tryRoute.TotalFee),
+		Token:           state.Transfer.Token,
+		Initiator:       state.Transfer.Initiator,
+		Target:          state.Transfer.Target,
+		Expiration:      lockExpiration,
+		LockSecretHash:  state.LockSecretHash,
+		Secret:          state.Secret,
+		Fee:             tryRoute.TotalFee,
+		Data:            state.Transfer.Data,
+		TrampolineOnion: tryRoute.TrampolineOnion,
 	}
 	msg := mt.NewEventSendMediatedTransfer(tr, tryRoute.HopNode(), tryRoute.Path)
 	if len(state.Routes.CanceledRoutes) > 0 {
@@ -207,16 +208,24 @@ func handleBlock(state *mt.InitiatorState, stateChange *transfer.BlockStateChang
 
 func handleRefund(state *mt.InitiatorState, stateChange *mt.ReceiveAnnounceDisposedStateChange) *transfer.TransitionResult {
 	if mediator.IsValidRefund(state.Transfer, state.Route, stateChange) {
-		it := cancelCurrentRoute(state, rerr.StandardError{
+		reason := rerr.StandardError{
 			ErrorCode: stateChange.Message.ErrorCode,
 			ErrorMsg:  stateChange.Message.ErrorMsg,
-		}.Error())
+		}.Error()
+		routeFailed := &mt.EventRouteFinished{
+			Token:    state.Transfer.Token,
+			Path:     state.Route.Path,
+			Amount:   state.Transfer.Amount,
+			Reason:   reason,
+			Reporter: stateChange.Sender,
+		}
+		it := cancelCurrentRoute(state, reason)
 		ev := &mt.EventSendAnnounceDisposedResponse{
 			LockSecretHash: stateChange.Lock.LockSecretHash,
 			Token:          state.Transfer.Token,
 			Receiver:       stateChange.Sender,
 		}
-		it.Events = append(it.Events, ev)
+		it.Events = append(it.Events, ev, routeFailed)
 		return it
 	}
 	return &transfer.TransitionResult{
@@ -343,7 +352,13 @@ func transferSuccessEvents(state *mt.InitiatorState) (events []transfer.Event) {
 	removeManager := &mt.EventRemoveStateManager{
 		Key: utils.Sha3(tr.LockSecretHash[:], tr.Token[:]),
 	}
-	events = []transfer.Event{unlockLock, transferSuccess, unlockSuccess, removeManager}
+	routeSuccess := &mt.EventRouteFinished{
+		Token:   tr.Token,
+		Path:    state.Route.Path,
+		Amount:  tr.Amount,
+		Success: true,
+	}
+	events = []transfer.Event{unlockLock, transferSuccess, unlockSuccess, removeManager, routeSuccess}
 	return events
 }
 
@@ -369,15 +369,16 @@ func nextTransferPair(payerRoute *route.State, payerTransfer *mediatedtransfer.L
 	lockTimeout := timeoutBlocks //- payeeRoute.RevealTimeout()
 	lockExpiration := int64(lockTimeout) + blockNumber
 	payeeTransfer := &mediatedtransfer.LockedTransferState{
-		TargetAmount:   payerTransfer.TargetAmount,
-		Amount:         big.NewInt(0).Sub(payerTransfer.Amount, payeeRoute.Fee),
-		Token:          payerTransfer.Token,
-		Initiator:      payerTransfer.Initiator,
-		Target:         payerTransfer.Target,
-		Expiration:     lockExpiration,
-		LockSecretHash: payerTransfer.LockSecretHash,
-		Secret:         payerTransfer.Secret,
-		Fee:            big.NewInt(0).Sub(payerTransfer.Fee, payeeRoute.Fee),
+		TargetAmount:    payerTransfer.TargetAmount,
+		Amount:          big.NewInt(0).Sub(payerTransfer.Amount, payeeRoute.Fee),
+		Token:           payerTransfer.Token,
+		Initiator:       payerTransfer.Initiator,
+		Target:          payerTransfer.Target,
+		Expiration:      lockExpiration,
+		LockSecretHash:  payerTransfer.LockSecretHash,
+		Secret:          payerTransfer.Secret,
+		Fee:             big.NewInt(0).Sub(payerTransfer.Fee, payeeRoute.Fee),
+		TrampolineOnion: payeeRoute.TrampolineOnion,
 	}
 	if payeeRoute.HopNode() == payeeTransfer.Target {
 		//i'm the last hop,so take the rest of the fee
@@ -286,6 +286,21 @@ func (e *ContractHistoryEventCompleteStateChange) GetBlockNumber() int64 {
 	return e.BlockNumber
 }
 
+/*
+ContractReorgStateChange 公链发生了分叉,从 ForkBlockNumber 开始的块被替换了,
+Orphaned 是已经交给 photon 处理过,但是没有被新的主链重新打包的事件
+*/
+type ContractReorgStateChange struct {
+	ForkBlockNumber int64
+	BlockNumber     int64
+	Orphaned        []ContractStateChange
+}
+
+//GetBlockNumber return when this event occur
+func (e *ContractReorgStateChange) GetBlockNumber() int64 {
+	return e.BlockNumber
+}
+
 func init() {
 	gob.Register(&ActionInitInitiatorStateChange{})
 	gob.Register(&ActionInitMediatorStateChange{})
@@ -318,6 +318,18 @@ func checkProof(proof []common.Hash, root, hash common.Hash) bool {
 	return hash == root
 }
 
+//CheckProofBytes 验证 Proof2Bytes 编码的 proof,hash 是否在 root 对应的 merkle tree 中
+func CheckProofBytes(proof []byte, root, hash common.Hash) bool {
+	if len(proof)%len(hash) != 0 {
+		return false
+	}
+	var hashes []common.Hash
+	for i := 0; i < len(proof); i += len(hash) {
+		hashes = append(hashes, common.BytesToHash(proof[i:i+len(hash)]))
+	}
+	return checkProof(hashes, root, hash)
+}
+
 //Proof2Bytes convert proof to bytes
 func Proof2Bytes(proof []common.Hash) []byte {
 	buf := new(bytes.Buffer)
@@ -25,6 +25,9 @@ type State struct {
 	Fee               *big.Int         // how much fee to this channel charge charge .
 	TotalFee          *big.Int         // how much fee for all path when initiator use this route
 	Path              []common.Address // 2019-03消息升级,路由中保存该条路径上所有节点,有序
+	MinRevealTimeout  int              // 根据链上状况动态计算的 reveal timeout,不会小于通道自身的 RevealTimeout	// reveal timeout chosen by expiration policy, never less than the channel's
+	Trampolines       []common.Address // Path 之后还需要经过的 trampoline 节点,Path 的最后一个节点是 Trampolines[0],只有发起方知道	// trampoline nodes after Path, the last node of Path is Trampolines[0], only known by the initiator
+	TrampolineOnion   []byte           // 发给下一跳的 trampoline 洋葱	// trampoline onion sent to the next hop
 }
 
 //NewState create route state
@@ -51,8 +54,11 @@ func (rs *State) SettleTimeout() int {
 	return rs.ch.SettleTimeout
 }
 
-//RevealTimeout reveal timeout of this channel
+//RevealTimeout reveal timeout of this channel, or MinRevealTimeout if it is larger
 func (rs *State) RevealTimeout() int {
+	if rs.MinRevealTimeout > rs.ch.RevealTimeout {
+		return rs.MinRevealTimeout
+	}
 	return rs.ch.RevealTimeout
 }
 
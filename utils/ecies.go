@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// EncryptedDataPrefix marks the transfer data is encrypted by ECIES to the target's public key
+// 交易附加信息加密后的格式为 ecies:base64(密文),中间节点只能看到密文
+const EncryptedDataPrefix = "ecies:"
+
+// EncryptOverhead 密文比明文多出的长度: 65 字节的临时公钥,16 字节的 IV 和 32 字节的 MAC
+const EncryptOverhead = 65 + 16 + 32
+
+//IsEncryptedData returns true if data is produced by EncryptData
+func IsEncryptedData(data string) bool {
+	return strings.HasPrefix(data, EncryptedDataPrefix)
+}
+
+//EncryptData encrypt data to the owner of pubkey, pubkey is the 65 bytes uncompressed public key
+func EncryptData(pubkey []byte, data string) (encdata string, err error) {
+	ct, err := EncryptBytes(pubkey, []byte(data))
+	if err != nil {
+		return
+	}
+	encdata = EncryptedDataPrefix + base64.RawStdEncoding.EncodeToString(ct)
+	return
+}
+
+//DecryptData decrypt data produced by EncryptData with our private key
+func DecryptData(privKey *ecdsa.PrivateKey, encdata string) (data string, err error) {
+	if !IsEncryptedData(encdata) {
+		err = errors.New("data is not encrypted")
+		return
+	}
+	ct, err := base64.RawStdEncoding.DecodeString(encdata[len(EncryptedDataPrefix):])
+	if err != nil {
+		return
+	}
+	m, err := DecryptBytes(privKey, ct)
+	if err != nil {
+		return
+	}
+	data = string(m)
+	return
+}
+
+//EncryptBytes is EncryptData without encoding, ciphertext is EncryptOverhead bytes longer than data
+func EncryptBytes(pubkey []byte, data []byte) (ct []byte, err error) {
+	pub := crypto.ToECDSAPub(pubkey)
+	if pub == nil || pub.X == nil {
+		err = errors.New("invalid public key")
+		return
+	}
+	return ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), data, nil, nil)
+}
+
+//DecryptBytes decrypt ciphertext produced by EncryptBytes with our private key
+func DecryptBytes(privKey *ecdsa.PrivateKey, ct []byte) (data []byte, err error) {
+	return ecies.ImportECDSA(privKey).Decrypt(rand.Reader, ct, nil, nil)
+}
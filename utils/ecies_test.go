@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestEncryptData(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	encdata, err := EncryptData(crypto.FromECDSAPub(&key.PublicKey), "reward for 2019-03")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !IsEncryptedData(encdata) {
+		t.Error("should be encrypted data")
+		return
+	}
+	data, err := DecryptData(key, encdata)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if data != "reward for 2019-03" {
+		t.Errorf("decrypt data not match, got %s", data)
+	}
+	other, _ := crypto.GenerateKey()
+	_, err = DecryptData(other, encdata)
+	if err == nil {
+		t.Error("should not decrypt with other key")
+	}
+	_, err = EncryptData([]byte{1, 2, 3}, "abc")
+	if err == nil {
+		t.Error("should fail with invalid public key")
+	}
+}
+
+func TestEncryptDataLength(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	pub := crypto.FromECDSAPub(&key.PublicKey)
+	encdata, _ := EncryptData(pub, strings.Repeat("a", 74))
+	if len(encdata) != 256 {
+		t.Errorf("74 bytes should be encrypted to 256 bytes, got %d", len(encdata))
+	}
+	encdata, _ = EncryptData(pub, strings.Repeat("a", 75))
+	if len(encdata) <= 256 {
+		t.Errorf("75 bytes should be encrypted to more than 256 bytes, got %d", len(encdata))
+	}
+}
+
+func TestEncryptBytes(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	ct, err := EncryptBytes(crypto.FromECDSAPub(&key.PublicKey), []byte("trampoline"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ct) != len("trampoline")+EncryptOverhead {
+		t.Errorf("ciphertext should be %d bytes longer, got %d", EncryptOverhead, len(ct))
+	}
+	data, err := DecryptBytes(key, ct)
+	if err != nil || string(data) != "trampoline" {
+		t.Errorf("decrypt bytes err=%v data=%s", err, data)
+	}
+}
@@ -0,0 +1,660 @@
+package photon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/rerr"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mtree"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+Watchtower 超级节点替不在线的手机节点看守通道:
+手机节点把 ChannelInformationFor3rdParty 生成的数据(第三方地址是我)上传过来,每个通道只保存最新的一份.
+通道被对方关闭以后,在结算期的后一半替它 UpdateBalanceProof,然后 unlock 对方发给它的锁,
+对方 unlock 了已经声明放弃的锁时替它 PunishObsoleteUnlock.
+通道关闭并且对方的 balance proof 已经在链上以后,才替它在链上注册已知的密码,避免为无法 unlock 的锁付 gas.
+如果设置了 WatchtowerFee,第一次委托某个通道时必须已经给我转账了不少于 WatchtowerFee 的同种 token.
+所有操作都在 Watchtower 自己的 goroutine 中进行,不会阻塞 service 的主循环.
+*/
+/*
+ *	Watchtower : a supernode watches channels for offline mobile nodes.
+ *	Mobile nodes upload data generated by ChannelInformationFor3rdParty (with me as the third party), only the latest one of each channel is kept.
+ *	After the partner closes the channel, balance proof of the partner is updated in the second half of the settle window,
+ *	then locks sent by the partner are unlocked. Obsolete unlocks of the partner are punished.
+ *	Known secrets are registered on chain only after the channel is closed and balance proof of the partner is on chain,
+ *	so no gas is paid for locks which can never be unlocked.
+ *	When WatchtowerFee is set, the first delegation of a channel requires a transfer of at least WatchtowerFee of the same token to me.
+ *	Everything is done in the goroutine of Watchtower, the main loop of service is never blocked.
+ */
+type Watchtower struct {
+	rs              *Service
+	reqChan         chan *watchtowerReq
+	stateChangeChan chan transfer.StateChange
+}
+
+type watchtowerReq struct {
+	c3                *ChannelFor3rd
+	feeLockSecretHash common.Hash
+	result            chan error
+}
+
+//watchtowerMaxTXAttempts 同一个 UpdateBalanceProof 或者 Unlock 失败这么多次以后不再尝试,避免一直浪费 gas
+const watchtowerMaxTXAttempts = 3
+
+func newWatchtower(rs *Service) *Watchtower {
+	return &Watchtower{
+		rs:              rs,
+		reqChan:         make(chan *watchtowerReq),
+		stateChangeChan: make(chan transfer.StateChange, 1000),
+	}
+}
+
+//Delegate 接受手机节点的委托
+func (wt *Watchtower) Delegate(c3 *ChannelFor3rd, feeLockSecretHash common.Hash) error {
+	req := &watchtowerReq{
+		c3:                c3,
+		feeLockSecretHash: feeLockSecretHash,
+		result:            make(chan error, 1),
+	}
+	select {
+	case wt.reqChan <- req:
+	case <-wt.rs.quitChan:
+		return rerr.ErrStopCreateNewTransfer.Append("photon stopped")
+	}
+	return <-req.result
+}
+
+/*
+OnStateChange 在 service 的 goroutine 中调用,不能阻塞.
+块事件可以丢,下一个块还会再来;合约事件有足够大的缓冲,满了只能丢掉并记录
+*/
+func (wt *Watchtower) OnStateChange(st transfer.StateChange) {
+	switch st.(type) {
+	case *transfer.BlockStateChange:
+		select {
+		case wt.stateChangeChan <- st:
+		default:
+		}
+	case *mediatedtransfer.ContractClosedStateChange,
+		*mediatedtransfer.ContractBalanceProofUpdatedStateChange,
+		*mediatedtransfer.ContractUnlockStateChange,
+		*mediatedtransfer.ContractPunishedStateChange,
+		*mediatedtransfer.ContractSettledStateChange,
+		*mediatedtransfer.ContractCooperativeSettledStateChange,
+		*mediatedtransfer.ContractReorgStateChange:
+		select {
+		case wt.stateChangeChan <- st:
+		default:
+			//不能阻塞 service 的主循环,丢掉的事件只能等委托方重新上传或者重启以后恢复
+			log.Error(fmt.Sprintf("watchtower is too busy, drop state change %s", utils.StringInterface1(st)))
+		}
+	}
+}
+
+func (wt *Watchtower) loop() {
+	log.Info("watchtower start")
+	for {
+		select {
+		case req := <-wt.reqChan:
+			req.result <- wt.delegate(req.c3, req.feeLockSecretHash)
+		case st := <-wt.stateChangeChan:
+			wt.handleStateChange(st)
+		case <-wt.rs.quitChan:
+			return
+		}
+	}
+}
+
+func (wt *Watchtower) delegate(c3 *ChannelFor3rd, feeLockSecretHash common.Hash) (err error) {
+	rs := wt.rs
+	if _, err = rs.dao.GetChannelByAddress(c3.ChannelIdentifier); err == nil {
+		return rerr.ErrArgumentError.Append("can not watch a channel of myself")
+	}
+	token, p1, p2, err := rs.dao.GetNonParticipantChannelByID(c3.ChannelIdentifier)
+	if err != nil {
+		return rerr.ErrChannelNotFound.Printf("channel %s not found", c3.ChannelIdentifier.String())
+	}
+	if token != c3.TokenAddrss {
+		return rerr.ErrArgumentError.Printf("token of channel %s is %s", utils.HPex(c3.ChannelIdentifier), token.String())
+	}
+	var delegator common.Address
+	switch c3.PartnerAddress {
+	case p1:
+		delegator = p2
+	case p2:
+		delegator = p1
+	default:
+		return rerr.ErrArgumentError.Printf("%s is not a participant of channel %s", c3.PartnerAddress.String(), utils.HPex(c3.ChannelIdentifier))
+	}
+	err = verifyChannelFor3rd(c3, delegator, rs.NodeAddress)
+	if err != nil {
+		return
+	}
+	d, err := rs.dao.GetWatchtowerDelegate(c3.ChannelIdentifier)
+	if err != nil || d.OpenBlockNumber < c3.OpenBlockNumber {
+		//通道第一次委托给我,或者是重新打开的通道
+		d = &models.WatchtowerDelegate{
+			ChannelIdentifier: c3.ChannelIdentifier,
+			OpenBlockNumber:   c3.OpenBlockNumber,
+			TokenAddress:      token,
+			Delegator:         delegator,
+			PartnerAddress:    c3.PartnerAddress,
+		}
+		if rs.Config.WatchtowerFee > 0 {
+			err = wt.verifyFee(d, feeLockSecretHash)
+			if err != nil {
+				return
+			}
+			d.FeeLockSecretHash = feeLockSecretHash
+		}
+	} else if d.OpenBlockNumber > c3.OpenBlockNumber {
+		return rerr.ErrArgumentError.Printf("channel %s was reopened at %d", utils.HPex(c3.ChannelIdentifier), d.OpenBlockNumber)
+	} else if c3.UpdateTransfer.Nonce < d.Nonce {
+		return rerr.ErrArgumentError.Printf("nonce %d is older than %d", c3.UpdateTransfer.Nonce, d.Nonce)
+	} else if c3.UpdateTransfer.Nonce > d.Nonce {
+		//新的 balance proof,需要重新提交
+		d.PartnerProofReady = false
+		d.UpdateSubmitted = false
+	}
+	buf, err := json.Marshal(c3)
+	if err != nil {
+		return rerr.ErrArgumentError.AppendError(err)
+	}
+	d.Nonce = c3.UpdateTransfer.Nonce
+	d.Content = string(buf)
+	log.Info(fmt.Sprintf("watchtower accept delegate of channel %s from %s, nonce=%d,unlocks=%d,punishes=%d",
+		utils.HPex(d.ChannelIdentifier), utils.APex2(delegator), d.Nonce, len(c3.Unlocks), len(c3.Punishes)))
+	return rs.dao.SaveWatchtowerDelegate(d)
+}
+
+//verifyFee 委托方必须已经给我转账了不少于 WatchtowerFee 的同种 token,并且这笔转账没有用于委托其他通道
+func (wt *Watchtower) verifyFee(d *models.WatchtowerDelegate, feeLockSecretHash common.Hash) error {
+	rs := wt.rs
+	if feeLockSecretHash == utils.EmptyHash {
+		return rerr.ErrArgumentError.Printf("watchtower fee %d is required", rs.Config.WatchtowerFee)
+	}
+	rt, err := rs.dao.GetReceivedTransferByLockSecretHash(d.TokenAddress, feeLockSecretHash)
+	if err != nil {
+		return rerr.ErrArgumentError.Printf("fee transfer %s not received", feeLockSecretHash.String())
+	}
+	if rt.FromAddress != d.Delegator || rt.Amount.Cmp(big.NewInt(rs.Config.WatchtowerFee)) < 0 {
+		return rerr.ErrArgumentError.Printf("fee transfer %s is not from %s or amount %s < %d",
+			feeLockSecretHash.String(), d.Delegator.String(), rt.Amount, rs.Config.WatchtowerFee)
+	}
+	list, err := rs.dao.GetWatchtowerDelegateList()
+	if err != nil {
+		return err
+	}
+	for _, d2 := range list {
+		if d2.FeeLockSecretHash == feeLockSecretHash && d2.ChannelIdentifier != d.ChannelIdentifier {
+			return rerr.ErrArgumentError.Printf("fee transfer %s already used", feeLockSecretHash.String())
+		}
+	}
+	return nil
+}
+
+/*
+verifyChannelFor3rd 所有委托方签名的数据都要验证,避免替别人提交无效的 tx
+*/
+func verifyChannelFor3rd(c3 *ChannelFor3rd, delegator, thirdAddress common.Address) error {
+	ut := &c3.UpdateTransfer
+	if ut.Nonce > 0 {
+		if ut.TransferAmount == nil {
+			return rerr.ErrArgumentError.Append("transfer_amount is required")
+		}
+		data := balanceProofFor3rdData(c3.ChannelIdentifier, c3.OpenBlockNumber, ut.TransferAmount, ut.Locksroot, ut.Nonce)
+		signer, err := utils.Ecrecover(utils.Sha3(data), ut.NonClosingSignature)
+		if err != nil || signer != delegator {
+			return rerr.ErrArgumentError.Append("invalid non_closing_signature")
+		}
+	} else if len(c3.Unlocks) > 0 {
+		return rerr.ErrArgumentError.Append("unlocks without balance proof")
+	}
+	if len(c3.Unlocks) > params.WatchtowerMaxUnlocks {
+		return rerr.ErrArgumentError.Printf("too many unlocks %d, max %d", len(c3.Unlocks), params.WatchtowerMaxUnlocks)
+	}
+	for _, u := range c3.Unlocks {
+		if u.Lock == nil || u.Lock.Amount == nil || utils.ShaSecret(u.Secret[:]) != u.Lock.LockSecretHash {
+			return rerr.ErrArgumentError.Append("invalid lock or secret of unlock")
+		}
+		//锁必须在 balance proof 的 locksroot 中,否则 unlock 一定会失败
+		if !mtree.CheckProofBytes(u.MerkleProof, ut.Locksroot, u.Lock.Hash()) {
+			return rerr.ErrArgumentError.Printf("invalid merkle proof of unlock %s", utils.HPex(u.Lock.LockSecretHash))
+		}
+		data := unlockFor3rdData(c3.ChannelIdentifier, c3.OpenBlockNumber, ut.TransferAmount, thirdAddress, u.Lock)
+		signer, err := utils.Ecrecover(utils.Sha3(data), u.Signature)
+		if err != nil || signer != delegator {
+			return rerr.ErrArgumentError.Printf("invalid signature of unlock %s,third party must be %s", utils.HPex(u.Lock.LockSecretHash), thirdAddress.String())
+		}
+	}
+	for _, p := range c3.Punishes {
+		data := disposedProofData(p.LockHash, c3.ChannelIdentifier, c3.OpenBlockNumber, p.AdditionalHash)
+		signer, err := utils.Ecrecover(utils.Sha3(data), p.Signature)
+		if err != nil || signer != c3.PartnerAddress {
+			return rerr.ErrArgumentError.Printf("invalid signature of punish %s", utils.HPex(p.LockHash))
+		}
+	}
+	return nil
+}
+
+//disposedProofData 和 AnnounceDisposed 中对方签名的数据相同
+func disposedProofData(lockHash, channelIdentifier common.Hash, openBlockNumber int64, additionalHash common.Hash) []byte {
+	buf := new(bytes.Buffer)
+	_, err := buf.Write(params.ContractSignaturePrefix)
+	_, err = buf.Write([]byte(params.ContractDisposedProofMessageLength))
+	_, err = buf.Write(lockHash[:])
+	_, err = buf.Write(channelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, openBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(params.ChainID))
+	_, err = buf.Write(additionalHash[:])
+	if err != nil {
+		log.Error(fmt.Sprintf("buf write error %s", err))
+	}
+	return buf.Bytes()
+}
+
+func (wt *Watchtower) handleStateChange(st transfer.StateChange) {
+	var channelIdentifier common.Hash
+	var blockNumber int64
+	switch st2 := st.(type) {
+	case *transfer.BlockStateChange:
+		wt.onBlock(st2.BlockNumber)
+		return
+	case *mediatedtransfer.ContractReorgStateChange:
+		wt.onReorg(st2)
+		return
+	case *mediatedtransfer.ContractClosedStateChange:
+		channelIdentifier, blockNumber = st2.ChannelIdentifier, st2.ClosedBlock
+	case *mediatedtransfer.ContractBalanceProofUpdatedStateChange:
+		channelIdentifier, blockNumber = st2.ChannelIdentifier, st2.BlockNumber
+	case *mediatedtransfer.ContractUnlockStateChange:
+		channelIdentifier, blockNumber = st2.ChannelIdentifier, st2.BlockNumber
+	case *mediatedtransfer.ContractPunishedStateChange:
+		channelIdentifier, blockNumber = st2.ChannelIdentifier, st2.BlockNumber
+	case *mediatedtransfer.ContractSettledStateChange:
+		channelIdentifier, blockNumber = st2.ChannelIdentifier, st2.SettledBlock
+	case *mediatedtransfer.ContractCooperativeSettledStateChange:
+		channelIdentifier, blockNumber = st2.ChannelIdentifier, st2.SettledBlock
+	default:
+		return
+	}
+	d, err := wt.rs.dao.GetWatchtowerDelegate(channelIdentifier)
+	if err != nil {
+		return
+	}
+	//重启以后可能收到以前通道的事件
+	if blockNumber < d.OpenBlockNumber {
+		return
+	}
+	var c3 ChannelFor3rd
+	err = json.Unmarshal([]byte(d.Content), &c3)
+	if err != nil {
+		log.Error(fmt.Sprintf("watchtower decode delegate of %s err %s", utils.HPex(channelIdentifier), err))
+		return
+	}
+	switch st2 := st.(type) {
+	case *mediatedtransfer.ContractClosedStateChange:
+		d.ClosedBlock = st2.ClosedBlock
+		d.ClosingAddress = st2.ClosingAddress
+		if st2.ClosingAddress == d.Delegator {
+			//委托方关闭通道时已经提交了对方的 balance proof
+			d.PartnerProofReady = isSameBalanceProof(&c3.UpdateTransfer, st2.LocksRoot, st2.TransferredAmount)
+		}
+		log.Info(fmt.Sprintf("watchtower channel %s of %s closed by %s", utils.HPex(channelIdentifier), utils.APex2(d.Delegator), utils.APex2(d.ClosingAddress)))
+	case *mediatedtransfer.ContractBalanceProofUpdatedStateChange:
+		if st2.Participant != d.PartnerAddress {
+			return
+		}
+		d.PartnerProofReady = isSameBalanceProof(&c3.UpdateTransfer, st2.LocksRoot, st2.TransferAmount)
+		d.UpdateSubmitted = d.PartnerProofReady
+	case *mediatedtransfer.ContractUnlockStateChange:
+		if st2.Participant == d.PartnerAddress {
+			d.UnlockedLocks = appendHashIfNotExist(d.UnlockedLocks, st2.LockHash)
+		} else if st2.Participant == d.Delegator && !d.Punished {
+			wt.punish(d, &c3, st2.LockHash)
+		}
+	case *mediatedtransfer.ContractPunishedStateChange:
+		if st2.Beneficiary == d.Delegator {
+			d.Punished = true
+		}
+	case *mediatedtransfer.ContractSettledStateChange, *mediatedtransfer.ContractCooperativeSettledStateChange:
+		log.Info(fmt.Sprintf("watchtower channel %s of %s settled", utils.HPex(channelIdentifier), utils.APex2(d.Delegator)))
+		err = wt.rs.dao.RemoveWatchtowerDelegate(channelIdentifier)
+		if err != nil {
+			log.Error(err.Error())
+		}
+		return
+	}
+	err = wt.rs.dao.SaveWatchtowerDelegate(d)
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+/*
+onReorg 关闭,提交 balance proof,unlock,punish 事件被回滚以后,按链上现在的状态修正受影响的委托.
+settle 的委托已经删除了,没法恢复
+*/
+func (wt *Watchtower) onReorg(st *mediatedtransfer.ContractReorgStateChange) {
+	var channels []common.Hash
+	for _, sc := range st.Orphaned {
+		switch s := sc.(type) {
+		case *mediatedtransfer.ContractClosedStateChange:
+			channels = appendHashIfNotExist(channels, s.ChannelIdentifier)
+		case *mediatedtransfer.ContractBalanceProofUpdatedStateChange:
+			channels = appendHashIfNotExist(channels, s.ChannelIdentifier)
+		case *mediatedtransfer.ContractUnlockStateChange:
+			channels = appendHashIfNotExist(channels, s.ChannelIdentifier)
+		case *mediatedtransfer.ContractPunishedStateChange:
+			channels = appendHashIfNotExist(channels, s.ChannelIdentifier)
+		}
+	}
+	for _, channelIdentifier := range channels {
+		d, err := wt.rs.dao.GetWatchtowerDelegate(channelIdentifier)
+		if err != nil {
+			continue
+		}
+		var c3 ChannelFor3rd
+		err = json.Unmarshal([]byte(d.Content), &c3)
+		if err == nil {
+			err = wt.resyncDelegateAfterReorg(d, &c3)
+		}
+		if err == nil {
+			err = wt.rs.dao.SaveWatchtowerDelegate(d)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("watchtower resync channel %s after reorg err %s", utils.HPex(channelIdentifier), err))
+		}
+	}
+}
+
+func (wt *Watchtower) resyncDelegateAfterReorg(d *models.WatchtowerDelegate, c3 *ChannelFor3rd) error {
+	tn, err := wt.rs.Chain.TokenNetwork(d.TokenAddress)
+	if err != nil {
+		return err
+	}
+	_, settleBlockNumber, _, state, settleTimeout, err := tn.GetChannelInfo(d.Delegator, d.PartnerAddress)
+	if err != nil {
+		return err
+	}
+	var partnerNonce, delegatorNonce uint64
+	if state == contracts.ChannelStateClosed {
+		_, _, partnerNonce, err = tn.GetChannelParticipantInfo(d.PartnerAddress, d.Delegator)
+		if err != nil {
+			return err
+		}
+		_, _, delegatorNonce, err = tn.GetChannelParticipantInfo(d.Delegator, d.PartnerAddress)
+		if err != nil {
+			return err
+		}
+	}
+	resyncDelegateWithChain(d, c3, state, int64(settleBlockNumber), int64(settleTimeout), partnerNonce, delegatorNonce, func(lockHash common.Hash) bool {
+		unlocked, err2 := tn.QueryUnlockedLocks(d.PartnerAddress, d.Delegator, lockHash)
+		if err2 != nil {
+			err = err2
+		}
+		return unlocked
+	})
+	return err
+}
+
+/*
+resyncDelegateWithChain 按链上的状态修正委托:
+1. 关闭事件被回滚,通道又是打开的,清除所有关闭以后的状态;
+2. 通道还是关闭的,按链上对方的 nonce 判断 balance proof 是否就绪,委托方的 nonce 为最大值表示已经惩罚过对方,
+只保留链上确实已经 unlock 的锁.
+*/
+func resyncDelegateWithChain(d *models.WatchtowerDelegate, c3 *ChannelFor3rd, state uint8, settleBlock, settleTimeout int64,
+	partnerNonce, delegatorNonce uint64, isUnlocked func(lockHash common.Hash) bool) {
+	if state != contracts.ChannelStateClosed {
+		if state == contracts.ChannelStateOpened {
+			d.ClosedBlock = 0
+			d.ClosingAddress = utils.EmptyAddress
+			d.SettleBlock = 0
+			d.SettleTimeout = 0
+			d.PartnerProofReady = false
+			d.UpdateSubmitted = false
+			d.RegisteredSecrets = nil
+			d.UnlockedLocks = nil
+			d.Punished = false
+		}
+		return
+	}
+	d.SettleBlock = settleBlock
+	d.SettleTimeout = settleTimeout
+	d.PartnerProofReady = c3.UpdateTransfer.Nonce > 0 && partnerNonce == c3.UpdateTransfer.Nonce
+	d.UpdateSubmitted = d.PartnerProofReady && d.ClosingAddress == d.PartnerAddress
+	d.Punished = delegatorNonce == math.MaxUint64
+	var unlocked []common.Hash
+	for _, lockHash := range d.UnlockedLocks {
+		if isUnlocked(lockHash) {
+			unlocked = append(unlocked, lockHash)
+		}
+	}
+	d.UnlockedLocks = unlocked
+}
+
+//punish 对方 unlock 了他已经声明放弃的锁
+func (wt *Watchtower) punish(d *models.WatchtowerDelegate, c3 *ChannelFor3rd, lockHash common.Hash) {
+	for _, p := range c3.Punishes {
+		if p.LockHash != lockHash {
+			continue
+		}
+		tn, err := wt.rs.Chain.TokenNetwork(d.TokenAddress)
+		if err == nil {
+			err = tn.PunishObsoleteUnlock(d.Delegator, d.PartnerAddress, p.LockHash, p.AdditionalHash, p.Signature)
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("watchtower PunishObsoleteUnlock %s of channel %s err %s", utils.HPex(lockHash), utils.HPex(d.ChannelIdentifier), err))
+			return
+		}
+		log.Info(fmt.Sprintf("watchtower punish %s on channel %s for %s", utils.APex2(d.PartnerAddress), utils.HPex(d.ChannelIdentifier), utils.APex2(d.Delegator)))
+		d.Punished = true
+		return
+	}
+}
+
+func (wt *Watchtower) onBlock(blockNumber int64) {
+	list, err := wt.rs.dao.GetWatchtowerDelegateList()
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	for _, d := range list {
+		var c3 ChannelFor3rd
+		err = json.Unmarshal([]byte(d.Content), &c3)
+		if err != nil {
+			continue
+		}
+		if wt.watch(d, &c3, blockNumber) {
+			err = wt.rs.dao.SaveWatchtowerDelegate(d)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+}
+
+/*
+watch 返回 true 表示 d 有变化,需要保存
+*/
+func (wt *Watchtower) watch(d *models.WatchtowerDelegate, c3 *ChannelFor3rd, blockNumber int64) (changed bool) {
+	rs := wt.rs
+	if d.ClosedBlock == 0 {
+		return
+	}
+	tn, err := rs.Chain.TokenNetwork(d.TokenAddress)
+	if err != nil {
+		return
+	}
+	if d.SettleBlock == 0 {
+		_, settleBlockNumber, _, state, settleTimeout, err := tn.GetChannelInfo(d.Delegator, d.PartnerAddress)
+		if err != nil || state != contracts.ChannelStateClosed {
+			return
+		}
+		d.SettleBlock = int64(settleBlockNumber)
+		d.SettleTimeout = int64(settleTimeout)
+		changed = true
+	}
+	if blockNumber > d.SettleBlock {
+		return
+	}
+	// 1. 对方关闭的通道,在结算期的后一半替委托方提交对方的 balance proof
+	ut := &c3.UpdateTransfer
+	if d.ClosingAddress == d.PartnerAddress && ut.Nonce > 0 && !d.PartnerProofReady && !d.UpdateSubmitted &&
+		blockNumber >= d.SettleBlock-d.SettleTimeout/2 {
+		//只有 tx 执行成功或者收到链上事件才算完成,失败了下一个块重新提交.分叉回滚以后 tx 的结果不再可信,以链上的状态为准
+		s := wt.delegateTXStatus(d, models.TXInfoTypeUpdateBalanceProof)[utils.EmptyHash]
+		var nonce uint64
+		if s.success {
+			_, _, nonce, err = tn.GetChannelParticipantInfo(d.PartnerAddress, d.Delegator)
+			if err != nil {
+				return
+			}
+		}
+		if s.success && nonce == ut.Nonce {
+			d.UpdateSubmitted = true
+			d.PartnerProofReady = true
+			changed = true
+		} else if !s.pending && s.failed < watchtowerMaxTXAttempts {
+			err = tn.UpdateBalanceProofDelegate(d.Delegator, d.PartnerAddress, d.OpenBlockNumber, ut.TransferAmount, ut.Locksroot, ut.Nonce, ut.ExtraHash, ut.ClosingSignature, ut.NonClosingSignature)
+			if err != nil {
+				log.Error(fmt.Sprintf("watchtower UpdateBalanceProofDelegate channel %s err %s", utils.HPex(d.ChannelIdentifier), err))
+			} else {
+				log.Info(fmt.Sprintf("watchtower update balance proof of channel %s for %s", utils.HPex(d.ChannelIdentifier), utils.APex2(d.Delegator)))
+			}
+		}
+	}
+	// 2. balance proof 在链上以后,注册密码并 unlock 对方发给委托方的锁
+	if !d.PartnerProofReady {
+		return
+	}
+	var unlockTXs map[common.Hash]delegateTXStatus
+	for _, u := range c3.Unlocks {
+		lockHash := u.Lock.Hash()
+		if containsHash(d.UnlockedLocks, lockHash) {
+			continue
+		}
+		if unlockTXs == nil {
+			unlockTXs = wt.delegateTXStatus(d, models.TXInfoTypeUnlock)
+		}
+		s := unlockTXs[lockHash]
+		if s.success {
+			unlocked, err := tn.QueryUnlockedLocks(d.PartnerAddress, d.Delegator, lockHash)
+			if err != nil {
+				continue
+			}
+			if unlocked {
+				d.UnlockedLocks = append(d.UnlockedLocks, lockHash)
+				changed = true
+				continue
+			}
+		}
+		if s.pending || s.failed >= watchtowerMaxTXAttempts {
+			continue
+		}
+		if !containsHash(d.RegisteredSecrets, u.Lock.LockSecretHash) {
+			//可能别人已经注册了
+			registered, err := rs.Chain.SecretRegistryProxy.IsSecretRegistered(u.Secret)
+			if err != nil {
+				continue
+			}
+			if !registered {
+				//过期的锁注册了密码也没用
+				if blockNumber >= u.Lock.Expiration {
+					continue
+				}
+				err = rs.Chain.SecretRegistryProxy.RegisterSecret(u.Secret)
+				if e, ok := err.(rerr.StandardError); ok && e.ErrorCode == rerr.ErrSecretAlreadyRegistered.ErrorCode {
+					err = nil
+				}
+				if err != nil {
+					log.Error(fmt.Sprintf("watchtower RegisterSecret %s err %s", utils.HPex(u.Lock.LockSecretHash), err))
+					continue
+				}
+			}
+			d.RegisteredSecrets = append(d.RegisteredSecrets, u.Lock.LockSecretHash)
+			changed = true
+		}
+		err = tn.UnlockDelegate(d.Delegator, d.PartnerAddress, d.OpenBlockNumber, ut.TransferAmount, u.Lock, u.MerkleProof, u.Signature)
+		if err != nil {
+			log.Error(fmt.Sprintf("watchtower UnlockDelegate %s of channel %s err %s", utils.HPex(u.Lock.LockSecretHash), utils.HPex(d.ChannelIdentifier), err))
+		}
+	}
+	return
+}
+
+//delegateTXStatus 我替委托方发起的 tx 的执行情况
+type delegateTXStatus struct {
+	success bool
+	pending bool
+	failed  int
+}
+
+/*
+delegateTXStatus 我替委托方在这个通道上发起的 txType 类型的 tx 的执行情况,
+Unlock 按锁的 hash 区分,UpdateBalanceProof 的 key 为空
+*/
+func (wt *Watchtower) delegateTXStatus(d *models.WatchtowerDelegate, txType models.TXInfoType) map[common.Hash]delegateTXStatus {
+	m := make(map[common.Hash]delegateTXStatus)
+	list, err := wt.rs.dao.GetTXInfoList(d.ChannelIdentifier, d.OpenBlockNumber, utils.EmptyAddress, txType, "")
+	if err != nil {
+		log.Error(fmt.Sprintf("watchtower GetTXInfoList of channel %s err %s", utils.HPex(d.ChannelIdentifier), err))
+		return m
+	}
+	for _, tx := range list {
+		var key common.Hash
+		if txType == models.TXInfoTypeUnlock {
+			var p models.UnlockTXParams
+			err = json.Unmarshal([]byte(tx.TXParams), &p)
+			if err != nil || p.Expiration == nil || p.Amount == nil {
+				continue
+			}
+			key = (&mtree.Lock{Expiration: p.Expiration.Int64(), Amount: p.Amount, LockSecretHash: p.LockSecretHash}).Hash()
+		}
+		s := m[key]
+		switch tx.Status {
+		case models.TXInfoStatusSuccess:
+			s.success = true
+		case models.TXInfoStatusPending:
+			s.pending = true
+		case models.TXInfoStatusFailed:
+			s.failed++
+		}
+		m[key] = s
+	}
+	return m
+}
+
+func isSameBalanceProof(ut *updateTransfer, locksRoot common.Hash, transferAmount *big.Int) bool {
+	return ut.Nonce > 0 && ut.Locksroot == locksRoot && ut.TransferAmount != nil && transferAmount.Cmp(ut.TransferAmount) == 0
+}
+
+func containsHash(hashes []common.Hash, h common.Hash) bool {
+	for _, h2 := range hashes {
+		if h2 == h {
+			return true
+		}
+	}
+	return false
+}
+
+func appendHashIfNotExist(hashes []common.Hash, h common.Hash) []common.Hash {
+	if containsHash(hashes, h) {
+		return hashes
+	}
+	return append(hashes, h)
+}
@@ -0,0 +1,247 @@
+package photon
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/network/rpc/contracts"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/transfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mediatedtransfer"
+	"github.com/MetaLife-Protocol/SuperNode/transfer/mtree"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyChannelFor3rd(t *testing.T) {
+	delegatorKey, delegator := utils.MakePrivateKeyAddress()
+	partnerKey, partner := utils.MakePrivateKeyAddress()
+	third := utils.NewRandomAddress()
+	c3 := &ChannelFor3rd{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+		PartnerAddress:    partner,
+	}
+	assert.Nil(t, verifyChannelFor3rd(c3, delegator, third))
+
+	secret := utils.NewRandomHash()
+	lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(1), LockSecretHash: utils.ShaSecret(secret[:])}
+	lock2 := &mtree.Lock{Expiration: 100, Amount: big.NewInt(2), LockSecretHash: utils.NewRandomHash()}
+	tree := mtree.NewMerkleTree([]*mtree.Lock{lock, lock2})
+	ut := &c3.UpdateTransfer
+	ut.Nonce = 5
+	ut.TransferAmount = big.NewInt(10)
+	ut.Locksroot = tree.MerkleRoot()
+	var err error
+	ut.NonClosingSignature, err = utils.SignData(delegatorKey, balanceProofFor3rdData(c3.ChannelIdentifier, c3.OpenBlockNumber, ut.TransferAmount, ut.Locksroot, ut.Nonce))
+	assert.Nil(t, err)
+	assert.Nil(t, verifyChannelFor3rd(c3, delegator, third))
+	assert.NotNil(t, verifyChannelFor3rd(c3, partner, third))
+
+	u := &unlock{Lock: lock, Secret: secret, MerkleProof: mtree.Proof2Bytes(tree.MakeProof(lock.Hash()))}
+	u.Signature, err = utils.SignData(delegatorKey, unlockFor3rdData(c3.ChannelIdentifier, c3.OpenBlockNumber, ut.TransferAmount, third, lock))
+	assert.Nil(t, err)
+	c3.Unlocks = []*unlock{u}
+	assert.Nil(t, verifyChannelFor3rd(c3, delegator, third))
+	//unlock 的签名只能由指定的第三方使用
+	assert.NotNil(t, verifyChannelFor3rd(c3, delegator, utils.NewRandomAddress()))
+	u.Secret = utils.NewRandomHash()
+	assert.NotNil(t, verifyChannelFor3rd(c3, delegator, third))
+	u.Secret = secret
+	//锁不在 locksroot 中
+	u.MerkleProof = mtree.Proof2Bytes(tree.MakeProof(lock2.Hash()))
+	assert.NotNil(t, verifyChannelFor3rd(c3, delegator, third))
+	u.MerkleProof = mtree.Proof2Bytes(tree.MakeProof(lock.Hash()))
+	//unlock 的数量有上限
+	for len(c3.Unlocks) <= params.WatchtowerMaxUnlocks {
+		c3.Unlocks = append(c3.Unlocks, u)
+	}
+	assert.NotNil(t, verifyChannelFor3rd(c3, delegator, third))
+	c3.Unlocks = []*unlock{u}
+
+	p := &punish{LockHash: lock.Hash(), AdditionalHash: utils.NewRandomHash()}
+	p.Signature, err = utils.SignData(partnerKey, disposedProofData(p.LockHash, c3.ChannelIdentifier, c3.OpenBlockNumber, p.AdditionalHash))
+	assert.Nil(t, err)
+	c3.Punishes = []*punish{p}
+	assert.Nil(t, verifyChannelFor3rd(c3, delegator, third))
+	p.AdditionalHash = utils.NewRandomHash()
+	assert.NotNil(t, verifyChannelFor3rd(c3, delegator, third))
+}
+
+func TestWatchtowerDelegate(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:         dao,
+		NodeAddress: utils.NewRandomAddress(),
+		Config:      &params.Config{WatchtowerFee: 10},
+	}
+	wt := newWatchtower(rs)
+	delegatorKey, delegator := utils.MakePrivateKeyAddress()
+	partner, token := utils.NewRandomAddress(), utils.NewRandomAddress()
+	c3 := &ChannelFor3rd{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+		TokenAddrss:       token,
+		PartnerAddress:    partner,
+	}
+	//不知道的通道
+	assert.NotNil(t, wt.delegate(c3, utils.EmptyHash))
+	assert.Nil(t, dao.NewNonParticipantChannel(token, c3.ChannelIdentifier, delegator, partner))
+	//需要先付费
+	assert.NotNil(t, wt.delegate(c3, utils.EmptyHash))
+	fee := utils.NewRandomHash()
+	dao.NewReceivedTransfer(2, utils.NewRandomHash(), 3, token, delegator, 1, big.NewInt(9), fee, "")
+	assert.NotNil(t, wt.delegate(c3, fee))
+	fee = utils.NewRandomHash()
+	dao.NewReceivedTransfer(2, utils.NewRandomHash(), 3, token, delegator, 2, big.NewInt(10), fee, "")
+	assert.Nil(t, wt.delegate(c3, fee))
+
+	sign := func(nonce uint64) {
+		ut := &c3.UpdateTransfer
+		ut.Nonce = nonce
+		ut.TransferAmount = big.NewInt(int64(nonce))
+		var err error
+		ut.NonClosingSignature, err = utils.SignData(delegatorKey, balanceProofFor3rdData(c3.ChannelIdentifier, c3.OpenBlockNumber, ut.TransferAmount, ut.Locksroot, ut.Nonce))
+		assert.Nil(t, err)
+	}
+	//同一个通道后续的委托不需要再付费
+	sign(5)
+	assert.Nil(t, wt.delegate(c3, utils.EmptyHash))
+	sign(4)
+	assert.NotNil(t, wt.delegate(c3, utils.EmptyHash))
+	d, err := dao.GetWatchtowerDelegate(c3.ChannelIdentifier)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, d.Nonce)
+	assert.EqualValues(t, delegator, d.Delegator)
+
+	//一笔费用只能用于一个通道
+	c3.ChannelIdentifier = utils.NewRandomHash()
+	assert.Nil(t, dao.NewNonParticipantChannel(token, c3.ChannelIdentifier, delegator, partner))
+	sign(1)
+	assert.NotNil(t, wt.delegate(c3, fee))
+}
+
+func TestWatchtowerOnStateChangeNotBlocked(t *testing.T) {
+	wt := newWatchtower(&Service{})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i <= cap(wt.stateChangeChan); i++ {
+			wt.OnStateChange(&transfer.BlockStateChange{BlockNumber: int64(i)})
+			wt.OnStateChange(&mediatedtransfer.ContractClosedStateChange{ChannelIdentifier: utils.NewRandomHash()})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("OnStateChange blocked")
+	}
+}
+
+func TestWatchtowerDelegateTXStatus(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	wt := newWatchtower(&Service{dao: dao})
+	d := &models.WatchtowerDelegate{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	}
+	var nonce uint64
+	newTX := func(txType models.TXInfoType, openBlockNumber int64, params models.TXParams, status models.TXInfoStatus) {
+		nonce++
+		tx := types.NewTransaction(nonce, utils.NewRandomAddress(), big.NewInt(0), 0, big.NewInt(0), nil)
+		_, err := dao.NewPendingTXInfo(tx, txType, d.ChannelIdentifier, openBlockNumber, params)
+		assert.Nil(t, err)
+		if status != models.TXInfoStatusPending {
+			_, err = dao.UpdateTXInfoStatus(tx.Hash(), status, 10, 0)
+			assert.Nil(t, err)
+		}
+	}
+	//发出去的 tx 还没有结果,不算完成
+	newTX(models.TXInfoTypeUpdateBalanceProof, d.OpenBlockNumber, nil, models.TXInfoStatusPending)
+	s := wt.delegateTXStatus(d, models.TXInfoTypeUpdateBalanceProof)[utils.EmptyHash]
+	assert.True(t, s.pending)
+	assert.False(t, s.success)
+	//以前的通道上的 tx 不算
+	newTX(models.TXInfoTypeUpdateBalanceProof, d.OpenBlockNumber-1, nil, models.TXInfoStatusSuccess)
+	s = wt.delegateTXStatus(d, models.TXInfoTypeUpdateBalanceProof)[utils.EmptyHash]
+	assert.False(t, s.success)
+	newTX(models.TXInfoTypeUpdateBalanceProof, d.OpenBlockNumber, nil, models.TXInfoStatusFailed)
+	s = wt.delegateTXStatus(d, models.TXInfoTypeUpdateBalanceProof)[utils.EmptyHash]
+	assert.EqualValues(t, 1, s.failed)
+
+	//Unlock 按锁区分
+	lock1 := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.NewRandomHash()}
+	lock2 := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.NewRandomHash()}
+	unlockParams := func(l *mtree.Lock) *models.UnlockTXParams {
+		return &models.UnlockTXParams{Expiration: big.NewInt(l.Expiration), Amount: l.Amount, LockSecretHash: l.LockSecretHash}
+	}
+	newTX(models.TXInfoTypeUnlock, d.OpenBlockNumber, unlockParams(lock1), models.TXInfoStatusFailed)
+	newTX(models.TXInfoTypeUnlock, d.OpenBlockNumber, unlockParams(lock1), models.TXInfoStatusSuccess)
+	newTX(models.TXInfoTypeUnlock, d.OpenBlockNumber, unlockParams(lock2), models.TXInfoStatusFailed)
+	m := wt.delegateTXStatus(d, models.TXInfoTypeUnlock)
+	assert.True(t, m[lock1.Hash()].success)
+	assert.False(t, m[lock2.Hash()].success)
+	assert.False(t, m[lock2.Hash()].pending)
+	assert.EqualValues(t, 1, m[lock2.Hash()].failed)
+}
+
+func TestResyncDelegateWithChain(t *testing.T) {
+	c3 := &ChannelFor3rd{UpdateTransfer: updateTransfer{Nonce: 5}}
+	lock1, lock2 := utils.NewRandomHash(), utils.NewRandomHash()
+	closed := func() *models.WatchtowerDelegate {
+		return &models.WatchtowerDelegate{
+			PartnerAddress:    utils.NewRandomAddress(),
+			ClosedBlock:       10,
+			SettleBlock:       110,
+			SettleTimeout:     100,
+			PartnerProofReady: true,
+			UpdateSubmitted:   true,
+			RegisteredSecrets: []common.Hash{utils.NewRandomHash()},
+			UnlockedLocks:     []common.Hash{lock1, lock2},
+			Punished:          true,
+		}
+	}
+	unlocked := func(lockHash common.Hash) bool { return lockHash == lock1 }
+
+	//关闭事件被回滚
+	d := closed()
+	resyncDelegateWithChain(d, c3, contracts.ChannelStateOpened, 0, 0, 0, 0, unlocked)
+	assert.EqualValues(t, 0, d.ClosedBlock)
+	assert.EqualValues(t, utils.EmptyAddress, d.ClosingAddress)
+	assert.False(t, d.PartnerProofReady)
+	assert.False(t, d.UpdateSubmitted)
+	assert.Empty(t, d.RegisteredSecrets)
+	assert.Empty(t, d.UnlockedLocks)
+	assert.False(t, d.Punished)
+
+	//提交 balance proof 的事件被回滚,链上对方的 nonce 不是委托的
+	d = closed()
+	d.ClosingAddress = d.PartnerAddress
+	resyncDelegateWithChain(d, c3, contracts.ChannelStateClosed, 120, 100, 4, math.MaxUint64, unlocked)
+	assert.False(t, d.PartnerProofReady)
+	assert.False(t, d.UpdateSubmitted)
+	assert.EqualValues(t, 120, d.SettleBlock)
+	assert.True(t, d.Punished)
+	resyncDelegateWithChain(d, c3, contracts.ChannelStateClosed, 120, 100, 5, math.MaxUint64, unlocked)
+	assert.True(t, d.PartnerProofReady)
+	assert.True(t, d.UpdateSubmitted)
+
+	//unlock 事件被回滚,只保留链上确实 unlock 了的锁
+	d = closed()
+	resyncDelegateWithChain(d, c3, contracts.ChannelStateClosed, 110, 100, 5, 0, unlocked)
+	assert.EqualValues(t, []common.Hash{lock1}, d.UnlockedLocks)
+	assert.True(t, d.PartnerProofReady)
+	//委托方不是通道的关闭方,不用替它提交
+	assert.False(t, d.UpdateSubmitted)
+
+	//punish 事件被回滚
+	assert.False(t, d.Punished)
+}
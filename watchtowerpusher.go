@@ -0,0 +1,314 @@
+package photon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel/channeltype"
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/log"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+WatchtowerPusher 手机节点经常离线,对方收到的 balance proof 每次变化以后,
+都自动以 watchtower 为第三方生成新的 ChannelFor3rd 推送给配置的每个 watchtower,
+失败以后按指数退避重试,并记录每个 watchtower 已经确认的 nonce.
+watchtower 的地址在配置中指定,格式是 address@url,unlock 的签名只有这个地址能用,不相信 url 返回的地址.
+如果配置了 WatchtowerPayFee,第一次委托一个通道之前先给 watchtower 转账,转账的 LockSecretHash 记录在 ack 中,重试时不会重复付费.
+*/
+/*
+ *	WatchtowerPusher : mobile nodes are offline most of the time, after every change of the partner's balance proof,
+ *	a freshly signed ChannelFor3rd is pushed to every configured watchtower, with me generating it for that watchtower.
+ *	Failed pushes are retried with exponential backoff, and the nonce acknowledged by each watchtower is recorded.
+ *	Address of every watchtower is pinned in the config as address@url, the address returned by the url is never trusted.
+ *	When WatchtowerPayFee is set, the fee is transferred to the watchtower before the first delegation of a channel,
+ *	and LockSecretHash of the fee transfer is kept in the ack, so retries never pay twice.
+ */
+type WatchtowerPusher struct {
+	rs         *Service
+	api        *API
+	towers     []*watchtowerEndpoint
+	notifyChan chan common.Hash
+	client     *http.Client
+}
+
+type watchtowerEndpoint struct {
+	url     string
+	address common.Address
+	retries map[common.Hash]*watchtowerRetry
+}
+
+type watchtowerRetry struct {
+	failures int
+	next     time.Time
+}
+
+func newWatchtowerPusher(rs *Service, towers []string) (p *WatchtowerPusher, err error) {
+	p = &WatchtowerPusher{
+		rs:         rs,
+		api:        NewPhotonAPI(rs),
+		notifyChan: make(chan common.Hash, 100),
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, tower := range towers {
+		addr, url, err := ParseWatchtower(tower)
+		if err != nil {
+			return nil, err
+		}
+		if addr == rs.NodeAddress {
+			return nil, fmt.Errorf("watchtower %s is myself", url)
+		}
+		p.towers = append(p.towers, &watchtowerEndpoint{
+			url:     url,
+			address: addr,
+			retries: make(map[common.Hash]*watchtowerRetry),
+		})
+	}
+	return
+}
+
+/*
+ParseWatchtower 解析 address@url 格式的 watchtower 配置,
+例如 0x3bc7726c489e617571792ac0cd8b70df8a5d0e22@http://127.0.0.1:5001
+*/
+func ParseWatchtower(s string) (addr common.Address, url string, err error) {
+	ss := strings.SplitN(s, "@", 2)
+	if len(ss) != 2 || ss[1] == "" {
+		err = fmt.Errorf("watchtower %s must be address@url", s)
+		return
+	}
+	addr, err = utils.HexToAddress(ss[0])
+	if err != nil {
+		err = fmt.Errorf("watchtower %s has an invalid address %s", s, err)
+		return
+	}
+	url = strings.TrimRight(ss[1], "/")
+	return
+}
+
+/*
+Notify 通道状态在数据库中更新以后调用,不会阻塞,
+漏掉的通知由定时检查补上
+*/
+func (p *WatchtowerPusher) Notify(channelIdentifier common.Hash) {
+	select {
+	case p.notifyChan <- channelIdentifier:
+	default:
+	}
+}
+
+func (p *WatchtowerPusher) loop() {
+	ticker := time.NewTicker(params.WatchtowerPushCheckInterval)
+	defer ticker.Stop()
+	p.pushAll()
+	for {
+		select {
+		case channelIdentifier := <-p.notifyChan:
+			c, err := p.rs.dao.GetChannelByAddress(channelIdentifier)
+			if err == nil {
+				p.pushChannel(c)
+			}
+		case <-ticker.C:
+			p.pushAll()
+		case <-p.rs.quitChan:
+			return
+		}
+	}
+}
+
+func (p *WatchtowerPusher) pushAll() {
+	cs, err := p.rs.dao.GetChannelList(utils.EmptyAddress, utils.EmptyAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("WatchtowerPusher GetChannelList err %s", err))
+		return
+	}
+	exist := make(map[common.Hash]bool)
+	for _, c := range cs {
+		exist[c.ChannelIdentifier.ChannelIdentifier] = true
+		p.pushChannel(c)
+	}
+	//已经 settle 的通道不再需要 watchtower
+	acks, err := p.rs.dao.GetWatchtowerAckList()
+	if err != nil {
+		return
+	}
+	for _, ack := range acks {
+		if !exist[ack.ChannelIdentifier] {
+			err = p.rs.dao.RemoveWatchtowerAck(ack.WatchtowerURL, ack.ChannelIdentifier)
+			if err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+	for _, t := range p.towers {
+		for id := range t.retries {
+			if !exist[id] {
+				delete(t.retries, id)
+			}
+		}
+	}
+}
+
+func (p *WatchtowerPusher) pushChannel(c *channeltype.Serialization) {
+	if c.PartnerBalanceProof == nil || c.PartnerBalanceProof.Nonce == 0 {
+		return
+	}
+	channelIdentifier := c.ChannelIdentifier.ChannelIdentifier
+	for _, t := range p.towers {
+		r := t.retries[channelIdentifier]
+		if r != nil && time.Now().Before(r.next) {
+			continue
+		}
+		err := p.push(t, c)
+		if err == nil {
+			delete(t.retries, channelIdentifier)
+			continue
+		}
+		if r == nil {
+			r = &watchtowerRetry{}
+			t.retries[channelIdentifier] = r
+		}
+		r.failures++
+		r.next = time.Now().Add(watchtowerRetryInterval(r.failures))
+		log.Warn(fmt.Sprintf("push channel %s to watchtower %s err %s, retry after %s",
+			utils.HPex(channelIdentifier), t.url, err, r.next.Sub(time.Now())))
+	}
+}
+
+//watchtowerRetryInterval 第 failures 次失败以后的重试间隔
+func watchtowerRetryInterval(failures int) time.Duration {
+	d := params.WatchtowerPushRetryMin
+	for i := 1; i < failures && d < params.WatchtowerPushRetryMax; i++ {
+		d *= 2
+	}
+	if d > params.WatchtowerPushRetryMax {
+		d = params.WatchtowerPushRetryMax
+	}
+	return d
+}
+
+//push 只推送 watchtower 还没有确认过的数据
+func (p *WatchtowerPusher) push(t *watchtowerEndpoint, c *channeltype.Serialization) (err error) {
+	channelIdentifier := c.ChannelIdentifier.ChannelIdentifier
+	c3, err := p.api.ChannelInformationFor3rdParty(channelIdentifier, t.address)
+	if err != nil {
+		return
+	}
+	content, err := json.Marshal(c3)
+	if err != nil {
+		return
+	}
+	contentHash := utils.Sha3(content)
+	ack, err := p.rs.dao.GetWatchtowerAck(t.url, channelIdentifier)
+	if err != nil || ack.OpenBlockNumber != c3.OpenBlockNumber {
+		//第一次委托,或者是重新打开的通道
+		ack = &models.WatchtowerAck{
+			WatchtowerURL:     t.url,
+			WatchtowerAddress: t.address,
+			ChannelIdentifier: channelIdentifier,
+			OpenBlockNumber:   c3.OpenBlockNumber,
+		}
+	} else if ack.ContentHash == contentHash {
+		return nil
+	}
+	if ack.ContentHash == utils.EmptyHash && p.rs.Config.WatchtowerPayFee > 0 {
+		err = p.payFee(t, c3.TokenAddrss, ack)
+		if err != nil {
+			return
+		}
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"channel":              json.RawMessage(content),
+		"fee_lock_secret_hash": ack.FeeLockSecretHash,
+	})
+	if err != nil {
+		return
+	}
+	resp, err := p.call(http.MethodPost, t.url+"/api/1/watchtower/delegate", payload)
+	if err != nil {
+		return
+	}
+	if resp.ErrorCode != dto.SUCCESS {
+		return fmt.Errorf("errorCode=%d,errorMsg=%s", resp.ErrorCode, resp.ErrorMsg)
+	}
+	log.Info(fmt.Sprintf("watchtower %s acknowledged channel %s nonce=%d", t.url, utils.HPex(channelIdentifier), c3.UpdateTransfer.Nonce))
+	ack.Nonce = c3.UpdateTransfer.Nonce
+	ack.ContentHash = contentHash
+	return p.rs.dao.SaveWatchtowerAck(ack)
+}
+
+/*
+payFee 第一次委托通道之前给 watchtower 转账 WatchtowerPayFee.
+转账发出以后马上把 LockSecretHash 保存到 ack 中,不等转账完成;
+重试的时候先查看这笔转账的状态,成功了就用它委托,还在进行中就等下次重试,失败了才重新付费
+*/
+func (p *WatchtowerPusher) payFee(t *watchtowerEndpoint, token common.Address, ack *models.WatchtowerAck) error {
+	if ack.FeeLockSecretHash != utils.EmptyHash {
+		std, err := p.rs.dao.GetSentTransferDetail(token, ack.FeeLockSecretHash)
+		if err == nil {
+			switch std.Status {
+			case models.TransferStatusSuccess:
+				return nil
+			case models.TransferStatusCanceled, models.TransferStatusFailed:
+				log.Warn(fmt.Sprintf("watchtower fee %s for channel %s failed, pay again",
+					ack.FeeLockSecretHash.String(), utils.HPex(ack.ChannelIdentifier)))
+			default:
+				return fmt.Errorf("watchtower fee %s is still in flight", ack.FeeLockSecretHash.String())
+			}
+		}
+	}
+	result, err := p.api.TransferInternal(token, big.NewInt(p.rs.Config.WatchtowerPayFee), t.address, utils.EmptyHash,
+		false, "watchtower fee", false, nil)
+	if err != nil {
+		return fmt.Errorf("pay watchtower fee err %s", err)
+	}
+	ack.FeeLockSecretHash = result.LockSecretHash
+	err = p.rs.dao.SaveWatchtowerAck(ack)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("pay fee %d to watchtower %s for channel %s, lockSecretHash=%s",
+		p.rs.Config.WatchtowerPayFee, t.url, utils.HPex(ack.ChannelIdentifier), ack.FeeLockSecretHash.String()))
+	select {
+	case err = <-result.Result:
+	case <-time.After(params.WatchtowerPayFeeTimeout):
+		return fmt.Errorf("watchtower fee %s is still in flight", ack.FeeLockSecretHash.String())
+	}
+	if err != nil {
+		return fmt.Errorf("pay watchtower fee err %s", err)
+	}
+	return nil
+}
+
+func (p *WatchtowerPusher) call(method, url string, payload []byte) (resp *dto.APIResponse, err error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r, err := p.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status=%d body=%s", r.StatusCode, string(body))
+	}
+	resp = new(dto.APIResponse)
+	err = json.Unmarshal(body, resp)
+	return
+}
@@ -0,0 +1,110 @@
+package photon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MetaLife-Protocol/SuperNode/channel"
+	"github.com/MetaLife-Protocol/SuperNode/codefortest"
+	"github.com/MetaLife-Protocol/SuperNode/dto"
+	"github.com/MetaLife-Protocol/SuperNode/models"
+	"github.com/MetaLife-Protocol/SuperNode/params"
+	"github.com/MetaLife-Protocol/SuperNode/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchtowerRetryInterval(t *testing.T) {
+	assert.EqualValues(t, params.WatchtowerPushRetryMin, watchtowerRetryInterval(1))
+	assert.EqualValues(t, params.WatchtowerPushRetryMin*2, watchtowerRetryInterval(2))
+	assert.EqualValues(t, params.WatchtowerPushRetryMin*8, watchtowerRetryInterval(4))
+	assert.EqualValues(t, params.WatchtowerPushRetryMax, watchtowerRetryInterval(100))
+}
+
+func TestParseWatchtower(t *testing.T) {
+	tower := utils.NewRandomAddress()
+	addr, url, err := ParseWatchtower(tower.String() + "@http://127.0.0.1:5001/")
+	assert.Nil(t, err)
+	assert.EqualValues(t, tower, addr)
+	assert.EqualValues(t, "http://127.0.0.1:5001", url)
+	_, _, err = ParseWatchtower("http://127.0.0.1:5001")
+	assert.NotNil(t, err)
+	_, _, err = ParseWatchtower("0x123@http://127.0.0.1:5001")
+	assert.NotNil(t, err)
+	_, _, err = ParseWatchtower(tower.String() + "@")
+	assert.NotNil(t, err)
+
+	rs := &Service{NodeAddress: tower}
+	_, err = newWatchtowerPusher(rs, []string{tower.String() + "@http://127.0.0.1:5001"})
+	assert.NotNil(t, err)
+}
+
+func TestWatchtowerPusherPush(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	ch, _ := channel.MakeTestPairChannel()
+	c := channel.NewChannelSerialization(ch)
+	assert.Nil(t, dao.NewChannel(c))
+	channelIdentifier := c.ChannelIdentifier.ChannelIdentifier
+
+	var feeLockSecretHash common.Hash
+	pushed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//地址是配置好的,不需要问 watchtower
+		assert.EqualValues(t, "/api/1/watchtower/delegate", r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		var payload struct {
+			Channel           *ChannelFor3rd `json:"channel"`
+			FeeLockSecretHash common.Hash    `json:"fee_lock_secret_hash"`
+		}
+		assert.Nil(t, json.Unmarshal(body, &payload))
+		assert.EqualValues(t, channelIdentifier, payload.Channel.ChannelIdentifier)
+		assert.EqualValues(t, feeLockSecretHash, payload.FeeLockSecretHash)
+		pushed++
+		_, err = w.Write([]byte(dto.NewSuccessAPIResponse(nil).ToFormatString()))
+		assert.Nil(t, err)
+	}))
+	defer server.Close()
+	tower := utils.NewRandomAddress()
+	rs := &Service{
+		dao:         dao,
+		NodeAddress: utils.NewRandomAddress(),
+		Config:      &params.Config{WatchtowerPayFee: 10},
+	}
+	p, err := newWatchtowerPusher(rs, []string{tower.String() + "@" + server.URL})
+	assert.Nil(t, err)
+	//已经付过费,但是还没有被确认,重试时用同一笔费用
+	feeLockSecretHash = utils.NewRandomHash()
+	assert.Nil(t, dao.SaveWatchtowerAck(&models.WatchtowerAck{
+		WatchtowerURL:     server.URL,
+		WatchtowerAddress: tower,
+		ChannelIdentifier: channelIdentifier,
+		OpenBlockNumber:   c.ChannelIdentifier.OpenBlockNumber,
+		FeeLockSecretHash: feeLockSecretHash,
+	}))
+	//费用还在转账中,不能再付一次,也不能委托
+	token := ch.TokenAddress
+	dao.NewSentTransferDetail(token, tower, big.NewInt(10), "watchtower fee", false, feeLockSecretHash)
+	dao.UpdateSentTransferDetailStatus(token, feeLockSecretHash, models.TransferStatusCanCancel, "MediatedTransfer sending", nil)
+	assert.NotNil(t, p.push(p.towers[0], c))
+	assert.EqualValues(t, 0, pushed)
+	ack, err := dao.GetWatchtowerAck(server.URL, channelIdentifier)
+	assert.Nil(t, err)
+	assert.EqualValues(t, feeLockSecretHash, ack.FeeLockSecretHash)
+	//费用转账成功以后用它委托
+	dao.UpdateSentTransferDetailStatus(token, feeLockSecretHash, models.TransferStatusSuccess, "transfer success", nil)
+	assert.Nil(t, p.push(p.towers[0], c))
+	assert.EqualValues(t, 1, pushed)
+	ack, err = dao.GetWatchtowerAck(server.URL, channelIdentifier)
+	assert.Nil(t, err)
+	assert.EqualValues(t, feeLockSecretHash, ack.FeeLockSecretHash)
+	assert.NotEqual(t, utils.EmptyHash, ack.ContentHash)
+	//内容没有变化,不再推送
+	assert.Nil(t, p.push(p.towers[0], c))
+	assert.EqualValues(t, 1, pushed)
+}